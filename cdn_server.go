@@ -1,10 +1,15 @@
 package main
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	sack "github.com/soumitsalman/coffeemaker/sdk/beansack"
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
 	"golang.org/x/time/rate"
 )
 
@@ -14,9 +19,35 @@ const (
 )
 
 type queryParams struct {
-	Window int      `form:"window"`
+	// days such as 7 or 7d, or hours such as 6h
+	Window string   `form:"window"`
 	TopN   int      `form:"topn"`
 	Kinds  []string `form:"kind"`
+	// positive, negative or neutral
+	Sentiments []string `form:"sentiment"`
+	// entity ids such as org:openai
+	Entities []string `form:"entity"`
+	// ids or names of the categories in the taxonomy
+	Categories []string `form:"category"`
+	// ISO 639-1 codes such as en, es
+	Languages []string `form:"lang"`
+	// one bean per story for /beans/trending
+	OnePerStory bool `form:"one_per_story"`
+	// urls or aliases of the beans
+	URLs []string `form:"urls"`
+	// default or classic for the trending nuggets and beans. empty means the stored trend scores
+	Scorer string `form:"scorer"`
+	// velocity for /beans/trending. empty means by trend score
+	SortBy string `form:"sort"`
+	// source:reddit kind:post author:"u/foo" created>2024-05-01 keyword:ransomware -source:medium
+	Query string `form:"q"`
+	// ISO-8601 dates such as 2024-05-01 or 2024-05-01T10:00:00Z. since is inclusive and until is exclusive
+	Since string `form:"since"`
+	Until string `form:"until"`
+	// created or updated. empty means updated
+	TimeField string `form:"time_field"`
+	// adds the score breakdown and the rank to each bean
+	Explain bool `form:"explain"`
 }
 
 type bodyParams struct {
@@ -25,6 +56,8 @@ type bodyParams struct {
 	Embeddings [][]float32 `json:"embeddings,omitempty"`
 	Context    string      `json:"context,omitempty"`
 	URLs       []string    `json:"urls,omitempty"`
+	// for /answers. it is used as the context as well if there is none
+	Question string `json:"question,omitempty"`
 }
 
 func extractParams(ctx *gin.Context) (*sack.SearchOptions, []string) {
@@ -39,21 +72,61 @@ func extractParams(ctx *gin.Context) (*sack.SearchOptions, []string) {
 	if len(query_params.Kinds) > 0 {
 		options.WithKind(query_params.Kinds)
 	}
-	if query_params.Window > 0 {
-		options.WithTimeWindow(query_params.Window)
+	options.WithTimeField(query_params.TimeField)
+	days, hours, window_err := parseTimeWindow(query_params.Window)
+	since, since_err := parseISODate(query_params.Since)
+	until, until_err := parseISODate(query_params.Until)
+	if window_err != nil || since_err != nil || until_err != nil {
+		ctx.String(http.StatusBadRequest, _ERROR_MESSAGE)
+		return nil, nil
+	}
+	if days > 0 {
+		options.WithTimeWindow(days)
+	}
+	if hours > 0 {
+		options.WithHourWindow(hours)
 	}
+	options.WithDateRange(since, until)
 	if query_params.TopN > 0 {
 		options.WithTopN(query_params.TopN)
 	}
+	options.WithSentiment(query_params.Sentiments)
+	options.WithEntities(query_params.Entities)
+	options.WithCategory(query_params.Categories)
+	options.WithLanguage(query_params.Languages)
+	options.WithOnePerStory(query_params.OnePerStory)
+	options.WithSortBy(query_params.SortBy)
+	options.WithExplain(query_params.Explain)
+	if query_params.Query != "" {
+		query, err := sack.ParseQuery(query_params.Query)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return nil, nil
+		}
+		options.WithQuery(query)
+	}
+	if query_params.Scorer != "" {
+		scorer := sack.GetTrendScorer(query_params.Scorer)
+		if scorer == nil {
+			ctx.String(http.StatusBadRequest, _ERROR_MESSAGE)
+			return nil, nil
+		}
+		options.WithTrendScorer(scorer)
+	}
 
 	var body_params bodyParams
 	// if body params are provided, assign them or else proceed without them
 	if ctx.ShouldBindJSON(&body_params) == nil {
-		options.SearchTexts = body_params.Categories
+		// the categories of the taxonomy filter. Anything else is searched for
+		options.WithCategoryTexts(body_params.Categories)
 		options.SearchEmbeddings = body_params.Embeddings
 		options.Context = body_params.Context
-		options.WithURLs(body_params.URLs)
+		if options.Context == "" {
+			options.Context = body_params.Question
+		}
 	}
+	// the urls of the query and the body are one list
+	options.WithURLs(append(query_params.URLs, body_params.URLs...))
 	return options, body_params.Nuggets
 }
 
@@ -88,6 +161,56 @@ func retrieveBeansHandler(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, sack.Retrieve(options))
 }
 
+// GET /beans/related?url=https://example.com/article&kind=news%20article&window=7
+func relatedBeansHandler(ctx *gin.Context) {
+	url := ctx.Query("url")
+	if url == "" {
+		ctx.String(http.StatusBadRequest, _ERROR_MESSAGE)
+		return
+	}
+	options, _ := extractParams(ctx)
+	if options == nil {
+		return
+	}
+	if related := sack.Related(url, options); related != nil {
+		ctx.JSON(http.StatusOK, related)
+	} else {
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// GET /beans/engagement?url=https://example.com/article&window=6h. no window or date range means the last 2 days
+func beanEngagementHandler(ctx *gin.Context) {
+	url := ctx.Query("url")
+	if url == "" {
+		ctx.String(http.StatusBadRequest, _ERROR_MESSAGE)
+		return
+	}
+	options, _ := extractParams(ctx)
+	if options == nil {
+		return
+	}
+	if engagement := sack.GetEngagement(url, options); engagement != nil {
+		ctx.JSON(http.StatusOK, engagement)
+	} else {
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// GET /beans/facets?field=source&field=updated&field=source_day&window=7. no field means all of them. no window or date range means the last 7 days
+func beanFacetsHandler(ctx *gin.Context) {
+	options, _ := extractParams(ctx)
+	if options == nil {
+		return
+	}
+	facets, err := sack.Facets(options, ctx.QueryArray("field"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, facets)
+}
+
 func trendingNuggetsHandler(ctx *gin.Context) {
 	options, _ := extractParams(ctx)
 	if options == nil {
@@ -96,6 +219,164 @@ func trendingNuggetsHandler(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, sack.TrendingNuggets(options))
 }
 
+// ?stream=true sends the answer as server-sent events: "answer" events with the chunks as they are generated and
+// a final "sources" event with the cited beans
+func answersHandler(ctx *gin.Context) {
+	options, _ := extractParams(ctx)
+	if options == nil {
+		return
+	}
+	question := options.Context
+	if question == "" {
+		ctx.String(http.StatusBadRequest, _ERROR_MESSAGE)
+		return
+	}
+
+	if ctx.Query("stream") != "true" {
+		if answer := sack.Ask(question, options); answer.Answer != "" {
+			ctx.JSON(http.StatusOK, answer)
+		} else {
+			ctx.Status(http.StatusNoContent)
+		}
+		return
+	}
+	streamAnswer(ctx, func(stream func(chunk string)) sack.Answer {
+		return sack.AskStream(question, options, stream)
+	})
+}
+
+// streams the chunks of the answer as they come and then the sources it cites.
+// The event stream starts with the first chunk so that no beans to answer from or a deferred LLM get a 204 like the non-stream answers do
+func streamAnswer(ctx *gin.Context, ask func(stream func(chunk string)) sack.Answer) {
+	started := false
+	start := func() {
+		if !started {
+			ctx.Header("Content-Type", "text/event-stream")
+			ctx.Header("Cache-Control", "no-cache")
+			started = true
+		}
+	}
+	answer := ask(func(chunk string) {
+		start()
+		ctx.SSEvent("answer", chunk)
+		ctx.Writer.Flush()
+	})
+	if !started {
+		if answer.Answer == "" {
+			ctx.Status(http.StatusNoContent)
+			return
+		}
+		// the answer came in one piece
+		start()
+		ctx.SSEvent("answer", answer.Answer)
+	}
+	if answer.Sources == nil {
+		answer.Sources = []sack.AnswerSource{}
+	}
+	ctx.SSEvent("sources", answer.Sources)
+}
+
+// GET /briefings/latest?window=1d&category=ai&since=2024-05-01&format=markdown. format is one of json, markdown, html. empty means json.
+// The window is the days the briefing covers. since and until pick from the briefings generated in that range
+func latestBriefingHandler(ctx *gin.Context) {
+	days, hours, window_err := parseTimeWindow(ctx.DefaultQuery("window", "1"))
+	since, since_err := parseISODate(ctx.Query("since"))
+	until, until_err := parseISODate(ctx.Query("until"))
+	// the briefings are generated per day
+	if window_err != nil || since_err != nil || until_err != nil || hours > 0 {
+		ctx.String(http.StatusBadRequest, _ERROR_MESSAGE)
+		return
+	}
+	briefing := sack.GetLatestBriefing(days, ctx.QueryArray("category"), since, until)
+	if briefing == nil {
+		ctx.Status(http.StatusNoContent)
+		return
+	}
+	switch ctx.Query("format") {
+	case sack.MARKDOWN_FORMAT:
+		ctx.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(briefing.ToMarkdown()))
+	case sack.HTML_FORMAT:
+		ctx.Data(http.StatusOK, "text/html; charset=utf-8", []byte(briefing.ToHTML()))
+	default:
+		ctx.JSON(http.StatusOK, briefing)
+	}
+}
+
+func getEntitiesHandler(ctx *gin.Context) {
+	names := ctx.QueryArray("name")
+	if len(names) == 0 {
+		ctx.String(http.StatusBadRequest, _ERROR_MESSAGE)
+		return
+	}
+	ctx.JSON(http.StatusOK, sack.GetEntities(names))
+}
+
+func trendingEntitiesHandler(ctx *gin.Context) {
+	options, _ := extractParams(ctx)
+	if options == nil {
+		return
+	}
+	ctx.JSON(http.StatusOK, sack.TrendingEntities(options))
+}
+
+func getCategoriesHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, sack.GetCategories())
+}
+
+func nlpStatsHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, nlp.GetUsageStats())
+}
+
+// POST /subscriptions {"name": "ransomware", "owner": "alice", "q": "keyword:ransomware", "notify": [{"notifier": "slack", "target": "https://hooks.slack.com/..."}]}
+func saveSubscriptionHandler(ctx *gin.Context) {
+	var sub sack.Subscription
+	if ctx.ShouldBindJSON(&sub) != nil {
+		ctx.String(http.StatusBadRequest, _ERROR_MESSAGE)
+		return
+	}
+	if err := sack.SaveSubscription(&sub); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, sub)
+}
+
+// GET /subscriptions?owner=alice
+func getSubscriptionsHandler(ctx *gin.Context) {
+	owner := ctx.Query("owner")
+	if owner == "" {
+		ctx.String(http.StatusBadRequest, _ERROR_MESSAGE)
+		return
+	}
+	if subs := sack.GetSubscriptions(owner); len(subs) > 0 {
+		ctx.JSON(http.StatusOK, subs)
+	} else {
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// DELETE /subscriptions?owner=alice&name=ransomware
+func deleteSubscriptionHandler(ctx *gin.Context) {
+	owner, name := ctx.Query("owner"), ctx.Query("name")
+	if owner == "" || name == "" {
+		ctx.String(http.StatusBadRequest, _ERROR_MESSAGE)
+		return
+	}
+	sack.DeleteSubscription(owner, name)
+	ctx.String(http.StatusOK, _SUCCESS_MESSAGE)
+}
+
+// the subscriptions carry the delivery targets of their owners and the stats carry the NLP spend so they aren't open to public
+func initializeAPIKeyAuth(api_key string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(ctx.GetHeader("X-API-Key")), []byte(api_key)) == 1 {
+			ctx.Next()
+		} else {
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+		}
+	}
+}
+
 func initializeRateLimiter() gin.HandlerFunc {
 	limiter := rate.NewLimiter(_RATE_LIMIT, _RATE_TPS)
 	return func(ctx *gin.Context) {
@@ -107,6 +388,30 @@ func initializeRateLimiter() gin.HandlerFunc {
 	}
 }
 
+// days such as 7 or 7d, or hours such as 6h. empty is no window
+func parseTimeWindow(window string) (int, int, error) {
+	if window == "" {
+		return 0, 0, nil
+	}
+	if hours, ok := strings.CutSuffix(window, "h"); ok {
+		num, err := strconv.Atoi(hours)
+		return 0, num, err
+	}
+	num, err := strconv.Atoi(strings.TrimSuffix(window, "d"))
+	return num, 0, err
+}
+
+// empty is the zero time
+func parseISODate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
 func sendBeans(res []sack.Bean, ctx *gin.Context) {
 	if len(res) > 0 {
 		ctx.JSON(http.StatusOK, res)
@@ -121,14 +426,41 @@ func newCDNServer() *gin.Engine {
 	// NO NEED FOR AUTH: this is open to public
 	open_group := router.Group("/")
 	open_group.Use(initializeRateLimiter())
-	// GET /beans
+	// GET /beans?urls=https://example.com/article&q=source:reddit%20kind:post
 	open_group.GET("/beans", retrieveBeansHandler)
-	// GET /beans/trending?window=1
+	// GET /beans/trending?window=4h&scorer=classic&sort=velocity
 	open_group.GET("/beans/trending", trendingBeansHandler)
-	// GET /beans/search?window=1
+	// GET /beans/related?url=https://example.com/article&window=7
+	open_group.GET("/beans/related", relatedBeansHandler)
+	// GET /beans/engagement?url=https://example.com/article&window=6h
+	open_group.GET("/beans/engagement", beanEngagementHandler)
+	// GET /beans/facets?field=source&field=updated&window=7&q=topic:cybersecurity
+	open_group.GET("/beans/facets", beanFacetsHandler)
+	// GET /beans/search?window=1&q=keyword:ransomware%20-source:medium
 	open_group.GET("/beans/search", searchBeansHandler)
 	// GET /nuggets/trending?window=1
 	open_group.GET("/nuggets/trending", trendingNuggetsHandler)
+	// GET /briefings/latest?window=1d&since=2024-05-01&format=markdown
+	open_group.GET("/briefings/latest", latestBriefingHandler)
+	// GET /entities?name=OpenAI&name=Open AI
+	open_group.GET("/entities", getEntitiesHandler)
+	// GET /entities/trending?window=1
+	open_group.GET("/entities/trending", trendingEntitiesHandler)
+	// GET /categories
+	open_group.GET("/categories", getCategoriesHandler)
+
+	if api_key := getAdminAPIKey(); api_key != "" {
+		auth_group := router.Group("/")
+		auth_group.Use(initializeAPIKeyAuth(api_key))
+		// GET /stats/nlp
+		auth_group.GET("/stats/nlp", nlpStatsHandler)
+		// every answer is an LLM call so it is not open to public
+		// POST /answers?window=7 {"question": "what happened with OpenAI this week?"}
+		auth_group.POST("/answers", answersHandler)
+		auth_group.POST("/subscriptions", saveSubscriptionHandler)
+		auth_group.GET("/subscriptions", getSubscriptionsHandler)
+		auth_group.DELETE("/subscriptions", deleteSubscriptionHandler)
+	}
 
 	return router
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	sack "github.com/soumitsalman/coffeemaker/sdk/beansack"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	tests := []struct {
+		window   string
+		days     int
+		hours    int
+		want_err bool
+	}{
+		{"", 0, 0, false},
+		{"7", 7, 0, false},
+		{"7d", 7, 0, false},
+		{"6h", 0, 6, false},
+		{"h", 0, 0, true},
+		{"2w", 0, 0, true},
+		{"six", 0, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.window, func(t *testing.T) {
+			days, hours, err := parseTimeWindow(test.window)
+			if (err != nil) != test.want_err {
+				t.Fatalf("parseTimeWindow(%q) error = %v, want an error: %v", test.window, err, test.want_err)
+			}
+			if !test.want_err && (days != test.days || hours != test.hours) {
+				t.Errorf("parseTimeWindow(%q) = %d days %d hours, want %d days %d hours", test.window, days, hours, test.days, test.hours)
+			}
+		})
+	}
+}
+
+func TestParseISODate(t *testing.T) {
+	tests := []struct {
+		value    string
+		want     time.Time
+		want_err bool
+	}{
+		{"", time.Time{}, false},
+		{"2024-03-01", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), false},
+		{"2024-03-01T12:30:00Z", time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), false},
+		{"2024-03-01T12:30:00+02:00", time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC), false},
+		{"2024-13-01", time.Time{}, true},
+		{"03/01/2024", time.Time{}, true},
+		{"1709251200", time.Time{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := parseISODate(test.value)
+			if (err != nil) != test.want_err {
+				t.Fatalf("parseISODate(%q) error = %v, want an error: %v", test.value, err, test.want_err)
+			}
+			if !test.want_err && !got.Equal(test.want) {
+				t.Errorf("parseISODate(%q) = %v, want %v", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestStreamAnswer(t *testing.T) {
+	sources := []sack.AnswerSource{{Index: 1, Url: "https://example.com/a"}}
+	tests := []struct {
+		name        string
+		chunks      []string
+		answer      sack.Answer
+		want_status int
+		want_events []string // the events in order
+		want_body   []string // in the body
+	}{
+		{"nothing to answer from", nil, sack.Answer{Question: "q"}, http.StatusNoContent, nil, nil},
+		{"streamed", []string{"A ", "[1]"}, sack.Answer{Answer: "A [1]", Sources: sources}, http.StatusOK, []string{"answer", "answer", "sources"}, []string{"data:A ", "data:[1]", "https://example.com/a"}},
+		{"in one piece", nil, sack.Answer{Answer: "A [1]", Sources: sources}, http.StatusOK, []string{"answer", "sources"}, []string{"data:A [1]"}},
+		{"no citations", []string{"A"}, sack.Answer{Answer: "A"}, http.StatusOK, []string{"answer", "sources"}, []string{"data:[]"}},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := gin.New()
+			router.POST("/answers", func(ctx *gin.Context) {
+				streamAnswer(ctx, func(stream func(chunk string)) sack.Answer {
+					for _, chunk := range test.chunks {
+						stream(chunk)
+					}
+					return test.answer
+				})
+			})
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/answers?stream=true", nil))
+
+			if recorder.Code != test.want_status {
+				t.Fatalf("status = %d, want %d", recorder.Code, test.want_status)
+			}
+			body := recorder.Body.String()
+			if test.want_status == http.StatusNoContent {
+				if body != "" || recorder.Header().Get("Content-Type") == "text/event-stream" {
+					t.Errorf("streamAnswer() wrote an event stream %q, want nothing", body)
+				}
+				return
+			}
+			if content_type := recorder.Header().Get("Content-Type"); content_type != "text/event-stream" {
+				t.Errorf("Content-Type = %q, want text/event-stream", content_type)
+			}
+			var events []string
+			for _, line := range strings.Split(body, "\n") {
+				if event, ok := strings.CutPrefix(line, "event:"); ok {
+					events = append(events, event)
+				}
+			}
+			if strings.Join(events, ",") != strings.Join(test.want_events, ",") {
+				t.Errorf("events = %v, want %v", events, test.want_events)
+			}
+			for _, want := range test.want_body {
+				if !strings.Contains(body, want) {
+					t.Errorf("body = %q, want %q in it", body, want)
+				}
+			}
+		})
+	}
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"log"
 	"os"
 	"strconv"
+	"strings"
+
+	sack "github.com/soumitsalman/coffeemaker/sdk/beansack"
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
 )
 
 // defaults
@@ -31,6 +37,128 @@ func getEmbedderCtx() int {
 	return num
 }
 
+// one of: memory, disk, store. empty means no caching
+func getNLPCache() string {
+	return os.Getenv("NLP_CACHE")
+}
+
+func getNLPCacheDir() string {
+	return os.Getenv("NLP_CACHE_DIR")
+}
+
+// json file of model name -> {"prompt": $ per 1M tokens, "completion": $ per 1M tokens}
+func getNLPPriceTable() map[string]nlp.Price {
+	var prices map[string]nlp.Price
+	filepath := os.Getenv("NLP_PRICES_FILE")
+	if filepath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath)
+	if err == nil {
+		err = json.Unmarshal(data, &prices)
+	}
+	if err != nil {
+		log.Println("[config] Failed loading NLP price table.", err)
+	}
+	return prices
+}
+
+// json file of nlp.RateLimit keyed by model name
+func getNLPRateLimits() map[string]nlp.RateLimit {
+	var limits map[string]nlp.RateLimit
+	filepath := os.Getenv("NLP_RATE_LIMITS_FILE")
+	if filepath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath)
+	if err == nil {
+		err = json.Unmarshal(data, &limits)
+	}
+	if err != nil {
+		log.Println("[config] Failed loading NLP rate limits.", err)
+	}
+	return limits
+}
+
+// daily budget in dollars. 0 means no budget
+func getNLPDailyBudget() float64 {
+	num, err := strconv.ParseFloat(os.Getenv("NLP_DAILY_BUDGET"), 64)
+	if err != nil {
+		return 0
+	}
+	return num
+}
+
+func getPromptsDir() string {
+	return os.Getenv("PROMPTS_DIR")
+}
+
+// prompt version per task. empty means the built-in default
+func getPromptVersions() map[string]string {
+	return map[string]string{
+		nlp.DIGEST_TASK:      os.Getenv("DIGEST_PROMPT_VERSION"),
+		nlp.CONCEPTS_TASK:    os.Getenv("CONCEPTS_PROMPT_VERSION"),
+		nlp.SENTIMENT_TASK:   os.Getenv("SENTIMENT_PROMPT_VERSION"),
+		nlp.ENTITIES_TASK:    os.Getenv("ENTITIES_PROMPT_VERSION"),
+		nlp.TRANSLATION_TASK: os.Getenv("TRANSLATION_PROMPT_VERSION"),
+	}
+}
+
+// one of: stuff, map_reduce, refine. empty means stuff
+func getSummarizationMode() string {
+	return os.Getenv("SUMMARIZATION_MODE")
+}
+
+// one of: llm, lexicon. empty means llm
+func getSentimentMode() string {
+	return os.Getenv("SENTIMENT_MODE")
+}
+
+func getTaxonomyFile() string {
+	return os.Getenv("TAXONOMY_FILE")
+}
+
+// comma separated <language>:<policy> such as "en:keep,es:translate,*:drop"
+// policy is one of keep, drop, translate. languages without a policy are kept
+func getLanguagePolicy() map[string]string {
+	policy := make(map[string]string)
+	for _, item := range strings.Split(os.Getenv("LANGUAGE_POLICY"), ",") {
+		if lang, action, ok := strings.Cut(strings.TrimSpace(item), ":"); ok {
+			policy[strings.TrimSpace(lang)] = strings.TrimSpace(action)
+		}
+	}
+	return policy
+}
+
+// "local" swaps the embedder and the LLM service for the offline deterministic stand-ins. empty means the live services
+func getNLPMode() string {
+	return os.Getenv("NLP_MODE")
+}
+
+// dimensions of the local embeddings. 0 means the default
+func getLocalEmbedderDim() int {
+	num, _ := strconv.Atoi(os.Getenv("LOCAL_EMBEDDER_DIM"))
+	return num
+}
+
+// json file of the trend scoring weights. The missing weights keep their defaults. nil means the default weights
+func getTrendWeights() *sack.TrendWeights {
+	filepath := os.Getenv("TREND_WEIGHTS_FILE")
+	if filepath == "" {
+		return nil
+	}
+	weights := sack.DefaultTrendWeights()
+	data, err := os.ReadFile(filepath)
+	if err == nil {
+		err = json.Unmarshal(data, &weights)
+	}
+	if err != nil {
+		log.Println("[config] Failed loading trend weights.", err)
+		return nil
+	}
+	return &weights
+}
+
 func getSitemaps() string {
 	return os.Getenv("SITEMAPS_FILE")
 }
@@ -68,3 +196,48 @@ func getCleanupSchedule() string {
 	}
 	return schedule
 }
+
+// schedule of the daily briefing. empty means no daily briefing
+func getDailyBriefingSchedule() string {
+	return os.Getenv("DAILY_BRIEFING_SCHEDULE")
+}
+
+// schedule of the weekly briefing. empty means no weekly briefing
+func getWeeklyBriefingSchedule() string {
+	return os.Getenv("WEEKLY_BRIEFING_SCHEDULE")
+}
+
+// comma separated ids of the categories the briefings are limited to. empty means everything
+func getBriefingCategories() []string {
+	var categories []string
+	for _, item := range strings.Split(os.Getenv("BRIEFING_CATEGORIES"), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			categories = append(categories, item)
+		}
+	}
+	return categories
+}
+
+// SMTP server for the email alerts of the subscriptions. nil means no email alerts
+func getSMTPConfig() *sack.SMTPConfig {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil
+	}
+	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	if err != nil {
+		port = 587
+	}
+	return &sack.SMTPConfig{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+}
+
+// key for the CDN endpoints that are not open to public such as the subscriptions and the NLP stats. empty means those endpoints are off
+func getAdminAPIKey() string {
+	return os.Getenv("ADMIN_API_KEY")
+}
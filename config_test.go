@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
+)
+
+// every task with a prompt can be pinned to a version
+func TestGetPromptVersions(t *testing.T) {
+	t.Setenv("TRANSLATION_PROMPT_VERSION", "v1")
+	versions := getPromptVersions()
+	for task := range nlp.DefaultPromptTemplates() {
+		if _, ok := versions[task]; !ok {
+			t.Errorf("getPromptVersions() = %v, want a version of %s", versions, task)
+		}
+	}
+	if versions[nlp.TRANSLATION_TASK] != "v1" {
+		t.Errorf("translation version = %q, want the TRANSLATION_PROMPT_VERSION", versions[nlp.TRANSLATION_TASK])
+	}
+}
@@ -32,8 +32,8 @@ func RunDebug() {
 	// nc := news.NewCollector(_SITEMAPS_PATH, sdk.AddBeans)
 	// nc.Collect()
 
-	// initialize the services
-	// if err := sdk.InitializeBeanSack(getDBConnectionString(), getLLMServiceAPIKey()); err != nil {
+	// main initializes the services with NLP_MODE before this. To re-initialize with the local NLP stand-ins:
+	// if err := sack.InitializeBeanSack(getDBConnectionString(), getEmbedderUrl(), getEmbedderCtx(), getLLMServiceAPIKey(), sack.WithLocalNLP(getLocalEmbedderDim())); err != nil {
 	// 	log.Fatalln("initialization not working", err)
 	// }
 
@@ -15,7 +15,7 @@ import (
 
 func Retrieval() {
 	// initialize the services
-	if err := beansack.InitializeBeanSack(os.Getenv("DB_CONNECTION_STRING"), os.Getenv("EMBEDDER_URL"), getEmbedderCtx(), os.Getenv("LLMSERVICE_API_KEY")); err != nil {
+	if err := beansack.InitializeBeanSack(os.Getenv("DB_CONNECTION_STRING"), os.Getenv("EMBEDDER_URL"), getEmbedderCtx(), os.Getenv("LLMSERVICE_API_KEY"), getBeanSackOptions()...); err != nil {
 		log.Fatalln("initialization not working", err)
 	}
 
@@ -41,7 +41,7 @@ func Retrieval() {
 
 func Search() {
 	// initialize the services
-	if err := beansack.InitializeBeanSack(os.Getenv("DB_CONNECTION_STRING"), os.Getenv("EMBEDDER_URL"), getEmbedderCtx(), os.Getenv("LLMSERVICE_API_KEY")); err != nil {
+	if err := beansack.InitializeBeanSack(os.Getenv("DB_CONNECTION_STRING"), os.Getenv("EMBEDDER_URL"), getEmbedderCtx(), os.Getenv("LLMSERVICE_API_KEY"), getBeanSackOptions()...); err != nil {
 		log.Fatalln("initialization not working", err)
 	}
 
@@ -89,8 +89,15 @@ func Nlp() {
 	// }
 	inputs := datautils.Transform(getBeans("./examples/data/dataset2.json"), func(item *beansack.Bean) string { return nlp.TruncateTextOnTokenCount(item.Text, getEmbedderCtx()) })
 
+	var embed nlp.Embedder
+	var pb nlp.Extractor
+	if isLocalNLP() {
+		embed, pb = nlp.NewHashingEmbedder(getLocalEmbedderDim()), nlp.NewLocalExtractor()
+	} else {
+		embed, pb = nlp.NewLlamaFileDriver(os.Getenv("EMBEDDER_URL"), getEmbedderCtx()), nlp.NewParrotboxClient(os.Getenv("LLMSERVICE_API_KEY"), nil)
+	}
+
 	// for embeddings
-	embed := nlp.NewLlamaFileDriver(os.Getenv("EMBEDDER_URL"), getEmbedderCtx())
 	start_time := time.Now()
 	res := datautils.ForEach(embed.CreateBatchTextEmbeddings(inputs, nlp.SEARCH_DOCUMENT), func(emb *[]float32) {
 		if len(*emb) == 0 {
@@ -103,8 +110,6 @@ func Nlp() {
 	log.Printf("%d embeddings generated in %ds. Avg %f\n", len(res), dur, float32(dur)/float32(len(res)))
 
 	// for keyconcepts and digests
-	pb := nlp.NewParrotboxClient(os.Getenv("LLMSERVICE_API_KEY"))
-
 	digests := pb.ExtractDigests(inputs)
 	fmt.Println(datautils.ToJsonString(digests))
 
@@ -115,7 +120,7 @@ func Nlp() {
 func NewBeans() {
 	beans := getBeans("./examples/data/dataset1.json")
 	// initialize the services
-	if err := beansack.InitializeBeanSack(os.Getenv("DB_CONNECTION_STRING"), os.Getenv("EMBEDDER_URL"), getEmbedderCtx(), os.Getenv("LLMSERVICE_API_KEY")); err != nil {
+	if err := beansack.InitializeBeanSack(os.Getenv("DB_CONNECTION_STRING"), os.Getenv("EMBEDDER_URL"), getEmbedderCtx(), os.Getenv("LLMSERVICE_API_KEY"), getBeanSackOptions()...); err != nil {
 		log.Fatalln("Beansack initialization not working.", err)
 	}
 	log.Println(len(beans), "New Beans")
@@ -136,6 +141,23 @@ func getBeans(dataset string) []beansack.Bean {
 	return beans
 }
 
+// NLP_MODE=local runs the examples offline with the local stand-ins of the embedder and the LLM service
+func isLocalNLP() bool {
+	return os.Getenv("NLP_MODE") == "local"
+}
+
+func getBeanSackOptions() []beansack.BeanSackOption {
+	if isLocalNLP() {
+		return []beansack.BeanSackOption{beansack.WithLocalNLP(getLocalEmbedderDim())}
+	}
+	return nil
+}
+
+func getLocalEmbedderDim() int {
+	dim, _ := strconv.Atoi(os.Getenv("LOCAL_EMBEDDER_DIM"))
+	return dim
+}
+
 func getEmbedderCtx() int {
 	ctx, _ := strconv.Atoi(os.Getenv("EMBEDDER_CTX"))
 	return ctx
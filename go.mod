@@ -16,6 +16,7 @@ require (
 	github.com/soumitsalman/data-utils v0.0.0-20240411181743-1067a6fce2ca
 	github.com/tmc/langchaingo v0.1.10
 	go.mongodb.org/mongo-driver v1.15.0
+	golang.org/x/net v0.25.0
 )
 
 require (
@@ -95,7 +96,6 @@ require (
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	golang.org/x/arch v0.8.0 // indirect
 	golang.org/x/crypto v0.23.0 // indirect
-	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 	golang.org/x/text v0.15.0 // indirect
 	golang.org/x/time v0.5.0
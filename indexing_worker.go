@@ -2,11 +2,14 @@ package main
 
 import (
 	"log"
+	"time"
 
 	"github.com/robfig/cron"
 	sack "github.com/soumitsalman/coffeemaker/sdk/beansack"
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
 	news "github.com/soumitsalman/coffeemaker/sdk/newscollector"
 	reddit "github.com/soumitsalman/coffeemaker/sdk/redditor"
+	datautils "github.com/soumitsalman/data-utils"
 )
 
 func StartIndexer() {
@@ -23,12 +26,19 @@ func StartIndexer() {
 	c.AddFunc(getCollectionSchedule(), func() {
 		// start collection session
 		coll_session <- true
+		run_start := time.Now().Unix()
 		log.Println("[INDEXER] Running news collector")
 		nc.Collect()
 		log.Println("[INDEXER] Running reddit collector")
 		rc.Collect()
 		// finish collection session so that the next session can continue
 		sack.Rectify()
+		log.Println("[INDEXER] Alerting subscribers")
+		sack.AlertSubscribers(run_start)
+		// the nuggets and the sentiments of the run are still being generated in the background
+		sack.WaitForBackgroundWork()
+		usage := nlp.CompleteUsageRun()
+		log.Printf("[INDEXER] NLP usage for the collection run: $%f. %s\n", usage.Cost, datautils.ToJsonString(usage.Models))
 		<-coll_session
 	})
 
@@ -45,5 +55,19 @@ func StartIndexer() {
 		sack.Cleanup(30)
 	})
 
+	// generate briefings
+	if schedule := getDailyBriefingSchedule(); schedule != "" {
+		c.AddFunc(schedule, func() {
+			log.Println("[INDEXER] Generating daily briefing")
+			sack.GenerateBriefing(1, getBriefingCategories())
+		})
+	}
+	if schedule := getWeeklyBriefingSchedule(); schedule != "" {
+		c.AddFunc(schedule, func() {
+			log.Println("[INDEXER] Generating weekly briefing")
+			sack.GenerateBriefing(7, getBriefingCategories())
+		})
+	}
+
 	c.Start()
 }
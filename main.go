@@ -5,12 +5,35 @@ import (
 
 	"github.com/joho/godotenv"
 	sack "github.com/soumitsalman/coffeemaker/sdk/beansack"
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
 )
 
+const _LOCAL_NLP_MODE = "local"
+
 func main() {
 	godotenv.Load()
 
-	if err := sack.InitializeBeanSack(getDBConnectionString(), getEmbedderUrl(), getEmbedderCtx(), getLLMServiceAPIKey()); err != nil {
+	nlp.SetPriceTable(getNLPPriceTable())
+	nlp.SetDailyBudget(getNLPDailyBudget())
+	nlp.SetRateLimits(getNLPRateLimits())
+
+	opts := []sack.BeanSackOption{
+		sack.WithNLPCache(getNLPCache(), getNLPCacheDir()),
+		sack.WithPrompts(getPromptsDir(), getPromptVersions()),
+		sack.WithSummarizationMode(getSummarizationMode()),
+		sack.WithSentimentMode(getSentimentMode()),
+		sack.WithTaxonomy(getTaxonomyFile()),
+		sack.WithLanguagePolicy(getLanguagePolicy()),
+		sack.WithTrendWeights(getTrendWeights()),
+	}
+	if smtp_config := getSMTPConfig(); smtp_config != nil {
+		opts = append(opts, sack.WithNotifier(sack.EMAIL_NOTIFIER, sack.NewEmailNotifier(*smtp_config)))
+	}
+	if getNLPMode() == _LOCAL_NLP_MODE {
+		log.Println("Running with local NLP.")
+		opts = append(opts, sack.WithLocalNLP(getLocalEmbedderDim()))
+	}
+	if err := sack.InitializeBeanSack(getDBConnectionString(), getEmbedderUrl(), getEmbedderCtx(), getLLMServiceAPIKey(), opts...); err != nil {
 		log.Fatalln("Initialization not working", err)
 	}
 
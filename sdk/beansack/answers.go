@@ -0,0 +1,102 @@
+package beansack
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
+	datautils "github.com/soumitsalman/data-utils"
+)
+
+var _CITATION_EXPR = regexp.MustCompile(`\[(\d+)\]`)
+
+// An answer generated from the beans. The answer cites its sources inline as [n] where n is the Index of the source
+type Answer struct {
+	Question string         `json:"question"`
+	Answer   string         `json:"answer,omitempty"`
+	Sources  []AnswerSource `json:"sources,omitempty"`
+}
+
+type AnswerSource struct {
+	Index   int    `json:"index"`
+	Url     string `json:"url"`
+	Title   string `json:"title,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Updated int64  `json:"updated,omitempty"`
+}
+
+// Answers the question from the collected beans.
+// Algorithm:
+//  1. Retrieve the beans matching the question through FuzzySearch. The options.Context is set to the question if it is empty
+//  2. Retrieve the beans of the nuggets and the entities named in the question through NuggetSearch
+//  3. Pack the summaries of the beans within the LLM window and generate an answer that cites them
+//  4. Return the answer along with the beans it cited
+func Ask(question string, options *SearchOptions) Answer {
+	return AskStream(question, options, nil)
+}
+
+// Same as Ask but the answer is sent to stream in chunks as it is generated
+func AskStream(question string, options *SearchOptions, stream func(chunk string)) Answer {
+	answer := Answer{Question: question}
+	beans := retrieveForAnswer(question, options)
+	if len(beans) == 0 {
+		log.Println("[beanops] No beans found to answer:", question)
+		return answer
+	}
+	if deferNLPWork(pb_client.ModelName(), "answer", 1) {
+		return answer
+	}
+
+	answer.Answer = pb_client.Answer(question, toAnswerDocuments(beans), stream)
+	answer.Sources = getCitedSources(answer.Answer, beans)
+	return answer
+}
+
+func retrieveForAnswer(question string, options *SearchOptions) []Bean {
+	if options == nil {
+		options = NewSearchOptions()
+	}
+	if options.Context == "" {
+		options.Context = question
+	}
+	beans := FuzzySearch(options)
+	if names := nlp.ExtractNames(question); len(names) > 0 {
+		beans = append(beans, NuggetSearch(names, options)...)
+	}
+	return filterAnswerBeans(beans)
+}
+
+// the summarized beans without the ones both searches found. The beans that are not summarized yet have nothing to answer from
+func filterAnswerBeans(beans []Bean) []Bean {
+	urls := make([]string, 0, len(beans))
+	return datautils.Filter(beans, func(item *Bean) bool {
+		if item.Summary == "" || slices.Contains(urls, item.Url) {
+			return false
+		}
+		urls = append(urls, item.Url)
+		return true
+	})
+}
+
+func toAnswerDocuments(beans []Bean) []string {
+	return datautils.Transform(beans, func(item *Bean) string { return fmt.Sprintf("TITLE: %s\nSUMMARY: %s", item.Title, item.Summary) })
+}
+
+// the beans cited in the answer in the order of their first citation
+func getCitedSources(answer string, beans []Bean) []AnswerSource {
+	var sources []AnswerSource
+	for _, match := range _CITATION_EXPR.FindAllStringSubmatch(answer, -1) {
+		index, err := strconv.Atoi(match[1])
+		if err != nil || index < 1 || index > len(beans) {
+			continue
+		}
+		if datautils.IndexAny(sources, func(item *AnswerSource) bool { return item.Index == index }) < 0 {
+			bean := &beans[index-1]
+			sources = append(sources, AnswerSource{Index: index, Url: bean.Url, Title: bean.Title, Source: bean.Source, Updated: bean.Updated})
+		}
+	}
+	return sources
+}
@@ -0,0 +1,77 @@
+package beansack
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetCitedSources(t *testing.T) {
+	beans := []Bean{
+		{Url: "a", Title: "A", Source: "example.com", Updated: 1},
+		{Url: "b", Title: "B"},
+		{Url: "c", Title: "C"},
+	}
+	tests := []struct {
+		name   string
+		answer string
+		want   []int // the indexes of the sources
+	}{
+		{"no citations", "Nothing to cite.", nil},
+		{"in the order of the first citation", "C happened [3]. Then A [1] and B [2].", []int{3, 1, 2}},
+		{"repeated", "A [1]. Again A [1] and C [3][1].", []int{1, 3}},
+		{"zero", "Nothing [0] here.", nil},
+		{"out of range", "A [1] and D [4] and more [42].", []int{1}},
+		{"not a number", "A [1] and [x] and [-2] and [ 2 ].", []int{1}},
+		{"too big to parse", "A [99999999999999999999999] and B [2].", []int{2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sources := getCitedSources(test.answer, beans)
+			var got []int
+			for _, source := range sources {
+				got = append(got, source.Index)
+				if bean := beans[source.Index-1]; source.Url != bean.Url || source.Title != bean.Title {
+					t.Errorf("source [%d] = %+v, want the bean %+v", source.Index, source, bean)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("getCitedSources() = %v, want %v", got, test.want)
+			}
+		})
+	}
+	want := AnswerSource{Index: 1, Url: "a", Title: "A", Source: "example.com", Updated: 1}
+	if got := getCitedSources("[1]", beans); !reflect.DeepEqual(got, []AnswerSource{want}) {
+		t.Errorf("getCitedSources() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFilterAnswerBeans(t *testing.T) {
+	tests := []struct {
+		name  string
+		beans []Bean
+		want  []string
+	}{
+		{"nothing", nil, []string{}},
+		{"not summarized yet", []Bean{{Url: "a", Summary: "s"}, {Url: "b"}, {Url: "c", Summary: "s"}}, []string{"a", "c"}},
+		{"found by both searches", []Bean{{Url: "a", Summary: "s"}, {Url: "b", Summary: "s"}, {Url: "a", Summary: "s"}}, []string{"a", "b"}},
+		{"summarized after the first one", []Bean{{Url: "a"}, {Url: "a", Summary: "s"}}, []string{"a"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, bean := range filterAnswerBeans(test.beans) {
+				got = append(got, bean.Url)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("filterAnswerBeans() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestToAnswerDocuments(t *testing.T) {
+	got := toAnswerDocuments([]Bean{{Title: "A", Summary: "about a"}})
+	if want := []string{"TITLE: A\nSUMMARY: about a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("toAnswerDocuments() = %q, want %q", got, want)
+	}
+}
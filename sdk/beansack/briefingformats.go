@@ -0,0 +1,100 @@
+package beansack
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"strings"
+	"time"
+)
+
+// briefing output formats
+const (
+	JSON_FORMAT     = "json"
+	MARKDOWN_FORMAT = "markdown"
+	HTML_FORMAT     = "html"
+)
+
+var (
+	// the titles and the urls of the links can't end the link text or the destination early
+	_MARKDOWN_LINK_TEXT = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`)
+	_MARKDOWN_LINK_URL  = strings.NewReplacer("(", "%28", ")", "%29", " ", "%20")
+)
+
+var _BRIEFING_HTML = template.Must(template.New("briefing").Funcs(template.FuncMap{"title": getBriefingTitle}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{title .}}</title></head>
+<body>
+<h1>{{title .}}</h1>
+{{range .Sections}}<section>
+<h2>{{.Title}}</h2>
+{{if .Overview}}<p>{{.Overview}}</p>
+{{end}}{{if .Nuggets}}<h3>Key Developments</h3>
+<ul>
+{{range .Nuggets}}<li><strong>{{.KeyPhrase}}</strong>: {{.Description}}</li>
+{{end}}</ul>
+{{end}}{{if .Sources}}<h3>Sources</h3>
+<ol>
+{{range .Sources}}<li><a href="{{.Url}}">{{.Title}}</a>{{if .Source}} ({{.Source}}){{end}}{{with .MediaNoise}} &middot; {{.ThumbsupCount}} likes &middot; {{.Comments}} comments{{end}}</li>
+{{end}}</ol>
+{{end}}</section>
+{{end}}</body>
+</html>
+`))
+
+// Renders the briefing as Markdown. The inline citations [n] of the overviews point to the numbered sources of the section
+func (briefing *Briefing) ToMarkdown() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "# %s\n\n", getBriefingTitle(briefing))
+	for _, section := range briefing.Sections {
+		fmt.Fprintf(&buf, "## %s\n\n", section.Title)
+		if section.Overview != "" {
+			fmt.Fprintf(&buf, "%s\n\n", section.Overview)
+		}
+		if len(section.Nuggets) > 0 {
+			buf.WriteString("### Key Developments\n\n")
+			for _, nugget := range section.Nuggets {
+				fmt.Fprintf(&buf, "- **%s**: %s\n", nugget.KeyPhrase, nugget.Description)
+			}
+			buf.WriteString("\n")
+		}
+		if len(section.Sources) > 0 {
+			buf.WriteString("### Sources\n\n")
+			for i, source := range section.Sources {
+				fmt.Fprintf(&buf, "%d. [%s](%s)", i+1, _MARKDOWN_LINK_TEXT.Replace(source.Title), _MARKDOWN_LINK_URL.Replace(source.Url))
+				if source.Source != "" {
+					fmt.Fprintf(&buf, " (%s)", source.Source)
+				}
+				if source.MediaNoise != nil {
+					fmt.Fprintf(&buf, " · %d likes · %d comments", source.MediaNoise.ThumbsupCount, source.MediaNoise.Comments)
+				}
+				buf.WriteString("\n")
+			}
+			buf.WriteString("\n")
+		}
+	}
+	return buf.String()
+}
+
+// Renders the briefing as a standalone HTML page
+func (briefing *Briefing) ToHTML() string {
+	var buf bytes.Buffer
+	if err := _BRIEFING_HTML.Execute(&buf, briefing); err != nil {
+		log.Println("[briefing] Failed rendering HTML.", err)
+		return ""
+	}
+	return buf.String()
+}
+
+func getBriefingTitle(briefing *Briefing) string {
+	title := "Daily Briefing"
+	if briefing.Window > _ONE_DAY {
+		title = fmt.Sprintf("%d-Day Briefing", briefing.Window)
+	}
+	title = fmt.Sprintf("%s: %s", title, time.Unix(briefing.Created, 0).Format("January 2, 2006"))
+	if len(briefing.Categories) > 0 {
+		title = fmt.Sprintf("%s (%s)", title, strings.Join(briefing.Categories, ", "))
+	}
+	return title
+}
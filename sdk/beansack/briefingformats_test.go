@@ -0,0 +1,75 @@
+package beansack
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToMarkdown(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local).Unix()
+	briefing := &Briefing{
+		Window:  1,
+		Created: created,
+		Sections: []BriefingSection{
+			{
+				Title:    "Cybersecurity",
+				Overview: "A breach [1] and a patch [2].",
+				Nuggets:  []BeanNugget{{KeyPhrase: "Fortra", Description: "patched GoAnywhere"}},
+				Sources: []BriefingSource{
+					{Url: "https://example.com/a", Title: "Breach", Source: "example.com", MediaNoise: &MediaNoise{ThumbsupCount: 10, Comments: 2}},
+					{Url: "https://example.com/b", Title: "Patch"},
+				},
+			},
+			{Title: "Top Stories"},
+		},
+	}
+	want := `# Daily Briefing: May 1, 2024
+
+## Cybersecurity
+
+A breach [1] and a patch [2].
+
+### Key Developments
+
+- **Fortra**: patched GoAnywhere
+
+### Sources
+
+1. [Breach](https://example.com/a) (example.com) · 10 likes · 2 comments
+2. [Patch](https://example.com/b)
+
+## Top Stories
+
+`
+	if got := briefing.ToMarkdown(); got != want {
+		t.Errorf("ToMarkdown() = %q, want %q", got, want)
+	}
+
+	briefing = &Briefing{Window: 7, Created: created, Categories: []string{"ai", "sec"}}
+	if got := briefing.ToMarkdown(); got != "# 7-Day Briefing: May 1, 2024 (ai, sec)\n\n" {
+		t.Errorf("ToMarkdown() = %q, want the title of the window and the categories", got)
+	}
+}
+
+func TestToMarkdownEscapesLinks(t *testing.T) {
+	tests := []struct {
+		name   string
+		source BriefingSource
+		want   string
+	}{
+		{"plain", BriefingSource{Url: "https://example.com/a", Title: "A"}, "1. [A](https://example.com/a)"},
+		{"brackets in the title", BriefingSource{Url: "https://example.com/a", Title: "[Update] CVE-2024-0001 ] fixed"}, `1. [\[Update\] CVE-2024-0001 \] fixed](https://example.com/a)`},
+		{"backslash in the title", BriefingSource{Url: "https://example.com/a", Title: `C:\ drive`}, `1. [C:\\ drive](https://example.com/a)`},
+		{"parentheses in the url", BriefingSource{Url: "https://en.wikipedia.org/wiki/Go_(programming_language)", Title: "Go"}, "1. [Go](https://en.wikipedia.org/wiki/Go_%28programming_language%29)"},
+		{"space in the url", BriefingSource{Url: "https://example.com/a b", Title: "A"}, "1. [A](https://example.com/a%20b)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			briefing := &Briefing{Window: 1, Sections: []BriefingSection{{Title: "S", Sources: []BriefingSource{test.source}}}}
+			if got := briefing.ToMarkdown(); !strings.Contains(got, test.want+"\n") {
+				t.Errorf("ToMarkdown() = %q, want %q in it", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package beansack
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
+)
+
+const (
+	_BRIEFING_NUGGETS          = 50 // trending nuggets to pick the sections from
+	_BRIEFING_SECTIONS         = 6
+	_BRIEFING_SECTION_NUGGETS  = 5
+	_BRIEFING_SECTION_BEANS    = 5
+	_BRIEFING_GENERAL_SECTION  = "Top Stories" // for the nuggets whose beans are not classified
+	_BRIEFING_OVERVIEW_REQUEST = "Write a short narrative overview of the latest news about %s covering the main developments and why they matter."
+)
+
+// A briefing of the trending nuggets of a time window grouped into sections
+type Briefing struct {
+	ID         string            `json:"id" bson:"_id"` // <date>:<window>d[:<categories>]
+	Window     int               `json:"window" bson:"window"`
+	Categories []string          `json:"categories,omitempty" bson:"categories,omitempty"`
+	Created    int64             `json:"created" bson:"created"`
+	Sections   []BriefingSection `json:"sections,omitempty" bson:"sections,omitempty"`
+}
+
+type BriefingSection struct {
+	Title string `json:"title" bson:"title"`
+	// cites the beans inline as [n] where n is the 1-based position in Sources
+	Overview string           `json:"overview,omitempty" bson:"overview,omitempty"`
+	Nuggets  []BeanNugget     `json:"nuggets,omitempty" bson:"nuggets,omitempty"`
+	Sources  []BriefingSource `json:"sources,omitempty" bson:"sources,omitempty"` // the top beans of the section
+	beans    []Bean
+	score    int
+}
+
+// a bean in a briefing with its media noise. Bean itself doesn't store its media noise
+type BriefingSource struct {
+	Url        string      `json:"url" bson:"url"`
+	Title      string      `json:"title,omitempty" bson:"title,omitempty"`
+	Source     string      `json:"source,omitempty" bson:"source,omitempty"`
+	Updated    int64       `json:"updated,omitempty" bson:"updated,omitempty"`
+	Summary    string      `json:"summary,omitempty" bson:"summary,omitempty"`
+	MediaNoise *MediaNoise `json:"media_noise,omitempty" bson:"media_noise,omitempty"`
+}
+
+func getBriefingId(briefing *Briefing) store.JSON {
+	return store.JSON{"_id": briefing.ID}
+}
+
+func briefingEquals(a, b *Briefing) bool {
+	return a.ID == b.ID
+}
+
+// Generates a briefing of the last `window` days from the trending nuggets and stores it. This replaces the briefing of the same day.
+// categories are ids or names of the categories in the taxonomy to limit the briefing to. empty means everything.
+// Algorithm:
+//  1. Find the trending nuggets of the window and their beans that are in the categories
+//  2. Put each nugget in the section of the category most of its beans are in. Without a taxonomy everything goes in one section
+//  3. Rank the sections by the trend score of their nuggets and the beans of each section by their media noise
+//  4. Write an overview of each section from the summaries of its beans
+func GenerateBriefing(window int, categories []string) *Briefing {
+	window = checkAndFixTimeWindow(window)
+	categories = getCategoryIds(categories)
+	briefing := &Briefing{
+		ID:         getBriefingKey(time.Now(), window, categories),
+		Window:     window,
+		Categories: categories,
+		Created:    time.Now().Unix(),
+	}
+
+	// 1. find the trending nuggets and their beans
+	// the trending nuggets don't filter by categories so there are more candidates to find the ones of the categories
+	topn := _BRIEFING_NUGGETS
+	if len(categories) > 0 {
+		topn = _MAX_TOPN
+	}
+	nuggets := TrendingNuggets(NewSearchOptions().WithTimeWindow(window).WithTopN(topn))
+	urls := make([]string, 0, len(nuggets)*5)
+	datautils.ForEach(nuggets, func(item *BeanNugget) { urls = append(urls, item.BeanUrls...) })
+	bean_options := NewSearchOptions().WithURLs(urls).WithCategory(categories)
+	bean_options.ScalarFilter["summary"] = store.JSON{"$exists": true}
+	beans := attachMediaNoises(beanstore.Get(bean_options.ScalarFilter, _PROJECTION_FIELDS, _SORT_BY_UPDATED, -1))
+	if len(beans) == 0 {
+		log.Printf("[briefing] Nothing trending for %s.\n", briefing.ID)
+		return nil
+	}
+	nuggets = datautils.SafeSlice(datautils.Filter(nuggets, func(nugget *BeanNugget) bool {
+		return datautils.IndexAny(beans, func(bean *Bean) bool { return slices.Contains(nugget.BeanUrls, bean.Url) }) >= 0
+	}), 0, _BRIEFING_NUGGETS)
+
+	// 2. group the nuggets into sections
+	sections := make(map[string]*BriefingSection)
+	datautils.ForEach(nuggets, func(nugget *BeanNugget) {
+		nugget_beans := datautils.Filter(beans, func(bean *Bean) bool { return slices.Contains(nugget.BeanUrls, bean.Url) })
+		if len(nugget_beans) == 0 {
+			return
+		}
+		title := getSectionTitle(nugget_beans)
+		section, ok := sections[title]
+		if !ok {
+			section = &BriefingSection{Title: title}
+			sections[title] = section
+		}
+		section.score += nugget.TrendScore
+		if len(section.Nuggets) < _BRIEFING_SECTION_NUGGETS {
+			section.Nuggets = append(section.Nuggets, BeanNugget{KeyPhrase: nugget.KeyPhrase, Event: nugget.Event, Description: nugget.Description, TrendScore: nugget.TrendScore})
+		}
+		datautils.ForEach(nugget_beans, func(bean *Bean) {
+			if datautils.IndexAny(section.beans, func(item *Bean) bool { return item.Url == bean.Url }) < 0 {
+				section.beans = append(section.beans, *bean)
+			}
+		})
+	})
+
+	// 3. rank the sections and their beans
+	_, values := datautils.MapToArray(sections)
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].score == values[j].score {
+			return values[i].Title < values[j].Title
+		}
+		return values[i].score > values[j].score
+	})
+	values = datautils.SafeSlice(values, 0, _BRIEFING_SECTIONS)
+	for _, section := range values {
+		sort.SliceStable(section.beans, func(i, j int) bool { return getNoiseScore(&section.beans[i]) > getNoiseScore(&section.beans[j]) })
+		section.beans = datautils.SafeSlice(section.beans, 0, _BRIEFING_SECTION_BEANS)
+		section.Sources = datautils.Transform(section.beans, func(bean *Bean) BriefingSource {
+			return BriefingSource{Url: bean.Url, Title: bean.Title, Source: bean.Source, Updated: bean.Updated, Summary: bean.Summary, MediaNoise: bean.MediaNoise}
+		})
+		briefing.Sections = append(briefing.Sections, *section)
+	}
+
+	// 4. write the overviews. The briefing is still useful without them if the LLM is not available
+	if !deferNLPWork(pb_client.ModelName(), "briefing", len(briefing.Sections)) {
+		for i := range briefing.Sections {
+			section := &briefing.Sections[i]
+			section.Overview = pb_client.Answer(fmt.Sprintf(_BRIEFING_OVERVIEW_REQUEST, section.Title), toAnswerDocuments(section.beans), nil)
+		}
+	}
+
+	briefingstore.Upsert([]Briefing{*briefing})
+	log.Printf("[briefing] Generated %s with %d sections.\n", briefing.ID, len(briefing.Sections))
+	return briefing
+}
+
+// Returns the latest briefing of the window and the categories generated from since until until. The zero times mean no bound.
+// nil if there is none
+func GetLatestBriefing(window int, categories []string, since, until time.Time) *Briefing {
+	if briefings := briefingstore.Get(getLatestBriefingFilter(window, categories, since, until), nil, store.JSON{"created": -1}, 1); len(briefings) > 0 {
+		return &briefings[0]
+	}
+	return nil
+}
+
+// the briefings of exactly the categories. The category ids are sorted the same way GenerateBriefing stores them
+func getLatestBriefingFilter(window int, categories []string, since, until time.Time) store.JSON {
+	categories = getCategoryIds(categories)
+	filter := store.JSON{"window": checkAndFixTimeWindow(window)}
+	created := make(store.JSON)
+	if !since.IsZero() {
+		created["$gte"] = since.Unix()
+	}
+	if !until.IsZero() {
+		created["$lt"] = until.Unix()
+	}
+	if len(created) > 0 {
+		filter["created"] = created
+	}
+	if len(categories) > 0 {
+		filter["categories"] = categories
+	} else {
+		filter["categories"] = store.JSON{"$exists": false}
+	}
+	return filter
+}
+
+func getBriefingKey(date time.Time, window int, categories []string) string {
+	key := fmt.Sprintf("%s:%dd", date.Format(time.DateOnly), window)
+	if len(categories) > 0 {
+		key += ":" + strings.Join(categories, ",")
+	}
+	return key
+}
+
+// the name of the category the most beans are in by their top category
+func getSectionTitle(beans []Bean) string {
+	counts := make(map[string]int)
+	datautils.ForEach(beans, func(bean *Bean) {
+		if len(bean.Categories) > 0 {
+			counts[bean.Categories[0].ID]++
+		}
+	})
+	id, max_count := "", 0
+	for category, count := range counts {
+		// ties go to the smaller id so that the same beans always land in the same section
+		if count > max_count || (count == max_count && category < id) {
+			id, max_count = category, count
+		}
+	}
+	if i := datautils.IndexAny(taxonomy, func(item *Category) bool { return item.ID == id }); i >= 0 {
+		return taxonomy[i].Name
+	}
+	return _BRIEFING_GENERAL_SECTION
+}
+
+func getNoiseScore(bean *Bean) int {
+	if bean.MediaNoise == nil {
+		return 0
+	}
+	return bean.MediaNoise.Score
+}
@@ -0,0 +1,100 @@
+package beansack
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+)
+
+// a bean whose top category is the first one
+func categorized(ids ...string) Bean {
+	bean := Bean{}
+	for _, id := range ids {
+		bean.Categories = append(bean.Categories, CategoryMatch{ID: id})
+	}
+	return bean
+}
+
+func TestGetSectionTitle(t *testing.T) {
+	setTestTaxonomy(t, []Category{{ID: "ai", Name: "Artificial Intelligence"}, {ID: "sec", Name: "Cybersecurity"}, {ID: "web", Name: "Web"}})
+	tests := []struct {
+		name  string
+		beans []Bean
+		want  string
+	}{
+		{"nothing", nil, _BRIEFING_GENERAL_SECTION},
+		{"not classified", []Bean{{}, {}}, _BRIEFING_GENERAL_SECTION},
+		{"most beans", []Bean{categorized("sec"), categorized("ai"), categorized("sec")}, "Cybersecurity"},
+		{"by the top category only", []Bean{categorized("sec", "ai"), categorized("web", "ai"), categorized("web")}, "Web"},
+		{"unclassified beans don't count", []Bean{{}, {}, categorized("web")}, "Web"},
+		{"ties go to the smaller id", []Bean{categorized("web"), categorized("sec"), categorized("ai")}, "Artificial Intelligence"},
+		{"ties regardless of the order", []Bean{categorized("web"), categorized("sec"), categorized("sec"), categorized("web")}, "Cybersecurity"},
+		{"not in the taxonomy", []Bean{categorized("gone")}, _BRIEFING_GENERAL_SECTION},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the map of the counts iterates in a different order every time
+			for range 10 {
+				if got := getSectionTitle(test.beans); got != test.want {
+					t.Fatalf("getSectionTitle() = %q, want %q", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetBriefingKey(t *testing.T) {
+	date := time.Date(2024, 5, 1, 23, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		window     int
+		categories []string
+		want       string
+	}{
+		{"everything", 1, nil, "2024-05-01:1d"},
+		{"a week", 7, []string{}, "2024-05-01:7d"},
+		{"a category", 1, []string{"ai"}, "2024-05-01:1d:ai"},
+		{"categories", 3, []string{"ai", "sec"}, "2024-05-01:3d:ai,sec"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := getBriefingKey(date, test.window, test.categories); got != test.want {
+				t.Errorf("getBriefingKey() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetLatestBriefingFilter(t *testing.T) {
+	setTestTaxonomy(t, []Category{{ID: "ai", Name: "Artificial Intelligence"}, {ID: "sec", Name: "Cybersecurity"}})
+	since, until := time.Unix(1000, 0), time.Unix(2000, 0)
+	tests := []struct {
+		name         string
+		window       int
+		categories   []string
+		since, until time.Time
+		want         store.JSON
+	}{
+		{"everything", 1, nil, time.Time{}, time.Time{}, store.JSON{"window": 1, "categories": store.JSON{"$exists": false}}},
+		{"window out of range", 100, nil, time.Time{}, time.Time{}, store.JSON{"window": _FOUR_WEEKS, "categories": store.JSON{"$exists": false}}},
+		{"by id", 1, []string{"sec"}, time.Time{}, time.Time{}, store.JSON{"window": 1, "categories": []string{"sec"}}},
+		{"by name", 1, []string{"cybersecurity"}, time.Time{}, time.Time{}, store.JSON{"window": 1, "categories": []string{"sec"}}},
+		{"in the stored order", 1, []string{"sec", "Artificial Intelligence", "ai"}, time.Time{}, time.Time{}, store.JSON{"window": 1, "categories": []string{"ai", "sec"}}},
+		{"unknown category", 1, []string{"gardening"}, time.Time{}, time.Time{}, store.JSON{"window": 1, "categories": []string{"gardening"}}},
+		{"since", 1, nil, since, time.Time{}, store.JSON{"window": 1, "categories": store.JSON{"$exists": false}, "created": store.JSON{"$gte": int64(1000)}}},
+		{"since until", 1, nil, since, until, store.JSON{"window": 1, "categories": store.JSON{"$exists": false}, "created": store.JSON{"$gte": int64(1000), "$lt": int64(2000)}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := getLatestBriefingFilter(test.window, test.categories, test.since, test.until); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("getLatestBriefingFilter() = %v, want %v", got, test.want)
+			}
+		})
+	}
+	// the briefings are generated with the same ids and key
+	if key := getBriefingKey(since, 1, getCategoryIds([]string{"sec", "ai"})); key != getBriefingKey(since, 1, getCategoryIds([]string{"Artificial Intelligence", "sec"})) {
+		t.Errorf("getBriefingKey() = %q, want the same key for the same categories", key)
+	}
+}
@@ -0,0 +1,42 @@
+package beansack
+
+import (
+	"time"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+)
+
+// cached nlp output stored in the beansack itself so that multiple instances can share it
+type cacheItem struct {
+	Key     string `bson:"key,omitempty"`
+	Value   []byte `bson:"value,omitempty"`
+	Updated int64  `bson:"updated,omitempty"`
+}
+
+// implements nlp.Cache on top of cachestore
+type storeCache struct{}
+
+// the hits are kept from expiring. Refreshing once a day is enough for the clean up window
+func (storeCache) Get(key string) ([]byte, bool) {
+	items := cachestore.Get(store.JSON{"key": key}, store.JSON{"value": 1, "updated": 1}, nil, 1)
+	if len(items) == 0 {
+		return nil, false
+	}
+	if now := time.Now(); items[0].Updated < now.AddDate(0, 0, -1).Unix() {
+		cachestore.Update([]any{store.JSON{"updated": now.Unix()}}, []store.JSON{{"key": key}})
+	}
+	return items[0].Value, true
+}
+
+// replaces the existing value of the key
+func (storeCache) Set(key string, value []byte) {
+	cachestore.Upsert([]cacheItem{{Key: key, Value: value, Updated: time.Now().Unix()}})
+}
+
+func getCacheItemId(item *cacheItem) store.JSON {
+	return store.JSON{"key": item.Key}
+}
+
+func cacheItemEquals(a, b *cacheItem) bool {
+	return a.Key == b.Key
+}
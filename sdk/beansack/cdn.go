@@ -2,6 +2,7 @@ package beansack
 
 import (
 	"log"
+	"slices"
 	"sort"
 
 	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
@@ -24,21 +25,29 @@ const (
 var (
 	_PROJECTION_FIELDS = store.JSON{
 		// for beans
-		"url":          1,
-		"updated":      1,
-		"source":       1,
-		"title":        1,
-		"kind":         1,
-		"author":       1,
-		"created":      1,
-		"summary":      1,
-		"keywords":     1,
-		"topic":        1,
-		"search_score": 1,
+		"url":                1,
+		"updated":            1,
+		"source":             1,
+		"title":              1,
+		"kind":               1,
+		"author":             1,
+		"created":            1,
+		"summary":            1,
+		"keywords":           1,
+		"topic":              1,
+		"search_score":       1,
+		"sentiment":          1,
+		"sentiment_score":    1,
+		"entities":           1,
+		"categories":         1,
+		"language":           1,
+		"translated_title":   1,
+		"translated_summary": 1,
+		"story_id":           1,
+		"duplicate_of":       1,
 
 		// for media noise
-		"score":     1,
-		"sentiment": 1,
+		"score": 1,
 
 		// for concepts
 		"keyphrase":   1,
@@ -129,9 +138,45 @@ func FuzzySearch(options *SearchOptions) []Bean {
 		if len(beans) <= 0 {
 			// options.TopN = 2
 			beans = TextSearch(keywords, options)
+			mode = _TEXT
 		}
 	}
-	return attachMediaNoises(beans)
+	beans = attachMediaNoises(beans)
+	if options.Explain {
+		beans = explainRanks(explainSearch(beans, getSearchModeName(mode, options), min_score, getSearchQuery(mode, options, keywords)))
+	}
+	return beans
+}
+
+// the mode for the explanations. A text search in a vector mode is the fallback
+func getSearchModeName(mode int, options *SearchOptions) string {
+	switch mode {
+	case _TEXT:
+		if options.hasFuzzySearch() {
+			return TEXT_FALLBACK_SEARCH_MODE
+		}
+		return TEXT_SEARCH_MODE
+	case _VECTOR, _VECTOR_OR_TEXT:
+		return VECTOR_SEARCH_MODE
+	default:
+		return GET_SEARCH_MODE
+	}
+}
+
+// the texts the search ran with. The embeddings passed in as is have none
+func getSearchQuery(mode int, options *SearchOptions, keywords []string) []string {
+	switch {
+	case mode == _TEXT:
+		return keywords
+	case len(options.SearchEmbeddings) > 0:
+		return nil
+	case len(options.SearchTexts) > 0:
+		return options.SearchTexts
+	case len(options.Context) > 0:
+		return []string{options.Context}
+	default:
+		return nil
+	}
 }
 
 // gets parameters for fuzzy search.
@@ -160,15 +205,19 @@ func getFuzzySearchMode(options *SearchOptions) (int, [][]float32, string, float
 	}
 }
 
+// nuggets are matched by their keyphrase or by the entity the keyphrase is known as.
+// the beans mentioning those entities are included as well
 func NuggetSearch(nuggets []string, settings *SearchOptions) []Bean {
+	entity_ids := getEntityIds(nuggets)
 	// get all the mapped urls
 	nuggets_filter := store.JSON{
-		"keyphrase": store.JSON{"$in": nuggets},
-	}
-	if updated, ok := settings.ScalarFilter["updated"]; ok {
-		nuggets_filter["updated"] = updated
+		"$or": []store.JSON{
+			{"keyphrase": store.JSON{"$in": nuggets}},
+			{"entities": store.JSON{"$in": entity_ids}},
+		},
 	}
-	initial_list := nuggetstore.Get(nuggets_filter, store.JSON{"mapped_urls": 1}, store.JSON{"match_count": -1}, settings.TopN)
+	pipeline, _ := settings.getTimeFilterPipeline(nuggets_filter, "updated", "mapped_urls")
+	initial_list := nuggetstore.Aggregate(append(pipeline, getStages(store.JSON{"keyphrase": 1, "mapped_urls": 1}, store.JSON{"match_count": -1}, settings.TopN)...))
 
 	// merge mapped_urls into one array
 	mapped_urls := make([]string, 0, len(initial_list)*5)
@@ -176,7 +225,10 @@ func NuggetSearch(nuggets []string, settings *SearchOptions) []Bean {
 
 	// find the news articles with the urls in scope
 	bean_filter := store.JSON{
-		"url": store.JSON{"$in": mapped_urls},
+		"$or": []store.JSON{
+			{"url": store.JSON{"$in": mapped_urls}},
+			{"entities": store.JSON{"$in": entity_ids}},
+		},
 	}
 	if kind, ok := settings.ScalarFilter["kind"]; ok {
 		bean_filter["kind"] = kind
@@ -187,7 +239,91 @@ func NuggetSearch(nuggets []string, settings *SearchOptions) []Bean {
 		_SORT_BY_UPDATED, // this way the newest ones are listed first
 		settings.TopN,
 	)
-	return attachMediaNoises(beans)
+	beans = attachMediaNoises(beans)
+	if settings.Explain {
+		beans = datautils.ForEach(explainSearch(beans, NUGGET_SEARCH_MODE, 0, nuggets), func(bean *Bean) {
+			bean.Explanation.MatchedNuggets = datautils.FilterAndTransform(initial_list, func(item *BeanNugget) (bool, string) {
+				return slices.Contains(item.BeanUrls, bean.Url), item.KeyPhrase
+			})
+			bean.Explanation.MatchedEntities = datautils.Filter(bean.Entities, func(item *string) bool { return slices.Contains(entity_ids, *item) })
+		})
+		beans = explainRanks(beans)
+	}
+	return beans
+}
+
+// the beans similar to a bean and the nuggets the bean is mapped to
+type RelatedBeans struct {
+	Url     string       `json:"url"`
+	Beans   []Bean       `json:"beans,omitempty"`
+	Nuggets []BeanNugget `json:"nuggets,omitempty"`
+}
+
+// Finds the beans covering the same thing as the bean with the url. url can be an alias of the bean.
+// The stored category embeddings of the bean are searched with as is. A near-duplicate is searched with the ones of its original.
+// The bean itself and its near-duplicates are left out. The kind and time window of the options apply.
+// Returns nil if the bean doesn't exist or has no embeddings yet
+func Related(url string, options *SearchOptions) *RelatedBeans {
+	beans := beanstore.Get(
+		NewSearchOptions().WithURLs([]string{url}).ScalarFilter,
+		store.JSON{"url": 1, "duplicate_of": 1, _CLASSIFICATION_EMB: 1},
+		nil, 1)
+	if len(beans) == 0 {
+		return nil
+	}
+	bean := &beans[0]
+	embeddings, own_urls, related_options := getRelatedSearch(bean, options, func(url string) []float32 {
+		if originals := beanstore.Get(store.JSON{"url": url}, store.JSON{_CLASSIFICATION_EMB: 1}, nil, 1); len(originals) > 0 {
+			return originals[0].CategoryEmbeddings
+		}
+		return nil
+	})
+	if len(embeddings) == 0 {
+		return nil
+	}
+	related := beanstore.VectorSearch(
+		[][]float32{embeddings},
+		_CLASSIFICATION_EMB,
+		store.WithVectorFilter(related_options.ScalarFilter),
+		store.WithProjection(_PROJECTION_FIELDS),
+		store.WithMinSearchScore(_DEFAULT_CLASSIFICATION_MATCH_SCORE),
+		store.WithVectorTopN(options.TopN))
+	related = attachMediaNoises(related)
+	if options.Explain {
+		related = explainRanks(explainSearch(related, RELATED_SEARCH_MODE, _DEFAULT_CLASSIFICATION_MATCH_SCORE, []string{bean.Url}))
+	}
+
+	return &RelatedBeans{
+		Url:   bean.Url,
+		Beans: related,
+		Nuggets: nuggetstore.Get(
+			store.JSON{"mapped_urls": store.JSON{"$in": own_urls}},
+			store.JSON{"embeddings": 0, "_id": 0},
+			store.JSON{"match_count": -1},
+			-1),
+	}
+}
+
+// the embeddings to search the beans related to the bean with, the urls of the bean itself and the options leaving those out.
+// get_embeddings returns the category embeddings of the original of a near-duplicate
+func getRelatedSearch(bean *Bean, options *SearchOptions, get_embeddings func(url string) []float32) ([]float32, []string, *SearchOptions) {
+	// a duplicate is related to its original and the other duplicates of the original the same way
+	embeddings, own_urls := bean.CategoryEmbeddings, []string{bean.Url}
+	if bean.DuplicateOf != "" {
+		own_urls = append(own_urls, bean.DuplicateOf)
+		// the duplicates don't get embeddings of their own
+		if original := get_embeddings(bean.DuplicateOf); len(original) > 0 {
+			embeddings = original
+		}
+	}
+	// in the $and so that the url filters of the options can't bring them back
+	related_options := *options
+	related_options.ScalarFilter = options.copyScalarFilter()
+	related_options.withAnd(store.JSON{
+		"url":          store.JSON{"$nin": own_urls},
+		"duplicate_of": store.JSON{"$nin": own_urls},
+	})
+	return embeddings, own_urls, &related_options
 }
 
 // Finds the trending news nuggets defined by the search parameter such as: by the day/week, by category match
@@ -201,12 +337,10 @@ func TrendingNuggets(options *SearchOptions) []BeanNugget {
 	nugget_filter := store.JSON{
 		"match_count": store.JSON{"$gte": 1}, // this a minimum
 	}
-	if updated, ok := options.ScalarFilter["updated"]; ok {
-		nugget_filter["updated"] = updated
-	}
+	pipeline, _ := options.getTimeFilterPipeline(nugget_filter, "updated", "mapped_urls")
 	initial_urls := make([]string, 0, 10) //default initialization
 	datautils.ForEach(
-		nuggetstore.Get(nugget_filter, store.JSON{"mapped_urls": 1}, nil, -1),
+		nuggetstore.Aggregate(append(pipeline, getStages(store.JSON{"mapped_urls": 1}, nil, -1)...)),
 		func(item *BeanNugget) { initial_urls = append(initial_urls, item.BeanUrls...) })
 	// there is nothing for the day
 	if len(initial_urls) <= 0 {
@@ -217,6 +351,7 @@ func TrendingNuggets(options *SearchOptions) []BeanNugget {
 	beans_options := *options
 	beans_options.ScalarFilter = store.JSON{"url": store.JSON{"$in": initial_urls}}
 	beans_options.TopN = len(initial_urls) // look for all the items that match and dont shorten to only user provided topN just yet
+	beans_options.Explain = false
 	matched_urls := datautils.Transform(FuzzySearch(&beans_options), func(item *Bean) string { return item.Url })
 	// there is nothing that matches the categories
 	if len(matched_urls) <= 0 {
@@ -226,15 +361,21 @@ func TrendingNuggets(options *SearchOptions) []BeanNugget {
 	// 2. Find the nuggets that has those URLs as mapped urls for that day
 	// 3. Stack rank them by trend score
 	nugget_filter["mapped_urls"] = store.JSON{"$in": matched_urls} // now find the ones with matched urls
-	return nuggetstore.Get(
-		nugget_filter,
-		store.JSON{
-			"embeddings": 0,
-			"_id":        0,
-		},
-		store.JSON{"match_count": -1}, // stack rank by trend score
-		options.TopN,                  // now add the topN provided by user
-	)
+	pipeline, _ = options.getTimeFilterPipeline(nugget_filter, "updated", "mapped_urls")
+	if options.TrendScorer != nil {
+		// the stored scores only narrow down the candidates for the scorer
+		nuggets := nuggetstore.Aggregate(append(pipeline, getStages(store.JSON{"embeddings": 0, "_id": 0}, store.JSON{"match_count": -1}, _TREND_CANDIDATES)...))
+		return datautils.SafeSlice(rescoreNuggets(nuggets, options.TrendScorer), 0, options.TopN)
+	}
+	return nuggetstore.Aggregate(append(pipeline,
+		getStages(
+			store.JSON{
+				"embeddings": 0,
+				"_id":        0,
+			},
+			store.JSON{"match_count": -1}, // stack rank by trend score
+			options.TopN,                  // now add the topN provided by user
+		)...))
 }
 
 // Returns the trending news/posts defined by the search parameter such as: by the day/week, by category match
@@ -243,13 +384,80 @@ func TrendingNuggets(options *SearchOptions) []BeanNugget {
 //  2. Find the nuggets that are mapped to these articles
 //  3. Take the highest nugget trend score and assign to the respective article
 //  4. Stack rank the news/posts by that trend score
+//  5. (Optional) Re-rank them by the velocity of their engagement
+//  6. (Optional) Keep the top one of each story
 func TrendingBeans(options *SearchOptions) []Bean {
 	//  1. Find all the news/posts for that day that matches the categories (match everything if there is no category)
-	beans := FuzzySearch(options)
+	search_options := *options
+	if options.OnePerStory {
+		// several of them will collapse into one
+		search_options.TopN = min(_STORY_SEARCH_FACTOR*search_options.TopN, _MAX_TOPN)
+	}
+	if options.SortBy == VELOCITY_SORT {
+		// the fastest growing ones are not necessarily the top ones by trend score
+		search_options.TopN = min(_VELOCITY_SEARCH_FACTOR*search_options.TopN, _MAX_TOPN)
+	}
+	beans := FuzzySearch(&search_options)
 
 	//  2. Find the nuggets that are mapped to these articles
 	urls := datautils.Transform(beans, func(item *Bean) string { return item.Url })
-	nuggets := nuggetstore.Aggregate([]store.JSON{
+	nuggets := getBeanTrendScores(urls, options.TrendScorer)
+
+	// if no nugget was found just return based on search score of the beans
+	if len(nuggets) > 0 {
+		//  3. Take the highest nugget trend score and assign to the respective article
+		beans = datautils.ForEach(beans, func(bn *Bean) {
+			i := datautils.IndexAny(nuggets, func(nug *beanTrendScore) bool { return bn.Url == nug.BeanUrl })
+			if i >= 0 {
+				bn.SearchScore = float64(nuggets[i].TrendScore)
+				if bn.Explanation != nil {
+					bn.Explanation.TrendScore = nuggets[i].TrendScore
+					bn.Explanation.MatchedNuggets = datautils.Filter([]string{nuggets[i].KeyPhrase}, func(item *string) bool { return *item != "" })
+				}
+			}
+		})
+
+		//  4. Stack rank the news/posts by that trend score
+		sort.Slice(beans, func(i, j int) bool { return beans[i].SearchScore > beans[j].SearchScore })
+	}
+	//  5. (Optional) Re-rank by how fast they are taking off. The trend score breaks the ties
+	if options.SortBy == VELOCITY_SORT {
+		beans = sortByVelocity(beans)
+	}
+	if options.OnePerStory {
+		beans = onePerStory(beans)
+	}
+	beans = attachMediaNoises(datautils.SafeSlice(beans, 0, options.TopN))
+	if options.Explain {
+		beans = explainRanks(beans)
+	}
+	return beans
+}
+
+// the highest trend score of the nuggets mapped to a bean
+type beanTrendScore struct {
+	BeanUrl    string `bson:"_id"`
+	KeyPhrase  string `bson:"keyphrase"` // of the nugget with the highest score
+	TrendScore int    `bson:"match_count"`
+}
+
+// the highest trend score of the nuggets mapped to each url.
+// scorer rescores the nuggets instead of using the stored scores if it is not nil
+func getBeanTrendScores(urls []string, scorer TrendScorer) []beanTrendScore {
+	if scorer != nil {
+		nuggets := rescoreNuggets(nuggetstore.Get(store.JSON{"mapped_urls": store.JSON{"$in": urls}}, store.JSON{"keyphrase": 1, "mapped_urls": 1}, nil, -1), scorer)
+		scores := make([]beanTrendScore, 0, len(urls))
+		// the nuggets are ranked so the first one of each url has the highest score
+		datautils.ForEach(nuggets, func(nugget *BeanNugget) {
+			datautils.ForEach(nugget.BeanUrls, func(url *string) {
+				if datautils.IndexAny(scores, func(item *beanTrendScore) bool { return item.BeanUrl == *url }) < 0 {
+					scores = append(scores, beanTrendScore{BeanUrl: *url, KeyPhrase: nugget.KeyPhrase, TrendScore: nugget.TrendScore})
+				}
+			})
+		})
+		return scores
+	}
+	return store.AggregateAs[beanTrendScore](nuggetstore, []store.JSON{
 		{
 			"$match": store.JSON{
 				"mapped_urls": store.JSON{"$in": urls},
@@ -264,34 +472,18 @@ func TrendingBeans(options *SearchOptions) []Bean {
 		{
 			"$project": store.JSON{
 				"match_count": 1,
+				"keyphrase":   1,
 				"url":         "$mapped_urls",
 			},
 		},
 		{
 			"$group": store.JSON{
-				"_id": "$url",
-				// HACK: using keywords as a holder for $url is a hack
-				"keyphrase":   store.JSON{"$first": "$url"},
+				"_id":         "$url",
+				"keyphrase":   store.JSON{"$first": "$keyphrase"},
 				"match_count": store.JSON{"$first": "$match_count"},
 			},
 		},
 	})
-
-	// if no nugget was found just return based on search score of the beans
-	if len(nuggets) > 0 {
-		//  3. Take the highest nugget trend score and assign to the respective article
-		beans = datautils.ForEach(beans, func(bn *Bean) {
-			i := datautils.IndexAny(nuggets, func(nug *BeanNugget) bool { return bn.Url == nug.KeyPhrase })
-			if i >= 0 {
-				bn.SearchScore = float64(nuggets[i].TrendScore)
-			}
-		})
-
-		//  4. Stack rank the news/posts by that trend score
-		sort.Slice(beans, func(i, j int) bool { return beans[i].SearchScore > beans[j].SearchScore })
-		beans = datautils.SafeSlice(beans, 0, options.TopN)
-	}
-	return attachMediaNoises(beans)
 }
 
 func attachMediaNoises(beans []Bean) []Bean {
@@ -329,12 +521,13 @@ func getMediaNoises(beans []Bean, total bool) []MediaNoise {
 					"source":     "$source",
 					"channel":    "$channel",
 				},
-				"updated":       store.JSON{"$first": "$updated"},
-				"mapped_url":    store.JSON{"$first": "$mapped_url"},
-				"channel":       store.JSON{"$first": "$channel"},
-				"container_url": store.JSON{"$first": "$container_url"},
-				"likes":         store.JSON{"$first": "$likes"},
-				"comments":      store.JSON{"$first": "$comments"},
+				"updated":                   store.JSON{"$first": "$updated"},
+				"mapped_url":                store.JSON{"$first": "$mapped_url"},
+				"channel":                   store.JSON{"$first": "$channel"},
+				"container_url":             store.JSON{"$first": "$container_url"},
+				"likes":                     store.JSON{"$first": "$likes"},
+				"comments":                  store.JSON{"$first": "$comments"},
+				"community_sentiment_score": store.JSON{"$first": "$community_sentiment_score"},
 			},
 		},
 		{
@@ -346,18 +539,31 @@ func getMediaNoises(beans []Bean, total bool) []MediaNoise {
 				"container_url": store.JSON{"$first": "$container_url"},
 				"likes":         store.JSON{"$sum": "$likes"},
 				"comments":      store.JSON{"$sum": "$comments"},
+				// the reaction across all the channels. $avg skips the ones without a score
+				"community_sentiment_score": store.JSON{"$avg": "$community_sentiment_score"},
 			},
 		},
 		{
 			"$project": store.JSON{
-				"mapped_url":    1,
-				"channel":       1,
-				"container_url": 1,
-				"likes":         1,
-				"comments":      1,
+				"mapped_url":                1,
+				"channel":                   1,
+				"container_url":             1,
+				"likes":                     1,
+				"comments":                  1,
+				"community_sentiment_score": 1,
+				"community_sentiment": store.JSON{
+					"$switch": store.JSON{
+						"branches": []store.JSON{
+							{"case": store.JSON{"$eq": []any{store.JSON{"$ifNull": []any{"$community_sentiment_score", nil}}, nil}}, "then": "$$REMOVE"},
+							{"case": store.JSON{"$gte": []any{"$community_sentiment_score", nlp.NEUTRAL_THRESHOLD}}, "then": nlp.POSITIVE},
+							{"case": store.JSON{"$lte": []any{"$community_sentiment_score", -nlp.NEUTRAL_THRESHOLD}}, "then": nlp.NEGATIVE},
+						},
+						"default": nlp.NEUTRAL,
+					},
+				},
 				"score": store.JSON{
 					"$add": []any{
-						store.JSON{"$multiply": []any{"$comments", 3}},
+						store.JSON{"$multiply": []any{"$comments", _COMMENT_WEIGHT}},
 						"$likes",
 					},
 				},
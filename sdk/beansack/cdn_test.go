@@ -0,0 +1,60 @@
+package beansack
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+)
+
+func TestGetRelatedSearch(t *testing.T) {
+	own, original := []float32{1, 0}, []float32{0, 1}
+	tests := []struct {
+		name            string
+		bean            Bean
+		original        []float32 // the embeddings of the original
+		want_embeddings []float32
+		want_urls       []string
+		want_lookups    []string
+	}{
+		{"original", Bean{Url: "a", CategoryEmbeddings: own}, original, own, []string{"a"}, nil},
+		{"original without embeddings", Bean{Url: "a"}, original, nil, []string{"a"}, nil},
+		{"duplicate searches with the original", Bean{Url: "b", DuplicateOf: "a"}, original, original, []string{"b", "a"}, []string{"a"}},
+		{"duplicate of an original without embeddings", Bean{Url: "b", DuplicateOf: "a", CategoryEmbeddings: own}, nil, own, []string{"b", "a"}, []string{"a"}},
+		{"duplicate of a deleted original", Bean{Url: "b", DuplicateOf: "a"}, nil, nil, []string{"b", "a"}, []string{"a"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := NewSearchOptions().WithKind([]string{"news"}).WithURLs([]string{"https://example.com/c"})
+			before := options.copyScalarFilter()
+			var lookups []string
+			embeddings, own_urls, related := getRelatedSearch(&test.bean, options, func(url string) []float32 {
+				lookups = append(lookups, url)
+				return test.original
+			})
+			if !reflect.DeepEqual(embeddings, test.want_embeddings) {
+				t.Errorf("embeddings = %v, want %v", embeddings, test.want_embeddings)
+			}
+			if !reflect.DeepEqual(own_urls, test.want_urls) {
+				t.Errorf("own urls = %v, want %v", own_urls, test.want_urls)
+			}
+			if !reflect.DeepEqual(lookups, test.want_lookups) {
+				t.Errorf("looked up the embeddings of %v, want %v", lookups, test.want_lookups)
+			}
+			// the url filter of the options stays and the bean and its duplicates are left out on top of it
+			want_and := append(before["$and"].([]store.JSON), store.JSON{
+				"url":          store.JSON{"$nin": test.want_urls},
+				"duplicate_of": store.JSON{"$nin": test.want_urls},
+			})
+			if !reflect.DeepEqual(related.ScalarFilter["$and"], want_and) {
+				t.Errorf("$and = %v, want %v", related.ScalarFilter["$and"], want_and)
+			}
+			if !reflect.DeepEqual(related.ScalarFilter["kind"], before["kind"]) {
+				t.Errorf("kind = %v, want %v", related.ScalarFilter["kind"], before["kind"])
+			}
+			if !reflect.DeepEqual(options.ScalarFilter, before) {
+				t.Errorf("getRelatedSearch() changed the options to %v", options.ScalarFilter)
+			}
+		})
+	}
+}
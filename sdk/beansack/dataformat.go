@@ -11,22 +11,42 @@ const (
 )
 
 type Bean struct {
-	Url         string               `json:"url,omitempty" bson:"url,omitempty"`         // this is unique across each item regardless of the source and will be used as ID
+	Url         string               `json:"url,omitempty" bson:"url,omitempty"`         // this is unique across each item regardless of the source and will be used as ID. It is the canonical url
+	Aliases     []string             `json:"aliases,omitempty" bson:"aliases,omitempty"` // other urls of the same content such as the ones with tracking params or the mobile site
 	Updated     int64                `json:"updated,omitempty" bson:"updated,omitempty"` // date of update of the post or comment. Empty for subreddits
 	Source      string               `json:"source,omitempty" bson:"source,omitempty"`   // which social media source is this coming from
 	Title       string               `json:"title,omitempty" bson:"title,omitempty"`     // represents text title of the item. Applies to subreddits and posts but not comments
 	Kind        string               `json:"kind,omitempty" bson:"kind,omitempty"`
 	Text        string               `json:"text,omitempty" bson:"text,omitempty"`
-	Author      string               `json:"author,omitempty" bson:"author,omitempty"`   // author of posts or comments. Empty for subreddits
-	Created     int64                `json:"created,omitempty" bson:"created,omitempty"` // date of creation of the post or comment. Empty for subreddits
+	Author      string               `json:"author,omitempty" bson:"author,omitempty"`     // author of posts or comments. Empty for subreddits
+	Created     int64                `json:"created,omitempty" bson:"created,omitempty"`   // date of creation of the post or comment. Empty for subreddits
+	Language    string               `json:"language,omitempty" bson:"language,omitempty"` // ISO 639-1 code of the original language. Empty if it could not be detected
 	*MediaNoise `bson:"-,omitempty"` // don't serialize this for BSON
 
-	Keywords           []string  `json:"keywords,omitempty" bson:"keywords,omitempty"`                       // This can come from input and/or computed from a small language model
-	Summary            string    `json:"summary,omitempty" bson:"summary,omitempty"`                         // generated from a large language model
-	Topic              string    `json:"topic,omitempty" bson:"topic,omitempty"`                             // generated from a large language model
-	SearchEmbeddings   []float32 `json:"search_embeddings,omitempty" bson:"search_embeddings,omitempty"`     // generated from a large language model
-	CategoryEmbeddings []float32 `json:"category_embeddings,omitempty" bson:"category_embeddings,omitempty"` // generated from a large language model
-	SearchScore        float64   `json:"search_score,omitempty" bson:"search_score,omitempty"`               // generated from DB search algorithm
+	Keywords           []string        `json:"keywords,omitempty" bson:"keywords,omitempty"`                       // This can come from input and/or computed from a small language model
+	Summary            string          `json:"summary,omitempty" bson:"summary,omitempty"`                         // generated from a large language model
+	Topic              string          `json:"topic,omitempty" bson:"topic,omitempty"`                             // generated from a large language model
+	DigestVersion      string          `json:"digest_version,omitempty" bson:"digest_version,omitempty"`           // version of the prompt that generated summary and topic
+	TranslatedTitle    string          `json:"translated_title,omitempty" bson:"translated_title,omitempty"`       // English translation of the title. Only for the languages whose policy is to translate
+	TranslatedSummary  string          `json:"translated_summary,omitempty" bson:"translated_summary,omitempty"`   // English translation of the summary. Rectify retries the ones without it
+	Sentiment          string          `json:"sentiment,omitempty" bson:"sentiment,omitempty"`                     // positive, negative or neutral. generated from a large language model or the lexicon scorer
+	SentimentScore     float64         `json:"sentiment_score,omitempty" bson:"sentiment_score,omitempty"`         // -1.0 (very negative) to 1.0 (very positive)
+	SentimentVersion   string          `json:"sentiment_version,omitempty" bson:"sentiment_version,omitempty"`     // version of the prompt or "lexicon" that generated the sentiment
+	Entities           []string        `json:"entities,omitempty" bson:"entities,omitempty"`                       // IDs of the BeanEntity mentioned in the bean
+	Categories         []CategoryMatch `json:"categories,omitempty" bson:"categories,omitempty"`                   // top categories from the taxonomy
+	StoryID            string          `json:"story_id,omitempty" bson:"story_id,omitempty"`                       // the Story the bean covers
+	DuplicateOf        string          `json:"duplicate_of,omitempty" bson:"duplicate_of,omitempty"`               // url of the bean this is a near-duplicate of. duplicates are not enriched
+	NuggetsGenerated   bool            `json:"-" bson:"nuggets_generated,omitempty"`                               // the news nuggets have been extracted from the bean. Rectify retries the ones without it
+	NuggetsClaimed     int64           `json:"-" bson:"nuggets_claimed,omitempty"`                                 // when a collection run took the bean for generating its nuggets in the background. Rectify leaves it alone for a while
+	Fingerprint        int64           `json:"-" bson:"fingerprint,omitempty"`                                     // SimHash of the text
+	FingerprintBands   []string        `json:"-" bson:"fingerprint_bands,omitempty"`                               // LSH keys of the fingerprint for looking up near-duplicates
+	SearchEmbeddings   []float32       `json:"search_embeddings,omitempty" bson:"search_embeddings,omitempty"`     // generated from a large language model
+	CategoryEmbeddings []float32       `json:"category_embeddings,omitempty" bson:"category_embeddings,omitempty"` // generated from a large language model
+	Enriched           int64           `json:"-" bson:"enriched,omitempty"`                                        // when the last generated field got written including the ones Rectify fills in later. The subscriptions alert the beans by this instead of the update time
+	SearchScore        float64         `json:"search_score,omitempty" bson:"search_score,omitempty"`               // generated from DB search algorithm
+	Velocity           float64         `json:"velocity,omitempty" bson:"-"`                                        // engagement per hour between the last two collection runs. only for the trending beans sorted by velocity
+
+	Explanation *SearchExplanation `json:"explanation,omitempty" bson:"-"` // why the bean is in the results. only when the search options ask for it
 }
 
 type MediaNoise struct {
@@ -43,6 +63,11 @@ type MediaNoise struct {
 	ThumbsupRatio float64 `json:"likes_ratio,omitempty" bson:"likes_ratio,omitempty"` // Applies to subreddit posts and comments. Doesn't apply to subreddits
 	Score         int     `json:"score,omitempty" bson:"score,omitempty"`
 	Digest        string  `json:"digest,omitempty" bson:"digest,omitempty"`
+	// reaction of the community scored from the Digest. Named differently from the Bean's sentiment since both get flattened in the same json
+	CommunitySentiment      string  `json:"community_sentiment,omitempty" bson:"community_sentiment,omitempty"`
+	CommunitySentimentScore float64 `json:"community_sentiment_score,omitempty" bson:"community_sentiment_score,omitempty"`
+	// when a collection run took the media noise for scoring in the background. Rectify leaves it alone for a while
+	SentimentClaimed int64 `json:"-" bson:"sentiment_claimed,omitempty"`
 }
 
 type BeanNugget struct {
@@ -54,6 +79,10 @@ type BeanNugget struct {
 	Updated     int64     `json:"updated,omitempty" bson:"updated,omitempty"`
 	TrendScore  int       `json:"match_count,omitempty" bson:"match_count,omitempty"`
 	BeanUrls    []string  `json:"mapped_urls,omitempty" bson:"mapped_urls,omitempty"`
+	SourceUrls  []string  `json:"source_urls,omitempty" bson:"source_urls,omitempty"` // the beans the nugget was extracted from. These are always part of mapped_urls
+	Entities    []string  `json:"entities,omitempty" bson:"entities,omitempty"`       // IDs of the BeanEntity the keyphrase is known as
+	// version of the prompt that generated the keyphrase, event and description
+	PromptVersion string `json:"prompt_version,omitempty" bson:"prompt_version,omitempty"`
 }
 
 type Sip struct {
@@ -65,9 +94,10 @@ type Sip struct {
 
 func toNewsNugget(concept *nlp.KeyConcept) BeanNugget {
 	return BeanNugget{
-		KeyPhrase:   concept.KeyPhrase,
-		Event:       concept.Event,
-		Description: concept.Description,
+		KeyPhrase:     concept.KeyPhrase,
+		Event:         concept.Event,
+		Description:   concept.Description,
+		PromptVersion: concept.PromptVersion,
 	}
 }
 
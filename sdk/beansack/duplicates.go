@@ -0,0 +1,117 @@
+package beansack
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/bits"
+	"strings"
+	"unicode"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
+)
+
+const (
+	_SHINGLE_SIZE         = 3  // words per shingle
+	_MIN_SHINGLES         = 20 // shorter texts don't have enough in them to tell copies apart from similar texts
+	_FINGERPRINT_BANDS    = 8  // 64 bits split into 8 bands of 8
+	_MAX_HAMMING_DISTANCE = 6  // fewer differing bits than bands guarantees a shared band. unrelated texts differ in about 32
+	_DUPLICATE_WINDOW     = 7  // days to look back for the originals
+)
+
+// SimHash fingerprint of the text over its word shingles. Near-duplicate texts differ in only a few bits.
+// It is stored as int64 since the database doesn't do unsigned. 0 means the text is too short to fingerprint
+func fingerprint(text string) int64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	if len(words) < _MIN_SHINGLES+_SHINGLE_SIZE-1 {
+		return 0
+	}
+	var votes [64]int
+	for i := 0; i+_SHINGLE_SIZE <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+_SHINGLE_SIZE], " ")))
+		sum := h.Sum64()
+		for b := 0; b < 64; b++ {
+			if sum&(1<<b) != 0 {
+				votes[b]++
+			} else {
+				votes[b]--
+			}
+		}
+	}
+	var fp uint64
+	for b := 0; b < 64; b++ {
+		if votes[b] > 0 {
+			fp |= 1 << b
+		}
+	}
+	return int64(fp)
+}
+
+// LSH keys of the fingerprint. Two fingerprints within the hamming distance share at least one
+func fingerprintBands(fp int64) []string {
+	bands := make([]string, _FINGERPRINT_BANDS)
+	width := 64 / _FINGERPRINT_BANDS
+	for i := range bands {
+		bands[i] = fmt.Sprintf("%d:%x", i, (uint64(fp)>>(i*width))&(1<<width-1))
+	}
+	return bands
+}
+
+func isNearDuplicate(a, b int64) bool {
+	return a != 0 && b != 0 && bits.OnesCount64(uint64(a^b)) <= _MAX_HAMMING_DISTANCE
+}
+
+// fingerprints the beans and links the near-duplicates of the recent beans or of the earlier beans in the same batch through duplicate_of.
+// the duplicates are still stored but they don't get enriched
+func detectDuplicates(beans []Bean) []Bean {
+	bands := make([]string, 0, len(beans)*_FINGERPRINT_BANDS)
+	beans = datautils.ForEach(beans, func(item *Bean) {
+		if item.Fingerprint = fingerprint(item.Text); item.Fingerprint != 0 {
+			item.FingerprintBands = fingerprintBands(item.Fingerprint)
+			bands = append(bands, item.FingerprintBands...)
+		}
+	})
+	if len(bands) == 0 {
+		return beans
+	}
+	originals := beanstore.Get(
+		store.JSON{
+			"fingerprint_bands": store.JSON{"$in": bands},
+			"duplicate_of":      store.JSON{"$exists": false},
+			"updated":           store.JSON{"$gte": timeValue(_DUPLICATE_WINDOW)},
+		},
+		store.JSON{"url": 1, "fingerprint": 1},
+		nil, -1)
+
+	if count := linkDuplicates(beans, originals); count > 0 {
+		log.Printf("[beanops] Found %d near-duplicate beans.\n", count)
+	}
+	return beans
+}
+
+// points each fingerprinted bean to the first of the originals or of the earlier originals in the batch it is a near-duplicate of.
+// Returns how many got linked
+func linkDuplicates(beans, originals []Bean) int {
+	count := 0
+	for i := range beans {
+		bean := &beans[i]
+		match := func(original *Bean) bool {
+			return original.Url != bean.Url && isNearDuplicate(original.Fingerprint, bean.Fingerprint)
+		}
+		if j := datautils.IndexAny(originals, match); j >= 0 {
+			bean.DuplicateOf = originals[j].Url
+		} else if j := datautils.IndexAny(beans[:i], func(item *Bean) bool { return item.DuplicateOf == "" && match(item) }); j >= 0 {
+			bean.DuplicateOf = beans[j].Url
+		}
+		if bean.DuplicateOf != "" {
+			count++
+		}
+	}
+	return count
+}
+
+func isOriginal(bean *Bean) bool {
+	return bean.DuplicateOf == ""
+}
@@ -0,0 +1,200 @@
+package beansack
+
+import (
+	"math/bits"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const (
+	_TEST_ARTICLE = "Fortra has released a patch for a critical vulnerability in FileCatalyst Workflow that allowed remote attackers to create administrator accounts. " +
+		"The flaw, tracked as CVE-2024-6633, comes from a default password for the HSQL database that ships with the product. " +
+		"Administrators are urged to update to version 5.1.6 build 139 or later and to restrict access to the database port. " +
+		"The company said it had not seen the flaw exploited in the wild before the disclosure by security researchers."
+	_TEST_OTHER_ARTICLE = "The city council approved a new budget on Tuesday that increases funding for public schools and parks while cutting spending on road maintenance. " +
+		"Council members debated the plan for more than four hours before voting seven to two in favor. " +
+		"The mayor said the budget reflects the priorities residents shared during a series of town hall meetings held across every district over the summer months this year."
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		duplicate bool
+	}{
+		{"same text", _TEST_ARTICLE, true},
+		{"case and punctuation", strings.ToUpper(strings.ReplaceAll(_TEST_ARTICLE, ",", "")), true},
+		{"one word changed", strings.Replace(_TEST_ARTICLE, "critical", "severe", 1), true},
+		{"sentence appended", _TEST_ARTICLE + " Read more on our website.", true},
+		{"prefixed", "Updated: " + _TEST_ARTICLE, true},
+		// 11 bits apart. just past the threshold
+		{"two words changed", strings.Replace(strings.Replace(_TEST_ARTICLE, "critical", "severe", 1), "urged", "advised", 1), false},
+		{"different article", _TEST_OTHER_ARTICLE, false},
+		{"too short", "Fortra patches a critical flaw in FileCatalyst Workflow", false},
+	}
+	original := fingerprint(_TEST_ARTICLE)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fp := fingerprint(test.text)
+			if got := isNearDuplicate(original, fp); got != test.duplicate {
+				t.Errorf("isNearDuplicate() = %v, want %v. %d bits apart", got, test.duplicate, bits.OnesCount64(uint64(original^fp)))
+			}
+		})
+	}
+}
+
+func TestFingerprintTooShort(t *testing.T) {
+	words := strings.Fields(_TEST_ARTICLE)
+	tests := []struct {
+		name  string
+		words int
+		zero  bool
+	}{
+		{"no text", 0, true},
+		{"one shingle short", _MIN_SHINGLES + _SHINGLE_SIZE - 2, true},
+		{"just enough shingles", _MIN_SHINGLES + _SHINGLE_SIZE - 1, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := fingerprint(strings.Join(words[:test.words], " ")); (got == 0) != test.zero {
+				t.Errorf("fingerprint() = %d, want zero: %v", got, test.zero)
+			}
+		})
+	}
+}
+
+func TestIsNearDuplicate(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b int64
+		want bool
+	}{
+		{"same", 0x0f0f, 0x0f0f, true},
+		{"6 bits apart", 0x0f0f, 0x0f0f ^ 0x3f, true},
+		{"7 bits apart", 0x0f0f, 0x0f0f ^ 0x7f, false},
+		{"7 bits apart in different bands", 0x0f0f, 0x0f0f ^ 0x0101010101010101 ^ 1<<60, false},
+		{"not fingerprinted", 0, 0, false},
+		{"one not fingerprinted", 0x0f0f, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isNearDuplicate(test.a, test.b); got != test.want {
+				t.Errorf("isNearDuplicate(%x, %x) = %v, want %v", test.a, test.b, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFingerprintBands(t *testing.T) {
+	shared := func(a, b int64) int {
+		count := 0
+		bands := fingerprintBands(b)
+		for _, band := range fingerprintBands(a) {
+			if slices.Contains(bands, band) {
+				count++
+			}
+		}
+		return count
+	}
+	fp := fingerprint(_TEST_ARTICLE)
+	tests := []struct {
+		name   string
+		a, b   int64
+		shared int
+	}{
+		{"same", fp, fp, _FINGERPRINT_BANDS},
+		// the worst case of the threshold: every differing bit in a band of its own
+		{"6 bits apart in 6 bands", fp, fp ^ 0x0000010101010101, 2},
+		{"6 bits apart in one band", fp, fp ^ 0x3f, 7},
+		{"every band differs", fp, fp ^ 0x0101010101010101, 0},
+		// the same value in different positions is not a collision
+		{"same band value in another position", 0x01, 0x0100, 6},
+		{"near-duplicate text", fp, fingerprint(_TEST_ARTICLE + " Read more on our website."), 6},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := shared(test.a, test.b); got != test.shared {
+				t.Errorf("shared bands = %d, want %d", got, test.shared)
+			}
+		})
+	}
+	if got := len(fingerprintBands(fp)); got != _FINGERPRINT_BANDS {
+		t.Errorf("len(fingerprintBands()) = %d, want %d", got, _FINGERPRINT_BANDS)
+	}
+}
+
+func TestLinkDuplicates(t *testing.T) {
+	original := fingerprint(_TEST_ARTICLE)
+	near := fingerprint(strings.Replace(_TEST_ARTICLE, "critical", "severe", 1))
+	other := fingerprint(_TEST_OTHER_ARTICLE)
+	tests := []struct {
+		name      string
+		beans     []Bean
+		originals []Bean
+		want      []string
+	}{
+		{
+			name:      "duplicate of a stored bean",
+			beans:     []Bean{{Url: "b", Fingerprint: near}},
+			originals: []Bean{{Url: "a", Fingerprint: original}},
+			want:      []string{"a"},
+		},
+		{
+			name:      "not its own duplicate",
+			beans:     []Bean{{Url: "a", Fingerprint: near}},
+			originals: []Bean{{Url: "a", Fingerprint: original}},
+			want:      []string{""},
+		},
+		{
+			name:  "duplicate of an earlier bean in the batch",
+			beans: []Bean{{Url: "a", Fingerprint: original}, {Url: "b", Fingerprint: other}, {Url: "c", Fingerprint: near}},
+			want:  []string{"", "", "a"},
+		},
+		{
+			name:  "chains point to the first original",
+			beans: []Bean{{Url: "a", Fingerprint: original}, {Url: "b", Fingerprint: original}, {Url: "c", Fingerprint: near}},
+			want:  []string{"", "a", "a"},
+		},
+		{
+			name:      "stored originals come first",
+			beans:     []Bean{{Url: "b", Fingerprint: original}, {Url: "c", Fingerprint: near}},
+			originals: []Bean{{Url: "a", Fingerprint: original}},
+			want:      []string{"a", "a"},
+		},
+		{
+			name:  "not fingerprinted",
+			beans: []Bean{{Url: "a"}, {Url: "b"}},
+			want:  []string{"", ""},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count := linkDuplicates(test.beans, test.originals)
+			got := make([]string, 0, len(test.beans))
+			linked := 0
+			for _, bean := range test.beans {
+				got = append(got, bean.DuplicateOf)
+				if bean.DuplicateOf != "" {
+					linked++
+				}
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("duplicate_of = %q, want %q", got, test.want)
+			}
+			if count != linked {
+				t.Errorf("linkDuplicates() = %d, want %d", count, linked)
+			}
+		})
+	}
+}
+
+func TestDetectDuplicatesWithoutFingerprints(t *testing.T) {
+	// nothing to look up when none of the texts are long enough
+	beans := detectDuplicates([]Bean{{Url: "a", Text: "too short"}, {Url: "b"}})
+	for _, bean := range beans {
+		if bean.Fingerprint != 0 || len(bean.FingerprintBands) != 0 || bean.DuplicateOf != "" {
+			t.Errorf("detectDuplicates() = %+v, want it untouched", bean)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package beansack
+
+import (
+	"sort"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
+)
+
+const (
+	VELOCITY_SORT = "velocity" // trending beans by how fast their engagement is growing
+
+	_VELOCITY_WINDOW        = 2 // days of engagement history the velocities are computed from
+	_VELOCITY_SEARCH_FACTOR = 3 // beans to search for per requested one when sorting by velocity
+)
+
+// the engagement of a bean on a source in one collection run. The media noises of the same bean
+// from different channels of the source are added up
+type EngagementPoint struct {
+	BeanUrl     string `json:"url" bson:"mapped_url"`
+	Source      string `json:"source" bson:"source"`
+	Timestamp   int64  `json:"timestamp" bson:"timestamp"` // the update time of the collection run
+	Likes       int    `json:"likes,omitempty" bson:"likes,omitempty"`
+	Comments    int    `json:"comments,omitempty" bson:"comments,omitempty"`
+	Score       int    `json:"score,omitempty" bson:"score,omitempty"` // the score the source reports such as the reddit score
+	Subscribers int    `json:"subscribers,omitempty" bson:"subscribers,omitempty"`
+}
+
+// how the engagement of a bean is changing. The rates are in engagement per hour where engagement is
+// 3 x comments + likes like the noise score, and they are added up across the sources
+type Engagement struct {
+	Url      string            `json:"url"`
+	Velocity float64           `json:"velocity"` // between the last two collection runs
+	Momentum float64           `json:"momentum"` // change of the velocity from the one before. positive means it is speeding up
+	History  []EngagementPoint `json:"history,omitempty"`
+}
+
+func getEngagementPointId(point *EngagementPoint) store.JSON {
+	return store.JSON{"mapped_url": point.BeanUrl, "source": point.Source, "timestamp": point.Timestamp}
+}
+
+func engagementPointEquals(a, b *EngagementPoint) bool {
+	return a.BeanUrl == b.BeanUrl && a.Source == b.Source && a.Timestamp == b.Timestamp
+}
+
+// Returns the engagement of the bean with its history oldest first. url can be an alias of the bean.
+// The engagement of a near-duplicate is the one of its original since their media noises go to the original.
+// The time window or the date range of the options applies to the collection runs, or to the publish time of the bean with CREATED_TIME.
+// Without one it is the collection runs of the last 2 days. Returns nil if the bean doesn't exist or has no engagement
+func GetEngagement(url string, options *SearchOptions) *Engagement {
+	beans := beanstore.Get(getUrlFilter(resolveUrls([]string{url})), store.JSON{"url": 1, "duplicate_of": 1}, nil, 1)
+	if len(beans) == 0 {
+		return nil
+	}
+	filter := store.JSON{"mapped_url": getEngagementUrl(&beans[0])}
+	pipeline, ok := options.getTimeFilterPipeline(filter, "timestamp", "mapped_url")
+	if !ok {
+		filter["timestamp"] = store.JSON{"$gte": timeValue(_VELOCITY_WINDOW)}
+		pipeline = []store.JSON{{"$match": filter}}
+	}
+	points := engagementstore.Aggregate(append(pipeline, getStages(store.JSON{"_id": 0}, store.JSON{"timestamp": 1}, -1)...))
+	if len(points) == 0 {
+		return nil
+	}
+	engagement := calculateEngagement(beans[0].Url, points)
+	engagement.History = points
+	return &engagement
+}
+
+// the url the engagement points of the bean are recorded under
+func getEngagementUrl(bean *Bean) string {
+	if bean.DuplicateOf != "" {
+		return bean.DuplicateOf
+	}
+	return bean.Url
+}
+
+// Returns the velocity and the momentum of the beans over the last `window` days without the history.
+// The beans without any engagement are left out
+func GetEngagements(urls []string, window int) []Engagement {
+	points := getEngagementPoints(urls, store.JSON{"timestamp": store.JSON{"$gte": timeValue(window)}})
+	by_url := make(map[string][]EngagementPoint)
+	datautils.ForEach(points, func(point *EngagementPoint) { by_url[point.BeanUrl] = append(by_url[point.BeanUrl], *point) })
+	engagements := make([]Engagement, 0, len(by_url))
+	for url, url_points := range by_url {
+		engagements = append(engagements, calculateEngagement(url, url_points))
+	}
+	return engagements
+}
+
+// adds a point for each bean and source of the media noises of a collection run
+func recordEngagements(medianoises []MediaNoise, timestamp int64) {
+	points := make([]EngagementPoint, 0, len(medianoises))
+	datautils.ForEach(medianoises, func(noise *MediaNoise) {
+		i := datautils.IndexAny(points, func(item *EngagementPoint) bool {
+			return item.BeanUrl == noise.BeanUrl && item.Source == noise.Source
+		})
+		if i < 0 {
+			points = append(points, EngagementPoint{BeanUrl: noise.BeanUrl, Source: noise.Source, Timestamp: timestamp})
+			i = len(points) - 1
+		}
+		points[i].Likes += noise.ThumbsupCount
+		points[i].Comments += noise.Comments
+		points[i].Score += noise.Score
+		points[i].Subscribers += noise.Subscribers
+	})
+	// no need to check for error since the history is auxiliary like the media noises
+	engagementstore.Add(points)
+}
+
+// oldest first. timestamp is the condition on the time of the collection runs
+// filter is on top of the urls such as the timestamps
+func getEngagementPoints(urls []string, filter store.JSON) []EngagementPoint {
+	if len(urls) == 0 {
+		return nil
+	}
+	filter["mapped_url"] = store.JSON{"$in": urls}
+	return engagementstore.Get(
+		filter,
+		store.JSON{"_id": 0},
+		store.JSON{"timestamp": 1},
+		-1)
+}
+
+// points are the ones of the url oldest first
+func calculateEngagement(url string, points []EngagementPoint) Engagement {
+	engagement := Engagement{Url: url}
+	by_source := make(map[string][]EngagementPoint)
+	datautils.ForEach(points, func(point *EngagementPoint) { by_source[point.Source] = append(by_source[point.Source], *point) })
+	for _, source_points := range by_source {
+		n := len(source_points)
+		if n < 2 {
+			continue
+		}
+		velocity := getEngagementRate(&source_points[n-2], &source_points[n-1])
+		engagement.Velocity += velocity
+		if n >= 3 {
+			engagement.Momentum += velocity - getEngagementRate(&source_points[n-3], &source_points[n-2])
+		}
+	}
+	return engagement
+}
+
+// engagement gained per hour from one point to the next
+func getEngagementRate(from, to *EngagementPoint) float64 {
+	hours := float64(to.Timestamp-from.Timestamp) / 3600
+	if hours <= 0 {
+		return 0
+	}
+	return float64(getEngagementValue(to)-getEngagementValue(from)) / hours
+}
+
+func getEngagementValue(point *EngagementPoint) int {
+	return _COMMENT_WEIGHT*point.Comments + point.Likes
+}
+
+// stack ranks the beans by the velocity of their engagement. The ones without any come last in the same order
+func sortByVelocity(beans []Bean) []Bean {
+	return rankByVelocity(beans, GetEngagements(datautils.Transform(beans, func(item *Bean) string { return item.Url }), _VELOCITY_WINDOW))
+}
+
+func rankByVelocity(beans []Bean, engagements []Engagement) []Bean {
+	beans = datautils.ForEach(beans, func(bean *Bean) {
+		if i := datautils.IndexAny(engagements, func(item *Engagement) bool { return item.Url == bean.Url }); i >= 0 {
+			bean.Velocity = engagements[i].Velocity
+		}
+	})
+	sort.SliceStable(beans, func(i, j int) bool { return beans[i].Velocity > beans[j].Velocity })
+	return beans
+}
@@ -0,0 +1,93 @@
+package beansack
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestGetEngagementRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to EngagementPoint
+		want     float64
+	}{
+		{"likes over an hour", EngagementPoint{Timestamp: 0, Likes: 10}, EngagementPoint{Timestamp: 3600, Likes: 40}, 30},
+		{"comments weigh more", EngagementPoint{Timestamp: 0}, EngagementPoint{Timestamp: 3600, Comments: 10, Likes: 5}, 10*_COMMENT_WEIGHT + 5},
+		{"over two hours", EngagementPoint{Timestamp: 0, Likes: 10}, EngagementPoint{Timestamp: 7200, Likes: 40}, 15},
+		{"losing engagement", EngagementPoint{Timestamp: 0, Likes: 40}, EngagementPoint{Timestamp: 3600, Likes: 10}, -30},
+		{"score and subscribers don't count", EngagementPoint{Timestamp: 0}, EngagementPoint{Timestamp: 3600, Score: 100, Subscribers: 1000}, 0},
+		{"same run", EngagementPoint{Timestamp: 3600, Likes: 10}, EngagementPoint{Timestamp: 3600, Likes: 40}, 0},
+		{"out of order", EngagementPoint{Timestamp: 7200, Likes: 10}, EngagementPoint{Timestamp: 3600, Likes: 40}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := getEngagementRate(&test.from, &test.to); math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("getEngagementRate() = %f, want %f", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCalculateEngagement(t *testing.T) {
+	point := func(source string, hour int64, likes int) EngagementPoint {
+		return EngagementPoint{BeanUrl: "a", Source: source, Timestamp: hour * 3600, Likes: likes}
+	}
+	tests := []struct {
+		name     string
+		points   []EngagementPoint
+		velocity float64
+		momentum float64
+	}{
+		{"no points", nil, 0, 0},
+		{"one point", []EngagementPoint{point("REDDIT", 0, 10)}, 0, 0},
+		{"two points", []EngagementPoint{point("REDDIT", 0, 10), point("REDDIT", 1, 30)}, 20, 0},
+		{"speeding up", []EngagementPoint{point("REDDIT", 0, 10), point("REDDIT", 1, 20), point("REDDIT", 2, 50)}, 30, 20},
+		{"slowing down", []EngagementPoint{point("REDDIT", 0, 10), point("REDDIT", 1, 50), point("REDDIT", 2, 60)}, 10, -30},
+		{"only the last three count", []EngagementPoint{point("REDDIT", 0, 0), point("REDDIT", 1, 100), point("REDDIT", 2, 110), point("REDDIT", 3, 130)}, 20, 10},
+		{
+			name: "sources add up",
+			points: []EngagementPoint{
+				point("REDDIT", 0, 10), point("YC HACKER NEWS", 0, 5),
+				point("REDDIT", 1, 30), point("YC HACKER NEWS", 2, 25),
+			},
+			velocity: 20 + 10,
+		},
+		{"a source with one point doesn't count", []EngagementPoint{point("REDDIT", 0, 10), point("REDDIT", 1, 30), point("YC HACKER NEWS", 1, 500)}, 20, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := calculateEngagement("a", test.points)
+			if got.Url != "a" || math.Abs(got.Velocity-test.velocity) > 1e-9 || math.Abs(got.Momentum-test.momentum) > 1e-9 {
+				t.Errorf("calculateEngagement() = %+v, want velocity %f and momentum %f", got, test.velocity, test.momentum)
+			}
+		})
+	}
+}
+
+func TestRankByVelocity(t *testing.T) {
+	beans := []Bean{{Url: "a"}, {Url: "b"}, {Url: "c"}, {Url: "d"}, {Url: "e"}}
+	engagements := []Engagement{{Url: "d", Velocity: 5}, {Url: "b", Velocity: 20}, {Url: "e", Velocity: -3}, {Url: "x", Velocity: 100}}
+	got := make([]string, 0, len(beans))
+	velocities := make([]float64, 0, len(beans))
+	for _, bean := range rankByVelocity(beans, engagements) {
+		got = append(got, bean.Url)
+		velocities = append(velocities, bean.Velocity)
+	}
+	// the ones without any engagement keep their order between the growing and the shrinking ones
+	if want := []string{"b", "d", "a", "c", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rankByVelocity() = %v, want %v", got, want)
+	}
+	if want := []float64{20, 5, 0, 0, -3}; !reflect.DeepEqual(velocities, want) {
+		t.Errorf("velocities = %v, want %v", velocities, want)
+	}
+}
+
+func TestGetEngagementUrl(t *testing.T) {
+	if got := getEngagementUrl(&Bean{Url: "a"}); got != "a" {
+		t.Errorf("getEngagementUrl() of an original = %q, want %q", got, "a")
+	}
+	if got := getEngagementUrl(&Bean{Url: "b", DuplicateOf: "a"}); got != "a" {
+		t.Errorf("getEngagementUrl() of a duplicate = %q, want its original %q", got, "a")
+	}
+}
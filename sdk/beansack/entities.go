@@ -0,0 +1,216 @@
+package beansack
+
+import (
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
+)
+
+const (
+	// names that don't normalize to the same key are still treated as the same entity above this score
+	_DEFAULT_ENTITY_MATCH_SCORE = 0.92
+)
+
+// A canonical entity in the registry. Beans and nuggets point to it through its ID
+type BeanEntity struct {
+	ID         string    `json:"id,omitempty" bson:"_id,omitempty"` // <type>:<normalized name> of the first name it was seen as
+	Name       string    `json:"name,omitempty" bson:"name,omitempty"`
+	Type       string    `json:"type,omitempty" bson:"type,omitempty"`
+	Aliases    []string  `json:"aliases,omitempty" bson:"aliases,omitempty"`
+	Keys       []string  `json:"-" bson:"keys,omitempty"` // normalized aliases for exact lookups
+	Embeddings []float32 `json:"-" bson:"embeddings,omitempty"`
+	Updated    int64     `json:"updated,omitempty" bson:"updated,omitempty"` // last time a bean mentioned it
+	Mentions   int       `json:"mentions,omitempty" bson:"-"`                // number of beans mentioning it. only set for trending entities
+}
+
+func getEntityId(entity *BeanEntity) store.JSON {
+	return store.JSON{"_id": entity.ID}
+}
+
+func entityEquals(a, b *BeanEntity) bool {
+	return a.ID == b.ID
+}
+
+// Returns the registered entities that the names are known as. Names that are not in the registry are ignored
+func GetEntities(names []string) []BeanEntity {
+	keys := datautils.Transform(names, func(name *string) string { return nlp.NormalizeEntityName(*name) })
+	return entitystore.Get(
+		store.JSON{"keys": store.JSON{"$in": keys}},
+		store.JSON{"embeddings": 0, "keys": 0},
+		nil, -1)
+}
+
+// returns the ids of the registered entities the ids or names are known as along with the values themselves.
+// Unknown names are kept so that they match nothing instead of everything
+func expandEntities(ids_or_names []string) []string {
+	ids := slices.Clone(ids_or_names)
+	// the registry is only there after Initialize
+	if entitystore != nil {
+		ids = append(ids, getEntityIds(ids_or_names)...)
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+func getEntityIds(names []string) []string {
+	return datautils.Transform(GetEntities(names), func(item *BeanEntity) string { return item.ID })
+}
+
+// number of beans mentioning the entity
+type entityMentions struct {
+	ID    string `bson:"_id"`
+	Count int    `bson:"count"`
+}
+
+// Returns the entities mentioned by the most beans matching the scalar filters of the search options such as: by the day/week, by kind
+func TrendingEntities(options *SearchOptions) []BeanEntity {
+	topn := options.TopN
+	if topn <= 0 {
+		topn = _DEFAULT_TOPN
+	}
+	// counted in the database so that the beans don't need to be loaded
+	counts := store.AggregateAs[entityMentions](beanstore, []store.JSON{
+		{"$match": datautils.AppendMaps(store.JSON{"entities": store.JSON{"$exists": true}}, options.ScalarFilter)},
+		{"$project": store.JSON{"entities": 1}},
+		{"$unwind": "$entities"},
+		{"$group": store.JSON{"_id": "$entities", "count": store.JSON{"$sum": 1}}},
+		{"$sort": store.JSON{"count": -1, "_id": 1}},
+		{"$limit": topn},
+	})
+	if len(counts) == 0 {
+		return nil
+	}
+	mentions := make(map[string]int)
+	datautils.ForEach(counts, func(item *entityMentions) { mentions[item.ID] = item.Count })
+	ids, _ := datautils.MapToArray(mentions)
+
+	entities := entitystore.Get(store.JSON{"_id": store.JSON{"$in": ids}}, store.JSON{"embeddings": 0, "keys": 0}, nil, -1)
+	datautils.ForEach(entities, func(item *BeanEntity) { item.Mentions = mentions[item.ID] })
+	sort.Slice(entities, func(i, j int) bool { return entities[i].Mentions > entities[j].Mentions })
+	return entities
+}
+
+// Maps the extracted entities to the registry and returns the entity IDs for each of the `count` documents.
+// Algorithm:
+//  1. Look up the normalized names in the registry's keys. Exact matches are the same entity regardless of type
+//  2. For the rest, look for an entity of the same type with a similar name embedding and add the name as its alias.
+//     The names of a batch matching the same entity are all added to it
+//  3. Whatever is still left is a new entity
+func resolveEntities(entities []nlp.Entity, count int) [][]string {
+	update_time := time.Now().Unix()
+	unresolved := getUniqueEntities(entities)
+	resolved := make(map[string]string) // normalized name -> entity id
+	keys := datautils.Transform(unresolved, func(item *nlp.Entity) string { return nlp.NormalizeEntityName(item.Name) })
+	datautils.ForEach(keys, func(key *string) { resolved[*key] = "" })
+
+	// 1. exact matches
+	existing := entitystore.Get(store.JSON{"keys": store.JSON{"$in": keys}}, store.JSON{"_id": 1, "keys": 1}, nil, -1)
+	datautils.ForEach(existing, func(item *BeanEntity) {
+		datautils.ForEach(item.Keys, func(key *string) {
+			if id, ok := resolved[*key]; ok && id == "" {
+				resolved[*key] = item.ID
+			}
+		})
+	})
+	unresolved = datautils.Filter(unresolved, func(item *nlp.Entity) bool { return resolved[nlp.NormalizeEntityName(item.Name)] == "" })
+	seen := datautils.Transform(existing, func(item *BeanEntity) any { return store.JSON{"updated": update_time} })
+
+	// 2. similar names
+	var new_entities []BeanEntity
+	matched := make(map[string]int) // entity id -> index in existing and seen
+	if len(unresolved) > 0 {
+		names := datautils.Transform(unresolved, func(item *nlp.Entity) string { return item.Name })
+		embs := embedder.CreateBatchTextEmbeddings(names, nlp.CLASSIFICATION)
+		for i := range unresolved {
+			key := nlp.NormalizeEntityName(unresolved[i].Name)
+			if i < len(embs) && len(embs[i]) > 0 {
+				matches := entitystore.VectorSearch([][]float32{embs[i]},
+					"embeddings",
+					store.WithVectorFilter(store.JSON{"type": unresolved[i].Type}),
+					store.WithMinSearchScore(_DEFAULT_ENTITY_MATCH_SCORE),
+					store.WithVectorTopN(1),
+					store.WithProjection(store.JSON{"_id": 1, "aliases": 1, "keys": 1}))
+				if len(matches) > 0 {
+					match := matches[0]
+					resolved[key] = match.ID
+					// the update is a $set so the aliases of an earlier match in this batch need to carry over
+					if j, ok := matched[match.ID]; ok {
+						update := seen[j].(BeanEntity)
+						update.Aliases = append(update.Aliases, unresolved[i].Name)
+						update.Keys = append(update.Keys, key)
+						seen[j] = update
+						continue
+					}
+					matched[match.ID] = len(seen)
+					existing = append(existing, match)
+					seen = append(seen, BeanEntity{
+						Aliases: append(match.Aliases, unresolved[i].Name),
+						Keys:    append(match.Keys, key),
+						Updated: update_time,
+					})
+					continue
+				}
+			}
+			// 3. new entity. a name that showed up twice in the same batch gets registered once
+			entity := BeanEntity{
+				ID:      unresolved[i].Type + ":" + key,
+				Name:    unresolved[i].Name,
+				Type:    unresolved[i].Type,
+				Aliases: []string{unresolved[i].Name},
+				Keys:    []string{key},
+				Updated: update_time,
+			}
+			if i < len(embs) {
+				entity.Embeddings = embs[i]
+			}
+			resolved[key] = entity.ID
+			new_entities = append(new_entities, entity)
+		}
+	}
+	if len(seen) > 0 {
+		entitystore.Update(seen, getEntityIdFilters(existing))
+	}
+	if len(new_entities) > 0 {
+		entitystore.Add(new_entities)
+	}
+
+	if len(new_entities) > 0 {
+		log.Printf("[beanops] Registered %d new entities.\n", len(new_entities))
+	}
+	return groupEntityIds(entities, resolved, count)
+}
+
+// the entities to resolve. The names that normalize to the same key are resolved once by the first spelling.
+// The names without a key have nothing to be looked up by
+func getUniqueEntities(entities []nlp.Entity) []nlp.Entity {
+	keys := make([]string, 0, len(entities))
+	return datautils.Filter(entities, func(item *nlp.Entity) bool {
+		key := nlp.NormalizeEntityName(item.Name)
+		if len(key) == 0 || slices.Contains(keys, key) {
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	})
+}
+
+// the unique ids of the resolved entities of each of the `count` documents in the order they were extracted
+func groupEntityIds(entities []nlp.Entity, resolved map[string]string, count int) [][]string {
+	ids := make([][]string, count)
+	datautils.ForEach(entities, func(item *nlp.Entity) {
+		id := resolved[nlp.NormalizeEntityName(item.Name)]
+		if id != "" && item.DocIndex >= 0 && item.DocIndex < count && !slices.Contains(ids[item.DocIndex], id) {
+			ids[item.DocIndex] = append(ids[item.DocIndex], id)
+		}
+	})
+	return ids
+}
+
+func getEntityIdFilters(entities []BeanEntity) []store.JSON {
+	return datautils.Transform(entities, func(item *BeanEntity) store.JSON { return getEntityId(item) })
+}
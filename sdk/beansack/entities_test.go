@@ -0,0 +1,83 @@
+package beansack
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
+)
+
+func TestGetUniqueEntities(t *testing.T) {
+	tests := []struct {
+		name     string
+		entities []nlp.Entity
+		want     []string
+	}{
+		{"nothing", nil, []string{}},
+		{"unique", []nlp.Entity{{Name: "OpenAI"}, {Name: "Microsoft"}}, []string{"OpenAI", "Microsoft"}},
+		{"spelling variants by the first spelling", []nlp.Entity{{Name: "Open AI", DocIndex: 1}, {Name: "OpenAI Inc."}, {Name: "openai"}}, []string{"Open AI"}},
+		{"across documents", []nlp.Entity{{Name: "Fortra", DocIndex: 0}, {Name: "GoAnywhere", DocIndex: 1}, {Name: "Fortra", DocIndex: 2}}, []string{"Fortra", "GoAnywhere"}},
+		{"regardless of the type", []nlp.Entity{{Name: "Apple", Type: nlp.ORG_ENTITY}, {Name: "Apple", Type: nlp.PRODUCT_ENTITY}}, []string{"Apple"}},
+		{"no key", []nlp.Entity{{Name: ""}, {Name: " - "}, {Name: "Fortra"}}, []string{"Fortra"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, entity := range getUniqueEntities(test.entities) {
+				got = append(got, entity.Name)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("getUniqueEntities() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGroupEntityIds(t *testing.T) {
+	resolved := map[string]string{"openai": "org:openai", "fortra": "org:fortra", "cve20240204": "cve:cve20240204", "failed": ""}
+	tests := []struct {
+		name     string
+		entities []nlp.Entity
+		count    int
+		want     [][]string
+	}{
+		{"nothing", nil, 2, [][]string{nil, nil}},
+		{
+			name:     "by document",
+			entities: []nlp.Entity{{Name: "Fortra", DocIndex: 1}, {Name: "OpenAI", DocIndex: 0}, {Name: "CVE-2024-0204", DocIndex: 1}},
+			count:    3,
+			want:     [][]string{{"org:openai"}, {"org:fortra", "cve:cve20240204"}, nil},
+		},
+		{
+			name:     "spelling variants once",
+			entities: []nlp.Entity{{Name: "OpenAI", DocIndex: 0}, {Name: "Open AI Inc", DocIndex: 0}, {Name: "openai", DocIndex: 1}},
+			count:    2,
+			want:     [][]string{{"org:openai"}, {"org:openai"}},
+		},
+		{
+			name:     "not resolved",
+			entities: []nlp.Entity{{Name: "Failed", DocIndex: 0}, {Name: "Unknown", DocIndex: 0}, {Name: "Fortra", DocIndex: 0}},
+			count:    1,
+			want:     [][]string{{"org:fortra"}},
+		},
+		{
+			name:     "outside of the documents",
+			entities: []nlp.Entity{{Name: "OpenAI", DocIndex: -1}, {Name: "Fortra", DocIndex: 2}, {Name: "Fortra", DocIndex: 1}},
+			count:    2,
+			want:     [][]string{nil, {"org:fortra"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := groupEntityIds(test.entities, resolved, test.count); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("groupEntityIds() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExpandEntitiesWithoutRegistry(t *testing.T) {
+	if got, want := expandEntities([]string{"org:fortra", "OpenAI", "org:fortra"}), []string{"OpenAI", "org:fortra"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expandEntities() = %v, want %v", got, want)
+	}
+}
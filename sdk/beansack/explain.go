@@ -0,0 +1,62 @@
+package beansack
+
+import (
+	datautils "github.com/soumitsalman/data-utils"
+)
+
+// how a bean was found
+const (
+	GET_SEARCH_MODE           = "get"           // scalar filters only
+	TEXT_SEARCH_MODE          = "text"          // text search over the keywords
+	VECTOR_SEARCH_MODE        = "vector"        // vector search over the categories or the context
+	TEXT_FALLBACK_SEARCH_MODE = "text_fallback" // text search after the vector search over the context found nothing
+	NUGGET_SEARCH_MODE        = "nugget"        // mapped to the nuggets or mentions their entities
+	RELATED_SEARCH_MODE       = "related"       // vector search over the category embeddings of another bean
+)
+
+// Why a bean is in the results and where it ranks. Only set when the search options ask for explanations
+type SearchExplanation struct {
+	Mode        string  `json:"mode"`
+	VectorScore float64 `json:"vector_score,omitempty"` // cosine similarity to the closest of the query embeddings
+	TextScore   float64 `json:"text_score,omitempty"`
+	MinScore    float64 `json:"min_score,omitempty"` // the vector search threshold the score had to clear
+	// the category texts or the context the query embeddings were created from
+	Query             []string `json:"query,omitempty"`
+	MatchedCategories []string `json:"matched_categories,omitempty"` // ids of the taxonomy categories of the bean
+	MatchedNuggets    []string `json:"matched_nuggets,omitempty"`    // keyphrases of the nuggets the bean got in through or its trend score comes from
+	MatchedEntities   []string `json:"matched_entities,omitempty"`   // ids of the searched entities the bean mentions
+	TrendScore        int      `json:"trend_score,omitempty"`
+	NoiseScore        int      `json:"noise_score,omitempty"` // media noise score of the bean
+	Velocity          float64  `json:"velocity,omitempty"`
+	Rank              int      `json:"rank"` // 1-based position in the results
+}
+
+// starts the explanation of each bean with how the search found it
+func explainSearch(beans []Bean, mode string, min_score float64, query []string) []Bean {
+	return datautils.ForEach(beans, func(bean *Bean) {
+		explanation := &SearchExplanation{Mode: mode, Query: query}
+		switch mode {
+		case VECTOR_SEARCH_MODE, RELATED_SEARCH_MODE:
+			explanation.VectorScore = bean.SearchScore
+			explanation.MinScore = min_score
+		case TEXT_SEARCH_MODE, TEXT_FALLBACK_SEARCH_MODE:
+			explanation.TextScore = bean.SearchScore
+		}
+		explanation.MatchedCategories = datautils.Transform(bean.Categories, func(item *CategoryMatch) string { return item.ID })
+		bean.Explanation = explanation
+	})
+}
+
+// finishes the explanations with the final ranks and the noise contributions
+func explainRanks(beans []Bean) []Bean {
+	for i := range beans {
+		bean := &beans[i]
+		if bean.Explanation == nil {
+			bean.Explanation = &SearchExplanation{}
+		}
+		bean.Explanation.Rank = i + 1
+		bean.Explanation.NoiseScore = getNoiseScore(bean)
+		bean.Explanation.Velocity = bean.Velocity
+	}
+	return beans
+}
@@ -0,0 +1,109 @@
+package beansack
+
+import (
+	"reflect"
+	"testing"
+)
+
+// the options of a fuzzy search over the texts, the context or the embeddings
+func searchOptions(texts []string, context string, embeddings [][]float32) *SearchOptions {
+	options := NewSearchOptions()
+	options.SearchTexts, options.Context, options.SearchEmbeddings = texts, context, embeddings
+	return options
+}
+
+func TestGetSearchModeName(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    int
+		options *SearchOptions
+		want    string
+	}{
+		{"get", _GET, NewSearchOptions(), GET_SEARCH_MODE},
+		{"text", _TEXT, NewSearchOptions(), TEXT_SEARCH_MODE},
+		{"vector over the categories", _VECTOR, searchOptions([]string{"cybersecurity"}, "", nil), VECTOR_SEARCH_MODE},
+		{"vector over the context", _VECTOR, searchOptions(nil, "what's new in rust", nil), VECTOR_SEARCH_MODE},
+		{"vector or text", _VECTOR_OR_TEXT, searchOptions(nil, "what's new in rust", nil), VECTOR_SEARCH_MODE},
+		{"text after the context found nothing", _TEXT, searchOptions(nil, "what's new in rust", nil), TEXT_FALLBACK_SEARCH_MODE},
+		{"text after the categories found nothing", _TEXT, searchOptions([]string{"cybersecurity"}, "", nil), TEXT_FALLBACK_SEARCH_MODE},
+		{"text after the embeddings found nothing", _TEXT, searchOptions(nil, "", [][]float32{{1, 0}}), TEXT_FALLBACK_SEARCH_MODE},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := getSearchModeName(test.mode, test.options); got != test.want {
+				t.Errorf("getSearchModeName() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetSearchQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     int
+		options  *SearchOptions
+		keywords []string
+		want     []string
+	}{
+		{"get", _GET, NewSearchOptions(), nil, nil},
+		{"text", _TEXT, NewSearchOptions(), []string{"rust"}, []string{"rust"}},
+		{"text fallback uses the keywords", _TEXT, searchOptions(nil, "what's new in rust", nil), []string{"rust"}, []string{"rust"}},
+		{"categories", _VECTOR, searchOptions([]string{"cybersecurity", "ai"}, "", nil), nil, []string{"cybersecurity", "ai"}},
+		{"context", _VECTOR, searchOptions(nil, "what's new in rust", nil), nil, []string{"what's new in rust"}},
+		{"embeddings have no text", _VECTOR, searchOptions([]string{"ai"}, "", [][]float32{{1, 0}}), nil, nil},
+		{"categories before the context", _VECTOR, searchOptions([]string{"ai"}, "what's new in rust", nil), nil, []string{"ai"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := getSearchQuery(test.mode, test.options, test.keywords); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("getSearchQuery() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExplainSearch(t *testing.T) {
+	bean := Bean{Url: "a", SearchScore: 0.8, Categories: []CategoryMatch{{ID: "ai"}, {ID: "tech"}}}
+	tests := []struct {
+		name string
+		mode string
+		want SearchExplanation
+	}{
+		{"vector", VECTOR_SEARCH_MODE, SearchExplanation{Mode: VECTOR_SEARCH_MODE, VectorScore: 0.8, MinScore: 0.7, Query: []string{"q"}, MatchedCategories: []string{"ai", "tech"}}},
+		{"related", RELATED_SEARCH_MODE, SearchExplanation{Mode: RELATED_SEARCH_MODE, VectorScore: 0.8, MinScore: 0.7, Query: []string{"q"}, MatchedCategories: []string{"ai", "tech"}}},
+		{"text", TEXT_SEARCH_MODE, SearchExplanation{Mode: TEXT_SEARCH_MODE, TextScore: 0.8, Query: []string{"q"}, MatchedCategories: []string{"ai", "tech"}}},
+		{"text fallback", TEXT_FALLBACK_SEARCH_MODE, SearchExplanation{Mode: TEXT_FALLBACK_SEARCH_MODE, TextScore: 0.8, Query: []string{"q"}, MatchedCategories: []string{"ai", "tech"}}},
+		{"get", GET_SEARCH_MODE, SearchExplanation{Mode: GET_SEARCH_MODE, Query: []string{"q"}, MatchedCategories: []string{"ai", "tech"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			beans := explainSearch([]Bean{bean}, test.mode, 0.7, []string{"q"})
+			if beans[0].Explanation == nil || !reflect.DeepEqual(*beans[0].Explanation, test.want) {
+				t.Errorf("explainSearch() = %+v, want %+v", beans[0].Explanation, test.want)
+			}
+		})
+	}
+}
+
+func TestExplainRanks(t *testing.T) {
+	beans := []Bean{
+		{Url: "a", Explanation: &SearchExplanation{Mode: VECTOR_SEARCH_MODE, VectorScore: 0.9}, MediaNoise: &MediaNoise{Score: 40}, Velocity: 2.5},
+		// the beans added after the search such as the ones of the nuggets have no explanation yet
+		{Url: "b"},
+		{Url: "c", Explanation: &SearchExplanation{Mode: VECTOR_SEARCH_MODE, VectorScore: 0.7}},
+	}
+	beans = explainRanks(beans)
+	want := []SearchExplanation{
+		{Mode: VECTOR_SEARCH_MODE, VectorScore: 0.9, NoiseScore: 40, Velocity: 2.5, Rank: 1},
+		{Rank: 2},
+		{Mode: VECTOR_SEARCH_MODE, VectorScore: 0.7, Rank: 3},
+	}
+	for i := range beans {
+		if !reflect.DeepEqual(*beans[i].Explanation, want[i]) {
+			t.Errorf("explainRanks()[%d] = %+v, want %+v", i, *beans[i].Explanation, want[i])
+		}
+	}
+	if got := explainRanks(nil); len(got) != 0 {
+		t.Errorf("explainRanks(nil) = %v, want nothing", got)
+	}
+}
@@ -0,0 +1,145 @@
+package beansack
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// the fields the beans can be counted by
+const (
+	SOURCE_FACET     = "source"
+	KIND_FACET       = "kind"
+	TOPIC_FACET      = "topic"
+	KEYWORD_FACET    = "keywords"
+	ENTITY_FACET     = "entities"   // ids of the entities in the registry
+	CHANNEL_FACET    = "channel"    // channels of the media noises of the beans such as the subreddits
+	UPDATED_FACET    = "updated"    // days of the update time in UTC
+	CREATED_FACET    = "created"    // days of the publish time in UTC
+	SOURCE_DAY_FACET = "source_day" // days of the time field of the options by source
+)
+
+const (
+	_FACET_SEARCH_TOPN = 1000 // beans of a vector or text search the counts are computed over
+	_FACET_WINDOW      = 7    // days counted over when the options have no time filter
+)
+
+var _FACETS = []string{SOURCE_FACET, KIND_FACET, TOPIC_FACET, KEYWORD_FACET, ENTITY_FACET, CHANNEL_FACET, UPDATED_FACET, CREATED_FACET, SOURCE_DAY_FACET}
+
+// number of beans with the value. Days are YYYY-MM-DD
+type FacetCount struct {
+	Value  string `json:"value" bson:"_id"`
+	Source string `json:"source,omitempty" bson:"source,omitempty"` // the source of the day of a source_day count
+	Count  int    `json:"count" bson:"count"`
+}
+
+// the counts keyed by the facet
+type BeanFacets map[string][]FacetCount
+
+// Counts the beans matching the options by each of the fields. Empty fields means all of them.
+// The scalar filters are counted over as is. A vector or text search is counted over its top beans.
+// The days are listed oldest first, the days by source oldest first and then by source, and the rest of the values by their count, options.TopN of them.
+// No time filter means the last _FACET_WINDOW days
+func Facets(options *SearchOptions, fields []string) (BeanFacets, error) {
+	if len(fields) == 0 {
+		fields = _FACETS
+	}
+	if i := slices.IndexFunc(fields, func(field string) bool { return !slices.Contains(_FACETS, field) }); i >= 0 {
+		return nil, BeanSackError(fmt.Sprintf("%q is not a facet", fields[i]))
+	}
+
+	filter := getFacetFilter(options)
+	if options.hasFuzzySearch() {
+		search_options := *options
+		// the default window applies to the search as well
+		search_options.ScalarFilter = filter
+		search_options.TopN = _FACET_SEARCH_TOPN
+		search_options.Explain = false
+		urls := datautils.Transform(FuzzySearch(&search_options), func(item *Bean) string { return item.Url })
+		filter = store.JSON{"url": store.JSON{"$in": urls}}
+	}
+
+	topn := options.TopN
+	if topn <= 0 {
+		topn = _DEFAULT_TOPN
+	}
+	res := store.AggregateAs[BeanFacets](beanstore, getFacetsPipeline(filter, fields, options.getTimeField(), topn))
+	if len(res) == 0 {
+		return BeanFacets{}, nil
+	}
+	return res[0], nil
+}
+
+// every bean of the filter is counted. The groups of the facets keep the output bounded
+func getFacetsPipeline(filter store.JSON, fields []string, time_field string, topn int) []store.JSON {
+	facets := make(store.JSON, len(fields))
+	datautils.ForEach(fields, func(field *string) { facets[*field] = getFacetPipeline(*field, time_field, topn) })
+	return []store.JSON{
+		{"$match": filter},
+		{"$project": store.JSON{"url": 1, "source": 1, "kind": 1, "topic": 1, "keywords": 1, "entities": 1, "updated": 1, "created": 1}},
+		{"$facet": facets},
+	}
+}
+
+// the scalar filter of the options with the default window if it has no time filter
+func getFacetFilter(options *SearchOptions) store.JSON {
+	filter := options.ScalarFilter
+	if filter[UPDATED_TIME] == nil && filter[CREATED_TIME] == nil {
+		filter = datautils.AppendMaps(store.JSON{options.getTimeField(): store.JSON{"$gte": timeValue(_FACET_WINDOW)}}, filter)
+	}
+	return filter
+}
+
+// time_field is the one the source_day facet counts the days of
+func getFacetPipeline(field, time_field string, topn int) []store.JSON {
+	var pipeline []store.JSON
+	switch field {
+	case UPDATED_FACET, CREATED_FACET:
+		return []store.JSON{
+			{"$match": store.JSON{field: store.JSON{"$gt": 0}}},
+			{"$group": store.JSON{"_id": getDayExpression(field), "count": store.JSON{"$sum": 1}}},
+			{"$sort": store.JSON{"_id": 1}},
+		}
+	case SOURCE_DAY_FACET:
+		return []store.JSON{
+			{"$match": store.JSON{time_field: store.JSON{"$gt": 0}, "source": store.JSON{"$nin": []any{nil, ""}}}},
+			{"$group": store.JSON{"_id": store.JSON{"day": getDayExpression(time_field), "source": "$source"}, "count": store.JSON{"$sum": 1}}},
+			{"$project": store.JSON{"_id": "$_id.day", "source": "$_id.source", "count": 1}},
+			{"$sort": bson.D{{Key: "_id", Value: 1}, {Key: "source", Value: 1}}},
+		}
+	case CHANNEL_FACET:
+		// each bean counts once per channel regardless of how many times its media noise got collected
+		pipeline = []store.JSON{
+			{"$lookup": store.JSON{"from": NOISES, "localField": "url", "foreignField": "mapped_url", "as": "noises"}},
+			{"$unwind": "$noises"},
+			{"$match": store.JSON{"noises.channel": store.JSON{"$nin": []any{nil, ""}}}},
+			{"$group": store.JSON{"_id": store.JSON{"url": "$url", "channel": "$noises.channel"}}},
+			{"$group": store.JSON{"_id": "$_id.channel", "count": store.JSON{"$sum": 1}}},
+		}
+	case KEYWORD_FACET, ENTITY_FACET:
+		pipeline = []store.JSON{
+			{"$unwind": "$" + field},
+			{"$group": store.JSON{"_id": "$" + field, "count": store.JSON{"$sum": 1}}},
+		}
+	default:
+		pipeline = []store.JSON{
+			{"$match": store.JSON{field: store.JSON{"$nin": []any{nil, ""}}}},
+			{"$group": store.JSON{"_id": "$" + field, "count": store.JSON{"$sum": 1}}},
+		}
+	}
+	// the keys of a sort are in order
+	return append(pipeline,
+		store.JSON{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+		store.JSON{"$limit": topn})
+}
+
+// YYYY-MM-DD of the time field in UTC. The times are in seconds
+func getDayExpression(field string) store.JSON {
+	return store.JSON{"$dateToString": store.JSON{
+		"format": "%Y-%m-%d",
+		"date":   store.JSON{"$toDate": store.JSON{"$multiply": []any{"$" + field, 1000}}},
+	}}
+}
@@ -0,0 +1,193 @@
+package beansack
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestGetFacetFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    *SearchOptions
+		want_field string // the time field of the default window. empty means no default window
+	}{
+		{"default window", NewSearchOptions(), UPDATED_TIME},
+		{"default window of the publish time", NewSearchOptions().WithTimeField(CREATED_TIME), CREATED_TIME},
+		{"time window", NewSearchOptions().WithTimeWindow(2), ""},
+		{"publish time window", NewSearchOptions().WithTimeField(CREATED_TIME).WithTimeWindow(2), ""},
+		{"date range", NewSearchOptions().WithDateRange(time.Now().AddDate(0, 0, -30), time.Time{}), ""},
+		{"other filters only", NewSearchOptions().WithKind([]string{"news"}), UPDATED_TIME},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := store.JSON(datautils.AppendMaps(store.JSON{}, test.options.ScalarFilter))
+			filter := getFacetFilter(test.options)
+			if test.want_field == "" {
+				if !reflect.DeepEqual(filter, test.options.ScalarFilter) {
+					t.Errorf("getFacetFilter() = %v, want the filter of the options %v", filter, test.options.ScalarFilter)
+				}
+				return
+			}
+			condition, ok := filter[test.want_field].(store.JSON)
+			if !ok {
+				t.Fatalf("getFacetFilter() = %v, want a default window on %q", filter, test.want_field)
+			}
+			if since := condition["$gte"].(int64); since > timeValue(_FACET_WINDOW) || since < timeValue(_FACET_WINDOW)-60 {
+				t.Errorf("default window starts at %d, want %d days ago", since, _FACET_WINDOW)
+			}
+			for key, value := range before {
+				if !reflect.DeepEqual(filter[key], value) {
+					t.Errorf("getFacetFilter()[%q] = %v, want %v", key, filter[key], value)
+				}
+			}
+			if !reflect.DeepEqual(test.options.ScalarFilter, before) {
+				t.Errorf("getFacetFilter() changed the options to %v", test.options.ScalarFilter)
+			}
+		})
+	}
+}
+
+func TestGetFacetPipeline(t *testing.T) {
+	by_count := bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}
+	tests := []struct {
+		name       string
+		field      string
+		time_field string
+		stages     []string // the stages in order
+		match      store.JSON
+		group_id   any
+		sort       any
+		limit      bool
+	}{
+		{
+			name:     "source",
+			field:    SOURCE_FACET,
+			stages:   []string{"$match", "$group", "$sort", "$limit"},
+			match:    store.JSON{"source": store.JSON{"$nin": []any{nil, ""}}},
+			group_id: "$source",
+			sort:     by_count,
+			limit:    true,
+		},
+		{
+			name:     "keywords are unwound",
+			field:    KEYWORD_FACET,
+			stages:   []string{"$unwind", "$group", "$sort", "$limit"},
+			group_id: "$keywords",
+			sort:     by_count,
+			limit:    true,
+		},
+		{
+			name:     "entities are unwound",
+			field:    ENTITY_FACET,
+			stages:   []string{"$unwind", "$group", "$sort", "$limit"},
+			group_id: "$entities",
+			sort:     by_count,
+			limit:    true,
+		},
+		{
+			name:     "channels are looked up",
+			field:    CHANNEL_FACET,
+			stages:   []string{"$lookup", "$unwind", "$match", "$group", "$group", "$sort", "$limit"},
+			group_id: store.JSON{"url": "$url", "channel": "$noises.channel"},
+			sort:     by_count,
+			limit:    true,
+		},
+		{
+			name:     "days of the update time",
+			field:    UPDATED_FACET,
+			stages:   []string{"$match", "$group", "$sort"},
+			match:    store.JSON{"updated": store.JSON{"$gt": 0}},
+			group_id: getDayExpression("updated"),
+			sort:     store.JSON{"_id": 1},
+		},
+		{
+			name:       "days of the publish time regardless of the time field",
+			field:      CREATED_FACET,
+			time_field: UPDATED_TIME,
+			stages:     []string{"$match", "$group", "$sort"},
+			match:      store.JSON{"created": store.JSON{"$gt": 0}},
+			group_id:   getDayExpression("created"),
+			sort:       store.JSON{"_id": 1},
+		},
+		{
+			name:       "source by day of the time field",
+			field:      SOURCE_DAY_FACET,
+			time_field: CREATED_TIME,
+			stages:     []string{"$match", "$group", "$project", "$sort"},
+			match:      store.JSON{"created": store.JSON{"$gt": 0}, "source": store.JSON{"$nin": []any{nil, ""}}},
+			group_id:   store.JSON{"day": getDayExpression("created"), "source": "$source"},
+			sort:       bson.D{{Key: "_id", Value: 1}, {Key: "source", Value: 1}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			time_field := test.time_field
+			if time_field == "" {
+				time_field = UPDATED_TIME
+			}
+			pipeline := getFacetPipeline(test.field, time_field, 5)
+			stages := make([]string, 0, len(pipeline))
+			stage := func(name string) any {
+				for _, item := range pipeline {
+					if value, ok := item[name]; ok {
+						return value
+					}
+				}
+				return nil
+			}
+			for _, item := range pipeline {
+				for name := range item {
+					stages = append(stages, name)
+				}
+			}
+			if !reflect.DeepEqual(stages, test.stages) {
+				t.Fatalf("stages = %v, want %v", stages, test.stages)
+			}
+			if test.match != nil && !reflect.DeepEqual(stage("$match"), test.match) {
+				t.Errorf("$match = %v, want %v", stage("$match"), test.match)
+			}
+			if group := stage("$group").(store.JSON); !reflect.DeepEqual(group["_id"], test.group_id) {
+				t.Errorf("$group._id = %v, want %v", group["_id"], test.group_id)
+			}
+			if !reflect.DeepEqual(stage("$sort"), test.sort) {
+				t.Errorf("$sort = %v, want %v", stage("$sort"), test.sort)
+			}
+			if limit := stage("$limit"); (limit != nil) != test.limit || (test.limit && limit != 5) {
+				t.Errorf("$limit = %v, want it: %v", limit, test.limit)
+			}
+		})
+	}
+}
+
+func TestFacetsRejectsUnknownFields(t *testing.T) {
+	if _, err := Facets(NewSearchOptions(), []string{SOURCE_FACET, "color"}); err == nil {
+		t.Error("Facets() with an unknown field returned no error")
+	}
+}
+
+func TestGetFacetsPipeline(t *testing.T) {
+	filter := store.JSON{"source": "reddit"}
+	pipeline := getFacetsPipeline(filter, []string{SOURCE_FACET, SOURCE_DAY_FACET}, CREATED_TIME, 5)
+	stages := make([]string, 0, len(pipeline))
+	for _, item := range pipeline {
+		for name := range item {
+			stages = append(stages, name)
+		}
+	}
+	// a limit before the facets would leave the counts short without any sign
+	if want := []string{"$match", "$project", "$facet"}; !reflect.DeepEqual(stages, want) {
+		t.Fatalf("stages = %v, want %v", stages, want)
+	}
+	if !reflect.DeepEqual(pipeline[0]["$match"], filter) {
+		t.Errorf("$match = %v, want %v", pipeline[0]["$match"], filter)
+	}
+	facets := pipeline[2]["$facet"].(store.JSON)
+	if len(facets) != 2 || !reflect.DeepEqual(facets[SOURCE_DAY_FACET], getFacetPipeline(SOURCE_DAY_FACET, CREATED_TIME, 5)) {
+		t.Errorf("$facet = %v, want the pipelines of the fields", facets)
+	}
+}
@@ -2,13 +2,18 @@ package beansack
 
 import (
 	"log"
+	"sync"
 	"time"
 
 	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
 	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
 	datautils "github.com/soumitsalman/data-utils"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
+// the background work of AddBeans
+var background sync.WaitGroup
+
 const (
 	_MIN_RECTIFY_WINDOW = 2
 	_MAX_RECTIFY_WINDOW = 30
@@ -16,20 +21,29 @@ const (
 
 // default configurations
 const (
-	_MIN_TEXT_LENGTH                 = 100 // content length for processing for NLP driver
-	_RECT_BATCH_SIZE                 = 10  // rectification
+	_MIN_TEXT_LENGTH                 = 100   // content length for processing for NLP driver
+	_MAX_TEXT_SIZE                   = 30000 // tokens of the content that get stored with the summarization modes other than stuff since they work over all of it
+	_RECT_BATCH_SIZE                 = 10    // rectification
+	_CLAIM_LEASE                     = 3600  // seconds Rectify leaves the work claimed by a collection run alone
 	_DEFAULT_NUGGET_MATCH_SCORE      = 0.73
 	_DEFAULT_NUGGET_TEXT_MATCH_SCORE = 10
 )
 
 // var _GENERATED_FIELDS = []string{_CATEGORY_EMB, _SEARCH_EMB, _SUMMARY}
 // removing search embeddings
-var _GENERATED_FIELDS = []string{_CLASSIFICATION_EMB, _SUMMARY}
+// categories and stories need to come after the category embeddings since they are computed from them.
+// the summaries come first since the translations are made from them and the rest from the translations
+var _GENERATED_FIELDS = []string{_SUMMARY, _CLASSIFICATION_EMB, _CATEGORIES, _STORY, _SENTIMENT, _ENTITIES}
 
 func Cleanup(delete_window int) {
 	delete_filter := store.JSON{
 		"updated": store.JSON{"$lte": timeValue(delete_window)},
 	}
+	// the deliveries go with their beans so that they don't pile up
+	deleted_urls := datautils.Transform(
+		beanstore.Get(datautils.AppendMaps(store.JSON{"kind": store.JSON{"$ne": CHANNEL}}, delete_filter), store.JSON{"url": 1}, nil, -1),
+		func(item *Bean) string { return item.Url })
+	deliverystore.Delete(store.JSON{"mapped_url": store.JSON{"$in": deleted_urls}})
 	// delete old stuff
 	beanstore.Delete(
 		datautils.AppendMaps(
@@ -40,26 +54,38 @@ func Cleanup(delete_window int) {
 	)
 	noisestore.Delete(delete_filter)
 	nuggetstore.Delete(delete_filter)
+	// the entity registry is not expired since the beans, nuggets and briefings that are kept still refer to the entities by their ids
+	storystore.Delete(store.JSON{"last_seen": store.JSON{"$lte": timeValue(delete_window)}})
+	engagementstore.Delete(store.JSON{"timestamp": store.JSON{"$lte": timeValue(delete_window)}})
+	if cachestore != nil {
+		cachestore.Delete(delete_filter)
+	}
 }
 
 // Adding feeds from news sources and social media
 // Steps:
-//  1. Filter out the tiny ones for now
-//  2. Truncate the contents to keep below the limit
+//  1. Filter out the tiny ones for now and the ones in languages that should be dropped. Link the near-duplicates to their originals
+//  2. Truncate the contents to keep below the limit and assign update time
 //  3. Add the beans to the database
-//  4. Add media noise to database
-//  5. Create news nuggets and add to db
-//  6. Create embeddings for news nuggets and add to db
-//  7. Create generated fields for the beans and add them to database
+//  4. Add media noise to database along with a point in the engagement history and score the community reaction in their digests in the background.
+//     The media noise of a duplicate goes to its original
+//  5. Summarize the beans and translate the titles and the summaries of the ones that need translation.
+//     The rest is generated from the translations of those. The duplicates are skipped from here on
+//  6. Create news nuggets and their embeddings and add to db
+//  7. Create the rest of the generated fields for the beans and add them to database
 //  8. Map the news nuggets to the beans
 func AddBeans(beans []Bean) {
 	// 1. Filter out the tiny ones and the channels for now
 	beans = datautils.Filter(beans, func(item *Bean) bool { return (len(item.Text) >= _MIN_TEXT_LENGTH) && (item.Kind != CHANNEL) })
+	beans = detectDuplicates(detectLanguages(canonicalizeBeans(beans)))
 
 	// extract out the beans medianoises
 	medianoises := datautils.FilterAndTransform(beans, func(item *Bean) (bool, MediaNoise) {
 		if item.MediaNoise != nil {
 			item.MediaNoise.BeanUrl = item.Url
+			if item.DuplicateOf != "" {
+				item.MediaNoise.BeanUrl = item.DuplicateOf
+			}
 			return true, *item.MediaNoise
 		} else {
 			return false, MediaNoise{}
@@ -67,10 +93,12 @@ func AddBeans(beans []Bean) {
 	})
 
 	// 2. Truncate the contents to keep below the limit and assign update time
+	// the new beans are claimed for the nuggets generation in the background so that Rectify doesn't generate them again
 	update_time := time.Now().Unix()
 	beans = datautils.ForEach(beans, func(item *Bean) {
 		item.Updated = update_time
-		item.Text = nlp.TruncateTextOnTokenCount(item.Text, embedder.Ctx)
+		item.NuggetsClaimed = update_time
+		item.Text = nlp.TruncateTextOnTokenCount(item.Text, maxTextSize())
 		item.MediaNoise = nil
 	})
 
@@ -82,6 +110,8 @@ func AddBeans(beans []Bean) {
 		log.Println("[beansack|Indexer] Failed to add new beans. Terminating early.", err)
 		return
 	}
+	// the duplicates get stored but nothing gets generated for them
+	beans = datautils.Filter(beans, isOriginal)
 
 	// 4. Add media noise to database
 	if len(medianoises) > 0 {
@@ -89,43 +119,74 @@ func AddBeans(beans []Bean) {
 		beans_update := make([]any, 0, len(medianoises))
 		beans_ids := make([]store.JSON, 0, len(medianoises))
 
+		// the media noises are claimed for the scoring in the background so that Rectify doesn't score them again
 		datautils.ForEach(medianoises, func(item *MediaNoise) {
 			item.Updated = update_time
-			item.Digest = nlp.TruncateTextOnTokenCount(item.Digest, embedder.Ctx)
+			item.SentimentClaimed = update_time
+			item.Digest = nlp.TruncateTextOnTokenCount(item.Digest, embedder.ContextWindow())
 			// create the update times for the beans
 			beans_update = append(beans_update, store.JSON{"updated": update_time})
 			beans_ids = append(beans_ids, store.JSON{"url": item.BeanUrl})
 		})
 		// now store the medianoises. But no need to check for error since their storage is auxiliary for the overall experience
 		noisestore.Add(medianoises)
+		recordEngagements(medianoises, update_time)
+		// this does not need to block the collection. the ones that don't get scored are picked up by Rectify
+		runInBackground(func() { scoreMediaNoises(medianoises) })
 		// update the beans with medianoise
 		beanstore.Update(beans_update, beans_ids)
 	}
 
 	// if no new bean got added then no need to go through hoops for these
 	if len(beans) > 0 {
-		// 5. Create news nuggets and add to db
-		// 6. Create embeddings for news nuggets and add to db
+		// 5. Summarize and translate
+		generateFieldForBeans(beans, _SUMMARY)
+		// the summaries are only in the database
+		urls := store.JSON{"url": store.JSON{"$in": datautils.Transform(beans, func(item *Bean) string { return item.Url })}}
+		translateBeans(getUntranslatedBeans(urls))
+		beans = attachTranslations(beans)
+
+		// 6. Create news nuggets and their embeddings and add to db
 		// parallelizing this one since its a different server than the embeddings
 		// this will be faster than going through the custom fields
-		go generateNewsNuggets(beans)
+		runInBackground(func() { generateNewsNuggets(beans) })
 
-		// 7. Create generated fields for the beans and add them to database
+		// 7. Create the rest of the generated fields for the beans and add them to database
 		generateCustomFieldsForBeans(beans)
 
 		// 8. Map the news nuggets to the beans
 		// this is remap across the board that will take place for each Add Beans to keep the mapping fresh
 		// even if not all the nuggets have been generated the new incoming nuggests will get mapped during the next rounds
 		// this can happen in parallel and does not need to block the call
-		go remapNewsNuggets(_MIN_RECTIFY_WINDOW)
+		runInBackground(func() { remapNewsNuggets(_MIN_RECTIFY_WINDOW) })
 	}
 }
 
+// the summaries are generated before the translations
 func generateCustomFieldsForBeans(beans []Bean) {
-	datautils.ForEach(_GENERATED_FIELDS, func(field_name *string) { generateFieldForBeans(beans, *field_name) })
+	datautils.ForEach(getGeneratedFields(), func(field_name *string) {
+		if *field_name != _SUMMARY {
+			generateFieldForBeans(beans, *field_name)
+		}
+	})
+}
+
+// categories are only generated when there is a taxonomy
+func getGeneratedFields() []string {
+	return datautils.Filter(_GENERATED_FIELDS, func(field_name *string) bool { return *field_name != _CATEGORIES || len(taxonomy) > 0 })
 }
 
 func generateFieldForBeans(beans []Bean, field_name string) {
+	if field_name != _SUMMARY {
+		beans = datautils.Filter(beans, isTranslated)
+		if len(beans) == 0 {
+			return
+		}
+	}
+	// these will get picked up by Rectify once the budget frees up or the service is back
+	if field_name != _CATEGORIES && field_name != _STORY && deferNLPWork(getFieldModel(field_name), field_name+" generation", len(beans)) {
+		return
+	}
 	log.Printf("[beanops] Generating %s for a batch of %d beans", field_name, len(beans))
 
 	// get identifier and text content for processing
@@ -136,28 +197,135 @@ func generateFieldForBeans(beans []Bean, field_name string) {
 	switch field_name {
 	case _CLASSIFICATION_EMB:
 		cat_embs := embedder.CreateBatchTextEmbeddings(texts, nlp.CLASSIFICATION)
-		updates = datautils.Transform(cat_embs, func(emb *[]float32) any {
-			return Bean{CategoryEmbeddings: *emb}
-		})
+		updates = datautils.Transform(cat_embs, func(emb *[]float32) any { return Bean{CategoryEmbeddings: *emb} })
+	case _CATEGORIES:
+		// no LLM call. this only compares the category embeddings
+		updates, filters = classifyBeans(beans)
+	case _STORY:
+		// no LLM call either
+		updates, filters = clusterBeans(beans)
 	case _SUMMARY:
 		// summary and topic. but topic is low priority field and it comes with summary
 		digests := pb_client.ExtractDigests(texts)
 		updates = datautils.Transform(digests, func(item *nlp.Digest) any { return item })
+	case _SENTIMENT:
+		sentiments := pb_client.ScoreSentiments(texts)
+		updates = datautils.Transform(sentiments, func(item *nlp.Sentiment) any { return item })
+	case _ENTITIES:
+		// only update the beans that got entities so that Rectify retries the rest
+		entity_ids := resolveEntities(pb_client.ExtractEntities(texts), len(texts))
+		filters = make([]store.JSON, 0, len(beans))
+		for i := range beans {
+			if len(entity_ids[i]) > 0 {
+				filters = append(filters, getBeanId(&beans[i]))
+				updates = append(updates, Bean{Entities: entity_ids[i]})
+			}
+		}
 	}
 	beanstore.Update(updates, filters)
+	// the fields Rectify fills in later move it too so that the subscriptions see the beans again
+	enriched, enriched_filters := getEnrichedUpdates(updates, filters, time.Now().Unix())
+	beanstore.Update(enriched, enriched_filters)
+}
+
+// the enrichment time of the beans whose updates write anything. The duds are left out since nothing got generated for them
+func getEnrichedUpdates(updates []any, filters []store.JSON, enriched int64) ([]any, []store.JSON) {
+	enriched_updates := make([]any, 0, len(updates))
+	enriched_filters := make([]store.JSON, 0, len(updates))
+	for i := range updates {
+		if i < len(filters) && !isEmptyUpdate(updates[i]) {
+			enriched_updates = append(enriched_updates, store.JSON{_ENRICHED: enriched})
+			enriched_filters = append(enriched_filters, filters[i])
+		}
+	}
+	return enriched_updates, enriched_filters
+}
+
+// true if the $set of the update has no fields such as the dud Bean{} of a failed embedding
+func isEmptyUpdate(update any) bool {
+	doc, err := bson.Marshal(update)
+	if err != nil {
+		return true
+	}
+	elements, err := bson.Raw(doc).Elements()
+	return err != nil || len(elements) == 0
+}
+
+// the digest of a media noise is what the community said about the bean (e.g. top comments of a reddit post).
+// This updates the stored media noises. The ones without a digest are left as is
+func scoreMediaNoises(medianoises []MediaNoise) {
+	if deferNLPWork(pb_client.ModelName(), "community sentiment", len(medianoises)) {
+		releaseSentimentClaims(medianoises)
+		return
+	}
+	indexes := make([]int, 0, len(medianoises))
+	digests := make([]string, 0, len(medianoises))
+	for i := range medianoises {
+		if len(medianoises[i].Digest) > 0 {
+			indexes = append(indexes, i)
+			digests = append(digests, medianoises[i].Digest)
+		}
+	}
+	if len(digests) == 0 {
+		return
+	}
+	log.Printf("[beanops] Scoring community sentiment for %d media noises", len(digests))
+	sentiments := pb_client.ScoreSentiments(digests)
+	// only update the ones that got scored so that Rectify retries the rest
+	updates := make([]any, 0, len(indexes))
+	filters := make([]store.JSON, 0, len(indexes))
+	var failed []MediaNoise
+	for j, i := range indexes {
+		if j < len(sentiments) && sentiments[j].Label != "" {
+			updates = append(updates, MediaNoise{CommunitySentiment: sentiments[j].Label, CommunitySentimentScore: sentiments[j].Score})
+			filters = append(filters, getMediaNoiseId(&medianoises[i]))
+		} else {
+			failed = append(failed, medianoises[i])
+		}
+	}
+	noisestore.Update(updates, filters)
+	releaseSentimentClaims(failed)
+}
+
+// the background work that claimed the media noises gave up on them so Rectify can pick them up right away
+func releaseSentimentClaims(medianoises []MediaNoise) {
+	if len(medianoises) == 0 {
+		return
+	}
+	noisestore.Update(
+		datautils.Transform(medianoises, func(item *MediaNoise) any { return store.JSON{"sentiment_claimed": 0} }),
+		datautils.Transform(medianoises, getMediaNoiseId))
 }
 
 func generateNewsNuggets(beans []Bean) {
+	beans = datautils.Filter(beans, isTranslated)
+	if len(beans) == 0 {
+		return
+	}
+	if deferNLPWork(pb_client.ModelName(), "News Nuggets generation", len(beans)) {
+		releaseNuggetClaims(beans)
+		return
+	}
 	// extract key newsnuggets
 	keyconcepts := pb_client.ExtractKeyConcepts(getTextFields(beans))
+	if len(keyconcepts) == 0 {
+		// most likely a failure. the beans are left unmarked so that Rectify retries them
+		log.Printf("[beanops] KeyConcepts generation returned nothing for %d beans.\n", len(beans))
+		releaseNuggetClaims(beans)
+		return
+	}
 	// remove the duds
 	nuggets := datautils.FilterAndTransform(keyconcepts, func(keyconcept *nlp.KeyConcept) (bool, BeanNugget) {
 		nugget := toNewsNugget(keyconcept)
-		if len(keyconcept.Description) == 0 {
+		if len(keyconcept.Description) == 0 || keyconcept.DocIndex >= len(beans) {
 			// don't do anything if it is a dud
 			return false, nugget
 		}
-		nugget.Updated = beans[0].Updated // update with time frame to associate to the beans
+		// the bean the concept came from is the first mapped url. remapping adds the corroborating beans
+		source := beans[keyconcept.DocIndex]
+		nugget.Updated = source.Updated
+		nugget.SourceUrls = []string{source.Url}
+		nugget.BeanUrls = []string{source.Url}
 		return true, nugget
 	})
 	if len(keyconcepts) > len(nuggets) {
@@ -174,11 +342,17 @@ func generateNewsNuggets(beans []Bean) {
 		nuggets[i].Embeddings = embs[i]
 	}
 
-	// now store the nuggets
+	// now store the nuggets and mark the beans they came from as done
 	nuggetstore.Add(nuggets)
+	beanstore.Update(
+		datautils.Transform(beans, func(item *Bean) any { return store.JSON{_NUGGETS: true} }),
+		getBeanIdFilters(beans))
 }
 
 func generateCustomFieldForNuggets(nuggets []BeanNugget) {
+	if deferNLPWork(embedder.ModelName(), "News Nuggets embeddings generation", len(nuggets)) {
+		return
+	}
 	log.Printf("[beanops] Generating embeddings for %d News Nuggets.\n", len(nuggets))
 
 	descriptions := datautils.Transform(nuggets, func(item *BeanNugget) string { return item.Description })
@@ -201,10 +375,21 @@ func remapNewsNuggets(window int) {
 			"updated":    store.JSON{"$gte": timeValue(window)},
 		},
 		store.JSON{
-			"_id":        1,
-			"embeddings": 1,
+			"_id":         1,
+			"embeddings":  1,
+			"keyphrase":   1,
+			"event":       1,
+			"source_urls": 1,
 		}, nil, -1)
 
+	// link the nuggets to the entities their keyphrases are known as
+	entity_ids := make(map[string]string)
+	datautils.ForEach(
+		GetEntities(datautils.Transform(nuggets, func(item *BeanNugget) string { return item.KeyPhrase })),
+		func(entity *BeanEntity) {
+			datautils.ForEach(entity.Aliases, func(alias *string) { entity_ids[nlp.NormalizeEntityName(*alias)] = entity.ID })
+		})
+
 	url_fields := store.JSON{"url": 1}
 	non_channels := store.JSON{
 		"kind": store.JSON{"$ne": CHANNEL},
@@ -227,11 +412,24 @@ func remapNewsNuggets(window int) {
 				store.WithTextTopN(2), // i might have to change this
 				store.WithProjection(url_fields))
 		}
-		// get media noises and add up the score to reflect in the Nugget Score
+		// the source beans are always mapped. the search results only add corroborating beans
+		urls := append([]string{}, km.SourceUrls...)
+		datautils.ForEach(beans, func(item *Bean) {
+			if !datautils.In(item.Url, urls, func(a, b *string) bool { return *a == *b }) {
+				urls = append(urls, item.Url)
+			}
+		})
 
+		var entities []string
+		if id, ok := entity_ids[nlp.NormalizeEntityName(km.KeyPhrase)]; ok {
+			entities = []string{id}
+		}
+
+		// get media noises and add up the score to reflect in the Nugget Score
 		return BeanNugget{
-			TrendScore: calculateNuggetScore(beans), // score = 5 x number_of_unique_urls + sum (noise_score)
-			BeanUrls:   datautils.Transform(beans, func(item *Bean) string { return item.Url }),
+			Entities:   entities,
+			TrendScore: calculateNuggetScore(urls),
+			BeanUrls:   urls,
 		}
 	})
 	ids := getNewsNuggetIds(nuggets)
@@ -242,25 +440,78 @@ func remapNewsNuggets(window int) {
 // this is currently not being run as a recurring service
 func Rectify() {
 	// BEANS: generate the fields that do not exist
-	for _, field_name := range _GENERATED_FIELDS {
+	for _, field_name := range getGeneratedFields() {
 		beans := beanstore.Get(
 			store.JSON{
-				field_name: store.JSON{"$exists": false},
-				"updated":  store.JSON{"$gte": timeValue(_MAX_RECTIFY_WINDOW)},
-				"kind":     store.JSON{"$ne": CHANNEL},
+				field_name:     store.JSON{"$exists": false},
+				"updated":      store.JSON{"$gte": timeValue(_MAX_RECTIFY_WINDOW)},
+				"kind":         store.JSON{"$ne": CHANNEL},
+				"duplicate_of": store.JSON{"$exists": false},
 			},
 			store.JSON{
-				"url":  1,
-				"text": 1,
+				"url":                1,
+				"text":               1,
+				"language":           1,
+				"translated_title":   1,
+				"translated_summary": 1,
 			},
 			_SORT_BY_UPDATED, // this way the newest ones get priority
 			-1,
 		)
 		// store generated field
 		generateFieldForBeans(beans, field_name)
+
+		// TRANSLATIONS: translate the ones that got skipped or failed before the rest gets generated from them
+		if field_name == _SUMMARY {
+			translateBeans(getUntranslatedBeans(store.JSON{"updated": store.JSON{"$gte": timeValue(_MAX_RECTIFY_WINDOW)}}))
+		}
 	}
 
-	// TODO: if certain bean doesn't have a nugget regenerate then
+	// NUGGETS: generate the nuggets of the beans that got skipped or failed.
+	// only the recent ones since the older nuggets are out of the trending windows anyway.
+	// the ones a collection run is still generating in the background are left alone
+	pageBeans(
+		store.JSON{
+			_NUGGETS:         store.JSON{"$exists": false},
+			_NUGGETS_CLAIMED: store.JSON{"$not": store.JSON{"$gt": time.Now().Unix() - _CLAIM_LEASE}},
+			"updated":        store.JSON{"$gte": timeValue(_MIN_RECTIFY_WINDOW)},
+			"kind":           store.JSON{"$ne": CHANNEL},
+			"duplicate_of":   store.JSON{"$exists": false},
+		},
+		store.JSON{
+			"url":                1,
+			"text":               1,
+			"updated":            1,
+			"language":           1,
+			"translated_title":   1,
+			"translated_summary": 1,
+		},
+		func(beans []Bean) bool {
+			generateNewsNuggets(beans)
+			// the rest of the pages would be deferred too
+			return !nlp.BudgetExceeded() && !nlp.ServiceUnavailable(pb_client.ModelName())
+		},
+	)
+
+	// NOISES: score the community sentiment of the ones that got skipped or failed.
+	// the ones a collection run is still scoring in the background are left alone
+	scoreMediaNoises(noisestore.Get(
+		store.JSON{
+			"community_sentiment": store.JSON{"$exists": false},
+			"sentiment_claimed":   store.JSON{"$not": store.JSON{"$gt": time.Now().Unix() - _CLAIM_LEASE}},
+			"digest":              store.JSON{"$nin": []any{nil, ""}},
+			"updated":             store.JSON{"$gte": timeValue(_MIN_RECTIFY_WINDOW)},
+		},
+		store.JSON{
+			"mapped_url": 1,
+			"source":     1,
+			"cid":        1,
+			"updated":    1,
+			"digest":     1,
+		},
+		_SORT_BY_UPDATED,
+		-1,
+	))
 
 	// NUGGETS: generate embeddings for the ones that do not yet have it
 	// process data in batches so that there is at least partial success
@@ -281,16 +532,94 @@ func Rectify() {
 	generateCustomFieldForNuggets(nuggets)
 	// MAPPING: now that the beans and nuggets have embeddings, remap them
 	remapNewsNuggets(_MAX_RECTIFY_WINDOW)
+	// STORIES: the beans keep getting media noise after they are clustered
+	updateStoryNoises(_MAX_RECTIFY_WINDOW)
 }
 
-// current calculation score: 5 x number_of_unique_articles_or_posts + sum_of(noise_scores)
-func calculateNuggetScore(beans []Bean) int {
-	var base = len(beans) * 5
-	score := getMediaNoises(beans, true)
-	if len(score) == 1 {
-		base += score[0].Score
+// hands the beans matching the filter to process _RECT_BATCH_SIZE at a time, newest first, until process returns false.
+// The page after is the beans updated before the last one of the page, plus the ones updated at the same time that haven't been seen yet.
+// This way the beans that process leaves unchanged are not seen again. fields needs the url and the updated time
+func pageBeans(filter, fields store.JSON, process func(beans []Bean) bool) {
+	page_filter := filter
+	var last_updated int64
+	var last_urls []string
+	for {
+		beans := beanstore.Get(page_filter, fields, _SORT_BY_UPDATED, _RECT_BATCH_SIZE)
+		if len(beans) == 0 || !process(beans) || len(beans) < _RECT_BATCH_SIZE {
+			return
+		}
+		// the beans come newest first
+		oldest := beans[len(beans)-1].Updated
+		if oldest != last_updated {
+			last_updated, last_urls = oldest, nil
+		}
+		datautils.ForEach(beans, func(item *Bean) {
+			if item.Updated == last_updated {
+				last_urls = append(last_urls, item.Url)
+			}
+		})
+		page_filter = store.JSON{
+			"$and": []store.JSON{
+				filter,
+				{"$or": []store.JSON{
+					{"updated": store.JSON{"$lt": last_updated}},
+					{"updated": last_updated, "url": store.JSON{"$nin": last_urls}},
+				}},
+			},
+		}
+	}
+}
+
+// the work AddBeans doesn't block the collection on. It is tracked so that the collection run can wait for it
+func runInBackground(work func()) {
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		work()
+	}()
+}
+
+// Blocks until the background work of the beans added so far is done such as the news nuggets generation.
+// The collection runs wait for it before closing out their NLP usage so that it is billed to the run that started it
+func WaitForBackgroundWork() {
+	background.Wait()
+}
+
+// the background work that claimed the beans gave up on them so Rectify can pick them up right away
+func releaseNuggetClaims(beans []Bean) {
+	beanstore.Update(
+		datautils.Transform(beans, func(item *Bean) any { return store.JSON{_NUGGETS_CLAIMED: 0} }),
+		getBeanIdFilters(beans))
+}
+
+// returns true if the work should be skipped because the NLP budget is exceeded or the model's circuit is open.
+// The indexing work skipped this way is left missing in the database so that Rectify picks it up
+func deferNLPWork(model, work string, count int) bool {
+	if nlp.BudgetExceeded() {
+		log.Printf("[beanops] NLP budget exceeded. Pausing %s for %d items", work, count)
+		return true
 	}
-	return base
+	if nlp.ServiceUnavailable(model) {
+		log.Printf("[beanops] %s unavailable. Deferring %s for %d items", model, work, count)
+		return true
+	}
+	return false
+}
+
+// the stuff summarization only reads as much of the text as the embedder does. The chunked modes read up to _MAX_TEXT_SIZE
+func maxTextSize() int {
+	if summarization_mode == nlp.MAP_REDUCE_SUMMARIZATION || summarization_mode == nlp.REFINE_SUMMARIZATION {
+		return _MAX_TEXT_SIZE
+	}
+	return embedder.ContextWindow()
+}
+
+// the model that generates the field
+func getFieldModel(field_name string) string {
+	if field_name == _CLASSIFICATION_EMB {
+		return embedder.ModelName()
+	}
+	return pb_client.ModelName()
 }
 
 func getBeanId(bean *Bean) store.JSON {
@@ -303,12 +632,26 @@ func getBeanIdFilters(beans []Bean) []store.JSON {
 	})
 }
 
+// the translated beans are processed from their translated title and summary
 func getTextFields(beans []Bean) []string {
 	return datautils.Transform(beans, func(bean *Bean) string {
+		if bean.TranslatedSummary != "" {
+			return bean.TranslatedTitle + "\n\n" + bean.TranslatedSummary
+		}
 		return bean.Text
 	})
 }
 
+// a media noise is a snapshot of the post or comment in a collection run
+func getMediaNoiseId(noise *MediaNoise) store.JSON {
+	id := store.JSON{"mapped_url": noise.BeanUrl, "source": noise.Source, "updated": noise.Updated}
+	// empty cid doesn't get stored
+	if noise.ContentId != "" {
+		id["cid"] = noise.ContentId
+	}
+	return id
+}
+
 func getNewsNuggetIds(batch []BeanNugget) []store.JSON {
 	// update it with updater
 	ids := datautils.Transform(batch, func(item *BeanNugget) store.JSON {
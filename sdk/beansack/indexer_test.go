@@ -0,0 +1,74 @@
+package beansack
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+)
+
+func TestGetEnrichedUpdates(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates []any
+		want    []string // the urls that get the enrichment time
+	}{
+		{"nothing generated", nil, []string{}},
+		{"embeddings", []any{Bean{CategoryEmbeddings: []float32{1, 0}}, Bean{}}, []string{"a"}},
+		{"digests", []any{&nlp.Digest{}, &nlp.Digest{Summary: "s", Topic: "t"}}, []string{"b"}},
+		{"sentiments", []any{&nlp.Sentiment{Label: nlp.NEGATIVE, Score: -0.5}, &nlp.Sentiment{}}, []string{"a"}},
+		{"an empty list marks the bean as classified", []any{store.JSON{_CATEGORIES: []CategoryMatch{}}, Bean{Categories: []CategoryMatch{{ID: "ai"}}}}, []string{"a", "b"}},
+		{"more updates than filters", []any{Bean{StoryID: "s"}, Bean{StoryID: "s"}, Bean{StoryID: "s"}}, []string{"a", "b"}},
+	}
+	filters := []store.JSON{{"url": "a"}, {"url": "b"}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			updates, got_filters := getEnrichedUpdates(test.updates, filters, 100)
+			got := make([]string, 0, len(got_filters))
+			for i := range got_filters {
+				got = append(got, got_filters[i]["url"].(string))
+				if !reflect.DeepEqual(updates[i], store.JSON{_ENRICHED: int64(100)}) {
+					t.Errorf("update[%d] = %v, want the enrichment time", i, updates[i])
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("getEnrichedUpdates() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWaitForBackgroundWork(t *testing.T) {
+	release := make(chan struct{})
+	var done atomic.Int32
+	for i := 0; i < 3; i++ {
+		runInBackground(func() {
+			<-release
+			done.Add(1)
+		})
+	}
+	waited := make(chan struct{})
+	go func() {
+		WaitForBackgroundWork()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("WaitForBackgroundWork() returned before the work got done")
+	case <-time.After(50 * time.Millisecond):
+	}
+	for i := 0; i < 3; i++ {
+		release <- struct{}{}
+	}
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("WaitForBackgroundWork() is still waiting after the work got done")
+	}
+	if done.Load() != 3 {
+		t.Errorf("done = %d, want 3", done.Load())
+	}
+}
@@ -3,28 +3,63 @@ package beansack
 import (
 	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
 	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
 )
 
 const (
-	BEANSACK    = "beansack"
-	BEANS       = "beans"
-	NOISES      = "noises"
-	KEYWORDS    = "keywords"
-	NEWSNUGGETS = "concepts"
+	BEANSACK      = "beansack"
+	BEANS         = "beans"
+	NOISES        = "noises"
+	KEYWORDS      = "keywords"
+	NEWSNUGGETS   = "concepts"
+	NLPCACHE      = "nlpcache"
+	ENTITIES      = "entities"
+	BRIEFINGS     = "briefings"
+	STORIES       = "stories"
+	ENGAGEMENTS   = "engagements"
+	SUBSCRIPTIONS = "subscriptions"
+	DELIVERIES    = "deliveries"
 )
 
 var (
-	beanstore   *store.Store[Bean]
-	nuggetstore *store.Store[BeanNugget]
-	noisestore  *store.Store[MediaNoise]
-	embedder    *nlp.EmbeddingsDriver
-	pb_client   *nlp.ParrotboxClient
+	beanstore     *store.Store[Bean]
+	nuggetstore   *store.Store[BeanNugget]
+	noisestore    *store.Store[MediaNoise]
+	cachestore    *store.Store[cacheItem]
+	entitystore   *store.Store[BeanEntity]
+	briefingstore *store.Store[Briefing]
+	storystore    *store.Store[Story]
+	// one point per bean per source per collection run
+	engagementstore *store.Store[EngagementPoint]
+	// saved searches and the beans delivered to their owners
+	subscriptionstore *store.Store[Subscription]
+	deliverystore     *store.Store[delivery]
+	embedder          nlp.Embedder
+	pb_client         nlp.Extractor
+	taxonomy          []Category
+	trend_scorer      TrendScorer = NewTrendScorer(DefaultTrendWeights()) // the trend scores of the nuggets are stored with this
+	// language -> KEEP_LANGUAGE, DROP_LANGUAGE or TRANSLATE_LANGUAGE
+	language_policy map[string]string
+	// how the LLM service summarizes the texts longer than its window. It decides how much of the text gets stored
+	summarization_mode = nlp.STUFF_SUMMARIZATION
+	// keyed by the name subscriptions refer to them with
+	notifiers = map[string]Notifier{
+		WEBHOOK_NOTIFIER: NewWebhookNotifier(),
+		SLACK_NOTIFIER:   NewSlackNotifier(),
+	}
 )
 
 const (
 	// _SEARCH_EMB = "search_embeddings"
 	_CLASSIFICATION_EMB = "category_embeddings"
 	_SUMMARY            = "summary"
+	_SENTIMENT          = "sentiment"
+	_ENTITIES           = "entities"
+	_CATEGORIES         = "categories"
+	_STORY              = "story_id"
+	_NUGGETS            = "nuggets_generated"
+	_NUGGETS_CLAIMED    = "nuggets_claimed"
+	_ENRICHED           = "enriched"
 )
 
 type BeanSackError string
@@ -33,7 +68,117 @@ func (err BeanSackError) Error() string {
 	return string(err)
 }
 
-func InitializeBeanSack(db_conn_str, emb_url string, emb_ctx int, pb_auth_token string) error {
+// cache backends for embeddings and LLM outputs
+const (
+	NO_CACHE     = ""
+	MEMORY_CACHE = "memory"
+	DISK_CACHE   = "disk"
+	STORE_CACHE  = "store"
+)
+
+type beansackConfig struct {
+	cache_backend      string
+	cache_dir          string
+	prompts_dir        string
+	prompt_versions    map[string]string
+	summarization_mode string
+	sentiment_mode     string
+	taxonomy_file      string
+	language_policy    map[string]string
+	local_nlp          bool
+	local_emb_dim      int
+	trend_weights      *TrendWeights
+	notifiers          map[string]Notifier
+}
+
+type BeanSackOption func(config *beansackConfig)
+
+// replaces the embedder and the LLM service with the local deterministic stand-ins nlp.HashingEmbedder and nlp.LocalExtractor.
+// Nothing leaves the process so the whole pipeline can run offline for demos and integration tests.
+// emb_dim is the number of dimensions of the embeddings and has to match the vector indexes. <= 0 means 768
+func WithLocalNLP(emb_dim int) BeanSackOption {
+	return func(config *beansackConfig) {
+		config.local_nlp = true
+		config.local_emb_dim = emb_dim
+	}
+}
+
+// backend is one of MEMORY_CACHE, DISK_CACHE or STORE_CACHE. cache_dir only applies to DISK_CACHE
+func WithNLPCache(backend, cache_dir string) BeanSackOption {
+	return func(config *beansackConfig) {
+		config.cache_backend = backend
+		config.cache_dir = cache_dir
+	}
+}
+
+// loads the prompt templates from prompts_dir/<task>/<version>.json, or the built-in ones of nlp/prompts when prompts_dir doesn't have the version.
+// An unknown version fails the initialization. versions is keyed by the nlp tasks such as nlp.DIGEST_TASK and nlp.CONCEPTS_TASK. Tasks without a version use the default prompts
+func WithPrompts(prompts_dir string, versions map[string]string) BeanSackOption {
+	return func(config *beansackConfig) {
+		config.prompts_dir = prompts_dir
+		config.prompt_versions = versions
+	}
+}
+
+// mode is one of nlp.STUFF_SUMMARIZATION, nlp.MAP_REDUCE_SUMMARIZATION or nlp.REFINE_SUMMARIZATION.
+// It decides how texts longer than the LLM window get summarized
+func WithSummarizationMode(mode string) BeanSackOption {
+	return func(config *beansackConfig) {
+		config.summarization_mode = mode
+	}
+}
+
+// mode is one of nlp.LLM_SENTIMENT or nlp.LEXICON_SENTIMENT. empty means nlp.LLM_SENTIMENT
+func WithSentimentMode(mode string) BeanSackOption {
+	return func(config *beansackConfig) {
+		config.sentiment_mode = mode
+	}
+}
+
+// loads the category taxonomy from a json file. The beans get classified into it during indexing
+func WithTaxonomy(filename string) BeanSackOption {
+	return func(config *beansackConfig) {
+		config.taxonomy_file = filename
+	}
+}
+
+// policy is keyed by ISO 639-1 language code or ANY_LANGUAGE and the values are
+// KEEP_LANGUAGE, DROP_LANGUAGE or TRANSLATE_LANGUAGE. Languages without a policy are kept.
+// The beans whose language could not be detected go by the ANY_LANGUAGE policy but they are never translated
+func WithLanguagePolicy(policy map[string]string) BeanSackOption {
+	return func(config *beansackConfig) {
+		config.language_policy = policy
+	}
+}
+
+// weights of the default TrendScorer the trend scores of the nuggets are stored with. nil means DefaultTrendWeights
+func WithTrendWeights(weights *TrendWeights) BeanSackOption {
+	return func(config *beansackConfig) {
+		config.trend_weights = weights
+	}
+}
+
+// adds a notifier for the alerts of the subscriptions or replaces the built-in one with the same name such as EMAIL_NOTIFIER
+func WithNotifier(name string, notifier Notifier) BeanSackOption {
+	return func(config *beansackConfig) {
+		if config.notifiers == nil {
+			config.notifiers = make(map[string]Notifier)
+		}
+		config.notifiers[name] = notifier
+	}
+}
+
+func InitializeBeanSack(db_conn_str, emb_url string, emb_ctx int, pb_auth_token string, opts ...BeanSackOption) error {
+	config := &beansackConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	language_policy = config.language_policy
+	if config.trend_weights != nil {
+		trend_scorer = NewTrendScorer(*config.trend_weights)
+	}
+	notifiers = datautils.AppendMaps(notifiers, config.notifiers)
+
 	beanstore = store.New(db_conn_str, BEANSACK, BEANS,
 		// store.WithMinSearchScore[Bean](0.55), // TODO: change this to 0.8 in future
 		// store.WithSearchTopN[Bean](10),
@@ -41,13 +186,63 @@ func InitializeBeanSack(db_conn_str, emb_url string, emb_ctx int, pb_auth_token
 	)
 	noisestore = store.New[MediaNoise](db_conn_str, BEANSACK, NOISES)
 	nuggetstore = store.New[BeanNugget](db_conn_str, BEANSACK, NEWSNUGGETS)
+	entitystore = store.New(db_conn_str, BEANSACK, ENTITIES, store.WithDataIDAndEqualsFunction(getEntityId, entityEquals))
+	briefingstore = store.New(db_conn_str, BEANSACK, BRIEFINGS, store.WithDataIDAndEqualsFunction(getBriefingId, briefingEquals))
+	storystore = store.New(db_conn_str, BEANSACK, STORIES, store.WithDataIDAndEqualsFunction(getStoryId, storyEquals))
+	engagementstore = store.New(db_conn_str, BEANSACK, ENGAGEMENTS, store.WithDataIDAndEqualsFunction(getEngagementPointId, engagementPointEquals))
+	subscriptionstore = store.New(db_conn_str, BEANSACK, SUBSCRIPTIONS, store.WithDataIDAndEqualsFunction(getSubscriptionId, subscriptionEquals))
+	deliverystore = store.New(db_conn_str, BEANSACK, DELIVERIES, store.WithDataIDAndEqualsFunction(getDeliveryId, deliveryEquals))
 
-	if beanstore == nil || nuggetstore == nil {
+	if beanstore == nil || nuggetstore == nil || entitystore == nil || briefingstore == nil || storystore == nil || engagementstore == nil || subscriptionstore == nil || deliverystore == nil {
 		return BeanSackError("Initialization Failed. db_conn_str Not working.")
 	}
 
-	pb_client = nlp.NewParrotboxClient(pb_auth_token)
+	if config.local_nlp {
+		// the local ones are cheap and deterministic so there is nothing to gain from caching them
+		embedder = nlp.NewHashingEmbedder(config.local_emb_dim)
+		pb_client = nlp.NewLocalExtractor()
+	} else if err := initializeNLPServices(db_conn_str, emb_url, emb_ctx, pb_auth_token, config); err != nil {
+		return err
+	}
+	if config.taxonomy_file != "" {
+		taxonomy = embedTaxonomy(LoadTaxonomy(config.taxonomy_file))
+	}
+
+	return nil
+}
+
+func initializeNLPServices(db_conn_str, emb_url string, emb_ctx int, pb_auth_token string, config *beansackConfig) error {
 	embedder = nlp.NewLlamaFileDriver(emb_url, emb_ctx)
+	prompts, err := nlp.LoadPromptTemplates(config.prompts_dir, config.prompt_versions)
+	if err != nil {
+		return BeanSackError("Initialization Failed. " + err.Error())
+	}
+	pb := nlp.NewParrotboxClient(pb_auth_token, prompts)
+	if pb == nil {
+		return BeanSackError("Initialization Failed. pb_auth_token Not working.")
+	}
+	pb_client = pb.WithSummarizationMode(config.summarization_mode).WithSentimentMode(config.sentiment_mode)
+	summarization_mode = pb.SummarizationMode()
+	if cache := createNLPCache(db_conn_str, config); cache != nil {
+		embedder = nlp.NewCachedEmbedder(embedder, cache)
+		pb_client = nlp.NewCachedExtractor(pb_client, cache)
+	}
+	return nil
+}
 
+func createNLPCache(db_conn_str string, config *beansackConfig) nlp.Cache {
+	switch config.cache_backend {
+	case MEMORY_CACHE:
+		return nlp.NewMemoryCache(0)
+	case DISK_CACHE:
+		// returning the nil pointer as is would make a non-nil interface
+		if cache := nlp.NewDiskCache(config.cache_dir); cache != nil {
+			return cache
+		}
+	case STORE_CACHE:
+		if cachestore = store.New(db_conn_str, BEANSACK, NLPCACHE, store.WithDataIDAndEqualsFunction(getCacheItemId, cacheItemEquals)); cachestore != nil {
+			return storeCache{}
+		}
+	}
 	return nil
 }
@@ -0,0 +1,131 @@
+package beansack
+
+import (
+	"log"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
+)
+
+// what to do with the beans of a language
+const (
+	KEEP_LANGUAGE      = "keep"
+	DROP_LANGUAGE      = "drop"
+	TRANSLATE_LANGUAGE = "translate" // translate the title and the summary to English. The originals are kept as is
+	// policy key for every language that doesn't have its own policy, including the ones that could not be detected
+	ANY_LANGUAGE = "*"
+)
+
+func getLanguagePolicy(language string) string {
+	if policy, ok := language_policy[language]; ok {
+		return policy
+	}
+	if policy, ok := language_policy[ANY_LANGUAGE]; ok {
+		return policy
+	}
+	return KEEP_LANGUAGE
+}
+
+// detects the language of each bean and removes the ones whose language policy is to drop
+func detectLanguages(beans []Bean) []Bean {
+	count := len(beans)
+	beans = datautils.Filter(
+		datautils.ForEach(beans, func(item *Bean) { item.Language = nlp.DetectLanguage(item.Title + "\n" + item.Text) }),
+		func(item *Bean) bool { return getLanguagePolicy(item.Language) != DROP_LANGUAGE })
+	if count > len(beans) {
+		log.Printf("[beanops] Dropped %d beans by language policy.\n", count-len(beans))
+	}
+	return beans
+}
+
+// the original beans with a summary but no translation whose language policy is to translate.
+// This is an extra filter on top of `filter`
+func getUntranslatedBeans(filter store.JSON) []Bean {
+	filter = datautils.AppendMaps(store.JSON{
+		"translated_summary": store.JSON{"$exists": false},
+		"summary":            store.JSON{"$nin": []any{nil, ""}},
+		"kind":               store.JSON{"$ne": CHANNEL},
+		"duplicate_of":       store.JSON{"$exists": false},
+	}, filter)
+	// the language filter goes last so that it doesn't get overwritten
+	var translate, other []string
+	for language, policy := range language_policy {
+		if language == ANY_LANGUAGE {
+			continue
+		}
+		if policy == TRANSLATE_LANGUAGE {
+			translate = append(translate, language)
+		} else {
+			other = append(other, language)
+		}
+	}
+	if getLanguagePolicy(ANY_LANGUAGE) == TRANSLATE_LANGUAGE {
+		// the ones without a language are the ones that could not be detected and the ones from before the detection
+		filter["language"] = store.JSON{"$exists": true, "$nin": append(other, nlp.ENGLISH)}
+	} else if len(translate) > 0 {
+		filter["language"] = store.JSON{"$in": translate}
+	} else {
+		return nil
+	}
+	return beanstore.Get(filter, store.JSON{"url": 1, "title": 1, "summary": 1, "language": 1}, _SORT_BY_UPDATED, -1)
+}
+
+// the beans whose language policy is to translate wait for their translation before anything else gets generated for them.
+// The ones without a language don't get translated so they don't wait
+func isTranslated(bean *Bean) bool {
+	return bean.TranslatedSummary != "" || bean.Language == "" || bean.Language == nlp.ENGLISH || getLanguagePolicy(bean.Language) != TRANSLATE_LANGUAGE
+}
+
+// loads the stored translations into the beans
+func attachTranslations(beans []Bean) []Bean {
+	translated := beanstore.Get(
+		store.JSON{
+			"url":                store.JSON{"$in": datautils.Transform(beans, func(item *Bean) string { return item.Url })},
+			"translated_summary": store.JSON{"$exists": true},
+		},
+		store.JSON{"url": 1, "translated_title": 1, "translated_summary": 1},
+		nil, -1)
+	return datautils.ForEach(beans, func(bean *Bean) {
+		if i := datautils.IndexAny(translated, func(item *Bean) bool { return item.Url == bean.Url }); i >= 0 {
+			bean.TranslatedTitle = translated[i].TranslatedTitle
+			bean.TranslatedSummary = translated[i].TranslatedSummary
+		}
+	})
+}
+
+// translates the titles and the summaries of the beans whose language policy is to translate and stores them in
+// translated_title and translated_summary. The original title, text and summary are kept as is.
+// the ones that fail to translate are left for Rectify
+func translateBeans(beans []Bean) {
+	beans = datautils.Filter(beans, func(item *Bean) bool {
+		return item.Language != "" && item.Language != nlp.ENGLISH && getLanguagePolicy(item.Language) == TRANSLATE_LANGUAGE && len(item.Summary) > 0
+	})
+	if len(beans) == 0 || deferNLPWork(pb_client.ModelName(), "translation", len(beans)) {
+		return
+	}
+	log.Printf("[beanops] Translating %d beans", len(beans))
+
+	// titles and summaries go in one batch
+	texts := append(
+		datautils.Transform(beans, func(item *Bean) string { return item.Title }),
+		datautils.Transform(beans, func(item *Bean) string { return item.Summary })...)
+	translations := pb_client.TranslateToEnglish(texts)
+	if len(translations) != len(texts) {
+		log.Printf("[beanops] Translation returned %d items for %d texts. Leaving them for Rectify.\n", len(translations), len(texts))
+		return
+	}
+
+	updates := make([]any, 0, len(beans))
+	filters := make([]store.JSON, 0, len(beans))
+	for i := range beans {
+		title, summary := translations[i], translations[len(beans)+i]
+		// posts may not have a title. the summary is what marks the bean as translated
+		if len(summary) == 0 {
+			continue
+		}
+		updates = append(updates, Bean{TranslatedTitle: title, TranslatedSummary: summary})
+		filters = append(filters, getBeanId(&beans[i]))
+	}
+	beanstore.Update(updates, filters)
+}
@@ -0,0 +1,273 @@
+package nlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	_BREAKER_THRESHOLD    = 5           // consecutive service failures that open the breaker
+	_BREAKER_COOLDOWN     = time.Minute // first cool down. doubles every time the breaker re-opens right after a trial
+	_MAX_BREAKER_COOLDOWN = 15 * time.Minute
+	_MAX_RETRY_WAIT       = 30 * time.Second // Retry-After longer than this opens the breaker instead of waiting
+	_MAX_LIMITER_WAIT     = 30 * time.Second // waiting longer than this for the limiter defers the work instead of blocking
+	_MIN_RATE_FACTOR      = 0.1              // the adaptive limit never drops below this share of the configured rate
+)
+
+var (
+	// the openai compatible client only has the status code in the error message
+	_STATUS_CODE_EXPR = regexp.MustCompile(`status code: ([1-5][0-9]{2})\b`)
+	// groq and openai say this in the error message of a 429
+	_TRY_AGAIN_EXPR = regexp.MustCompile(`(?i)try again in ([0-9.]+(?:ms|s|m|h)(?:[0-9.]+(?:ms|s))?)`)
+)
+
+// requests and tokens per minute allowed by the provider for a model
+type RateLimit struct {
+	RPM int `json:"rpm,omitempty"`
+	TPM int `json:"tpm,omitempty"`
+}
+
+// Returned without calling the service when its breaker is open or when the rate limiter would block for too long.
+// The callers should treat this as a dud. The beansack indexer leaves the duds ungenerated so that Rectify retries them
+type ServiceUnavailableError struct {
+	Model string
+	Until time.Time
+}
+
+func (err ServiceUnavailableError) Error() string {
+	return fmt.Sprintf("%s unavailable until %s", err.Model, err.Until.Format(time.TimeOnly))
+}
+
+// an http error from a service with the Retry-After it sent, if any
+type ServiceError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (err ServiceError) Error() string {
+	return fmt.Sprintf("%d: %s %s", err.StatusCode, http.StatusText(err.StatusCode), err.Message)
+}
+
+// circuit breaker and token bucket limiters for one model endpoint
+type serviceGuard struct {
+	model string
+	lock  sync.Mutex
+
+	failures   int
+	open_until time.Time
+	cooldown   time.Duration
+
+	limit    RateLimit
+	requests *rate.Limiter // nil when there is no configured limit
+	tokens   *rate.Limiter
+}
+
+var (
+	guards      = make(map[string]*serviceGuard)
+	rate_limits = make(map[string]RateLimit)
+	guards_lock sync.Mutex
+)
+
+// Sets the provider rate limits keyed by model name. Models without a limit are not rate limited
+func SetRateLimits(limits map[string]RateLimit) {
+	guards_lock.Lock()
+	defer guards_lock.Unlock()
+	for model, limit := range limits {
+		rate_limits[model] = limit
+		// reset the existing ones so that they pick up the new limit
+		delete(guards, model)
+	}
+}
+
+// Returns true if the breaker of the model is open. The callers can use this to skip a batch of work altogether
+func ServiceUnavailable(model string) bool {
+	guard := getServiceGuard(model)
+	guard.lock.Lock()
+	defer guard.lock.Unlock()
+	return time.Now().Before(guard.open_until)
+}
+
+func getServiceGuard(model string) *serviceGuard {
+	guards_lock.Lock()
+	defer guards_lock.Unlock()
+	guard, ok := guards[model]
+	if !ok {
+		guard = &serviceGuard{model: model, cooldown: _BREAKER_COOLDOWN, limit: rate_limits[model]}
+		if guard.limit.RPM > 0 {
+			guard.requests = rate.NewLimiter(rate.Limit(float64(guard.limit.RPM)/60), max(1, guard.limit.RPM/60))
+		}
+		if guard.limit.TPM > 0 {
+			guard.tokens = rate.NewLimiter(rate.Limit(float64(guard.limit.TPM)/60), guard.limit.TPM)
+		}
+		guards[model] = guard
+	}
+	return guard
+}
+
+// waits for the limiters and returns ServiceUnavailableError if the breaker is open or the wait is too long
+func (guard *serviceGuard) acquire() error {
+	guard.lock.Lock()
+	if until := guard.open_until; time.Now().Before(until) {
+		guard.lock.Unlock()
+		return ServiceUnavailableError{Model: guard.model, Until: until}
+	}
+	var wait time.Duration
+	if guard.requests != nil {
+		reservation := guard.requests.Reserve()
+		if wait = reservation.Delay(); wait > _MAX_LIMITER_WAIT {
+			reservation.Cancel()
+			guard.lock.Unlock()
+			return ServiceUnavailableError{Model: guard.model, Until: time.Now().Add(wait)}
+		}
+	}
+	// tokens are paid for after the call so this only waits out the debt of the previous calls
+	if guard.tokens != nil {
+		reservation := guard.tokens.Reserve()
+		token_wait := reservation.Delay()
+		reservation.Cancel()
+		if token_wait > _MAX_LIMITER_WAIT {
+			guard.lock.Unlock()
+			return ServiceUnavailableError{Model: guard.model, Until: time.Now().Add(token_wait)}
+		} else {
+			wait = max(wait, token_wait)
+		}
+	}
+	guard.lock.Unlock()
+
+	time.Sleep(wait)
+	return nil
+}
+
+// records the outcome of a call. Service failures count towards opening the breaker and 429s slow down the limiters.
+// The other errors such as a bad request say nothing about the service so they leave it as is
+func (guard *serviceGuard) release(err error) {
+	guard.lock.Lock()
+	defer guard.lock.Unlock()
+
+	if err == nil {
+		guard.failures = 0
+		guard.cooldown = _BREAKER_COOLDOWN
+		guard.adjustRate(1.05)
+		return
+	}
+	if !isServiceError(err) {
+		return
+	}
+
+	guard.failures++
+	if getStatusCode(err) == http.StatusTooManyRequests {
+		guard.adjustRate(0.5)
+	}
+	// the service says how long to back off. Short ones are waited out by the retry
+	if wait := retryAfter(err); wait > _MAX_RETRY_WAIT {
+		guard.open(wait)
+	} else if guard.failures >= _BREAKER_THRESHOLD {
+		guard.open(guard.cooldown)
+		guard.cooldown = min(2*guard.cooldown, _MAX_BREAKER_COOLDOWN)
+	}
+}
+
+func (guard *serviceGuard) open(duration time.Duration) {
+	guard.open_until = time.Now().Add(duration)
+	guard.failures = 0
+	log.Printf("[%s] Circuit open for %s.\n", guard.model, duration)
+}
+
+func (guard *serviceGuard) isOpen() bool {
+	guard.lock.Lock()
+	defer guard.lock.Unlock()
+	return time.Now().Before(guard.open_until)
+}
+
+// AIMD: halves the rate on a 429 and slowly brings it back up to the configured one on success
+func (guard *serviceGuard) adjustRate(factor float64) {
+	if guard.requests != nil {
+		configured := float64(guard.limit.RPM) / 60
+		guard.requests.SetLimit(rate.Limit(min(configured, max(configured*_MIN_RATE_FACTOR, float64(guard.requests.Limit())*factor))))
+	}
+	if guard.tokens != nil {
+		configured := float64(guard.limit.TPM) / 60
+		guard.tokens.SetLimit(rate.Limit(min(configured, max(configured*_MIN_RATE_FACTOR, float64(guard.tokens.Limit())*factor))))
+	}
+}
+
+// pays for the tokens used by a call
+func (guard *serviceGuard) consumeTokens(count int) {
+	guard.lock.Lock()
+	defer guard.lock.Unlock()
+	if guard.tokens != nil && count > 0 {
+		guard.tokens.ReserveN(time.Now(), min(count, guard.limit.TPM))
+	}
+}
+
+// rate limits, server errors and connection failures. These are worth retrying and count against the breaker
+func isServiceError(err error) bool {
+	var unavailable_err ServiceUnavailableError
+	if err == nil || errors.As(err, &unavailable_err) {
+		return false
+	}
+	if status := getStatusCode(err); status != 0 {
+		return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+	}
+	// the connection failed, timed out or got cut off
+	var net_err net.Error
+	return errors.As(err, &net_err) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// the http status of the response the error came from. 0 if there was no response
+func getStatusCode(err error) int {
+	var service_err ServiceError
+	if errors.As(err, &service_err) {
+		return service_err.StatusCode
+	}
+	if match := _STATUS_CODE_EXPR.FindStringSubmatch(err.Error()); len(match) > 1 {
+		status, _ := strconv.Atoi(match[1])
+		return status
+	}
+	return 0
+}
+
+// how long the service asked to back off. 0 if it didn't say
+func retryAfter(err error) time.Duration {
+	if err == nil {
+		return 0
+	}
+	var service_err ServiceError
+	if errors.As(err, &service_err) {
+		return service_err.RetryAfter
+	}
+	if match := _TRY_AGAIN_EXPR.FindStringSubmatch(err.Error()); len(match) > 1 {
+		if wait, parse_err := time.ParseDuration(match[1]); parse_err == nil {
+			return wait
+		}
+	}
+	return 0
+}
+
+// Retry-After is either seconds or an http date
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
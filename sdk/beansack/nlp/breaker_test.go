@@ -0,0 +1,93 @@
+package nlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsServiceError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"rate limited", ServiceError{StatusCode: 429}, true},
+		{"server error", ServiceError{StatusCode: 503}, true},
+		{"bad request", ServiceError{StatusCode: 400, Message: "max tokens 4500 exceeded"}, false},
+		{"client rate limited", errors.New("API returned unexpected status code: 429: Rate limit reached. Please try again in 2s"), true},
+		{"client server error", errors.New("API returned unexpected status code: 502"), true},
+		// the numbers and words in the message don't matter
+		{"client bad request", errors.New("API returned unexpected status code: 400: max tokens 4500 exceeded, timeout or EOF"), false},
+		{"numbers in a message", errors.New("context of 5000 tokens is over the limit of 500"), false},
+		{"connection refused", &url.Error{Op: "Post", URL: "http://localhost", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, true},
+		{"unexpected eof", fmt.Errorf("decode response: %w", io.ErrUnexpectedEOF), true},
+		{"deadline", fmt.Errorf("send request: %w", context.DeadlineExceeded), true},
+		{"breaker open", ServiceUnavailableError{Model: "m", Until: time.Now()}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isServiceError(test.err); got != test.want {
+				t.Errorf("isServiceError(%v) = %v, want %v", test.err, got, test.want)
+			}
+		})
+	}
+}
+
+func TestReleaseNeutralErrors(t *testing.T) {
+	guard := &serviceGuard{model: "test", cooldown: _BREAKER_COOLDOWN}
+	for i := 0; i < _BREAKER_THRESHOLD-1; i++ {
+		guard.release(ServiceError{StatusCode: 503})
+	}
+	// a bad request neither resets the failures nor counts as one
+	guard.release(ServiceError{StatusCode: 400})
+	if guard.failures != _BREAKER_THRESHOLD-1 || guard.isOpen() {
+		t.Fatalf("failures = %d, open = %v after a bad request", guard.failures, guard.isOpen())
+	}
+	guard.release(ServiceError{StatusCode: 503})
+	if !guard.isOpen() {
+		t.Errorf("breaker is not open after %d service failures", _BREAKER_THRESHOLD)
+	}
+}
+
+func TestRetryOnlyServiceErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		calls int
+	}{
+		{"bad request", ServiceError{StatusCode: 400}, 1},
+		{"unauthorized", ServiceError{StatusCode: 401}, 1},
+		{"not a service error", errors.New("expected 2 embeddings, got 1"), 1},
+		{"server error", ServiceError{StatusCode: 502}, RETRY_ATTEMPTS},
+		{"connection refused", &url.Error{Op: "Post", URL: "http://localhost", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, RETRY_ATTEMPTS},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			model := "retry test " + test.name
+			t.Cleanup(func() {
+				guards_lock.Lock()
+				delete(guards, model)
+				guards_lock.Unlock()
+			})
+			calls := 0
+			retryT(model, func() (int, error) {
+				calls++
+				return 0, test.err
+			})
+			if calls != test.calls {
+				t.Errorf("retryT() called the function %d times for %v, want %d", calls, test.err, test.calls)
+			}
+			if guard := getServiceGuard(model); guard.failures != 0 && !isServiceError(test.err) {
+				t.Errorf("%v counted %d failures against the breaker", test.err, guard.failures)
+			}
+		})
+	}
+}
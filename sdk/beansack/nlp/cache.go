@@ -0,0 +1,110 @@
+package nlp
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	_DEFAULT_CACHE_CAPACITY = 10000
+)
+
+// Cache is a content-addressed key value store for generated outputs such as embeddings, digests and keyconcepts.
+// The keys are generated through CacheKey so the backends don't need to know anything about the content
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+}
+
+// hash(model, task_type, prompt_version, text)
+func CacheKey(model, task_type, prompt_version, text string) string {
+	hash := sha256.Sum256([]byte(strings.Join([]string{model, task_type, prompt_version, text}, "\x00")))
+	return hex.EncodeToString(hash[:])
+}
+
+// //	IN-MEMORY LRU CACHE		////
+type memoryCacheItem struct {
+	key   string
+	value []byte
+}
+
+type MemoryCache struct {
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+	lock     sync.Mutex
+}
+
+func NewMemoryCache(capacity int) *MemoryCache {
+	if capacity <= 0 {
+		capacity = _DEFAULT_CACHE_CAPACITY
+	}
+	return &MemoryCache{
+		capacity: capacity,
+		items:    make(map[string]*list.Element, capacity),
+		order:    list.New(),
+	}
+}
+
+func (cache *MemoryCache) Get(key string) ([]byte, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if elem, ok := cache.items[key]; ok {
+		cache.order.MoveToFront(elem)
+		return elem.Value.(*memoryCacheItem).value, true
+	}
+	return nil, false
+}
+
+func (cache *MemoryCache) Set(key string, value []byte) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if elem, ok := cache.items[key]; ok {
+		elem.Value.(*memoryCacheItem).value = value
+		cache.order.MoveToFront(elem)
+		return
+	}
+	cache.items[key] = cache.order.PushFront(&memoryCacheItem{key, value})
+	// evict the least recently used one
+	if cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.items, oldest.Value.(*memoryCacheItem).key)
+	}
+}
+
+// //	ON-DISK CACHE		////
+// each item is stored as a file named by its key
+type DiskCache struct {
+	dir string
+}
+
+func NewDiskCache(dir string) *DiskCache {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("[DiskCache] Failed creating cache directory %s. %v\n", dir, err)
+		return nil
+	}
+	return &DiskCache{dir: dir}
+}
+
+func (cache *DiskCache) Get(key string) ([]byte, bool) {
+	data, err := os.ReadFile(filepath.Join(cache.dir, key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (cache *DiskCache) Set(key string, value []byte) {
+	if err := os.WriteFile(filepath.Join(cache.dir, key), value, 0644); err != nil {
+		log.Printf("[DiskCache] Failed writing %s. %v\n", key, err)
+	}
+}
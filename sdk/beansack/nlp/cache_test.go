@@ -0,0 +1,126 @@
+package nlp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMemoryCacheEviction(t *testing.T) {
+	cache := NewMemoryCache(3)
+	cache.Set("a", []byte("1"))
+	cache.Set("b", []byte("2"))
+	cache.Set("c", []byte("3"))
+	// reading a makes b the least recently used one
+	cache.Get("a")
+	cache.Set("d", []byte("4"))
+	tests := []struct {
+		key   string
+		value string
+		ok    bool
+	}{
+		{"a", "1", true},
+		{"b", "", false},
+		{"c", "3", true},
+		{"d", "4", true},
+	}
+	for _, test := range tests {
+		if value, ok := cache.Get(test.key); ok != test.ok || string(value) != test.value {
+			t.Errorf("Get(%q) = %q %v, want %q %v", test.key, value, ok, test.value, test.ok)
+		}
+	}
+	if cache.order.Len() != 3 || len(cache.items) != 3 {
+		t.Errorf("cache holds %d items and %d keys, want 3", cache.order.Len(), len(cache.items))
+	}
+}
+
+func TestMemoryCacheReplace(t *testing.T) {
+	cache := NewMemoryCache(2)
+	cache.Set("a", []byte("1"))
+	cache.Set("b", []byte("2"))
+	// replacing a makes it the most recently used one without taking another slot
+	cache.Set("a", []byte("3"))
+	cache.Set("c", []byte("4"))
+	if value, ok := cache.Get("a"); !ok || string(value) != "3" {
+		t.Errorf("Get(a) = %q %v, want the replaced value", value, ok)
+	}
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Get(b) found the least recently used item")
+	}
+	if cache := NewMemoryCache(0); cache.capacity != _DEFAULT_CACHE_CAPACITY {
+		t.Errorf("NewMemoryCache(0) capacity = %d, want %d", cache.capacity, _DEFAULT_CACHE_CAPACITY)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	key := CacheKey("model", "task", "v1", "text")
+	tests := []struct {
+		name  string
+		other string
+		same  bool
+	}{
+		{"same input", CacheKey("model", "task", "v1", "text"), true},
+		{"other model", CacheKey("other", "task", "v1", "text"), false},
+		{"other task", CacheKey("model", "other", "v1", "text"), false},
+		{"other prompt version", CacheKey("model", "task", "v2", "text"), false},
+		{"other text", CacheKey("model", "task", "v1", "other"), false},
+		// the parts are delimited so they can't run into each other
+		{"shifted parts", CacheKey("mode", "ltask", "v1", "text"), false},
+		{"shifted into the text", CacheKey("model", "task", "v", "1text"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.other == key; got != test.same {
+				t.Errorf("CacheKey() same = %v, want %v", got, test.same)
+			}
+		})
+	}
+	if len(key) != 64 {
+		t.Errorf("CacheKey() = %q, want a hex sha256", key)
+	}
+}
+
+// extracts an entity named after each text and records the texts it got
+type stubEntityExtractor struct {
+	Extractor
+	calls   [][]string
+	skipped map[string]bool // the texts that get no entities like a failure
+}
+
+func (stub *stubEntityExtractor) ModelName() string                { return "stub" }
+func (stub *stubEntityExtractor) PromptVersion(task string) string { return "v1" }
+func (stub *stubEntityExtractor) ExtractEntities(texts []string) []Entity {
+	stub.calls = append(stub.calls, texts)
+	var output []Entity
+	// out of order like an LLM may list them
+	for i := len(texts) - 1; i >= 0; i-- {
+		if !stub.skipped[texts[i]] {
+			output = append(output, Entity{DocIndex: i, Name: texts[i]})
+		}
+	}
+	return output
+}
+
+func TestGetCachedPerDocument(t *testing.T) {
+	stub := &stubEntityExtractor{skipped: map[string]bool{"failed": true}}
+	client := NewCachedExtractor(stub, NewMemoryCache(10))
+	client.ExtractEntities([]string{"b", "d"})
+
+	entities := client.ExtractEntities([]string{"a", "b", "c", "d", "failed"})
+	got := make([]Entity, 0, len(entities))
+	for _, entity := range entities {
+		got = append(got, Entity{DocIndex: entity.DocIndex, Name: entity.Name})
+	}
+	want := []Entity{{DocIndex: 0, Name: "a"}, {DocIndex: 1, Name: "b"}, {DocIndex: 2, Name: "c"}, {DocIndex: 3, Name: "d"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractEntities() = %+v, want %+v", got, want)
+	}
+	// only the misses are extracted
+	if want := [][]string{{"b", "d"}, {"a", "c", "failed"}}; !reflect.DeepEqual(stub.calls, want) {
+		t.Errorf("extracted %v, want %v", stub.calls, want)
+	}
+	// the failure isn't cached so it is tried again
+	client.ExtractEntities([]string{"a", "failed"})
+	if last := stub.calls[len(stub.calls)-1]; !reflect.DeepEqual(last, []string{"failed"}) {
+		t.Errorf("extracted %v, want only the failed one again", last)
+	}
+}
@@ -0,0 +1,223 @@
+package nlp
+
+import (
+	"encoding/json"
+	"sort"
+
+	datautils "github.com/soumitsalman/data-utils"
+)
+
+// CachedEmbedder looks up the cache before calling the underlying embedder
+// only the cache misses get sent to the embedder and duds are never cached
+type CachedEmbedder struct {
+	Embedder
+	cache Cache
+}
+
+func NewCachedEmbedder(embedder Embedder, cache Cache) *CachedEmbedder {
+	return &CachedEmbedder{Embedder: embedder, cache: cache}
+}
+
+func (driver *CachedEmbedder) CreateBatchTextEmbeddings(texts []string, task_type string) [][]float32 {
+	output := make([][]float32, len(texts))
+	keys := make([]string, len(texts))
+	// collect the misses and their position in the output
+	miss_indexes := make([]int, 0, len(texts))
+	miss_texts := make([]string, 0, len(texts))
+	for i := range texts {
+		keys[i] = CacheKey(driver.ModelName(), task_type, "", texts[i])
+		if !getCachedValue(driver.cache, keys[i], &output[i]) {
+			miss_indexes = append(miss_indexes, i)
+			miss_texts = append(miss_texts, texts[i])
+		}
+	}
+	if len(miss_texts) == 0 {
+		return output
+	}
+
+	embs := driver.Embedder.CreateBatchTextEmbeddings(miss_texts, task_type)
+	for j, i := range miss_indexes {
+		if j < len(embs) && len(embs[j]) > 0 {
+			output[i] = embs[j]
+			setCachedValue(driver.cache, keys[i], embs[j])
+		}
+	}
+	return output
+}
+
+func (driver *CachedEmbedder) CreateTextEmbeddings(text string, task_type string) []float32 {
+	var emb []float32
+	key := CacheKey(driver.ModelName(), task_type, "", text)
+	if getCachedValue(driver.cache, key, &emb) {
+		return emb
+	}
+	if emb = driver.Embedder.CreateTextEmbeddings(text, task_type); len(emb) > 0 {
+		setCachedValue(driver.cache, key, emb)
+	}
+	return emb
+}
+
+// CachedExtractor looks up the cache before calling the underlying LLM.
+// Digests, KeyConcepts, Sentiments, Entities and Translations are cached per text
+type CachedExtractor struct {
+	Extractor
+	cache Cache
+}
+
+func NewCachedExtractor(extractor Extractor, cache Cache) *CachedExtractor {
+	return &CachedExtractor{Extractor: extractor, cache: cache}
+}
+
+func (client *CachedExtractor) ExtractDigests(texts []string) []Digest {
+	output := make([]Digest, len(texts))
+	keys := make([]string, len(texts))
+	miss_indexes := make([]int, 0, len(texts))
+	miss_texts := make([]string, 0, len(texts))
+	version := client.digestVersion()
+	for i := range texts {
+		keys[i] = CacheKey(client.ModelName(), DIGEST_TASK, version, texts[i])
+		if !getCachedValue(client.cache, keys[i], &output[i]) {
+			miss_indexes = append(miss_indexes, i)
+			miss_texts = append(miss_texts, texts[i])
+		}
+	}
+	if len(miss_texts) == 0 {
+		return output
+	}
+
+	digests := client.Extractor.ExtractDigests(miss_texts)
+	for j, i := range miss_indexes {
+		if j < len(digests) && len(digests[j].Summary) > 0 {
+			output[i] = digests[j]
+			setCachedValue(client.cache, keys[i], digests[j])
+		}
+	}
+	return output
+}
+
+// the digest of a long text also depends on the summarization mode
+func (client *CachedExtractor) digestVersion() string {
+	version := client.PromptVersion(DIGEST_TASK)
+	if summarizer, ok := client.Extractor.(interface{ SummarizationMode() string }); ok {
+		version += "/" + summarizer.SummarizationMode()
+	}
+	return version
+}
+
+func (client *CachedExtractor) ExtractKeyConcepts(texts []string) []KeyConcept {
+	return getCachedPerDocument(client, CONCEPTS_TASK, texts, client.Extractor.ExtractKeyConcepts,
+		func(item *KeyConcept) *int { return &item.DocIndex })
+}
+
+func (client *CachedExtractor) ExtractEntities(texts []string) []Entity {
+	return getCachedPerDocument(client, ENTITIES_TASK, texts, client.Extractor.ExtractEntities,
+		func(item *Entity) *int { return &item.DocIndex })
+}
+
+// caches the outputs of a multi-document extraction per document.
+// doc_index points to the DocIndex field of the output item
+func getCachedPerDocument[T any](client *CachedExtractor, task string, texts []string, extract func(texts []string) []T, doc_index func(item *T) *int) []T {
+	output := make([]T, 0, len(texts))
+	keys := make([]string, len(texts))
+	miss_indexes := make([]int, 0, len(texts))
+	miss_texts := make([]string, 0, len(texts))
+	for i := range texts {
+		var items []T
+		keys[i] = CacheKey(client.ModelName(), task, client.PromptVersion(task), texts[i])
+		if getCachedValue(client.cache, keys[i], &items) {
+			output = append(output, datautils.ForEach(items, func(item *T) { *doc_index(item) = i })...)
+		} else {
+			miss_indexes = append(miss_indexes, i)
+			miss_texts = append(miss_texts, texts[i])
+		}
+	}
+	if len(miss_texts) == 0 {
+		return output
+	}
+
+	// group by document so that each document gets cached on its own
+	per_doc := make([][]T, len(miss_texts))
+	datautils.ForEach(extract(miss_texts), func(item *T) {
+		if j := *doc_index(item); j >= 0 && j < len(per_doc) {
+			per_doc[j] = append(per_doc[j], *item)
+		}
+	})
+	for j, i := range miss_indexes {
+		// the cached values are stored with doc_index 0 since they are stored per document
+		// a document without any output is not cached since that is most likely a failure
+		if len(per_doc[j]) > 0 {
+			setCachedValue(client.cache, keys[i], datautils.ForEach(per_doc[j], func(item *T) { *doc_index(item) = 0 }))
+			output = append(output, datautils.ForEach(per_doc[j], func(item *T) { *doc_index(item) = i })...)
+		}
+	}
+	// the hits and the misses go back in the order of the texts
+	sort.SliceStable(output, func(a, b int) bool { return *doc_index(&output[a]) < *doc_index(&output[b]) })
+	return output
+}
+
+func (client *CachedExtractor) ScoreSentiments(texts []string) []Sentiment {
+	output := make([]Sentiment, len(texts))
+	keys := make([]string, len(texts))
+	miss_indexes := make([]int, 0, len(texts))
+	miss_texts := make([]string, 0, len(texts))
+	version := client.PromptVersion(SENTIMENT_TASK)
+	for i := range texts {
+		keys[i] = CacheKey(client.ModelName(), SENTIMENT_TASK, version, texts[i])
+		if !getCachedValue(client.cache, keys[i], &output[i]) {
+			miss_indexes = append(miss_indexes, i)
+			miss_texts = append(miss_texts, texts[i])
+		}
+	}
+	if len(miss_texts) == 0 {
+		return output
+	}
+
+	sentiments := client.Extractor.ScoreSentiments(miss_texts)
+	for j, i := range miss_indexes {
+		if j < len(sentiments) {
+			output[i] = sentiments[j]
+			// the failed ones are not cached so that the LLM gets another shot next time
+			if sentiments[j].Label != "" {
+				setCachedValue(client.cache, keys[i], sentiments[j])
+			}
+		}
+	}
+	return output
+}
+
+func (client *CachedExtractor) TranslateToEnglish(texts []string) []string {
+	output := make([]string, len(texts))
+	keys := make([]string, len(texts))
+	miss_indexes := make([]int, 0, len(texts))
+	miss_texts := make([]string, 0, len(texts))
+	for i := range texts {
+		keys[i] = CacheKey(client.ModelName(), TRANSLATION_TASK, client.PromptVersion(TRANSLATION_TASK), texts[i])
+		if !getCachedValue(client.cache, keys[i], &output[i]) {
+			miss_indexes = append(miss_indexes, i)
+			miss_texts = append(miss_texts, texts[i])
+		}
+	}
+	if len(miss_texts) == 0 {
+		return output
+	}
+
+	translations := client.Extractor.TranslateToEnglish(miss_texts)
+	for j, i := range miss_indexes {
+		if j < len(translations) && len(translations[j]) > 0 {
+			output[i] = translations[j]
+			setCachedValue(client.cache, keys[i], translations[j])
+		}
+	}
+	return output
+}
+
+func getCachedValue[T any](cache Cache, key string, value *T) bool {
+	data, ok := cache.Get(key)
+	return ok && json.Unmarshal(data, value) == nil
+}
+
+func setCachedValue[T any](cache Cache, key string, value T) {
+	if data, err := json.Marshal(value); err == nil {
+		cache.Set(key, data)
+	}
+}
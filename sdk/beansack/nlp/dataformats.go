@@ -1,8 +1,10 @@
 package nlp
 
 type Digest struct {
-	Summary string `json:"summary,omitempty" bson:"summary,omitempty" jsonschema_description:"A concise summary of the document"`
-	Topic   string `json:"topic,omitempty" bson:"topic,omitempty" jsonschema_description:"The topic of the content such as: Threat Intelligence, New Malware, Israel Hamas War, iPhone Release, LLAMA Performance, Disease, Politics, Drug Epidemic, Entertainment, Gaiming etc."`
+	Summary string `json:"summary,omitempty" bson:"summary,omitempty" jsonschema:"required,minLength=1" jsonschema_description:"A concise summary of the document"`
+	Topic   string `json:"topic,omitempty" bson:"topic,omitempty" jsonschema:"required" jsonschema_description:"The topic of the content such as: Threat Intelligence, New Malware, Israel Hamas War, iPhone Release, LLAMA Performance, Disease, Politics, Drug Epidemic, Entertainment, Gaiming etc."`
+	// not generated by the LLM. stamped after generation
+	PromptVersion string `json:"prompt_version,omitempty" bson:"digest_version,omitempty" jsonschema:"-"`
 }
 
 type keyConceptList struct {
@@ -10,7 +12,42 @@ type keyConceptList struct {
 }
 
 type KeyConcept struct {
-	KeyPhrase   string `json:"keyphrase" jsonschema_description:"'keyphrase' can be the name of a company, product, person, place, security vulnerability, entity, location, organization, object, condition, acronym, documents, service, disease, medical condition, vehicle, polical group etc."`
+	DocIndex    int    `json:"doc_index" jsonschema_description:"The number of the DOCUMENT the keyconcept was extracted from"`
+	KeyPhrase   string `json:"keyphrase" jsonschema:"minLength=1" jsonschema_description:"'keyphrase' can be the name of a company, product, person, place, security vulnerability, entity, location, organization, object, condition, acronym, documents, service, disease, medical condition, vehicle, polical group etc."`
 	Event       string `json:"event" jsonschema_description:"'event' can be action, state or condition associated to the 'keyphrase' such as: what is the 'keyphrase' doing OR what is happening to the 'keyphrase' OR how is 'keyphrase' being impacted."`
-	Description string `json:"description"  jsonschema_description:"A concise summary of the 'event' associated to the 'keyphrase'"`
+	Description string `json:"description" jsonschema:"minLength=1" jsonschema_description:"A concise summary of the 'event' associated to the 'keyphrase'"`
+	// not generated by the LLM. stamped after generation
+	PromptVersion string `json:"prompt_version,omitempty" jsonschema:"-"`
+}
+
+type Sentiment struct {
+	Label string  `json:"sentiment" bson:"sentiment,omitempty" jsonschema:"required,enum=positive,enum=negative,enum=neutral" jsonschema_description:"The overall tone of the content. One of: positive, negative, neutral"`
+	Score float64 `json:"score" bson:"sentiment_score,omitempty" jsonschema:"required" jsonschema_description:"How strong the tone is from -1.0 (very negative) to 1.0 (very positive). 0 means neutral"`
+	// not generated by the LLM. stamped after generation
+	PromptVersion string `json:"prompt_version,omitempty" bson:"sentiment_version,omitempty" jsonschema:"-"`
+}
+
+// entity types
+const (
+	ORG_ENTITY      = "org"
+	PERSON_ENTITY   = "person"
+	PRODUCT_ENTITY  = "product"
+	CVE_ENTITY      = "cve"
+	LOCATION_ENTITY = "location"
+)
+
+type entityList struct {
+	Items []Entity `json:"entities" jsonschema_description:"Array of named entities"`
+}
+
+type Entity struct {
+	DocIndex int    `json:"doc_index" jsonschema_description:"The number of the DOCUMENT the entity was extracted from"`
+	Name     string `json:"name" jsonschema:"minLength=1" jsonschema_description:"The name of the entity as it appears in the DOCUMENT"`
+	Type     string `json:"type" jsonschema:"enum=org,enum=person,enum=product,enum=cve,enum=location" jsonschema_description:"One of: org (company, agency, group), person, product (product, service, software, malware), cve (security vulnerability ID), location (country, city, place)"`
+	// not generated by the LLM. stamped after generation
+	PromptVersion string `json:"prompt_version,omitempty" jsonschema:"-"`
+}
+
+type Translation struct {
+	Text string `json:"text" jsonschema:"minLength=1" jsonschema_description:"The English translation of the document"`
 }
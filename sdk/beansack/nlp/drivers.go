@@ -0,0 +1,31 @@
+package nlp
+
+// Embedder is implemented by anything that can turn text into vectors.
+// EmbeddingsDriver is the default implementation backed by a llamafile server
+type Embedder interface {
+	CreateBatchTextEmbeddings(texts []string, task_type string) [][]float32
+	CreateTextEmbeddings(text string, task_type string) []float32
+	// max number of tokens the embedder can take in one input
+	ContextWindow() int
+	ModelName() string
+}
+
+// Extractor is implemented by anything that can generate digests, keyconcepts, sentiments and entities from text, translate it and answer questions from it.
+// ParrotboxClient is the default implementation backed by an LLM service
+type Extractor interface {
+	ExtractDigests(texts []string) []Digest
+	ExtractKeyConcepts(texts []string) []KeyConcept
+	// returns one sentiment per text in the same order
+	ScoreSentiments(texts []string) []Sentiment
+	// extracts the named entities from all the texts. Each entity's DocIndex points to the text in `texts` it came from
+	ExtractEntities(texts []string) []Entity
+	// returns the English translation of each text in the same order. Failed ones are empty strings
+	TranslateToEnglish(texts []string) []string
+	// answers the question from the documents alone citing them inline as [n] where n is the 1-based position in `documents`.
+	// Documents that don't fit the window are left out. stream, if not nil, gets the answer in chunks as it is generated.
+	// Returns empty string if it fails
+	Answer(question string, documents []string, stream func(chunk string)) string
+	ModelName() string
+	// version of the prompt used for the task. This is stamped on the generated values
+	PromptVersion(task string) string
+}
@@ -0,0 +1,51 @@
+package nlp
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	_CVE_EXPR       = regexp.MustCompile(`(?i)\bCVE-\d{4}-\d{4,}\b`)
+	_NON_ALNUM_EXPR = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+	// legal suffixes that don't make an org a different org
+	_ORG_SUFFIXES = []string{"incorporated", "inc", "corporation", "corp", "company", "co", "limited", "ltd", "llc", "plc", "gmbh", "ag", "sa"}
+)
+
+// returns the unique CVE IDs in the text in upper case
+func ExtractCVEs(text string) []string {
+	var cves []string
+	for _, match := range _CVE_EXPR.FindAllString(text, -1) {
+		match = strings.ToUpper(match)
+		if !slices.Contains(cves, match) {
+			cves = append(cves, match)
+		}
+	}
+	return cves
+}
+
+// Reduces an entity name to a lookup key so that the spelling variants of the same entity collide.
+// "OpenAI", "Open AI" and "OpenAI Inc." all become "openai"
+func NormalizeEntityName(name string) string {
+	words := strings.Fields(_NON_ALNUM_EXPR.ReplaceAllString(strings.ToLower(name), " "))
+	// the name itself can be a suffix like "Co" so keep at least one word
+	for len(words) > 1 && slices.Contains(_ORG_SUFFIXES, words[len(words)-1]) {
+		words = words[:len(words)-1]
+	}
+	return strings.Join(words, "")
+}
+
+// returns the capitalized phrases and the CVE IDs in the text.
+// This is for looking up the entities mentioned in short texts such as search queries without calling the LLM
+func ExtractNames(text string) []string {
+	var names []string
+	for _, sentence := range splitSentences(text) {
+		for _, name := range capitalizedPhrases(sentence) {
+			if !slices.Contains(names, name) && !_CVE_EXPR.MatchString(name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return append(names, ExtractCVEs(text)...)
+}
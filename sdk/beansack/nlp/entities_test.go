@@ -0,0 +1,82 @@
+package nlp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeEntityName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"OpenAI", "openai"},
+		{"Open AI", "openai"},
+		{"OpenAI Inc.", "openai"},
+		{"OpenAI, Inc.", "openai"},
+		{"Microsoft Corporation", "microsoft"},
+		{"ACME LLC Ltd", "acme"},
+		{"3M Company", "3m"},
+		{"AT&T", "att"},
+		{"Inc. Magazine", "incmagazine"},
+		{"Co", "co"},
+		{"Café", "café"},
+		{"华为", "华为"},
+		{"", ""},
+		{" - ", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := NormalizeEntityName(test.name); got != test.want {
+				t.Errorf("NormalizeEntityName(%q) = %q, want %q", test.name, got, test.want)
+			}
+		})
+	}
+}
+
+func TestExtractCVEs(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"none", "nothing to see here", nil},
+		{"one", "Fortra patched CVE-2024-0204.", []string{"CVE-2024-0204"}},
+		{"upper cased", "cve-2024-3094 in xz", []string{"CVE-2024-3094"}},
+		{"repeated", "CVE-2024-3094 aka cve-2024-3094 and CVE-2024-3094", []string{"CVE-2024-3094"}},
+		{"in order", "CVE-2023-12345 then CVE-2021-44228", []string{"CVE-2023-12345", "CVE-2021-44228"}},
+		{"too short", "CVE-24-1234 and CVE-2024-123", nil},
+		{"inside a word", "xCVE-2024-1234 and CVE-2024-1234x", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ExtractCVEs(test.text); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ExtractCVEs() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExtractNames(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"lower case", "what is new in rust", nil},
+		{"empty", "", nil},
+		{"names", "What did OpenAI announce about GPT-5?", []string{"OpenAI", "GPT-5"}},
+		{"multi word names", "Is the Fortra GoAnywhere flaw patched", []string{"Fortra GoAnywhere"}},
+		{"repeated", "Is Microsoft Azure down? Microsoft Azure outage.", []string{"Microsoft Azure"}},
+		// a capitalized word at the start of a sentence is not a name on its own
+		{"sentence start", "Google and Apple", []string{"Apple"}},
+		{"CVEs at the end", "The CVE-2024-0204 flaw in Fortra GoAnywhere", []string{"Fortra GoAnywhere", "CVE-2024-0204"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ExtractNames(test.text); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ExtractNames() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
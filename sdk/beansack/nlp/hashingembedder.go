@@ -0,0 +1,89 @@
+package nlp
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+
+	datautils "github.com/soumitsalman/data-utils"
+)
+
+const (
+	_DEFAULT_HASHING_DIM = 768 // same as nomic-embed-text so that the local vectors fit the existing vector indexes
+	_HASHING_CONTEXT     = 8192
+	// the tokenizer downloads its vocabulary on first use so the local embedder truncates on characters instead
+	_HASHING_MAX_CHARS = 4 * _HASHING_CONTEXT
+)
+
+// HashingEmbedder is a local deterministic embedder for offline runs and tests. It uses the hashing trick:
+// every word and word bigram is hashed into one of the dimensions with a hashed sign and weighted by its log term frequency.
+// The vectors are L2 normalized so texts sharing vocabulary end up close in cosine similarity
+type HashingEmbedder struct {
+	dim int
+}
+
+// dim is the number of dimensions of the vectors. <= 0 means 768
+func NewHashingEmbedder(dim int) *HashingEmbedder {
+	if dim <= 0 {
+		dim = _DEFAULT_HASHING_DIM
+	}
+	return &HashingEmbedder{dim: dim}
+}
+
+func (embedder *HashingEmbedder) CreateBatchTextEmbeddings(texts []string, task_type string) [][]float32 {
+	return datautils.Transform(texts, func(item *string) []float32 { return embedder.CreateTextEmbeddings(*item, task_type) })
+}
+
+// task_type is ignored since the same text has to land on the same vector for queries and documents
+func (embedder *HashingEmbedder) CreateTextEmbeddings(text string, task_type string) []float32 {
+	words := tokenizeWords(safeSlice(text, _HASHING_MAX_CHARS))
+	if len(words) == 0 {
+		return nil
+	}
+	counts := make(map[string]int)
+	for i := range words {
+		counts[words[i]]++
+		if i > 0 {
+			counts[words[i-1]+" "+words[i]]++
+		}
+	}
+
+	vec := make([]float64, embedder.dim)
+	for term, count := range counts {
+		index, sign := embedder.hash(term)
+		vec[index] += sign * (1 + math.Log(float64(count)))
+	}
+	var norm float64
+	for _, v := range vec {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	return datautils.Transform(vec, func(v *float64) float32 { return float32(*v / norm) })
+}
+
+func (embedder *HashingEmbedder) ContextWindow() int {
+	return _HASHING_CONTEXT
+}
+
+func (embedder *HashingEmbedder) ModelName() string {
+	return fmt.Sprintf("local-hashing-%d", embedder.dim)
+}
+
+// the index comes from the low bits and the sign from the top bit so that collisions cancel out on average
+func (embedder *HashingEmbedder) hash(term string) (int, float64) {
+	h := fnv.New64a()
+	h.Write([]byte(term))
+	sum := h.Sum64()
+	if sum>>63 == 1 {
+		return int(sum % uint64(embedder.dim)), -1
+	}
+	return int(sum % uint64(embedder.dim)), 1
+}
+
+// lowercase words and numbers. stopwords are dropped since they say nothing about the content
+func tokenizeWords(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	return datautils.Filter(words, func(word *string) bool { return !isStopword(*word) })
+}
@@ -0,0 +1,58 @@
+package nlp
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func cosine(a, b []float32) float64 {
+	var dot, norm_a, norm_b float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		norm_a += float64(a[i]) * float64(a[i])
+		norm_b += float64(b[i]) * float64(b[i])
+	}
+	return dot / math.Sqrt(norm_a*norm_b)
+}
+
+func TestHashingEmbedder(t *testing.T) {
+	embedder := NewHashingEmbedder(256)
+	ransomware := "LockBit ransomware gang leaks stolen pension data after the breach"
+	similar := "The LockBit ransomware gang leaked stolen pension fund data"
+	unrelated := "Chocolate cake recipe with strawberries and whipped cream"
+
+	emb := embedder.CreateTextEmbeddings(ransomware, SEARCH_DOCUMENT)
+	if len(emb) != 256 {
+		t.Fatalf("len(CreateTextEmbeddings()) = %d, want 256", len(emb))
+	}
+	if norm := cosine(emb, emb); math.Abs(norm-1) > 1e-6 {
+		t.Errorf("embedding is not normalized. cosine with itself = %f", norm)
+	}
+	// the same text lands on the same vector for every task and every call
+	if query := embedder.CreateTextEmbeddings(ransomware, SEARCH_QUERY); !reflect.DeepEqual(emb, query) {
+		t.Errorf("CreateTextEmbeddings() depends on the task type")
+	}
+	if again := NewHashingEmbedder(256).CreateTextEmbeddings(ransomware, SEARCH_DOCUMENT); !reflect.DeepEqual(emb, again) {
+		t.Errorf("CreateTextEmbeddings() is not deterministic")
+	}
+	similar_score := cosine(emb, embedder.CreateTextEmbeddings(similar, SEARCH_DOCUMENT))
+	unrelated_score := cosine(emb, embedder.CreateTextEmbeddings(unrelated, SEARCH_DOCUMENT))
+	if similar_score <= unrelated_score || similar_score < 0.3 {
+		t.Errorf("cosine with similar text = %f, with unrelated text = %f", similar_score, unrelated_score)
+	}
+}
+
+func TestHashingEmbedderEdgeCases(t *testing.T) {
+	if emb := NewHashingEmbedder(0).CreateTextEmbeddings("Kubernetes", SEARCH_DOCUMENT); len(emb) != _DEFAULT_HASHING_DIM {
+		t.Errorf("default dimensions = %d, want %d", len(emb), _DEFAULT_HASHING_DIM)
+	}
+	// nothing but stopwords and punctuation is a dud
+	if emb := NewHashingEmbedder(16).CreateTextEmbeddings("the and of ...", SEARCH_DOCUMENT); emb != nil {
+		t.Errorf("CreateTextEmbeddings(stopwords) = %v, want nil", emb)
+	}
+	embs := NewHashingEmbedder(16).CreateBatchTextEmbeddings([]string{"alpha beta", "", "gamma"}, SEARCH_DOCUMENT)
+	if len(embs) != 3 || len(embs[0]) != 16 || embs[1] != nil || len(embs[2]) != 16 {
+		t.Errorf("CreateBatchTextEmbeddings() = %v, want one embedding per text with a dud for the empty one", embs)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 
-	datautils "github.com/soumitsalman/data-utils"
 	"github.com/tmc/langchaingo/chains"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/prompts"
@@ -34,17 +33,19 @@ type JsonValueExtraction struct {
 	llm_chain *chains.LLMChain
 }
 
-func NewJsonValueExtraction[T any](llm llms.Model, sample_input string, sample_output *T) *JsonValueExtraction {
-	parser := NewJsonOutputParser[T](*sample_output)
+// creates a chain for the prompt template. The few-shot examples in the template are added as sample input/output messages
+// the instruction in the template is passed in as "context" during Call so that it can be swapped for retries
+func NewJsonValueExtraction[T any](llm llms.Model, template *PromptTemplate) *JsonValueExtraction {
+	var sample_output T
+	parser := NewJsonOutputParser[T](sample_output)
 
 	prompt := prompts.NewChatPromptTemplate([]prompts.MessageFormatter{
 		prompts.NewSystemMessagePromptTemplate(fmt.Sprintf(_SYS_TEMPLATE, parser.GetFormatInstructions()), []string{"context"}),
 	})
-	if len(sample_input) > 0 {
-		prompt.Messages = append(prompt.Messages, prompts.NewHumanMessagePromptTemplate(fmt.Sprintf(_SAMPLE_INPUT, sample_input), nil))
-	}
-	if sample_output != nil {
-		prompt.Messages = append(prompt.Messages, prompts.NewAIMessagePromptTemplate(fmt.Sprintf(_SAMPLE_OUTPUT, datautils.ToJsonString(sample_output)), nil))
+	for _, example := range template.Examples {
+		prompt.Messages = append(prompt.Messages,
+			prompts.NewHumanMessagePromptTemplate(fmt.Sprintf(_SAMPLE_INPUT, example.Input), nil),
+			prompts.NewAIMessagePromptTemplate(fmt.Sprintf(_SAMPLE_OUTPUT, string(example.Output)), nil))
 	}
 	prompt.Messages = append(prompt.Messages, prompts.NewHumanMessagePromptTemplate(_USER_TEMPLATE, []string{"input_text"}))
 
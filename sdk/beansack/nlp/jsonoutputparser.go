@@ -3,7 +3,6 @@ package nlp
 import (
 	"encoding/json"
 	"log"
-	"strings"
 
 	"github.com/invopop/jsonschema"
 	datautils "github.com/soumitsalman/data-utils"
@@ -13,6 +12,8 @@ import (
 type ParseError struct {
 	Text   string
 	Reason string
+	// concrete schema violations or syntax errors that can be fed back to the LLM
+	Errors []string `json:",omitempty"`
 }
 
 func (err ParseError) Error() string {
@@ -34,32 +35,34 @@ func NewJsonOutputParser[T any](sample_val T) JsonOutputParser[T] {
 	}
 }
 
-// Parse parses the output of an LLM into a map. If the content fails to serialize it will return an error
-// or else it will return a value of type T
+// Parse parses the output of an LLM into a value of type T.
+// Steps:
+//  1. Pull out the json content from ```json ... ``` or whatever chatter is around it
+//  2. If that doesn't deserialize, leniently repair trailing commas, single quotes and truncated arrays
+//  3. Validate the value against the json schema of T: required fields, types and non-empty strings
+//
+// If any of these fail it returns a ParseError with the list of errors so that the caller can ask the LLM to repair it
 func (p JsonOutputParser[T]) ParseT(text string) (T, error) {
 	var parsed T
-	// try parsing the content as is, if it doesnt work try the following
-	if err := json.Unmarshal([]byte(text), &parsed); err != nil {
-		// assume that it has ```json\nJSON_FORMAT\n```
-		// Remove the ```json that should be at the start of the text, and the ```
-		// that should be at the end of the text.
-		withoutJSONStart := strings.Split(text, "```json")
-		if !(len(withoutJSONStart) > 1) {
-			return parsed, ParseError{Text: text, Reason: "no ```json at start of output"}
-		}
+	json_text := extractJsonText(text)
 
-		withoutJSONEnd := strings.Split(withoutJSONStart[1], "```")
-		if len(withoutJSONEnd) < 1 {
-			return parsed, ParseError{Text: text, Reason: "no ``` at end of output"}
+	var value any
+	if err := json.Unmarshal([]byte(json_text), &value); err != nil {
+		if value, err = lenientUnmarshal(json_text); err != nil {
+			log.Printf("[%s] Failed unmarshalling. %s", p.Type(), json_text)
+			return parsed, ParseError{Text: text, Reason: "output is not valid json", Errors: []string{err.Error()}}
 		}
+	}
 
-		json_text := withoutJSONEnd[0]
+	if errs := validateJson(value, p.data_schema, p.data_schema.Definitions, ""); len(errs) > 0 {
+		log.Printf("[%s] Output failed schema validation. %v", p.Type(), errs)
+		return parsed, ParseError{Text: text, Reason: "output does not match the json schema", Errors: errs}
+	}
 
-		err := json.Unmarshal([]byte(json_text), &parsed)
-		if err != nil {
-			log.Printf("[%s] Failed unmarshalling. %s", p.Type(), json_text)
-			return parsed, err
-		}
+	// the value is already validated so this is just a type conversion
+	data, _ := json.Marshal(value)
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return parsed, ParseError{Text: text, Reason: "output does not match the json schema", Errors: []string{err.Error()}}
 	}
 	return parsed, nil
 }
@@ -0,0 +1,228 @@
+package nlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+)
+
+const (
+	_MAX_TRUNCATION_REPAIRS = 5
+)
+
+// pulls out the json content from an LLM output.
+// it can be wrapped in ```json ... ``` or it can have some chatter before the json begins
+func extractJsonText(text string) string {
+	if parts := strings.SplitN(text, "```json", 2); len(parts) > 1 {
+		// the closing ``` may not be there if the output got truncated
+		return strings.SplitN(parts[1], "```", 2)[0]
+	}
+	if start := strings.IndexAny(text, "{["); start >= 0 {
+		return text[start:]
+	}
+	return text
+}
+
+// tries to fix the common mistakes LLMs make: single quotes, trailing commas and truncated outputs.
+// if the output got truncated in the middle of an item, that item gets dropped
+func lenientUnmarshal(json_text string) (any, error) {
+	var value any
+	var err error
+	for i := 0; i < _MAX_TRUNCATION_REPAIRS; i++ {
+		if err = json.Unmarshal([]byte(repairJson(json_text)), &value); err == nil {
+			return value, nil
+		}
+		// drop the last incomplete item and try again
+		last_comma := lastIndexOutsideString(json_text, ',')
+		if last_comma < 0 {
+			break
+		}
+		json_text = json_text[:last_comma]
+	}
+	return nil, err
+}
+
+func repairJson(text string) string {
+	text = strings.TrimSpace(text)
+	output := make([]byte, 0, len(text)+8)
+	closers := make([]byte, 0, 8)
+	in_string, escaped := false, false
+	var quote byte
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if in_string {
+			switch {
+			case escaped:
+				escaped = false
+				if c == '\'' {
+					// \' is not a valid json escape
+					output[len(output)-1] = c
+				} else {
+					output = append(output, c)
+				}
+			case c == '\\':
+				escaped = true
+				output = append(output, c)
+			case c == quote:
+				in_string = false
+				output = append(output, '"')
+			case c == '"':
+				// double quote inside a single quoted string
+				output = append(output, '\\', c)
+			default:
+				output = append(output, c)
+			}
+			continue
+		}
+
+		switch c {
+		case '"', '\'':
+			in_string, quote = true, c
+			output = append(output, '"')
+		case '{':
+			closers = append(closers, '}')
+			output = append(output, c)
+		case '[':
+			closers = append(closers, ']')
+			output = append(output, c)
+		case '}', ']':
+			output = trimTrailingComma(output)
+			if len(closers) > 0 {
+				closers = closers[:len(closers)-1]
+			}
+			output = append(output, c)
+		default:
+			output = append(output, c)
+		}
+	}
+
+	// close out whatever got truncated
+	if in_string {
+		if escaped {
+			output = output[:len(output)-1]
+		}
+		output = append(output, '"')
+	}
+	output = trimTrailingComma(output)
+	for i := len(closers) - 1; i >= 0; i-- {
+		output = append(output, closers[i])
+	}
+	return string(output)
+}
+
+func trimTrailingComma(output []byte) []byte {
+	trimmed := strings.TrimRight(string(output), " \t\r\n")
+	if strings.HasSuffix(trimmed, ",") {
+		return []byte(trimmed[:len(trimmed)-1])
+	}
+	return output
+}
+
+func lastIndexOutsideString(text string, target byte) int {
+	index := -1
+	in_string, escaped := false, false
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case in_string && escaped:
+			escaped = false
+		case in_string && c == '\\':
+			escaped = true
+		case in_string && c == quote:
+			in_string = false
+		case in_string:
+		case c == '"' || c == '\'':
+			in_string, quote = true, c
+		case c == target:
+			index = i
+		}
+	}
+	return index
+}
+
+// validates a decoded json value against the schema and returns the list of violations.
+// this covers the subset of json schema that jsonschema.Reflect generates for the output types
+func validateJson(value any, schema *jsonschema.Schema, definitions jsonschema.Definitions, path string) []string {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" {
+		return validateJson(value, definitions[strings.TrimPrefix(schema.Ref, "#/$defs/")], definitions, path)
+	}
+
+	var errs []string
+	switch schema.Type {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return []string{typeError(path, "object")}
+		}
+		for _, field := range schema.Required {
+			if _, ok := obj[field]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required field", joinPath(path, field)))
+			}
+		}
+		if schema.Properties != nil {
+			for pair := schema.Properties.Oldest(); pair != nil; pair = pair.Next() {
+				if field_val, ok := obj[pair.Key]; ok {
+					errs = append(errs, validateJson(field_val, pair.Value, definitions, joinPath(path, pair.Key))...)
+				}
+			}
+		}
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			return []string{typeError(path, "array")}
+		}
+		if schema.MinItems != nil && uint64(len(arr)) < *schema.MinItems {
+			errs = append(errs, fmt.Sprintf("%s: must have at least %d items", path, *schema.MinItems))
+		}
+		for i := range arr {
+			errs = append(errs, validateJson(arr[i], schema.Items, definitions, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return []string{typeError(path, "string")}
+		}
+		if schema.MinLength != nil && uint64(len(strings.TrimSpace(str))) < *schema.MinLength {
+			errs = append(errs, fmt.Sprintf("%s: must not be empty", path))
+		}
+		if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, any(str)) {
+			errs = append(errs, fmt.Sprintf("%s: must be one of %v", path, schema.Enum))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return []string{typeError(path, "number")}
+		}
+	case "integer":
+		if num, ok := value.(float64); !ok || num != math.Trunc(num) {
+			return []string{typeError(path, "integer")}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{typeError(path, "boolean")}
+		}
+	}
+	return errs
+}
+
+func typeError(path, expected string) string {
+	if path == "" {
+		path = "output"
+	}
+	return fmt.Sprintf("%s: must be of type %s", path, expected)
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
@@ -0,0 +1,134 @@
+package nlp
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/invopop/jsonschema"
+)
+
+func TestExtractJsonText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain object", `{"a": 1}`, `{"a": 1}`},
+		{"fenced", "here you go\n```json\n{\"a\": 1}\n```\nanything else?", "\n{\"a\": 1}\n"},
+		{"fenced and truncated", "```json\n{\"a\": 1", "\n{\"a\": 1"},
+		{"chatter before object", `Sure! {"a": 1}`, `{"a": 1}`},
+		{"chatter before array", `Sure! [1, 2]`, `[1, 2]`},
+		{"no json", `nothing here`, `nothing here`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := extractJsonText(test.text); got != test.want {
+				t.Errorf("extractJsonText(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRepairJson(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"valid", `{"a": [1, 2]}`, `{"a": [1, 2]}`},
+		{"trailing comma in object", `{"a": 1,}`, `{"a": 1}`},
+		{"trailing comma in array", `[1, 2, ]`, `[1, 2]`},
+		{"single quotes", `{'a': 'b'}`, `{"a": "b"}`},
+		{"escaped single quote", `{'a': 'it\'s'}`, `{"a": "it's"}`},
+		{"double quote in single quoted string", `{'a': 'say "hi"'}`, `{"a": "say \"hi\""}`},
+		{"brackets inside strings", `{"a": "[}{"}`, `{"a": "[}{"}`},
+		{"truncated object", `{"a": [1, 2`, `{"a": [1, 2]}`},
+		{"truncated string", `{"a": "hel`, `{"a": "hel"}`},
+		{"truncated after escape", `{"a": "hel\`, `{"a": "hel"}`},
+		{"truncated after comma", `[{"a": 1},`, `[{"a": 1}]`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := repairJson(test.text)
+			if got != test.want {
+				t.Errorf("repairJson(%q) = %q, want %q", test.text, got, test.want)
+			}
+			var value any
+			if err := json.Unmarshal([]byte(got), &value); err != nil {
+				t.Errorf("repairJson(%q) is not valid json. %v", test.text, err)
+			}
+		})
+	}
+}
+
+func TestLenientUnmarshal(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		want     any
+		want_err bool
+	}{
+		{"repairable", `{'a': 1,}`, map[string]any{"a": float64(1)}, false},
+		// the item cut off in the middle of a key gets dropped
+		{"truncated item", `[{"a": 1}, {"a": 2}, {"a`, []any{map[string]any{"a": float64(1)}, map[string]any{"a": float64(2)}}, false},
+		{"garbage", `not json at all`, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := lenientUnmarshal(test.text)
+			if (err != nil) != test.want_err {
+				t.Fatalf("lenientUnmarshal(%q) error = %v, want error %v", test.text, err, test.want_err)
+			}
+			if !test.want_err && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("lenientUnmarshal(%q) = %v, want %v", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateJson(t *testing.T) {
+	schemas := map[string]*jsonschema.Schema{
+		"digest":    NewJsonOutputParser(Digest{}).data_schema,
+		"concepts":  NewJsonOutputParser(keyConceptList{}).data_schema,
+		"sentiment": NewJsonOutputParser(Sentiment{}).data_schema,
+	}
+	tests := []struct {
+		name   string
+		schema string
+		text   string
+		errors []string // substrings of the expected violations in order. empty means valid
+	}{
+		{"valid digest", "digest", `{"summary": "s", "topic": "t"}`, nil},
+		{"missing required field", "digest", `{"summary": "s"}`, []string{"topic: missing required field"}},
+		{"blank required string", "digest", `{"summary": "  ", "topic": "t"}`, []string{"summary: must not be empty"}},
+		{"wrong root type", "digest", `["s", "t"]`, []string{"output: must be of type object"}},
+		{"wrong field type", "digest", `{"summary": 1, "topic": "t"}`, []string{"summary: must be of type string"}},
+		{"valid concepts", "concepts", `{"concepts": [{"doc_index": 0, "keyphrase": "k", "event": "e", "description": "d"}]}`, nil},
+		{"fractional integer", "concepts", `{"concepts": [{"doc_index": 0.5, "keyphrase": "k", "event": "e", "description": "d"}]}`, []string{"concepts[0].doc_index: must be of type integer"}},
+		{"empty item string", "concepts", `{"concepts": [{"doc_index": 0, "keyphrase": "", "event": "e", "description": "d"}]}`, []string{"concepts[0].keyphrase: must not be empty"}},
+		{"not an array", "concepts", `{"concepts": {"doc_index": 0}}`, []string{"concepts: must be of type array"}},
+		{"valid sentiment", "sentiment", `{"sentiment": "positive", "score": 0.5}`, nil},
+		{"not in enum", "sentiment", `{"sentiment": "happy", "score": 0.5}`, []string{"sentiment: must be one of"}},
+		{"number as string", "sentiment", `{"sentiment": "neutral", "score": "0"}`, []string{"score: must be of type number"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var value any
+			if err := json.Unmarshal([]byte(test.text), &value); err != nil {
+				t.Fatal(err)
+			}
+			schema := schemas[test.schema]
+			errs := validateJson(value, schema, schema.Definitions, "")
+			if len(errs) != len(test.errors) {
+				t.Fatalf("validateJson(%s) = %v, want %v", test.text, errs, test.errors)
+			}
+			for i := range errs {
+				if !strings.Contains(errs[i], test.errors[i]) {
+					t.Errorf("validateJson(%s)[%d] = %q, want it to contain %q", test.text, i, errs[i], test.errors[i])
+				}
+			}
+		})
+	}
+}
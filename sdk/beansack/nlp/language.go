@@ -0,0 +1,108 @@
+package nlp
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	ENGLISH = "en"
+
+	_MIN_STOPWORD_HITS  = 3    // below this the latin text is too short or too odd to call
+	_MIN_SCRIPT_RATIO   = 0.3  // share of letters in a non-latin script for the text to be in that script
+	_MAX_DETECTION_SIZE = 4096 // the beginning of the text is enough to tell the language
+)
+
+// the most frequent words of the latin script languages. These rarely show up in other languages
+var _STOPWORDS = map[string]map[string]struct{}{
+	ENGLISH: toWordSet("the and of to is in that it for was on are with as this by be have from they at but not or which has"),
+	"es":    toWordSet("el la los las del que y en un una por con para es se su al lo como pero más sus le ya fue este"),
+	"fr":    toWordSet("le la les des du et est un une que qui dans pour pas sur au avec ce il elle sont par plus ses cette"),
+	"de":    toWordSet("der die das und ist nicht ein eine zu den von mit sich des auf für im dem auch es an werden aus er"),
+	"it":    toWordSet("il la di che e è un una per non gli del della sono con si le da come anche nel alla questo"),
+	"pt":    toWordSet("o a os as de do da que e em um uma para com não por se mais na no dos das ao é foi"),
+	"nl":    toWordSet("de het een en van is dat op te in zijn met voor niet aan er om ook als dan maar bij"),
+}
+
+// non-latin scripts that are mostly used by one language
+var _SCRIPTS = []struct {
+	language string
+	table    *unicode.RangeTable
+}{
+	{"ja", unicode.Hiragana}, // japanese uses han as well so it needs to be checked first
+	{"ja", unicode.Katakana},
+	{"ko", unicode.Hangul},
+	{"zh", unicode.Han},
+	{"ru", unicode.Cyrillic},
+	{"ar", unicode.Arabic},
+	{"hi", unicode.Devanagari},
+	{"el", unicode.Greek},
+	{"he", unicode.Hebrew},
+	{"th", unicode.Thai},
+}
+
+// Detects the language of the text locally and returns its ISO 639-1 code.
+// Non-latin scripts are detected by the script itself and latin ones by stopword frequency.
+// Returns empty string when the language cannot be determined
+func DetectLanguage(text string) string {
+	text = strings.ToLower(safeSlice(text, _MAX_DETECTION_SIZE))
+
+	letters := 0
+	script_counts := make(map[string]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, script := range _SCRIPTS {
+			if unicode.Is(script.table, r) {
+				script_counts[script.language]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// kana anywhere means japanese even though most of the letters are han
+	if script_counts["ja"] > 0 && float64(script_counts["ja"]+script_counts["zh"])/float64(letters) >= _MIN_SCRIPT_RATIO {
+		return "ja"
+	}
+	for _, script := range _SCRIPTS {
+		if float64(script_counts[script.language])/float64(letters) >= _MIN_SCRIPT_RATIO {
+			return script.language
+		}
+	}
+
+	hits := make(map[string]int)
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsLetter(r) }) {
+		for language, stopwords := range _STOPWORDS {
+			if _, ok := stopwords[word]; ok {
+				hits[language]++
+			}
+		}
+	}
+	language, max_hits := "", 0
+	for lang, count := range hits {
+		// ties go to english since it is the most common in the feeds
+		if count > max_hits || (count == max_hits && lang == ENGLISH) {
+			language, max_hits = lang, count
+		}
+	}
+	if max_hits < _MIN_STOPWORD_HITS {
+		return ""
+	}
+	return language
+}
+
+// slices on a rune boundary
+func safeSlice(text string, size int) string {
+	if len(text) <= size {
+		return text
+	}
+	for size > 0 && !utf8.RuneStart(text[size]) {
+		size--
+	}
+	return text[:size]
+}
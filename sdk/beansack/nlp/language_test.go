@@ -0,0 +1,53 @@
+package nlp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"english", "The company said that it was going to release the update for the app on Monday and that it has fixed the bug.", ENGLISH},
+		{"spanish", "El gobierno anunció que la nueva ley para los trabajadores entrará en vigor en enero, pero los sindicatos se oponen.", "es"},
+		{"french", "Le gouvernement a annoncé que la nouvelle loi pour les travailleurs entrera en vigueur dans un mois, mais il est contesté.", "fr"},
+		{"german", "Die Regierung hat angekündigt, dass das neue Gesetz für die Arbeitnehmer im Januar in Kraft tritt und es ist nicht unumstritten.", "de"},
+		{"japanese has han and kana", "東京の株式市場は今日大きく値上がりしました。", "ja"},
+		{"chinese", "今天东京股市大幅上涨，投资者信心增强。", "zh"},
+		{"korean", "오늘 서울 증시가 크게 올랐습니다.", "ko"},
+		{"russian", "Сегодня фондовый рынок значительно вырос.", "ru"},
+		{"script wins over latin words", "Сегодня фондовый рынок значительно вырос. The end", "ru"},
+		{"empty", "", ""},
+		{"no letters", "12345 !!! 67890", ""},
+		{"too few stopwords", "Kubernetes operator rollout", ""},
+		{"ties go to english", "the of and la el del", ENGLISH},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DetectLanguage(test.text); got != test.want {
+				t.Errorf("DetectLanguage(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestDetectLanguageOnlyReadsTheBeginning(t *testing.T) {
+	// the english words past the detection size don't count
+	text := strings.Repeat("le la les des du et est ", _MAX_DETECTION_SIZE/24+1) + strings.Repeat("the and of to is ", 1000)
+	if got := DetectLanguage(text); got != "fr" {
+		t.Errorf("DetectLanguage() = %q, want fr", got)
+	}
+}
+
+func TestSafeSlice(t *testing.T) {
+	text := "aé" // é is 2 bytes
+	if got := safeSlice(text, 2); got != "a" {
+		t.Errorf("safeSlice(%q, 2) = %q, want a", text, got)
+	}
+	if got := safeSlice(text, 10); got != text {
+		t.Errorf("safeSlice(%q, 10) = %q, want %q", text, got, text)
+	}
+}
@@ -0,0 +1,95 @@
+package nlp
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+// sentiment labels
+const (
+	POSITIVE = "positive"
+	NEGATIVE = "negative"
+	NEUTRAL  = "neutral"
+)
+
+// scores within the threshold either side of 0 are labeled NEUTRAL
+const NEUTRAL_THRESHOLD = 0.05
+
+const (
+	_NORMALIZE_ALPHA    = 15 // same normalization as VADER. keeps the score within -1 to 1
+	_NEGATION_LOOKBACK  = 3  // number of words before a sentiment word that can negate it
+	_LEXICON_SCORER_TAG = "lexicon"
+)
+
+var (
+	_WORD_EXPR = regexp.MustCompile(`[a-z']+`)
+
+	_POSITIVE_WORDS = toWordSet("achieve achievement advance amazing approve awesome benefit best better boost breakthrough celebrate " +
+		"confident cool easy effective efficient enjoy excellent excited exciting fantastic favorable fix fixed gain good great " +
+		"grow growth happy helpful hope impressive improve improved improvement innovative launch love lucky optimistic outperform " +
+		"perfect pleased popular positive praise profit progress promising protect rally record recover recovery reliable relief " +
+		"resolve resolved rise robust safe save secure smooth solid strong succeed success successful support surge thrive " +
+		"upbeat upgrade win wins winning wonderful")
+	_NEGATIVE_WORDS = toWordSet("abuse alarm alarming angry attack bad ban bankrupt breach broke broken bug crash crisis critical " +
+		"damage danger dangerous dead death decline delay deny difficult disappoint disaster disrupt down drop exploit fail " +
+		"failed failure fake fall fear fine fraud hack hacked harm hate hurt illegal injury kill lawsuit layoff layoffs leak " +
+		"lose loss losses malware outage panic poor problem protest ransomware recall risk risky scam scandal slump steal " +
+		"stolen struggle sue threat threaten trouble unsafe violation vulnerability vulnerable war warn warning weak worse worst")
+	_NEGATIONS = toWordSet("not no never none nobody nothing neither nor without cannot can't don't doesn't didn't isn't wasn't aren't won't")
+)
+
+// LexiconSentimentScorer is a local rule based scorer. It counts positive and negative words and flips the ones that are negated.
+// It is used on its own to avoid LLM calls
+type LexiconSentimentScorer struct{}
+
+func (LexiconSentimentScorer) ScoreSentiments(texts []string) []Sentiment {
+	output := make([]Sentiment, len(texts))
+	for i := range texts {
+		output[i] = scoreWithLexicon(texts[i])
+	}
+	return output
+}
+
+func scoreWithLexicon(text string) Sentiment {
+	words := _WORD_EXPR.FindAllString(strings.ToLower(text), -1)
+	total := 0.0
+	for i, word := range words {
+		var polarity float64
+		if _, ok := _POSITIVE_WORDS[word]; ok {
+			polarity = 1
+		} else if _, ok := _NEGATIVE_WORDS[word]; ok {
+			polarity = -1
+		} else {
+			continue
+		}
+		for j := max(0, i-_NEGATION_LOOKBACK); j < i; j++ {
+			if _, ok := _NEGATIONS[words[j]]; ok {
+				polarity = -polarity
+				break
+			}
+		}
+		total += polarity
+	}
+	score := total / math.Sqrt(total*total+_NORMALIZE_ALPHA)
+	return Sentiment{Label: toSentimentLabel(score), Score: score, PromptVersion: _LEXICON_SCORER_TAG}
+}
+
+func toSentimentLabel(score float64) string {
+	switch {
+	case score >= NEUTRAL_THRESHOLD:
+		return POSITIVE
+	case score <= -NEUTRAL_THRESHOLD:
+		return NEGATIVE
+	default:
+		return NEUTRAL
+	}
+}
+
+func toWordSet(words string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, word := range strings.Fields(words) {
+		set[word] = struct{}{}
+	}
+	return set
+}
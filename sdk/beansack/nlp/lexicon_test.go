@@ -0,0 +1,77 @@
+package nlp
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestScoreWithLexicon(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		label string
+		score float64
+	}{
+		{"empty", "", NEUTRAL, 0},
+		{"no sentiment words", "The company released the quarterly report on Monday.", NEUTRAL, 0},
+		{"positive", "The launch was a success.", POSITIVE, 2 / math.Sqrt(4+_NORMALIZE_ALPHA)},
+		{"negative", "Ransomware attack on the hospital", NEGATIVE, -2 / math.Sqrt(4+_NORMALIZE_ALPHA)},
+		{"mixed cancel out", "A good quarter after a bad one", NEUTRAL, 0},
+		{"case and punctuation", "GREAT!!!", POSITIVE, 1 / math.Sqrt(1+_NORMALIZE_ALPHA)},
+		{"negated", "The update is not good", NEGATIVE, -1 / math.Sqrt(1+_NORMALIZE_ALPHA)},
+		{"negated negative", "They didn't fail", POSITIVE, 1 / math.Sqrt(1+_NORMALIZE_ALPHA)},
+		{"negation within the lookback", "no one was hurt", POSITIVE, 1 / math.Sqrt(1+_NORMALIZE_ALPHA)},
+		{"negation past the lookback", "no one was really hurt", NEGATIVE, -1 / math.Sqrt(1+_NORMALIZE_ALPHA)},
+		{"two negations flip once", "not never good", NEGATIVE, -1 / math.Sqrt(1+_NORMALIZE_ALPHA)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := scoreWithLexicon(test.text)
+			if got.Label != test.label || math.Abs(got.Score-test.score) > 1e-9 {
+				t.Errorf("scoreWithLexicon(%q) = %s %f, want %s %f", test.text, got.Label, got.Score, test.label, test.score)
+			}
+			if got.PromptVersion != _LEXICON_SCORER_TAG {
+				t.Errorf("scoreWithLexicon(%q) version = %q, want %q", test.text, got.PromptVersion, _LEXICON_SCORER_TAG)
+			}
+		})
+	}
+}
+
+func TestScoreWithLexiconBounds(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"very positive", strings.Repeat("great success ", 1000)},
+		{"very negative", strings.Repeat("terrible disaster ", 1000)},
+		{"very negated", strings.Repeat("not good ", 1000)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := scoreWithLexicon(test.text); got.Score <= -1 || got.Score >= 1 || got.Label == NEUTRAL {
+				t.Errorf("scoreWithLexicon() = %s %f, want a strong score within -1 and 1", got.Label, got.Score)
+			}
+		})
+	}
+}
+
+func TestToSentimentLabel(t *testing.T) {
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{0, NEUTRAL},
+		{NEUTRAL_THRESHOLD, POSITIVE},
+		{NEUTRAL_THRESHOLD - 0.01, NEUTRAL},
+		{-NEUTRAL_THRESHOLD, NEGATIVE},
+		{-NEUTRAL_THRESHOLD + 0.01, NEUTRAL},
+		{1, POSITIVE},
+		{-1, NEGATIVE},
+	}
+	for _, test := range tests {
+		if got := toSentimentLabel(test.score); got != test.want {
+			t.Errorf("toSentimentLabel(%f) = %q, want %q", test.score, got, test.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package nlp
 import (
 	"fmt"
 	"log"
+	"time"
 
 	datautils "github.com/soumitsalman/data-utils"
 )
@@ -45,8 +46,12 @@ func NewLlamaFileDriver(base_url string, ctx int) *EmbeddingsDriver {
 }
 
 func (driver *EmbeddingsDriver) CreateBatchTextEmbeddings(texts []string, task_type string) [][]float32 {
+	// a single text that is too long cannot be split in half
+	if len(texts) == 1 {
+		texts = []string{TruncateTextOnTokenCount(texts[0], driver.Ctx)}
+	}
 	// if the count is over the window size split in half and try
-	if CountTokens(texts) > driver.Ctx {
+	if len(texts) > 1 && CountTokens(texts) > driver.Ctx {
 		return append(
 			driver.CreateBatchTextEmbeddings(texts[:len(texts)/2], task_type),
 			driver.CreateBatchTextEmbeddings(texts[len(texts)/2:], task_type)...)
@@ -61,6 +66,7 @@ func (driver *EmbeddingsDriver) CreateBatchTextEmbeddings(texts []string, task_t
 }
 
 func (driver *EmbeddingsDriver) CreateTextEmbeddings(text string, task_type string) []float32 {
+	text = TruncateTextOnTokenCount(text, driver.Ctx)
 	output := driver.createEmbeddings(&EmbeddingsRequest{[]string{driver.toEmbeddingInput(text, task_type)}})
 	if len(output) >= 1 {
 		return output[0]
@@ -68,6 +74,15 @@ func (driver *EmbeddingsDriver) CreateTextEmbeddings(text string, task_type stri
 	return nil
 }
 
+func (driver *EmbeddingsDriver) ContextWindow() int {
+	return driver.Ctx
+}
+
+// the embedder server hosts one model so the url is what identifies the model
+func (driver *EmbeddingsDriver) ModelName() string {
+	return driver.Url
+}
+
 func (driver *EmbeddingsDriver) toEmbeddingInput(text, task_type string) string {
 	if len(task_type) > 0 {
 		text = fmt.Sprintf("%s: %s", task_type, text)
@@ -76,9 +91,12 @@ func (driver *EmbeddingsDriver) toEmbeddingInput(text, task_type string) string
 }
 
 func (driver *EmbeddingsDriver) createEmbeddings(input *EmbeddingsRequest) [][]float32 {
-	return retryT(
+	return retryT(driver.ModelName(),
 		func() ([][]float32, error) {
-			if embs, err := postHTTPRequest[EmbeddingResponse](driver.Url, "", input); err != nil {
+			start_time := time.Now()
+			embs, err := postHTTPRequest[EmbeddingResponse](driver.Url, "", input)
+			recordCall(driver.ModelName(), time.Since(start_time), err)
+			if err != nil {
 				log.Printf("[EmbeddingsDriver] Embedding generation failed. %v\n", err)
 				return nil, err // return a dud
 			} else if len(embs.Results) != len(input.Inputs) {
@@ -86,6 +104,8 @@ func (driver *EmbeddingsDriver) createEmbeddings(input *EmbeddingsRequest) [][]f
 				log.Println(err_msg)
 				return nil, EmbeddingServerError(err_msg) // return a dud
 			} else {
+				// the server doesn't report token counts so count them locally
+				recordTokens(driver.ModelName(), CountTokens(input.Inputs), 0)
 				return datautils.Transform(embs.Results, func(item *embedddingResult) []float32 { return item.Embedding }), nil
 			}
 		})
@@ -0,0 +1,367 @@
+package nlp
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	_LOCAL_MODEL            = "local"
+	_LOCAL_SUMMARY_SIZE     = 3 // number of sentences in an extractive summary
+	_LOCAL_KEYCONCEPT_TOPN  = 5
+	_LOCAL_MAX_PHRASE_WORDS = 4
+	_LOCAL_MAX_SENTENCES    = 200 // textrank is quadratic on the number of sentences
+	_TEXTRANK_DAMPING       = 0.85
+	_TEXTRANK_ITERATIONS    = 30
+	_LOCAL_ANSWER_SIZE      = 3 // number of sentences in an extractive answer
+)
+
+var (
+	_SENTENCE_EXPR = regexp.MustCompile(`[^.!?\n]+[.!?]*`)
+	_FIELD_LABEL   = regexp.MustCompile(`^[A-Z]+:\s*`) // such as TITLE: or SUMMARY: in front of the document fields
+	_PHRASE_WORD   = regexp.MustCompile(`[\p{L}\p{N}][\p{L}\p{N}'&.-]*`)
+	// english stopwords for the local extractors on top of the ones used for language detection
+	_LOCAL_STOPWORDS = toWordSet("a an i me my we our you your he him his she her its them their what who whom these those am were been " +
+		"being do does did doing would should could can will just than too very so if then there here when where why how all any both " +
+		"each few more most other some such only own same into through during before after above below up out off over under again " +
+		"further once about against between also said says say new one two like get got make made may might must us via per")
+)
+
+// LocalExtractor is a local deterministic stand-in for the LLM backed extractor for offline runs and tests.
+//   - digests are extractive: the top sentences by TextRank in their original order. Short texts use the lead sentences
+//   - keyconcepts are the top noun phrases by RAKE score, i.e. runs of words between stopwords and punctuation
+//   - sentiments come from the LexiconSentimentScorer
+//   - entities are the capitalized phrases and the CVE IDs
+//   - translation is not possible offline so the texts come back as they are. This way the beans waiting on a translation still get processed
+//   - answers are extractive: the sentences of the documents that share the most words with the question
+type LocalExtractor struct{}
+
+func NewLocalExtractor() *LocalExtractor {
+	return &LocalExtractor{}
+}
+
+func (extractor *LocalExtractor) ExtractDigests(texts []string) []Digest {
+	output := make([]Digest, len(texts))
+	for i := range texts {
+		sentences := splitSentences(texts[i])
+		if len(sentences) == 0 {
+			continue
+		}
+		output[i] = Digest{
+			Summary:       strings.Join(rankSentences(sentences, _LOCAL_SUMMARY_SIZE), " "),
+			PromptVersion: _LOCAL_MODEL,
+		}
+		if phrases := extractPhrases(sentences, 1); len(phrases) > 0 {
+			output[i].Topic = phrases[0].text
+		}
+	}
+	return output
+}
+
+func (extractor *LocalExtractor) ExtractKeyConcepts(texts []string) []KeyConcept {
+	output := make([]KeyConcept, 0, len(texts)*_LOCAL_KEYCONCEPT_TOPN)
+	for i := range texts {
+		sentences := splitSentences(texts[i])
+		for _, phrase := range extractPhrases(sentences, _LOCAL_KEYCONCEPT_TOPN) {
+			output = append(output, KeyConcept{
+				DocIndex:      i,
+				KeyPhrase:     phrase.text,
+				Description:   sentences[phrase.sentence],
+				PromptVersion: _LOCAL_MODEL,
+			})
+		}
+	}
+	return output
+}
+
+func (extractor *LocalExtractor) ScoreSentiments(texts []string) []Sentiment {
+	return LexiconSentimentScorer{}.ScoreSentiments(texts)
+}
+
+func (extractor *LocalExtractor) ExtractEntities(texts []string) []Entity {
+	output := make([]Entity, 0, len(texts))
+	for i := range texts {
+		for _, name := range ExtractNames(texts[i]) {
+			entity_type := guessEntityType(name)
+			if _CVE_EXPR.MatchString(name) {
+				entity_type = CVE_ENTITY
+			}
+			output = append(output, Entity{DocIndex: i, Name: name, Type: entity_type, PromptVersion: _LOCAL_MODEL})
+		}
+	}
+	return output
+}
+
+// there is no local translation model so the texts pass through untranslated
+func (extractor *LocalExtractor) TranslateToEnglish(texts []string) []string {
+	return append([]string{}, texts...)
+}
+
+func (extractor *LocalExtractor) Answer(question string, documents []string, stream func(chunk string)) string {
+	type match struct {
+		sentence string
+		doc      int
+		overlap  int
+	}
+	question_words := tokenizeWords(question)
+	var matches []match
+	for i := range documents {
+		for _, sentence := range splitSentences(documents[i]) {
+			sentence = _FIELD_LABEL.ReplaceAllString(sentence, "")
+			overlap := 0
+			for _, word := range tokenizeWords(sentence) {
+				if slices.Contains(question_words, word) {
+					overlap++
+				}
+			}
+			if overlap > 0 {
+				matches = append(matches, match{sentence, i, overlap})
+			}
+		}
+	}
+	// ties go to the earlier documents since they are the better search matches
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].overlap > matches[j].overlap })
+	if len(matches) > _LOCAL_ANSWER_SIZE {
+		matches = matches[:_LOCAL_ANSWER_SIZE]
+	}
+	answer := make([]string, len(matches))
+	for i := range matches {
+		answer[i] = fmt.Sprintf("%s [%d]", matches[i].sentence, matches[i].doc+1)
+	}
+	output := strings.Join(answer, " ")
+	if stream != nil && output != "" {
+		stream(output)
+	}
+	return output
+}
+
+func (extractor *LocalExtractor) ModelName() string {
+	return _LOCAL_MODEL
+}
+
+func (extractor *LocalExtractor) PromptVersion(task string) string {
+	// the lexicon scorer stamps its own tag on the sentiments
+	if task == SENTIMENT_TASK {
+		return _LEXICON_SCORER_TAG
+	}
+	return _LOCAL_MODEL
+}
+
+func isStopword(word string) bool {
+	_, ok := _STOPWORDS[ENGLISH][word]
+	if !ok {
+		_, ok = _LOCAL_STOPWORDS[word]
+	}
+	return ok
+}
+
+func splitSentences(text string) []string {
+	sentences := make([]string, 0)
+	for _, match := range _SENTENCE_EXPR.FindAllString(text, -1) {
+		if match = strings.TrimSpace(match); len(tokenizeWords(match)) > 0 {
+			sentences = append(sentences, match)
+		}
+		if len(sentences) >= _LOCAL_MAX_SENTENCES {
+			break
+		}
+	}
+	return sentences
+}
+
+// TextRank: sentences are nodes, edges are weighted by the word overlap normalized by the sentence lengths.
+// Returns the topn sentences by rank in the order they appear in the text
+func rankSentences(sentences []string, topn int) []string {
+	if len(sentences) <= topn {
+		return sentences
+	}
+	words := make([][]string, len(sentences))
+	for i := range sentences {
+		words[i] = tokenizeWords(sentences[i])
+	}
+	weights := make([][]float64, len(sentences))
+	totals := make([]float64, len(sentences))
+	for i := range sentences {
+		weights[i] = make([]float64, len(sentences))
+		for j := range sentences {
+			if i != j && len(words[i]) > 1 && len(words[j]) > 1 {
+				overlap := 0
+				for _, word := range words[i] {
+					if slices.Contains(words[j], word) {
+						overlap++
+					}
+				}
+				weights[i][j] = float64(overlap) / (math.Log(float64(len(words[i]))) + math.Log(float64(len(words[j]))))
+				totals[i] += weights[i][j]
+			}
+		}
+	}
+
+	ranks := make([]float64, len(sentences))
+	for i := range ranks {
+		ranks[i] = 1
+	}
+	for iter := 0; iter < _TEXTRANK_ITERATIONS; iter++ {
+		next := make([]float64, len(sentences))
+		for i := range sentences {
+			sum := 0.0
+			for j := range sentences {
+				if totals[j] > 0 {
+					sum += weights[j][i] / totals[j] * ranks[j]
+				}
+			}
+			next[i] = (1 - _TEXTRANK_DAMPING) + _TEXTRANK_DAMPING*sum
+		}
+		ranks = next
+	}
+
+	indexes := make([]int, len(sentences))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	// ties go to the earlier sentence. With no overlap at all this falls back to the lead sentences
+	sort.SliceStable(indexes, func(a, b int) bool { return ranks[indexes[a]] > ranks[indexes[b]] })
+	indexes = indexes[:topn]
+	sort.Ints(indexes)
+	output := make([]string, len(indexes))
+	for i, index := range indexes {
+		output[i] = sentences[index]
+	}
+	return output
+}
+
+type phrase struct {
+	text     string
+	sentence int // index of the first sentence it shows up in
+	score    float64
+}
+
+// RAKE: candidate phrases are the runs of words between stopwords, punctuation and changes of capitalization.
+// Each word scores its degree over its frequency and a phrase scores the sum of its words.
+// Capitalized phrases are more likely to be names so they get a boost
+func extractPhrases(sentences []string, topn int) []phrase {
+	candidates := make([][]string, 0)
+	positions := make([]int, 0)
+	for i, sentence := range sentences {
+		for _, fragment := range strings.FieldsFunc(sentence, func(r rune) bool { return strings.ContainsRune(",;:()[]\"“”", r) }) {
+			var run []string
+			flush := func() {
+				if len(run) > 0 && len(run) <= _LOCAL_MAX_PHRASE_WORDS {
+					candidates = append(candidates, run)
+					positions = append(positions, i)
+				}
+				run = nil
+			}
+			for _, word := range _PHRASE_WORD.FindAllString(fragment, -1) {
+				word = strings.TrimRight(word, ".'-")
+				if isStopword(strings.ToLower(word)) || isNumber(word) {
+					flush()
+					continue
+				}
+				// names and common noun phrases don't mix
+				if len(run) > 0 && isCapitalized(run[len(run)-1]) != isCapitalized(word) {
+					flush()
+				}
+				run = append(run, word)
+			}
+			flush()
+		}
+	}
+
+	freq := make(map[string]float64)
+	degree := make(map[string]float64)
+	for _, candidate := range candidates {
+		for _, word := range candidate {
+			word = strings.ToLower(word)
+			freq[word]++
+			degree[word] += float64(len(candidate))
+		}
+	}
+
+	phrases := make(map[string]*phrase)
+	for i, candidate := range candidates {
+		key := strings.ToLower(strings.Join(candidate, " "))
+		if existing, ok := phrases[key]; ok {
+			existing.score += 1 // repeated phrases are more central
+			continue
+		}
+		score := 0.0
+		for _, word := range candidate {
+			score += degree[strings.ToLower(word)] / freq[strings.ToLower(word)]
+		}
+		if isCapitalized(candidate[0]) && !(len(candidate) == 1 && strings.HasPrefix(sentences[positions[i]], candidate[0])) {
+			score *= 2
+		}
+		phrases[key] = &phrase{text: strings.Join(candidate, " "), sentence: positions[i], score: score}
+	}
+
+	output := make([]phrase, 0, len(phrases))
+	for _, item := range phrases {
+		// single letters and such are noise
+		if len([]rune(item.text)) > 2 {
+			output = append(output, *item)
+		}
+	}
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].score == output[j].score {
+			return output[i].text < output[j].text
+		}
+		return output[i].score > output[j].score
+	})
+	if len(output) > topn {
+		output = output[:topn]
+	}
+	return output
+}
+
+// runs of capitalized words. A single capitalized word at the start of a sentence is just a sentence start
+func capitalizedPhrases(sentence string) []string {
+	var output, run []string
+	start := true
+	flush := func() {
+		if len(run) > 1 || (len(run) == 1 && !start) {
+			output = append(output, strings.Join(run, " "))
+		}
+		run = nil
+	}
+	for i, word := range _PHRASE_WORD.FindAllString(sentence, -1) {
+		word = strings.TrimRight(word, ".'-")
+		if isCapitalized(word) && !(i == 0 && isStopword(strings.ToLower(word))) {
+			if len(run) == 0 {
+				start = i == 0
+			}
+			run = append(run, word)
+		} else {
+			flush()
+		}
+	}
+	flush()
+	return output
+}
+
+// acronyms and names with a legal suffix are orgs. Without a knowledge base the rest are assumed to be products
+func guessEntityType(name string) string {
+	words := strings.Fields(strings.ToLower(name))
+	if len(words) > 1 && slices.Contains(_ORG_SUFFIXES, words[len(words)-1]) {
+		return ORG_ENTITY
+	}
+	if len(name) > 1 && strings.ToUpper(name) == name {
+		return ORG_ENTITY
+	}
+	return PRODUCT_ENTITY
+}
+
+func isCapitalized(word string) bool {
+	for _, r := range word {
+		return unicode.IsUpper(r)
+	}
+	return false
+}
+
+func isNumber(word string) bool {
+	return strings.IndexFunc(word, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' && r != ',' }) < 0
+}
@@ -0,0 +1,106 @@
+package nlp
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const _TEST_ARTICLE = "Fortra has released details of a critical flaw in FileCatalyst Workflow. " +
+	"The flaw, tracked as CVE-2024-25153, lets unauthenticated attackers upload files to the FileCatalyst Workflow web portal. " +
+	"Attackers can use the uploaded files to run code on the server. " +
+	"Fortra fixed the flaw in FileCatalyst Workflow version 5.1.6 last year. " +
+	"The weather was sunny."
+
+func TestLocalExtractorDigests(t *testing.T) {
+	extractor := NewLocalExtractor()
+	digests := extractor.ExtractDigests([]string{_TEST_ARTICLE, "", "Short post about Go generics."})
+	if len(digests) != 3 {
+		t.Fatalf("len(ExtractDigests()) = %d, want 3", len(digests))
+	}
+	// the top sentences in their original order. the off topic one doesn't make it
+	sentences := splitSentences(_TEST_ARTICLE)
+	summary := digests[0].Summary
+	if strings.Contains(summary, "weather") || strings.Count(summary, ". ")+1 != _LOCAL_SUMMARY_SIZE {
+		t.Errorf("ExtractDigests() summary = %q", summary)
+	}
+	last := -1
+	for _, sentence := range sentences {
+		if i := strings.Index(summary, sentence); i >= 0 {
+			if i < last {
+				t.Errorf("ExtractDigests() summary is out of order: %q", summary)
+			}
+			last = i
+		}
+	}
+	if digests[1] != (Digest{}) {
+		t.Errorf("ExtractDigests(empty) = %+v, want a dud", digests[1])
+	}
+	if digests[2].Summary != "Short post about Go generics." || digests[2].PromptVersion != _LOCAL_MODEL {
+		t.Errorf("ExtractDigests(short) = %+v, want the text as the summary", digests[2])
+	}
+	// deterministic
+	if again := extractor.ExtractDigests([]string{_TEST_ARTICLE}); !reflect.DeepEqual(again[0], digests[0]) {
+		t.Errorf("ExtractDigests() = %+v then %+v", digests[0], again[0])
+	}
+}
+
+func TestLocalExtractorKeyConcepts(t *testing.T) {
+	concepts := NewLocalExtractor().ExtractKeyConcepts([]string{"", _TEST_ARTICLE})
+	if len(concepts) == 0 || len(concepts) > _LOCAL_KEYCONCEPT_TOPN {
+		t.Fatalf("len(ExtractKeyConcepts()) = %d, want 1 to %d", len(concepts), _LOCAL_KEYCONCEPT_TOPN)
+	}
+	phrases := make([]string, len(concepts))
+	for i, concept := range concepts {
+		phrases[i] = concept.KeyPhrase
+		// provenance points at the text the concept came from and the description is the sentence it showed up in
+		if concept.DocIndex != 1 || !strings.Contains(_TEST_ARTICLE, concept.Description) || !strings.Contains(concept.Description, concept.KeyPhrase) {
+			t.Errorf("ExtractKeyConcepts() = %+v", concept)
+		}
+	}
+	if !slices.Contains(phrases, "FileCatalyst Workflow") {
+		t.Errorf("ExtractKeyConcepts() phrases = %v, want FileCatalyst Workflow", phrases)
+	}
+}
+
+func TestLocalExtractorEntities(t *testing.T) {
+	entities := NewLocalExtractor().ExtractEntities([]string{_TEST_ARTICLE})
+	types := make(map[string]string)
+	for _, entity := range entities {
+		types[entity.Name] = entity.Type
+	}
+	if types["CVE-2024-25153"] != CVE_ENTITY {
+		t.Errorf("ExtractEntities() = %v, want CVE-2024-25153 as a CVE", types)
+	}
+	if _, ok := types["FileCatalyst Workflow"]; !ok {
+		t.Errorf("ExtractEntities() = %v, want FileCatalyst Workflow", types)
+	}
+	// a capitalized word is only a sentence start
+	if _, ok := types["Attackers"]; ok {
+		t.Errorf("ExtractEntities() = %v, don't want Attackers", types)
+	}
+}
+
+func TestLocalExtractorTranslation(t *testing.T) {
+	texts := []string{"Hola mundo", ""}
+	if got := NewLocalExtractor().TranslateToEnglish(texts); !reflect.DeepEqual(got, texts) {
+		t.Errorf("TranslateToEnglish(%v) = %v, want the texts as they are", texts, got)
+	}
+}
+
+func TestLocalExtractorAnswer(t *testing.T) {
+	documents := []string{"The weather was sunny.", _TEST_ARTICLE}
+	var streamed string
+	answer := NewLocalExtractor().Answer("Which FileCatalyst version fixed the flaw?", documents, func(chunk string) { streamed += chunk })
+	// the best match comes first and the unrelated document is not cited
+	if !strings.HasPrefix(answer, "Fortra fixed the flaw in FileCatalyst Workflow version") || strings.Contains(answer, "[1]") {
+		t.Errorf("Answer() = %q", answer)
+	}
+	if streamed != answer {
+		t.Errorf("streamed %q, answered %q", streamed, answer)
+	}
+	if answer := NewLocalExtractor().Answer("quantum chromodynamics", documents, nil); answer != "" {
+		t.Errorf("Answer(unrelated) = %q, want empty", answer)
+	}
+}
@@ -2,10 +2,14 @@ package nlp
 
 import (
 	ctx "context"
+	"fmt"
 	"log"
 	"strings"
+	"time"
 
+	"github.com/avast/retry-go"
 	datautils "github.com/soumitsalman/data-utils"
+	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/llms/openai"
 )
 
@@ -13,76 +17,228 @@ const (
 	_MODEL        = "llama3-8b-8192"
 	_BASE_URL     = "https://api.groq.com/openai/v1"
 	_MODEL_WINDOW = 6000 // reducing the size to account for instructions and samples
+
+	_MAX_REPAIR_ATTEMPTS = 2
+	_SUMMARY_CHUNK_SIZE  = 5000 // leaving room for the running digest in refine mode
+	_TRANSLATION_SIZE    = 2500 // the translation is about as long as the input so both need to fit in the window
+	_ANSWER_SOURCES_SIZE = 5000 // leaving room for the question and the answer
+)
+
+// summarization modes for texts longer than the LLM window
+const (
+	STUFF_SUMMARIZATION      = "stuff"
+	MAP_REDUCE_SUMMARIZATION = "map_reduce"
+	REFINE_SUMMARIZATION     = "refine"
+)
+
+// sentiment scoring modes
+const (
+	LLM_SENTIMENT     = "llm"
+	LEXICON_SENTIMENT = "lexicon" // local scoring without any LLM call
 )
 
 const (
 	_BATCH_DELIMETER = "\n```\n"
+	_DOCUMENT_HEADER = "DOCUMENT %d:\n"
+	_SOURCE_HEADER   = "SOURCE [%d]:\n"
+	_ANSWER_INPUT    = "SOURCES:\n```\n%s\n```\n\nQUESTION: %s"
 )
 
 type ParrotboxClient struct {
-	concepts_chain *JsonValueExtraction
-	digest_chain   *JsonValueExtraction
+	concepts_chain    *JsonValueExtraction
+	digest_chain      *JsonValueExtraction
+	sentiment_chain   *JsonValueExtraction
+	entities_chain    *JsonValueExtraction
+	translation_chain *JsonValueExtraction
+	// answers are free text so they can't go through the json chains
+	chat_llm *openai.LLM
+	prompts  map[string]*PromptTemplate
+
+	summarization_mode string
+	sentiment_mode     string
 }
 
-func NewParrotboxClient(api_key string) *ParrotboxClient {
+// prompts is keyed by task. Missing tasks or nil prompts use DefaultPromptTemplates
+func NewParrotboxClient(api_key string, prompts map[string]*PromptTemplate) *ParrotboxClient {
 	client, err := openai.New(
 		openai.WithBaseURL(_BASE_URL),
 		openai.WithModel(_MODEL),
 		openai.WithToken(api_key),
+		openai.WithCallback(usageCallbackHandler{model: _MODEL}),
 		openai.WithResponseFormat(openai.ResponseFormatJSON))
 
 	if err != nil {
 		log.Println(err)
 		return nil
 	}
+	chat_llm, err := openai.New(
+		openai.WithBaseURL(_BASE_URL),
+		openai.WithModel(_MODEL),
+		openai.WithToken(api_key),
+		openai.WithCallback(usageCallbackHandler{model: _MODEL}))
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	templates := DefaultPromptTemplates()
+	for task, output_type := range map[string]string{DIGEST_TASK: DIGEST_OUTPUT, CONCEPTS_TASK: CONCEPTS_OUTPUT, SENTIMENT_TASK: SENTIMENT_OUTPUT, ENTITIES_TASK: ENTITIES_OUTPUT, TRANSLATION_TASK: TRANSLATION_OUTPUT} {
+		if template, ok := prompts[task]; ok && template != nil {
+			if template.OutputType != output_type {
+				log.Printf("[parrotboxdriver] %s prompt %s has output type %s. Expected %s. Using default.\n", task, template.Version, template.OutputType, output_type)
+				continue
+			}
+			templates[task] = template
+		}
+	}
+
 	return &ParrotboxClient{
-		concepts_chain: NewJsonValueExtraction(client, _CONCEPTS_SAMPLE_INPUT, &_CONCEPTS_SAMPLE_OUTPUT),
-		digest_chain:   NewJsonValueExtraction(client, _DIGEST_SAMPLE_INPUT, &_DIGEST_SAMPLE_OUTPUT),
+		concepts_chain:    NewJsonValueExtraction[keyConceptList](client, templates[CONCEPTS_TASK]),
+		digest_chain:      NewJsonValueExtraction[Digest](client, templates[DIGEST_TASK]),
+		sentiment_chain:   NewJsonValueExtraction[Sentiment](client, templates[SENTIMENT_TASK]),
+		entities_chain:    NewJsonValueExtraction[entityList](client, templates[ENTITIES_TASK]),
+		translation_chain: NewJsonValueExtraction[Translation](client, templates[TRANSLATION_TASK]),
+		chat_llm:          chat_llm,
+		prompts:           templates,
+
+		summarization_mode: STUFF_SUMMARIZATION,
+		sentiment_mode:     LLM_SENTIMENT,
+	}
+}
+
+func (client *ParrotboxClient) WithSummarizationMode(mode string) *ParrotboxClient {
+	switch mode {
+	case MAP_REDUCE_SUMMARIZATION, REFINE_SUMMARIZATION:
+		client.summarization_mode = mode
+	default:
+		client.summarization_mode = STUFF_SUMMARIZATION
 	}
+	return client
+}
+
+func (client *ParrotboxClient) SummarizationMode() string {
+	return client.summarization_mode
+}
+
+func (client *ParrotboxClient) WithSentimentMode(mode string) *ParrotboxClient {
+	if mode == LEXICON_SENTIMENT {
+		client.sentiment_mode = LEXICON_SENTIMENT
+	} else {
+		client.sentiment_mode = LLM_SENTIMENT
+	}
+	return client
 }
 
 func (client *ParrotboxClient) ExtractDigests(texts []string) []Digest {
-	output := make([]Digest, 0, len(texts))
-	datautils.ForEach(texts, func(text *string) {
-		res := serverErrorRetry(
-			func() (Digest, error) {
-				result, err := client.digest_chain.Call(
-					ctx.Background(),
-					map[string]any{
-						"context":    _DIGEST_INSTRUCTION,
-						"input_text": text,
-					},
-				)
-				if err != nil {
-					result, err = retryIfParseError(client.digest_chain, err)
-				}
-				// now check if there is an error. If there is server error the serverErrorRetry will try again
-				if err != nil {
-					log.Println("[goparrotboxdriver] ExtractDigest failed.", err)
-					// insert duds for this batch.
-					return Digest{}, err // inserting dud
-				}
-				return result["value"].(Digest), nil
-			})
-		output = append(output, res)
+	return datautils.Transform(texts, func(text *string) Digest { return client.summarize(*text) })
+}
+
+// texts within the LLM window get summarized in one call.
+// longer texts are summarized according to the summarization mode:
+//   - stuff: truncate to the LLM window and summarize the prefix
+//   - map_reduce: summarize each chunk and then merge the chunk summaries into the final digest
+//   - refine: summarize the first chunk and then refine the running digest with each next chunk
+func (client *ParrotboxClient) summarize(text string) Digest {
+	if client.summarization_mode == STUFF_SUMMARIZATION || CountTokens([]string{text}) <= _MODEL_WINDOW {
+		return client.extractDigest(TruncateTextOnTokenCount(text, _MODEL_WINDOW), client.prompts[DIGEST_TASK].Instruction)
+	}
+
+	chunks := SplitTextOnTokenCount(text, _SUMMARY_CHUNK_SIZE)
+	log.Printf("[parrotboxdriver] Summarizing %d chunks with %s.\n", len(chunks), client.summarization_mode)
+	if client.summarization_mode == REFINE_SUMMARIZATION {
+		return refineDigest(chunks, client.prompts[DIGEST_TASK].Instruction, client.extractDigest)
+	}
+	return mapReduceDigest(chunks, client.prompts[DIGEST_TASK].Instruction, client.extractDigest)
+}
+
+// extract generates the digest of the text with the instruction. An empty summary means it failed
+func mapReduceDigest(chunks []string, instruction string, extract func(text, instruction string) Digest) Digest {
+	// map
+	digests := datautils.FilterAndTransform(chunks, func(chunk *string) (bool, Digest) {
+		digest := extract(*chunk, instruction)
+		return len(digest.Summary) > 0, digest
 	})
-	return output
+	return reduceDigests(digests, extract)
+}
+
+// merges the digests into one. If they don't fit in the window together,
+// the ones that fit get merged first and then the merged ones get merged again
+func reduceDigests(digests []Digest, extract func(text, instruction string) Digest) Digest {
+	switch len(digests) {
+	case 0:
+		return Digest{}
+	case 1:
+		// nothing to merge with
+		return digests[0]
+	}
+	summaries := datautils.Transform(digests, func(digest *Digest) string {
+		return fmt.Sprintf(_PARTIAL_DIGEST, digest.Topic, digest.Summary)
+	})
+	batches := batchOnTokenCount(summaries, _MODEL_WINDOW)
+	if len(batches) == 1 || len(batches) == len(summaries) {
+		// either it all fits or no two summaries fit together and merging in rounds won't get anywhere
+		return extract(TruncateTextOnTokenCount(strings.Join(summaries, _BATCH_DELIMETER), _MODEL_WINDOW), _REDUCE_INSTRUCTION)
+	}
+	return reduceDigests(datautils.FilterAndTransform(batches, func(batch *string) (bool, Digest) {
+		digest := extract(*batch, _REDUCE_INSTRUCTION)
+		return len(digest.Summary) > 0, digest
+	}), extract)
 }
 
+func refineDigest(chunks []string, instruction string, extract func(text, instruction string) Digest) Digest {
+	digest := extract(chunks[0], instruction)
+	for _, chunk := range chunks[1:] {
+		input := fmt.Sprintf(_PARTIAL_DIGEST, digest.Topic, digest.Summary) + _BATCH_DELIMETER + chunk
+		// if refining fails keep the running digest as is
+		if refined := extract(input, _REFINE_INSTRUCTION); len(refined.Summary) > 0 {
+			digest = refined
+		}
+	}
+	return digest
+}
+
+func (client *ParrotboxClient) extractDigest(text, instruction string) Digest {
+	return serverErrorRetry(_MODEL,
+		func() (Digest, error) {
+			start_time := time.Now()
+			result, err := client.digest_chain.Call(
+				ctx.Background(),
+				map[string]any{
+					"context":    instruction,
+					"input_text": text,
+				},
+			)
+			recordCall(_MODEL, time.Since(start_time), err)
+			if err != nil {
+				result, err = retryIfParseError(client.digest_chain, err)
+			}
+			// now check if there is an error. If there is server error the serverErrorRetry will try again
+			if err != nil {
+				log.Println("[goparrotboxdriver] ExtractDigest failed.", err)
+				return Digest{}, err // inserting dud
+			}
+			digest := result["value"].(Digest)
+			digest.PromptVersion = client.PromptVersion(DIGEST_TASK)
+			return digest, nil
+		})
+}
+
+// extracts the keyconcepts from all the texts. Each keyconcept's DocIndex points to the text in `texts` it came from
 func (client *ParrotboxClient) ExtractKeyConcepts(texts []string) []KeyConcept {
-	output := make([]KeyConcept, 0, len(texts))
-	datautils.ForEach(stuffAndBatchInput(texts), func(batch *string) {
+	output := extractFromBatches(texts, func(batch string) []KeyConcept {
 		// retry for each batch
 		// if a batch doesnt workout, just move on to the next batch. No need to insert duds since no sequence need to be maintained
-		res := serverErrorRetry(
+		return serverErrorRetry(_MODEL,
 			func() ([]KeyConcept, error) {
+				start_time := time.Now()
 				result, err := client.concepts_chain.Call(
 					ctx.Background(),
 					map[string]any{
-						"context":    _CONCEPTS_INSTRUCTION,
+						"context":    client.prompts[CONCEPTS_TASK].Instruction,
 						"input_text": batch,
 					},
 				)
+				recordCall(_MODEL, time.Since(start_time), err)
 				if err != nil {
 					result, err = retryIfParseError(client.concepts_chain, err)
 				}
@@ -94,42 +250,264 @@ func (client *ParrotboxClient) ExtractKeyConcepts(texts []string) []KeyConcept {
 				}
 				return result["value"].(keyConceptList).Items, nil
 			})
-		if len(res) > 0 {
-			output = append(output, res...)
+	}, func(item *KeyConcept) *int { return &item.DocIndex })
+	return datautils.ForEach(output, func(item *KeyConcept) { item.PromptVersion = client.PromptVersion(CONCEPTS_TASK) })
+}
+
+// extracts the named entities the same way as keyconcepts.
+// CVE IDs are also picked up with a regex since the LLM tends to miss or mangle them
+func (client *ParrotboxClient) ExtractEntities(texts []string) []Entity {
+	output := extractFromBatches(texts, func(batch string) []Entity {
+		return serverErrorRetry(_MODEL,
+			func() ([]Entity, error) {
+				start_time := time.Now()
+				result, err := client.entities_chain.Call(
+					ctx.Background(),
+					map[string]any{
+						"context":    client.prompts[ENTITIES_TASK].Instruction,
+						"input_text": batch,
+					},
+				)
+				recordCall(_MODEL, time.Since(start_time), err)
+				if err != nil {
+					result, err = retryIfParseError(client.entities_chain, err)
+				}
+				if err != nil {
+					log.Println("[goparrotboxdriver] ExtractEntities failed.", err)
+					return nil, err
+				}
+				return result["value"].(entityList).Items, nil
+			})
+	}, func(item *Entity) *int { return &item.DocIndex })
+	datautils.ForEach(output, func(item *Entity) { item.PromptVersion = client.PromptVersion(ENTITIES_TASK) })
+	for i := range texts {
+		for _, cve := range ExtractCVEs(texts[i]) {
+			if datautils.IndexAny(output, func(item *Entity) bool { return item.DocIndex == i && strings.EqualFold(item.Name, cve) }) < 0 {
+				output = append(output, Entity{DocIndex: i, Name: cve, Type: CVE_ENTITY, PromptVersion: client.PromptVersion(ENTITIES_TASK)})
+			}
 		}
-	})
+	}
 	return output
 }
 
-// the error can be a parse error because content isn't json, or it can be server error
+// translates each text on its own. Texts longer than the translation size are truncated
+func (client *ParrotboxClient) TranslateToEnglish(texts []string) []string {
+	return datautils.Transform(texts, func(text *string) string {
+		return client.translate(TruncateTextOnTokenCount(*text, _TRANSLATION_SIZE))
+	})
+}
+
+func (client *ParrotboxClient) translate(text string) string {
+	return serverErrorRetry(_MODEL,
+		func() (string, error) {
+			start_time := time.Now()
+			result, err := client.translation_chain.Call(
+				ctx.Background(),
+				map[string]any{
+					"context":    client.prompts[TRANSLATION_TASK].Instruction,
+					"input_text": text,
+				},
+			)
+			recordCall(_MODEL, time.Since(start_time), err)
+			if err != nil {
+				result, err = retryIfParseError(client.translation_chain, err)
+			}
+			if err != nil {
+				log.Println("[goparrotboxdriver] Translate failed.", err)
+				return "", err // inserting dud
+			}
+			return result["value"].(Translation).Text, nil
+		})
+}
+
+// scores each text on its own. In lexicon mode the local lexicon scorer is used instead of the LLM.
+// The texts the LLM fails on get an empty Sentiment so that Rectify retries them
+func (client *ParrotboxClient) ScoreSentiments(texts []string) []Sentiment {
+	if client.sentiment_mode == LEXICON_SENTIMENT {
+		return LexiconSentimentScorer{}.ScoreSentiments(texts)
+	}
+	return datautils.Transform(texts, func(text *string) Sentiment {
+		return client.scoreSentiment(TruncateTextOnTokenCount(*text, _MODEL_WINDOW))
+	})
+}
+
+func (client *ParrotboxClient) scoreSentiment(text string) Sentiment {
+	return serverErrorRetry(_MODEL,
+		func() (Sentiment, error) {
+			start_time := time.Now()
+			result, err := client.sentiment_chain.Call(
+				ctx.Background(),
+				map[string]any{
+					"context":    client.prompts[SENTIMENT_TASK].Instruction,
+					"input_text": text,
+				},
+			)
+			recordCall(_MODEL, time.Since(start_time), err)
+			if err != nil {
+				result, err = retryIfParseError(client.sentiment_chain, err)
+			}
+			if err != nil {
+				log.Println("[goparrotboxdriver] ScoreSentiment failed.", err)
+				return Sentiment{}, err // inserting dud
+			}
+			sentiment := result["value"].(Sentiment)
+			// keep the score within range and consistent with the label
+			sentiment.Score = max(-1, min(1, sentiment.Score))
+			if (sentiment.Label == POSITIVE && sentiment.Score < 0) || (sentiment.Label == NEGATIVE && sentiment.Score > 0) {
+				sentiment.Score = -sentiment.Score
+			}
+			sentiment.PromptVersion = client.PromptVersion(SENTIMENT_TASK)
+			return sentiment, nil
+		})
+}
+
+// the documents are packed in order until the window is full. The first one gets truncated if it doesn't fit on its own
+func (client *ParrotboxClient) Answer(question string, documents []string, stream func(chunk string)) string {
+	sources := make([]string, 0, len(documents))
+	size := 0
+	for i := range documents {
+		source := fmt.Sprintf(_SOURCE_HEADER, i+1) + documents[i]
+		if i == 0 {
+			source = TruncateTextOnTokenCount(source, _ANSWER_SOURCES_SIZE)
+		}
+		tokens := CountTokens([]string{source})
+		if size+tokens > _ANSWER_SOURCES_SIZE {
+			break
+		}
+		sources = append(sources, source)
+		size += tokens
+	}
+	if len(sources) == 0 {
+		return ""
+	}
+	log.Printf("[parrotboxdriver] Answering from %d of %d sources.\n", len(sources), len(documents))
+
+	messages := []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, _ANSWER_INSTRUCTION),
+		llms.TextParts(llms.ChatMessageTypeHuman, fmt.Sprintf(_ANSWER_INPUT, strings.Join(sources, _BATCH_DELIMETER), question)),
+	}
+	return serverErrorRetry(_MODEL,
+		func() (string, error) {
+			options := []llms.CallOption{llms.WithTemperature(0.1), llms.WithSeed(1000)}
+			streamed := false
+			if stream != nil {
+				options = append(options, llms.WithStreamingFunc(func(_ ctx.Context, chunk []byte) error {
+					streamed = true
+					stream(string(chunk))
+					return nil
+				}))
+			}
+			start_time := time.Now()
+			resp, err := client.chat_llm.GenerateContent(ctx.Background(), messages, options...)
+			recordCall(_MODEL, time.Since(start_time), err)
+			if err == nil && len(resp.Choices) == 0 {
+				err = fmt.Errorf("no answer generated")
+			}
+			if err != nil {
+				log.Println("[goparrotboxdriver] Answer failed.", err)
+				// the chunks already sent can't be taken back so retrying would repeat them
+				if streamed {
+					return "", retry.Unrecoverable(err)
+				}
+				return "", err
+			}
+			return resp.Choices[0].Content, nil
+		})
+}
+
+func (client *ParrotboxClient) ModelName() string {
+	return _MODEL
+}
+
+func (client *ParrotboxClient) PromptVersion(task string) string {
+	if task == SENTIMENT_TASK && client.sentiment_mode == LEXICON_SENTIMENT {
+		return _LEXICON_SCORER_TAG
+	}
+	if template, ok := client.prompts[task]; ok {
+		return template.Version
+	}
+	return ""
+}
+
+// the error can be a parse error because content isn't json or doesn't match the schema, or it can be server error
 // for server error try again multiple times
-// for parser error try with the _RETRY_INSTRUCTION
+// for parser error run a bounded repair loop that feeds the concrete errors back to the LLM
 func retryIfParseError(chain *JsonValueExtraction, err error) (map[string]any, error) {
 	var result map[string]any
-	// try once and send whatever the result is
-	if parse_err, ok := err.(ParseError); ok {
-		// this is ParserError. retry once
-		log.Println("[parrotboxdriver] Retyring json format extraction.")
+	for i := 0; i < _MAX_REPAIR_ATTEMPTS; i++ {
+		parse_err, ok := err.(ParseError)
+		if !ok {
+			// this is either a success or a server error
+			break
+		}
+		log.Printf("[parrotboxdriver] Repairing json format extraction. Attempt %d. %v\n", i+1, parse_err.Errors)
 		// reassigning the result and err
+		start_time := time.Now()
 		result, err = chain.Call(
 			ctx.Background(),
 			map[string]any{
-				"context":    _RETRY_INSTRUCTION,
+				"context":    fmt.Sprintf(_REPAIR_INSTRUCTION, strings.Join(parse_err.Errors, "\n")),
 				"input_text": parse_err.Text,
 			},
 		)
+		recordCall(_MODEL, time.Since(start_time), err)
 	}
 	// send whatever is there
 	return result, err
 }
 
-func stuffAndBatchInput(texts []string) []string {
-	if CountTokens(texts) > _MODEL_WINDOW {
+// a batch of documents stuffed into one input
+type inputBatch struct {
+	text   string
+	offset int // index of the first document of the batch in the original list
+	count  int
+}
+
+// joins the consecutive texts that fit in max_tokens together. A text is never split across batches
+func batchOnTokenCount(texts []string, max_tokens int) []string {
+	batches := make([]string, 0, len(texts))
+	batch, batch_tokens := make([]string, 0, len(texts)), 0
+	for _, text := range texts {
+		tokens := CountTokens([]string{text, _BATCH_DELIMETER})
+		if len(batch) > 0 && batch_tokens+tokens > max_tokens {
+			batches = append(batches, strings.Join(batch, _BATCH_DELIMETER))
+			batch, batch_tokens = batch[:0], 0
+		}
+		batch = append(batch, text)
+		batch_tokens += tokens
+	}
+	if len(batch) > 0 {
+		batches = append(batches, strings.Join(batch, _BATCH_DELIMETER))
+	}
+	return batches
+}
+
+// runs extract on each batch of the texts. The doc_index of the items extract returns is local to the batch.
+// These get remapped to the index in `texts` and the ones pointing outside of the batch are dropped
+func extractFromBatches[T any](texts []string, extract func(batch string) []T, doc_index func(item *T) *int) []T {
+	output := make([]T, 0, len(texts))
+	datautils.ForEach(stuffAndBatchInput(texts, 0), func(batch *inputBatch) {
+		res := datautils.Filter(extract(batch.text), func(item *T) bool { return *doc_index(item) >= 0 && *doc_index(item) < batch.count })
+		output = append(output, datautils.ForEach(res, func(item *T) { *doc_index(item) += batch.offset })...)
+	})
+	return output
+}
+
+func stuffAndBatchInput(texts []string, offset int) []inputBatch {
+	// a single text that is too long cannot be split in half
+	if len(texts) == 1 {
+		texts = []string{TruncateTextOnTokenCount(texts[0], _MODEL_WINDOW)}
+	}
+	if len(texts) > 1 && CountTokens(texts) > _MODEL_WINDOW {
 		// split in half and retry recursively
 		return append(
-			stuffAndBatchInput(texts[:len(texts)/2]),
-			stuffAndBatchInput(texts[len(texts)/2:])...)
+			stuffAndBatchInput(texts[:len(texts)/2], offset),
+			stuffAndBatchInput(texts[len(texts)/2:], offset+len(texts)/2)...)
+	}
+	// it is within context window so just batch em up all together and number them so that the keyconcepts can point back to them
+	numbered := make([]string, len(texts))
+	for i := range texts {
+		numbered[i] = fmt.Sprintf(_DOCUMENT_HEADER, i) + texts[i]
 	}
-	// it is within context window so just batch em up all together
-	return []string{strings.Join(texts, _BATCH_DELIMETER)}
+	return []inputBatch{{text: strings.Join(numbered, _BATCH_DELIMETER), offset: offset, count: len(texts)}}
 }
@@ -0,0 +1,244 @@
+package nlp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const _TEST_INSTRUCTION = "summarize"
+
+type digestCall struct {
+	text, instruction string
+}
+
+// a digest chain that fails on the texts with "fail" in them and numbers the other digests by the call
+type stubDigestChain struct {
+	calls []digestCall
+}
+
+func (chain *stubDigestChain) extract(text, instruction string) Digest {
+	chain.calls = append(chain.calls, digestCall{text, instruction})
+	if strings.Contains(text, "fail") {
+		return Digest{}
+	}
+	return Digest{Topic: "topic", Summary: fmt.Sprintf("digest %d", len(chain.calls))}
+}
+
+func (chain *stubDigestChain) instructions() []string {
+	instructions := make([]string, len(chain.calls))
+	for i, call := range chain.calls {
+		instructions[i] = call.instruction
+	}
+	return instructions
+}
+
+func partialDigest(summary string) string {
+	return fmt.Sprintf(_PARTIAL_DIGEST, "topic", summary)
+}
+
+func TestMapReduceDigest(t *testing.T) {
+	tests := []struct {
+		name         string
+		chunks       []string
+		want         Digest
+		instructions []string
+		reduced      []string // the partial digests the reduce step merged in order
+	}{
+		{
+			name:         "every chunk",
+			chunks:       []string{"one", "two", "three"},
+			want:         Digest{Topic: "topic", Summary: "digest 4"},
+			instructions: []string{_TEST_INSTRUCTION, _TEST_INSTRUCTION, _TEST_INSTRUCTION, _REDUCE_INSTRUCTION},
+			reduced:      []string{partialDigest("digest 1"), partialDigest("digest 2"), partialDigest("digest 3")},
+		},
+		{
+			name:         "a chunk failed",
+			chunks:       []string{"one", "fail", "three"},
+			want:         Digest{Topic: "topic", Summary: "digest 4"},
+			instructions: []string{_TEST_INSTRUCTION, _TEST_INSTRUCTION, _TEST_INSTRUCTION, _REDUCE_INSTRUCTION},
+			reduced:      []string{partialDigest("digest 1"), partialDigest("digest 3")},
+		},
+		{
+			name:         "only one chunk left has nothing to merge with",
+			chunks:       []string{"fail", "two", "fail"},
+			want:         Digest{Topic: "topic", Summary: "digest 2"},
+			instructions: []string{_TEST_INSTRUCTION, _TEST_INSTRUCTION, _TEST_INSTRUCTION},
+		},
+		{
+			name:         "every chunk failed",
+			chunks:       []string{"fail", "fail"},
+			want:         Digest{},
+			instructions: []string{_TEST_INSTRUCTION, _TEST_INSTRUCTION},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := &stubDigestChain{}
+			if got := mapReduceDigest(test.chunks, _TEST_INSTRUCTION, chain.extract); got != test.want {
+				t.Errorf("mapReduceDigest() = %+v, want %+v", got, test.want)
+			}
+			if got := chain.instructions(); !reflect.DeepEqual(got, test.instructions) {
+				t.Fatalf("instructions = %v, want %v", got, test.instructions)
+			}
+			for i, chunk := range test.chunks {
+				if chain.calls[i].text != chunk {
+					t.Errorf("map step %d = %q, want the chunk %q", i, chain.calls[i].text, chunk)
+				}
+			}
+			if test.reduced != nil {
+				if got := chain.calls[len(chain.calls)-1].text; got != strings.Join(test.reduced, _BATCH_DELIMETER) {
+					t.Errorf("reduce step = %q, want %q", got, test.reduced)
+				}
+			}
+		})
+	}
+}
+
+func TestReduceDigestsInRounds(t *testing.T) {
+	// no three of these fit in the window together but two do regardless of how the tokens are counted
+	long := strings.Repeat("word ", 2200)
+	digests := make([]Digest, 4)
+	for i := range digests {
+		digests[i] = Digest{Topic: "topic", Summary: fmt.Sprintf("part %d %s", i, long)}
+	}
+	chain := &stubDigestChain{}
+	if got, want := reduceDigests(digests, chain.extract), (Digest{Topic: "topic", Summary: "digest 3"}); got != want {
+		t.Errorf("reduceDigests() = %+v, want %+v", got, want)
+	}
+	// two batches of two and then the merged ones
+	if got, want := chain.instructions(), []string{_REDUCE_INSTRUCTION, _REDUCE_INSTRUCTION, _REDUCE_INSTRUCTION}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("instructions = %v, want %v", got, want)
+	}
+	for i, parts := range [][]int{{0, 1}, {2, 3}} {
+		text := chain.calls[i].text
+		for part := range 4 {
+			if has := strings.Contains(text, fmt.Sprintf("part %d ", part)); has != (part == parts[0] || part == parts[1]) {
+				t.Errorf("batch %d has part %d: %v, want the parts %v", i, part, has, parts)
+			}
+		}
+	}
+	if got, want := chain.calls[2].text, partialDigest("digest 1")+_BATCH_DELIMETER+partialDigest("digest 2"); got != want {
+		t.Errorf("last round = %q, want %q", got, want)
+	}
+
+	chain = &stubDigestChain{}
+	if got := reduceDigests(nil, chain.extract); got != (Digest{}) || len(chain.calls) != 0 {
+		t.Errorf("reduceDigests(nil) = %+v after %d calls, want nothing", got, len(chain.calls))
+	}
+}
+
+func TestRefineDigest(t *testing.T) {
+	tests := []struct {
+		name         string
+		chunks       []string
+		want         Digest
+		instructions []string
+		refined      []string // the running digest each refine step started from
+	}{
+		{
+			name:         "one chunk",
+			chunks:       []string{"one"},
+			want:         Digest{Topic: "topic", Summary: "digest 1"},
+			instructions: []string{_TEST_INSTRUCTION},
+		},
+		{
+			name:         "every chunk",
+			chunks:       []string{"one", "two", "three"},
+			want:         Digest{Topic: "topic", Summary: "digest 3"},
+			instructions: []string{_TEST_INSTRUCTION, _REFINE_INSTRUCTION, _REFINE_INSTRUCTION},
+			refined:      []string{"digest 1", "digest 2"},
+		},
+		{
+			name:         "a failed refine keeps the running digest",
+			chunks:       []string{"one", "fail", "three"},
+			want:         Digest{Topic: "topic", Summary: "digest 3"},
+			instructions: []string{_TEST_INSTRUCTION, _REFINE_INSTRUCTION, _REFINE_INSTRUCTION},
+			refined:      []string{"digest 1", "digest 1"},
+		},
+		{
+			name:         "the last refine failed",
+			chunks:       []string{"one", "two", "fail"},
+			want:         Digest{Topic: "topic", Summary: "digest 2"},
+			instructions: []string{_TEST_INSTRUCTION, _REFINE_INSTRUCTION, _REFINE_INSTRUCTION},
+			refined:      []string{"digest 1", "digest 2"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := &stubDigestChain{}
+			if got := refineDigest(test.chunks, _TEST_INSTRUCTION, chain.extract); got != test.want {
+				t.Errorf("refineDigest() = %+v, want %+v", got, test.want)
+			}
+			if got := chain.instructions(); !reflect.DeepEqual(got, test.instructions) {
+				t.Fatalf("instructions = %v, want %v", got, test.instructions)
+			}
+			if chain.calls[0].text != test.chunks[0] {
+				t.Errorf("first step = %q, want the first chunk %q", chain.calls[0].text, test.chunks[0])
+			}
+			for i, summary := range test.refined {
+				if want := partialDigest(summary) + _BATCH_DELIMETER + test.chunks[i+1]; chain.calls[i+1].text != want {
+					t.Errorf("refine step %d = %q, want %q", i+1, chain.calls[i+1].text, want)
+				}
+			}
+		})
+	}
+}
+
+// a keyconcept chain that names each keyconcept after the document it came from by its batch local doc_index.
+// It also makes up doc_index values outside of the batch
+func stubConceptChain(batches *int) func(batch string) []KeyConcept {
+	return func(batch string) []KeyConcept {
+		*batches++
+		concepts := []KeyConcept{{DocIndex: -1, KeyPhrase: "before the batch"}}
+		documents := strings.Split(batch, _BATCH_DELIMETER)
+		for _, document := range documents {
+			var index int
+			var name string
+			if _, err := fmt.Sscanf(document, _DOCUMENT_HEADER+"%s", &index, &name); err == nil {
+				concepts = append(concepts, KeyConcept{DocIndex: index, KeyPhrase: name})
+			}
+		}
+		return append(concepts, KeyConcept{DocIndex: len(documents), KeyPhrase: "after the batch"})
+	}
+}
+
+func TestExtractFromBatches(t *testing.T) {
+	// two of these fit in the window together but three don't regardless of how the tokens are counted
+	long := strings.Repeat(" word", 2100)
+	documents := func(count int, text string) []string {
+		texts := make([]string, count)
+		for i := range texts {
+			texts[i] = fmt.Sprintf("doc%d%s", i, text)
+		}
+		return texts
+	}
+	tests := []struct {
+		name    string
+		texts   []string
+		batches int
+	}{
+		{"one batch", documents(3, " short"), 1},
+		{"even batches", documents(8, long), 4},
+		{"uneven batches", documents(5, long), 3},
+		{"one text each", documents(3, long+long), 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			batches := 0
+			concepts := extractFromBatches(test.texts, stubConceptChain(&batches), func(item *KeyConcept) *int { return &item.DocIndex })
+			if batches != test.batches {
+				t.Errorf("batches = %d, want %d", batches, test.batches)
+			}
+			if len(concepts) != len(test.texts) {
+				t.Fatalf("extractFromBatches() = %+v, want one keyconcept per text", concepts)
+			}
+			for i, concept := range concepts {
+				if want := fmt.Sprintf("doc%d", i); concept.DocIndex != i || concept.KeyPhrase != want {
+					t.Errorf("keyconcept %q points to %d, want %q at %d", concept.KeyPhrase, concept.DocIndex, want, i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package nlp
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// tasks that have prompt templates
+const (
+	DIGEST_TASK      = "digest"
+	CONCEPTS_TASK    = "keyconcepts"
+	SENTIMENT_TASK   = "sentiment"
+	ENTITIES_TASK    = "entities"
+	TRANSLATION_TASK = "translation"
+)
+
+// output types the templates can produce
+const (
+	DIGEST_OUTPUT      = "Digest"
+	CONCEPTS_OUTPUT    = "KeyConceptList"
+	SENTIMENT_OUTPUT   = "Sentiment"
+	ENTITIES_OUTPUT    = "EntityList"
+	TRANSLATION_OUTPUT = "Translation"
+)
+
+// versions of the templates in the prompts directory of this package that are used when no version is configured
+const (
+	DEFAULT_DIGEST_PROMPT_VERSION      = "v1"
+	DEFAULT_CONCEPTS_PROMPT_VERSION    = "v2" // v2 added doc_index to the keyconcepts. v1 only gets it from the output schema
+	DEFAULT_SENTIMENT_PROMPT_VERSION   = "v1"
+	DEFAULT_ENTITIES_PROMPT_VERSION    = "v1"
+	DEFAULT_TRANSLATION_PROMPT_VERSION = "v1"
+)
+
+// the built-in templates as prompts/<task>/<version>.json. These are the only copy of the prompts
+//
+//go:embed prompts
+var builtin_prompts embed.FS
+
+// A versioned prompt for a task. The built-in ones are compiled in from the prompts directory of this package.
+// More versions can be loaded from files like PROMPTS_DIR/<task>/<version>.json
+// so that the instructions and few-shot samples can be tuned without a rebuild
+type PromptTemplate struct {
+	Task        string          `json:"task"`
+	Version     string          `json:"version"`
+	OutputType  string          `json:"output_type"`
+	Instruction string          `json:"instruction"`
+	Examples    []PromptExample `json:"examples,omitempty"`
+}
+
+type PromptExample struct {
+	Input  string          `json:"input"`
+	Output json.RawMessage `json:"output"` // json value of the OutputType
+}
+
+// the built-in templates of the default versions. These are used when no version is configured
+func DefaultPromptTemplates() map[string]*PromptTemplate {
+	templates := make(map[string]*PromptTemplate)
+	for task, version := range map[string]string{
+		DIGEST_TASK:      DEFAULT_DIGEST_PROMPT_VERSION,
+		CONCEPTS_TASK:    DEFAULT_CONCEPTS_PROMPT_VERSION,
+		SENTIMENT_TASK:   DEFAULT_SENTIMENT_PROMPT_VERSION,
+		ENTITIES_TASK:    DEFAULT_ENTITIES_PROMPT_VERSION,
+		TRANSLATION_TASK: DEFAULT_TRANSLATION_PROMPT_VERSION,
+	} {
+		template, err := readPromptTemplate(builtin_prompts, path.Join("prompts", task, version+".json"))
+		if err != nil {
+			// the defaults are compiled in so this is a broken build
+			panic(err)
+		}
+		templates[task] = template
+	}
+	return templates
+}
+
+// Loads the templates of the versions specified for each task from prompts_dir, or from the built-in ones if prompts_dir doesn't have it.
+// Tasks without a version use the default templates. A version that is in neither place or that doesn't parse is an error
+// so that a typo in the configuration doesn't silently run the default prompt
+func LoadPromptTemplates(prompts_dir string, versions map[string]string) (map[string]*PromptTemplate, error) {
+	templates := DefaultPromptTemplates()
+	for task, version := range versions {
+		if version == "" {
+			continue
+		}
+		template, err := loadPromptTemplate(prompts_dir, task, version)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[prompts] Loaded %s prompt version %s\n", template.Task, template.Version)
+		templates[task] = template
+	}
+	return templates, nil
+}
+
+func loadPromptTemplate(prompts_dir, task, version string) (*PromptTemplate, error) {
+	filename := path.Join(task, version+".json")
+	if prompts_dir != "" {
+		template, err := readPromptTemplate(os.DirFS(prompts_dir), filename)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return template, err
+		}
+	}
+	template, err := readPromptTemplate(builtin_prompts, path.Join("prompts", filename))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("unknown %s prompt version %s", task, version)
+	}
+	return template, err
+}
+
+func readPromptTemplate(fsys fs.FS, filename string) (*PromptTemplate, error) {
+	data, err := fs.ReadFile(fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+	var template PromptTemplate
+	if err = json.Unmarshal(data, &template); err != nil {
+		return nil, fmt.Errorf("failed parsing prompt %s. %w", filename, err)
+	}
+	// fill in task and version from the file path if the file doesn't say
+	if template.Task == "" {
+		template.Task = path.Base(path.Dir(filename))
+	}
+	if template.Version == "" {
+		template.Version = strings.TrimSuffix(path.Base(filename), ".json")
+	}
+	return &template, nil
+}
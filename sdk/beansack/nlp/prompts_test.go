@@ -0,0 +1,32 @@
+package nlp
+
+import "testing"
+
+func TestLoadPromptTemplates(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions map[string]string
+		want     map[string]string // task -> version of the loaded template
+		want_err bool
+	}{
+		{"defaults", nil, map[string]string{DIGEST_TASK: DEFAULT_DIGEST_PROMPT_VERSION, CONCEPTS_TASK: DEFAULT_CONCEPTS_PROMPT_VERSION, SENTIMENT_TASK: DEFAULT_SENTIMENT_PROMPT_VERSION, ENTITIES_TASK: DEFAULT_ENTITIES_PROMPT_VERSION, TRANSLATION_TASK: DEFAULT_TRANSLATION_PROMPT_VERSION}, false},
+		{"built-in version", map[string]string{CONCEPTS_TASK: "v1"}, map[string]string{CONCEPTS_TASK: "v1"}, false},
+		{"newer built-in version", map[string]string{CONCEPTS_TASK: "v2"}, map[string]string{CONCEPTS_TASK: "v2"}, false},
+		{"empty version", map[string]string{CONCEPTS_TASK: ""}, map[string]string{CONCEPTS_TASK: DEFAULT_CONCEPTS_PROMPT_VERSION, SENTIMENT_TASK: DEFAULT_SENTIMENT_PROMPT_VERSION, ENTITIES_TASK: DEFAULT_ENTITIES_PROMPT_VERSION, TRANSLATION_TASK: DEFAULT_TRANSLATION_PROMPT_VERSION}, false},
+		{"unknown version", map[string]string{CONCEPTS_TASK: "v9"}, nil, true},
+		{"unknown task", map[string]string{"haiku": "v1"}, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			templates, err := LoadPromptTemplates(t.TempDir(), test.versions)
+			if (err != nil) != test.want_err {
+				t.Fatalf("LoadPromptTemplates(%v) error = %v, want error %v", test.versions, err, test.want_err)
+			}
+			for task, version := range test.want {
+				if templates[task] == nil || templates[task].Version != version || templates[task].Instruction == "" {
+					t.Errorf("LoadPromptTemplates(%v)[%s] = %+v, want version %s", test.versions, task, templates[task], version)
+				}
+			}
+		})
+	}
+}
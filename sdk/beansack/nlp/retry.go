@@ -1,7 +1,7 @@
 package nlp
 
 import (
-	"regexp"
+	"log"
 	"time"
 
 	"github.com/avast/retry-go"
@@ -14,45 +14,47 @@ const (
 	RETRY_ATTEMPTS = 3
 )
 
-func serverErrorRetry[T any](original_func func() (T, error)) T {
-	var res T
-	var err error
+// retries rate limits and server errors with back off or as long as the service asked to in Retry-After.
+// the calls go through the circuit breaker and the rate limiters of the model so that an unavailable service fails fast
+func serverErrorRetry[T any](model string, original_func func() (T, error)) T {
+	return guardedRetry(model, LONG_DELAY, isServiceError, original_func)
+}
 
-	retry.Do(
-		func() error {
-			if res, err = original_func(); err != nil {
-				// something went wrong with the function so try again
-				return err
-			}
-			// no error
-			return nil
-		},
-		retry.Delay(LONG_DELAY),
-		retry.Attempts(RETRY_ATTEMPTS),
-		retry.RetryIf(func(err error) bool {
-			// match for 503: Service Unavailable & 429: Rate limit
-			res, err := regexp.MatchString("(?i)(429:.+Rate.+limit|503: Service Unavailable)", err.Error())
-			return err == nil && res
-		}),
-	)
-	return res
+// retries the same errors as serverErrorRetry with a short delay. A request the service rejected such as a 400 or a 401 fails the same way every time
+func retryT[T any](model string, original_func func() (T, error)) T {
+	return guardedRetry(model, SHORT_DELAY, isServiceError, original_func)
 }
 
-func retryT[T any](original_func func() (T, error)) T {
+func guardedRetry[T any](model string, delay time.Duration, retry_if func(err error) bool, original_func func() (T, error)) T {
 	var res T
 	var err error
-	// retry for each batch
+	guard := getServiceGuard(model)
+
 	retry.Do(
 		func() error {
-			if res, err = original_func(); err != nil {
-				// something went wrong with the function so try again
-				return err
+			if err = guard.acquire(); err != nil {
+				// don't wait on a service that is down. the caller gets a dud
+				log.Printf("[%s] Deferring call. %v\n", model, err)
+				return retry.Unrecoverable(err)
 			}
-			// no error
-			return nil
+			res, err = original_func()
+			guard.release(err)
+			if err != nil && guard.isOpen() {
+				return retry.Unrecoverable(err)
+			}
+			return err
 		},
-		retry.Delay(SHORT_DELAY),
+		retry.Delay(delay),
+		retry.DelayType(func(n uint, err error, config *retry.Config) time.Duration {
+			if wait := retryAfter(err); wait > 0 {
+				return wait
+			}
+			return retry.BackOffDelay(n, err, config)
+		}),
+		retry.MaxDelay(_MAX_RETRY_WAIT),
 		retry.Attempts(RETRY_ATTEMPTS),
+		retry.OnRetry(func(_ uint, _ error) { recordRetry(model) }),
+		retry.RetryIf(func(err error) bool { return retry.IsRecoverable(err) && retry_if(err) }),
 	)
 	return res
 }
@@ -70,7 +72,10 @@ func postHTTPRequest[T any](url, auth_token string, input any) (T, error) {
 		req = req.SetAuthToken(auth_token)
 	}
 	// make the request
-	_, err := req.Post(url)
+	resp, err := req.Post(url)
+	if err == nil && resp.IsError() {
+		err = ServiceError{StatusCode: resp.StatusCode(), RetryAfter: parseRetryAfter(resp.Header().Get("Retry-After")), Message: resp.String()}
+	}
 	// if there is no error the err value will be `nil`
 	return result, err
 }
@@ -86,6 +91,7 @@ func postHTTPRequestAndRetryOnFail[T any](url, auth_token string, input any) T {
 		},
 		retry.Attempts(RETRY_ATTEMPTS),
 		retry.Delay(SHORT_DELAY),
+		retry.RetryIf(isServiceError),
 	)
 	return result
 }
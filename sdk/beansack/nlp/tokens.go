@@ -1,12 +1,36 @@
 package nlp
 
 import (
+	"log"
+	"sync"
+
 	"github.com/pkoukk/tiktoken-go"
 	datautils "github.com/soumitsalman/data-utils"
 )
 
+const _CHARS_PER_TOKEN = 4 // rough size of a token when the tokenizer is not available
+
+var (
+	encoding      *tiktoken.Tiktoken
+	encoding_once sync.Once
+)
+
+// the tokenizer downloads its vocabulary on first use. nil if that failed such as in offline runs
+func getEncoding() *tiktoken.Tiktoken {
+	encoding_once.Do(func() {
+		var err error
+		if encoding, err = tiktoken.GetEncoding("cl100k_base"); err != nil {
+			log.Printf("[nlp] Tokenizer not available. Counting tokens on characters instead. %v\n", err)
+		}
+	})
+	return encoding
+}
+
 func TruncateTextOnTokenCount(text string, max_tokens int) string {
-	tk, _ := tiktoken.GetEncoding("cl100k_base")
+	tk := getEncoding()
+	if tk == nil {
+		return string(datautils.SafeSlice([]rune(text), 0, max_tokens*_CHARS_PER_TOKEN))
+	}
 	return tk.Decode(
 		datautils.SafeSlice(
 			tk.Encode(text, nil, nil),
@@ -16,8 +40,36 @@ func TruncateTextOnTokenCount(text string, max_tokens int) string {
 }
 
 func CountTokens(texts []string) int {
-	tk, _ := tiktoken.GetEncoding("cl100k_base")
+	tk := getEncoding()
 	total := 0
-	datautils.ForEach(texts, func(text *string) { total += len(tk.Encode(*text, nil, nil)) })
+	datautils.ForEach(texts, func(text *string) {
+		if tk == nil {
+			total += (len([]rune(*text)) + _CHARS_PER_TOKEN - 1) / _CHARS_PER_TOKEN
+		} else {
+			total += len(tk.Encode(*text, nil, nil))
+		}
+	})
 	return total
 }
+
+// splits the text into chunks of max_tokens each
+func SplitTextOnTokenCount(text string, max_tokens int) []string {
+	tk := getEncoding()
+	if tk == nil {
+		return splitRunes([]rune(text), max_tokens*_CHARS_PER_TOKEN)
+	}
+	tokens := tk.Encode(text, nil, nil)
+	chunks := make([]string, 0, len(tokens)/max_tokens+1)
+	for i := 0; i < len(tokens); i += max_tokens {
+		chunks = append(chunks, tk.Decode(datautils.SafeSlice(tokens, i, i+max_tokens)))
+	}
+	return chunks
+}
+
+func splitRunes(runes []rune, size int) []string {
+	chunks := make([]string, 0, len(runes)/size+1)
+	for i := 0; i < len(runes); i += size {
+		chunks = append(chunks, string(datautils.SafeSlice(runes, i, i+size)))
+	}
+	return chunks
+}
@@ -0,0 +1,223 @@
+package nlp
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/callbacks"
+	"github.com/tmc/langchaingo/llms"
+)
+
+const (
+	_MAX_USAGE_RUNS = 30 // number of past collection runs to keep around
+)
+
+// Price per 1 million tokens in dollars
+type Price struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+type ModelUsage struct {
+	Model            string  `json:"model"`
+	Calls            int     `json:"calls"`
+	Retries          int     `json:"retries"`
+	Failures         int     `json:"failures"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	LatencyMs        int64   `json:"latency_ms"`
+	Cost             float64 `json:"cost"`
+}
+
+// usage aggregated over a time frame such as a collection run or a day
+type UsageReport struct {
+	Start  int64                  `json:"start"`
+	End    int64                  `json:"end,omitempty"`
+	Cost   float64                `json:"cost"`
+	Models map[string]*ModelUsage `json:"models"`
+}
+
+type UsageStats struct {
+	CurrentRun  *UsageReport  `json:"current_run"`
+	Today       *UsageReport  `json:"today"`
+	DailyBudget float64       `json:"daily_budget,omitempty"`
+	PastRuns    []UsageReport `json:"past_runs,omitempty"`
+}
+
+type usageTracker struct {
+	lock         sync.Mutex
+	prices       map[string]Price
+	daily_budget float64
+	current_run  *UsageReport
+	today        *UsageReport
+	past_runs    []UsageReport
+}
+
+var tracker = &usageTracker{
+	current_run: newUsageReport(),
+	today:       newUsageReport(),
+}
+
+// prices are keyed by the ModelName of the driver. Models without a price are counted as free
+func SetPriceTable(prices map[string]Price) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	tracker.prices = prices
+}
+
+// 0 or less means no budget
+func SetDailyBudget(dollars float64) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	tracker.daily_budget = dollars
+}
+
+// returns true if the spend for the day has gone over the daily budget.
+// Enrichment stages should pause until the next day when this happens
+func BudgetExceeded() bool {
+	return tracker.budgetExceeded()
+}
+
+func GetUsageStats() UsageStats {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	tracker.rollOverDay()
+	return UsageStats{
+		CurrentRun:  tracker.current_run.copy(),
+		Today:       tracker.today.copy(),
+		DailyBudget: tracker.daily_budget,
+		PastRuns:    append([]UsageReport{}, tracker.past_runs...),
+	}
+}
+
+// closes out the usage of the current collection run, starts a new one and returns the closed one.
+// The background work of the run needs to be done before this so that its tokens aren't billed to the next run
+func CompleteUsageRun() UsageReport {
+	return tracker.completeRun()
+}
+
+func recordCall(model string, latency time.Duration, err error) {
+	tracker.update(model, func(usage *ModelUsage) {
+		usage.Calls += 1
+		usage.LatencyMs += latency.Milliseconds()
+		if err != nil {
+			usage.Failures += 1
+		}
+	})
+}
+
+func recordRetry(model string) {
+	tracker.update(model, func(usage *ModelUsage) { usage.Retries += 1 })
+}
+
+func recordTokens(model string, prompt_tokens, completion_tokens int) {
+	tracker.recordTokens(model, prompt_tokens, completion_tokens)
+	getServiceGuard(model).consumeTokens(prompt_tokens + completion_tokens)
+}
+
+func (tracker *usageTracker) budgetExceeded() bool {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	tracker.rollOverDay()
+	return tracker.daily_budget > 0 && tracker.today.Cost >= tracker.daily_budget
+}
+
+func (tracker *usageTracker) completeRun() UsageReport {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	run := tracker.current_run
+	run.End = time.Now().Unix()
+	tracker.past_runs = append(tracker.past_runs, *run)
+	if len(tracker.past_runs) > _MAX_USAGE_RUNS {
+		tracker.past_runs = tracker.past_runs[len(tracker.past_runs)-_MAX_USAGE_RUNS:]
+	}
+	tracker.current_run = newUsageReport()
+	return *run
+}
+
+// the price lookup and the costs are one update so that a price change or a day rolling over can't split them
+func (tracker *usageTracker) recordTokens(model string, prompt_tokens, completion_tokens int) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	tracker.rollOverDay()
+	price := tracker.prices[model]
+	cost := (float64(prompt_tokens)*price.Prompt + float64(completion_tokens)*price.Completion) / 1000000
+	for _, report := range []*UsageReport{tracker.current_run, tracker.today} {
+		usage := report.get(model)
+		usage.PromptTokens += prompt_tokens
+		usage.CompletionTokens += completion_tokens
+		usage.Cost += cost
+		report.Cost += cost
+	}
+}
+
+func (tracker *usageTracker) update(model string, update_func func(usage *ModelUsage)) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	tracker.rollOverDay()
+	update_func(tracker.current_run.get(model))
+	update_func(tracker.today.get(model))
+}
+
+// starts a new day if the day has changed. lock must be held by the caller
+func (tracker *usageTracker) rollOverDay() {
+	if !sameDay(tracker.today.Start, time.Now().Unix()) {
+		log.Printf("[usage] Closing usage for the day. Total cost $%f\n", tracker.today.Cost)
+		tracker.today = newUsageReport()
+	}
+}
+
+func newUsageReport() *UsageReport {
+	return &UsageReport{
+		Start:  time.Now().Unix(),
+		Models: make(map[string]*ModelUsage),
+	}
+}
+
+func (report *UsageReport) get(model string) *ModelUsage {
+	usage, ok := report.Models[model]
+	if !ok {
+		usage = &ModelUsage{Model: model}
+		report.Models[model] = usage
+	}
+	return usage
+}
+
+func (report *UsageReport) copy() *UsageReport {
+	output := *report
+	output.Models = make(map[string]*ModelUsage, len(report.Models))
+	for model, usage := range report.Models {
+		usage_copy := *usage
+		output.Models[model] = &usage_copy
+	}
+	return &output
+}
+
+func sameDay(a, b int64) bool {
+	return time.Unix(a, 0).Format(time.DateOnly) == time.Unix(b, 0).Format(time.DateOnly)
+}
+
+// collects the token counts reported by the LLM service
+type usageCallbackHandler struct {
+	callbacks.SimpleHandler
+	model string
+}
+
+func (handler usageCallbackHandler) HandleLLMGenerateContentEnd(_ context.Context, res *llms.ContentResponse) {
+	if res == nil {
+		return
+	}
+	prompt_tokens, completion_tokens := 0, 0
+	for _, choice := range res.Choices {
+		if val, ok := choice.GenerationInfo["PromptTokens"].(int); ok {
+			prompt_tokens += val
+		}
+		if val, ok := choice.GenerationInfo["CompletionTokens"].(int); ok {
+			completion_tokens += val
+		}
+	}
+	recordTokens(handler.model, prompt_tokens, completion_tokens)
+}
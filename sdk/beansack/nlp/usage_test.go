@@ -0,0 +1,127 @@
+package nlp
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestTracker(budget float64) *usageTracker {
+	return &usageTracker{
+		prices:       map[string]Price{"priced": {Prompt: 2, Completion: 10}},
+		daily_budget: budget,
+		current_run:  newUsageReport(),
+		today:        newUsageReport(),
+	}
+}
+
+func TestRecordTokensCost(t *testing.T) {
+	tests := []struct {
+		name               string
+		model              string
+		prompt, completion int
+		want               float64
+	}{
+		{"priced per million tokens", "priced", 1000000, 100000, 2 + 1},
+		{"prompt only", "priced", 500000, 0, 1},
+		{"unpriced is free", "free", 1000000, 1000000, 0},
+		{"nothing", "priced", 0, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := newTestTracker(0)
+			tracker.recordTokens(test.model, test.prompt, test.completion)
+			for _, report := range []*UsageReport{tracker.current_run, tracker.today} {
+				usage := report.Models[test.model]
+				if math.Abs(report.Cost-test.want) > 1e-9 || math.Abs(usage.Cost-test.want) > 1e-9 {
+					t.Errorf("cost = %f and %f for the model, want %f", report.Cost, usage.Cost, test.want)
+				}
+				if usage.PromptTokens != test.prompt || usage.CompletionTokens != test.completion {
+					t.Errorf("tokens = %d and %d, want %d and %d", usage.PromptTokens, usage.CompletionTokens, test.prompt, test.completion)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordTokensConcurrently(t *testing.T) {
+	tracker := newTestTracker(0)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tracker.recordTokens("priced", 10000, 1000)
+		}()
+	}
+	wg.Wait()
+	if want := 50 * (10000*2.0 + 1000*10.0) / 1000000; math.Abs(tracker.current_run.Cost-want) > 1e-9 || math.Abs(tracker.today.Cost-want) > 1e-9 {
+		t.Errorf("cost = %f and %f today, want %f", tracker.current_run.Cost, tracker.today.Cost, want)
+	}
+	if got := tracker.today.Models["priced"].PromptTokens; got != 50*10000 {
+		t.Errorf("prompt tokens = %d, want %d", got, 50*10000)
+	}
+}
+
+func TestBudgetExceeded(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget float64
+		tokens int // prompt tokens of the priced model at $2 per million
+		want   bool
+	}{
+		{"no budget", 0, 10000000, false},
+		{"under the budget", 1, 400000, false},
+		{"at the budget", 1, 500000, true},
+		{"over the budget", 1, 600000, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := newTestTracker(test.budget)
+			tracker.recordTokens("priced", test.tokens, 0)
+			if got := tracker.budgetExceeded(); got != test.want {
+				t.Errorf("budgetExceeded() = %v at $%f of $%f, want %v", got, tracker.today.Cost, test.budget, test.want)
+			}
+		})
+	}
+}
+
+func TestRollOverDay(t *testing.T) {
+	tracker := newTestTracker(1)
+	tracker.recordTokens("priced", 600000, 0)
+	if !tracker.budgetExceeded() {
+		t.Fatal("budgetExceeded() = false, want true")
+	}
+	// the spend was yesterday
+	tracker.today.Start = time.Now().AddDate(0, 0, -1).Unix()
+	if tracker.budgetExceeded() {
+		t.Error("budgetExceeded() = true on the next day, want false")
+	}
+	if tracker.today.Cost != 0 || len(tracker.today.Models) != 0 {
+		t.Errorf("today = %+v, want a new day", tracker.today)
+	}
+	// the collection run goes on across the days
+	tracker.recordTokens("priced", 100000, 0)
+	if math.Abs(tracker.current_run.Cost-1.4) > 1e-9 || math.Abs(tracker.today.Cost-0.2) > 1e-9 {
+		t.Errorf("cost = %f for the run and %f today, want 1.4 and 0.2", tracker.current_run.Cost, tracker.today.Cost)
+	}
+}
+
+func TestCompleteRun(t *testing.T) {
+	tracker := newTestTracker(0)
+	tracker.recordTokens("priced", 500000, 0)
+	run := tracker.completeRun()
+	if run.Cost != 1 || run.End == 0 {
+		t.Errorf("completeRun() = %+v, want the closed run", run)
+	}
+	if tracker.current_run.Cost != 0 || len(tracker.current_run.Models) != 0 || tracker.today.Cost != 1 {
+		t.Errorf("current run = %+v and today = %+v, want a new run and the same day", tracker.current_run, tracker.today)
+	}
+	for i := 0; i < _MAX_USAGE_RUNS+5; i++ {
+		tracker.completeRun()
+	}
+	if len(tracker.past_runs) != _MAX_USAGE_RUNS || tracker.past_runs[0].Cost != 0 {
+		t.Errorf("past runs = %d, want the last %d", len(tracker.past_runs), _MAX_USAGE_RUNS)
+	}
+}
@@ -0,0 +1,149 @@
+package beansack
+
+import (
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// names of the built-in notifiers
+const (
+	WEBHOOK_NOTIFIER = "webhook" // POSTs the Alert as json to the target url
+	SLACK_NOTIFIER   = "slack"   // POSTs a message to the target Slack-compatible incoming webhook url
+	EMAIL_NOTIFIER   = "email"   // emails the target address through SMTP. Only available when it is configured through WithNotifier
+)
+
+const _NOTIFY_TIMEOUT = 30 * time.Second
+
+// Delivers the alerts of the subscriptions. Custom ones are added through WithNotifier
+type Notifier interface {
+	// checks the target of a subscription when it is saved and returns it the way it gets stored
+	Validate(target string) (string, error)
+	Notify(target string, alert *Alert) error
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string // empty means no authentication
+	Password string
+	From     string
+}
+
+func NewWebhookNotifier() Notifier {
+	return webhookNotifier{client: resty.New().SetTimeout(_NOTIFY_TIMEOUT)}
+}
+
+func NewSlackNotifier() Notifier {
+	return slackNotifier{client: resty.New().SetTimeout(_NOTIFY_TIMEOUT)}
+}
+
+func NewEmailNotifier(config SMTPConfig) Notifier {
+	return emailNotifier{config: config}
+}
+
+type webhookNotifier struct {
+	client *resty.Client
+}
+
+func (webhookNotifier) Validate(target string) (string, error) {
+	return target, validateWebhookUrl(target)
+}
+
+func (notifier webhookNotifier) Notify(target string, alert *Alert) error {
+	return postNotification(notifier.client, target, alert)
+}
+
+type slackNotifier struct {
+	client *resty.Client
+}
+
+func (slackNotifier) Validate(target string) (string, error) {
+	return target, validateWebhookUrl(target)
+}
+
+func (notifier slackNotifier) Notify(target string, alert *Alert) error {
+	return postNotification(notifier.client, target, map[string]string{"text": getSlackText(alert)})
+}
+
+// slack treats & < > as control characters in the text. In a link | also ends the url so it is percent-encoded
+func getSlackText(alert *Alert) string {
+	escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	escape_url := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "|", "%7C")
+	var text strings.Builder
+	fmt.Fprintf(&text, "*%s*: %d new\n", escape.Replace(alert.Subscription), len(alert.Beans))
+	for _, bean := range alert.Beans {
+		fmt.Fprintf(&text, "• <%s|%s> (%s)\n", escape_url.Replace(bean.Url), escape.Replace(getAlertTitle(&bean)), escape.Replace(bean.Source))
+	}
+	return text.String()
+}
+
+type emailNotifier struct {
+	config SMTPConfig
+}
+
+// stores the bare address of `Alice <alice@example.com>` since it is used as is for the recipient
+func (emailNotifier) Validate(target string) (string, error) {
+	addr, err := mail.ParseAddress(target)
+	if err != nil {
+		return "", BeanSackError(fmt.Sprintf("%q is not an email address", target))
+	}
+	return addr.Address, nil
+}
+
+func (notifier emailNotifier) Notify(target string, alert *Alert) error {
+	// the subscription name ends up in a header
+	single_line := strings.NewReplacer("\r", " ", "\n", " ")
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", notifier.config.From)
+	fmt.Fprintf(&body, "To: %s\r\n", target)
+	fmt.Fprintf(&body, "Subject: %d new for %s\r\n", len(alert.Beans), single_line.Replace(alert.Subscription))
+	body.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	for _, bean := range alert.Beans {
+		fmt.Fprintf(&body, "%s\r\n%s\r\n", getAlertTitle(&bean), bean.Url)
+		if bean.Summary != "" {
+			fmt.Fprintf(&body, "%s\r\n", bean.Summary)
+		}
+		body.WriteString("\r\n")
+	}
+
+	var auth smtp.Auth
+	if notifier.config.Username != "" {
+		auth = smtp.PlainAuth("", notifier.config.Username, notifier.config.Password, notifier.config.Host)
+	}
+	addr := notifier.config.Host + ":" + strconv.Itoa(notifier.config.Port)
+	return smtp.SendMail(addr, auth, notifier.config.From, []string{target}, []byte(body.String()))
+}
+
+func validateWebhookUrl(target string) error {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return BeanSackError(fmt.Sprintf("%q is not a webhook url", target))
+	}
+	return nil
+}
+
+func postNotification(client *resty.Client, target string, body any) error {
+	resp, err := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(body).
+		Post(target)
+	if err == nil && resp.IsError() {
+		err = BeanSackError(fmt.Sprintf("%s returned %d", target, resp.StatusCode()))
+	}
+	return err
+}
+
+// posts and comments may not have a title
+func getAlertTitle(bean *Bean) string {
+	if bean.Title != "" {
+		return bean.Title
+	}
+	return bean.Url
+}
@@ -0,0 +1,67 @@
+package beansack
+
+import "testing"
+
+func TestEmailNotifierValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		want     string
+		want_err bool
+	}{
+		{"bare address", "alice@example.com", "alice@example.com", false},
+		{"display name", "Alice <alice@example.com>", "alice@example.com", false},
+		{"quoted display name", `"Alice, Bob" <alice@example.com>`, "alice@example.com", false},
+		{"not an address", "alice", "", true},
+		{"several addresses", "alice@example.com, bob@example.com", "", true},
+		{"header injection", "alice@example.com\r\nBcc: bob@example.com", "", true},
+	}
+	notifier := NewEmailNotifier(SMTPConfig{})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := notifier.Validate(test.target)
+			if (err != nil) != test.want_err {
+				t.Fatalf("Validate(%q) error = %v, want error %v", test.target, err, test.want_err)
+			}
+			if got != test.want {
+				t.Errorf("Validate(%q) = %q, want %q", test.target, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetSlackText(t *testing.T) {
+	tests := []struct {
+		name  string
+		alert Alert
+		want  string
+	}{
+		{
+			name:  "plain",
+			alert: Alert{Subscription: "security", Beans: []Bean{{Url: "https://example.com/a", Title: "Patch now", Source: "example.com"}}},
+			want:  "*security*: 1 new\n• <https://example.com/a|Patch now> (example.com)\n",
+		},
+		{
+			name:  "control characters in the text",
+			alert: Alert{Subscription: "R&D <new>", Beans: []Bean{{Url: "https://example.com/a", Title: "a < b > c & d", Source: "A&B"}}},
+			want:  "*R&amp;D &lt;new&gt;*: 1 new\n• <https://example.com/a|a &lt; b &gt; c &amp; d> (A&amp;B)\n",
+		},
+		{
+			name:  "pipe and angle brackets in the url",
+			alert: Alert{Subscription: "s", Beans: []Bean{{Url: "https://example.com/a?q=x|y&t=<b>", Title: "t", Source: "s"}}},
+			want:  "*s*: 1 new\n• <https://example.com/a?q=x%7Cy&amp;t=&lt;b&gt;|t> (s)\n",
+		},
+		{
+			name:  "no title",
+			alert: Alert{Subscription: "s", Beans: []Bean{{Url: "https://example.com/a|b", Source: "s"}}},
+			want:  "*s*: 1 new\n• <https://example.com/a%7Cb|https://example.com/a|b> (s)\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := getSlackText(&test.alert); got != test.want {
+				t.Errorf("getSlackText() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
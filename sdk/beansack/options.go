@@ -1,9 +1,11 @@
 package beansack
 
 import (
+	"slices"
 	"time"
 
 	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
 )
 
 const (
@@ -11,12 +13,28 @@ const (
 	_MAX_TOPN     = 100
 )
 
+// the time field the time windows and the date ranges filter on
+const (
+	UPDATED_TIME = "updated" // when the bean was collected or its media noise last changed
+	CREATED_TIME = "created" // when the bean was published
+)
+
 type SearchOptions struct {
 	ScalarFilter     store.JSON
 	TopN             int
 	SearchTexts      []string
 	SearchEmbeddings [][]float32
 	Context          string
+	// keep only the top bean of each story
+	OnePerStory bool
+	// scores the trends instead of the stored trend scores. nil means the stored ones
+	TrendScorer TrendScorer
+	// VELOCITY_SORT for the trending beans. empty means by trend score
+	SortBy string
+	// UPDATED_TIME or CREATED_TIME. empty means UPDATED_TIME
+	TimeField string
+	// attach a SearchExplanation to each bean
+	Explain bool
 }
 
 func NewSearchOptions() *SearchOptions {
@@ -26,8 +44,50 @@ func NewSearchOptions() *SearchOptions {
 	}
 }
 
+// the last `time_window` days. It is clamped to 1 - 28 days
 func (settings *SearchOptions) WithTimeWindow(time_window int) *SearchOptions {
-	settings.ScalarFilter["updated"] = store.JSON{"$gte": timeValue(time_window)}
+	settings.ScalarFilter[settings.getTimeField()] = store.JSON{"$gte": timeValue(time_window)}
+	return settings
+}
+
+// the last `hours` hours for the views shorter than a day. It is clamped to 1 hour - 28 days
+func (settings *SearchOptions) WithHourWindow(hours int) *SearchOptions {
+	hours = min(max(hours, 1), _FOUR_WEEKS*24)
+	settings.ScalarFilter[settings.getTimeField()] = store.JSON{"$gte": time.Now().Add(-time.Duration(hours) * time.Hour).Unix()}
+	return settings
+}
+
+// from is inclusive and to is exclusive. Either can be zero for an open range. Unlike the time windows this isn't clamped
+// so it works for historical lookups. It replaces the start of a time window set before it
+func (settings *SearchOptions) WithDateRange(from, to time.Time) *SearchOptions {
+	condition := make(store.JSON)
+	if !from.IsZero() {
+		condition["$gte"] = from.Unix()
+	}
+	if !to.IsZero() {
+		condition["$lt"] = to.Unix()
+	}
+	if len(condition) > 0 {
+		if existing, ok := settings.ScalarFilter[settings.getTimeField()].(store.JSON); ok {
+			condition = datautils.AppendMaps(existing, condition)
+		}
+		settings.ScalarFilter[settings.getTimeField()] = condition
+	}
+	return settings
+}
+
+// field is UPDATED_TIME or CREATED_TIME. Anything else is ignored. The time window or the date range set before it moves to the field
+func (settings *SearchOptions) WithTimeField(field string) *SearchOptions {
+	if field != UPDATED_TIME && field != CREATED_TIME {
+		return settings
+	}
+	if previous := settings.getTimeField(); previous != field {
+		if condition, ok := settings.ScalarFilter[previous]; ok {
+			delete(settings.ScalarFilter, previous)
+			settings.ScalarFilter[field] = condition
+		}
+	}
+	settings.TimeField = field
 	return settings
 }
 
@@ -47,13 +107,172 @@ func (settings *SearchOptions) WithTopN(topn int) *SearchOptions {
 	return settings
 }
 
+// the urls match the beans by their canonical url or any of their aliases
 func (settings *SearchOptions) WithURLs(urls []string) *SearchOptions {
 	if len(urls) > 0 {
-		settings.ScalarFilter["url"] = store.JSON{"$in": urls}
+		// the $or goes in the $and so that it doesn't clash with the other $or filters
+		settings.withAnd(getUrlFilter(resolveUrls(urls)))
+	}
+	return settings
+}
+
+// labels are nlp.POSITIVE, nlp.NEGATIVE or nlp.NEUTRAL
+func (settings *SearchOptions) WithSentiment(labels []string) *SearchOptions {
+	if len(labels) > 0 {
+		settings.ScalarFilter["sentiment"] = store.JSON{"$in": labels}
+	}
+	return settings
+}
+
+// ids of the BeanEntity the beans should mention
+func (settings *SearchOptions) WithEntities(entity_ids []string) *SearchOptions {
+	if len(entity_ids) > 0 {
+		settings.ScalarFilter["entities"] = store.JSON{"$in": entity_ids}
+	}
+	return settings
+}
+
+// ids or names of the categories in the taxonomy. Beans in the sub-categories match as well
+func (settings *SearchOptions) WithCategory(categories []string) *SearchOptions {
+	if len(categories) > 0 {
+		settings.ScalarFilter["categories.id"] = store.JSON{"$in": expandCategories(categories)}
+	}
+	return settings
+}
+
+// the texts that are ids or names of the categories in the taxonomy filter by those categories on top of the ones already filtered by.
+// The rest are free text for the vector search
+func (settings *SearchOptions) WithCategoryTexts(texts []string) *SearchOptions {
+	categories := datautils.Filter(texts, func(text *string) bool { return isCategory(*text) })
+	settings.SearchTexts = datautils.Filter(texts, func(text *string) bool { return !isCategory(*text) })
+	if len(categories) > 0 {
+		ids := expandCategories(categories)
+		if filter, ok := settings.ScalarFilter["categories.id"].(store.JSON); ok {
+			ids = append(filter["$in"].([]string), ids...)
+			slices.Sort(ids)
+			ids = slices.Compact(ids)
+		}
+		settings.ScalarFilter["categories.id"] = store.JSON{"$in": ids}
+	}
+	return settings
+}
+
+// ISO 639-1 codes of the original language of the beans
+func (settings *SearchOptions) WithLanguage(languages []string) *SearchOptions {
+	if len(languages) > 0 {
+		settings.ScalarFilter["language"] = store.JSON{"$in": languages}
 	}
 	return settings
 }
 
+// returns one bean per story instead of every bean covering the same event
+func (settings *SearchOptions) WithOnePerStory(one_per_story bool) *SearchOptions {
+	settings.OnePerStory = one_per_story
+	return settings
+}
+
+// scores the trending nuggets and beans with the scorer instead of the one the trend scores are stored with
+func (settings *SearchOptions) WithTrendScorer(scorer TrendScorer) *SearchOptions {
+	settings.TrendScorer = scorer
+	return settings
+}
+
+// sort_by is VELOCITY_SORT or empty for the trend score
+func (settings *SearchOptions) WithSortBy(sort_by string) *SearchOptions {
+	settings.SortBy = sort_by
+	return settings
+}
+
+// attaches a breakdown of the scores and the final rank to each bean for tuning the relevance
+func (settings *SearchOptions) WithExplain(explain bool) *SearchOptions {
+	settings.Explain = explain
+	return settings
+}
+
+// adds the filters of the parsed query. They take precedence over the other filters on the same fields
+func (settings *SearchOptions) WithQuery(query *Query) *SearchOptions {
+	if query == nil {
+		return settings
+	}
+	for path, condition := range query.Filter() {
+		if path == "$and" {
+			settings.withAnd(condition.([]store.JSON)...)
+		} else if existing, ok := settings.ScalarFilter[path].(store.JSON); ok {
+			settings.ScalarFilter[path] = datautils.AppendMaps(existing, condition.(store.JSON))
+		} else {
+			settings.ScalarFilter[path] = condition
+		}
+	}
+	return settings
+}
+
+// adds the conditions that can't be keyed by a field such as $or
+func (settings *SearchOptions) withAnd(conditions ...store.JSON) {
+	and, _ := settings.ScalarFilter["$and"].([]store.JSON)
+	settings.ScalarFilter["$and"] = append(and, conditions...)
+}
+
+// a copy of the scalar filter that conditions can be added to without changing the options
+func (settings *SearchOptions) copyScalarFilter() store.JSON {
+	filter := datautils.AppendMaps(make(store.JSON, len(settings.ScalarFilter)), settings.ScalarFilter)
+	if and, ok := filter["$and"].([]store.JSON); ok {
+		filter["$and"] = slices.Clip(and)
+	}
+	return filter
+}
+
+// there is something to vector search with
+func (settings *SearchOptions) hasFuzzySearch() bool {
+	return len(settings.SearchEmbeddings) > 0 || len(settings.SearchTexts) > 0 || len(settings.Context) > 0
+}
+
+func (settings *SearchOptions) getTimeField() string {
+	if settings.TimeField == CREATED_TIME {
+		return CREATED_TIME
+	}
+	return UPDATED_TIME
+}
+
+// the pipeline of the docs matching the filter in the collections that only have an update time such as the nuggets and the engagement history.
+// They get updated in the same collection runs as their beans so the update time carries over to time_field. The publish time doesn't
+// so the beans urls_field points to are looked up for it instead. false if there is no time filter
+func (settings *SearchOptions) getTimeFilterPipeline(filter store.JSON, time_field, urls_field string) ([]store.JSON, bool) {
+	condition, ok := settings.ScalarFilter[settings.getTimeField()]
+	if !ok {
+		return []store.JSON{{"$match": filter}}, false
+	}
+	if settings.getTimeField() == UPDATED_TIME {
+		return []store.JSON{{"$match": store.JSON(datautils.AppendMaps(store.JSON{time_field: condition}, filter))}}, true
+	}
+	return []store.JSON{
+		{"$match": filter},
+		{"$lookup": store.JSON{
+			"from":         BEANS,
+			"localField":   urls_field,
+			"foreignField": "url",
+			"pipeline":     []store.JSON{{"$match": store.JSON{CREATED_TIME: condition}}, {"$project": store.JSON{"url": 1}}},
+			"as":           "_published",
+		}},
+		{"$match": store.JSON{"_published": store.JSON{"$ne": []any{}}}},
+		{"$project": store.JSON{"_published": 0}},
+	}, true
+}
+
+// the stages that finish a pipeline the way Get does
+func getStages(fields, sort_by store.JSON, top_n int) []store.JSON {
+	stages := make([]store.JSON, 0, 3)
+	if len(sort_by) > 0 {
+		stages = append(stages, store.JSON{"$sort": sort_by})
+	}
+	if top_n > 0 {
+		stages = append(stages, store.JSON{"$limit": top_n})
+	}
+	if len(fields) > 0 {
+		stages = append(stages, store.JSON{"$project": fields})
+	}
+	return stages
+}
+
 func timeValue(time_window int) int64 {
 	return time.Now().AddDate(0, 0, -checkAndFixTimeWindow(time_window)).Unix()
 }
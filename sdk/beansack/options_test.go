@@ -0,0 +1,224 @@
+package beansack
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+)
+
+func TestWithHourWindow(t *testing.T) {
+	tests := []struct {
+		name  string
+		hours int
+		want  int // the hours the window actually covers
+	}{
+		{"hours", 6, 6},
+		{"less than an hour", 0, 1},
+		{"negative", -5, 1},
+		{"more than four weeks", 1000, _FOUR_WEEKS * 24},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			want := time.Now().Add(-time.Duration(test.want) * time.Hour).Unix()
+			since := NewSearchOptions().WithHourWindow(test.hours).ScalarFilter[UPDATED_TIME].(store.JSON)["$gte"].(int64)
+			if since < want-60 || since > want+60 {
+				t.Errorf("WithHourWindow(%d) starts at %d, want %d", test.hours, since, want)
+			}
+		})
+	}
+	options := NewSearchOptions().WithTimeField(CREATED_TIME).WithHourWindow(2)
+	if _, ok := options.ScalarFilter[CREATED_TIME]; !ok || len(options.ScalarFilter) != 1 {
+		t.Errorf("WithHourWindow() with the publish time = %v, want a window on %q", options.ScalarFilter, CREATED_TIME)
+	}
+}
+
+func TestWithDateRange(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	window := timeValue(7)
+	tests := []struct {
+		name    string
+		options *SearchOptions
+		from    time.Time
+		to      time.Time
+		want    store.JSON
+	}{
+		{"range", NewSearchOptions(), from, to, store.JSON{"$gte": from.Unix(), "$lt": to.Unix()}},
+		{"open start", NewSearchOptions(), time.Time{}, to, store.JSON{"$lt": to.Unix()}},
+		{"open end", NewSearchOptions(), from, time.Time{}, store.JSON{"$gte": from.Unix()}},
+		{"replaces the start of a window", NewSearchOptions().WithTimeWindow(7), from, to, store.JSON{"$gte": from.Unix(), "$lt": to.Unix()}},
+		{"ends a window", NewSearchOptions().WithTimeWindow(7), time.Time{}, to, store.JSON{"$gte": window, "$lt": to.Unix()}},
+		{"historical range isn't clamped", NewSearchOptions(), from.AddDate(-5, 0, 0), from, store.JSON{"$gte": from.AddDate(-5, 0, 0).Unix(), "$lt": from.Unix()}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.options.WithDateRange(test.from, test.to).ScalarFilter[UPDATED_TIME]
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("WithDateRange() = %v, want %v", got, test.want)
+			}
+		})
+	}
+	if options := NewSearchOptions().WithDateRange(time.Time{}, time.Time{}); len(options.ScalarFilter) != 0 {
+		t.Errorf("WithDateRange() of an open range = %v, want no filter", options.ScalarFilter)
+	}
+}
+
+func TestWithTimeField(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		options    *SearchOptions
+		field      string
+		want_field string
+	}{
+		{"moves the window", NewSearchOptions().WithDateRange(from, time.Time{}), CREATED_TIME, CREATED_TIME},
+		{"moves the window back", NewSearchOptions().WithTimeField(CREATED_TIME).WithDateRange(from, time.Time{}), UPDATED_TIME, UPDATED_TIME},
+		{"same field", NewSearchOptions().WithDateRange(from, time.Time{}), UPDATED_TIME, UPDATED_TIME},
+		{"unknown field is ignored", NewSearchOptions().WithDateRange(from, time.Time{}), "deleted", UPDATED_TIME},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := test.options.WithKind([]string{ARTICLE}).WithTimeField(test.field)
+			want := store.JSON{
+				"kind":          store.JSON{"$in": []string{ARTICLE}},
+				test.want_field: store.JSON{"$gte": from.Unix()},
+			}
+			if !reflect.DeepEqual(options.ScalarFilter, want) {
+				t.Errorf("WithTimeField(%q) = %v, want %v", test.field, options.ScalarFilter, want)
+			}
+			if options.getTimeField() != test.want_field {
+				t.Errorf("getTimeField() = %q, want %q", options.getTimeField(), test.want_field)
+			}
+		})
+	}
+	// the window set after the field goes to the field
+	if options := NewSearchOptions().WithTimeField(CREATED_TIME).WithTimeWindow(3); options.ScalarFilter[CREATED_TIME] == nil {
+		t.Errorf("WithTimeWindow() after WithTimeField() = %v, want a window on %q", options.ScalarFilter, CREATED_TIME)
+	}
+}
+
+func TestGetTimeFilterPipeline(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	condition := store.JSON{"$gte": from.Unix()}
+	tests := []struct {
+		name    string
+		options *SearchOptions
+		want    []store.JSON
+		want_ok bool
+	}{
+		{
+			name:    "no time filter",
+			options: NewSearchOptions(),
+			want:    []store.JSON{{"$match": store.JSON{"match_count": 1}}},
+		},
+		{
+			name:    "update time carries over",
+			options: NewSearchOptions().WithDateRange(from, time.Time{}),
+			want:    []store.JSON{{"$match": store.JSON{"match_count": 1, "updated": condition}}},
+			want_ok: true,
+		},
+		{
+			name:    "publish time is looked up in the beans",
+			options: NewSearchOptions().WithTimeField(CREATED_TIME).WithDateRange(from, time.Time{}),
+			want: []store.JSON{
+				{"$match": store.JSON{"match_count": 1}},
+				{"$lookup": store.JSON{
+					"from":         BEANS,
+					"localField":   "mapped_urls",
+					"foreignField": "url",
+					"pipeline":     []store.JSON{{"$match": store.JSON{CREATED_TIME: condition}}, {"$project": store.JSON{"url": 1}}},
+					"as":           "_published",
+				}},
+				{"$match": store.JSON{"_published": store.JSON{"$ne": []any{}}}},
+				{"$project": store.JSON{"_published": 0}},
+			},
+			want_ok: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := store.JSON{"match_count": 1}
+			got, ok := test.options.getTimeFilterPipeline(filter, "updated", "mapped_urls")
+			if ok != test.want_ok || !reflect.DeepEqual(got, test.want) {
+				t.Errorf("getTimeFilterPipeline() = %v %v, want %v %v", got, ok, test.want, test.want_ok)
+			}
+			if !reflect.DeepEqual(filter, store.JSON{"match_count": 1}) {
+				t.Errorf("getTimeFilterPipeline() changed the filter to %v", filter)
+			}
+		})
+	}
+}
+
+func TestGetStages(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields store.JSON
+		sort   store.JSON
+		top_n  int
+		want   []string
+	}{
+		{"everything", store.JSON{"url": 1}, store.JSON{"updated": -1}, 5, []string{"$sort", "$limit", "$project"}},
+		{"no limit", store.JSON{"url": 1}, store.JSON{"updated": -1}, -1, []string{"$sort", "$project"}},
+		{"nothing", nil, nil, -1, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, stage := range getStages(test.fields, test.sort, test.top_n) {
+				for name := range stage {
+					got = append(got, name)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("getStages() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWithCategoryTexts(t *testing.T) {
+	taxonomy := []Category{
+		{ID: "tech", Name: "Technology"},
+		{ID: "ai", Name: "Artificial Intelligence", Parent: "tech"},
+		{ID: "security", Name: "Cybersecurity", Parent: "tech"},
+	}
+	tests := []struct {
+		name       string
+		taxonomy   []Category
+		query      []string // the categories of the query parameters
+		texts      []string
+		want_texts []string
+		want_ids   []string // nil means no category filter
+	}{
+		{"nothing", taxonomy, nil, nil, []string{}, nil},
+		{"no taxonomy", nil, nil, []string{"ai", "rust compilers"}, []string{"ai", "rust compilers"}, nil},
+		{"free text", taxonomy, nil, []string{"rust compilers"}, []string{"rust compilers"}, nil},
+		{"by id", taxonomy, nil, []string{"ai"}, []string{}, []string{"ai"}},
+		{"by name", taxonomy, nil, []string{"cybersecurity"}, []string{}, []string{"security"}},
+		{"with the descendants", taxonomy, nil, []string{"Technology"}, []string{}, []string{"tech", "ai", "security"}},
+		{"both", taxonomy, nil, []string{"ai", "rust compilers", "Cybersecurity"}, []string{"rust compilers"}, []string{"ai", "security"}},
+		{"on top of the query", taxonomy, []string{"security"}, []string{"ai", "security"}, []string{}, []string{"ai", "security"}},
+		{"free text keeps the query", taxonomy, []string{"ai"}, []string{"rust compilers"}, []string{"rust compilers"}, []string{"ai"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestTaxonomy(t, test.taxonomy)
+			options := NewSearchOptions().WithCategory(test.query).WithCategoryTexts(test.texts)
+			if !reflect.DeepEqual(options.SearchTexts, test.want_texts) {
+				t.Errorf("SearchTexts = %q, want %q", options.SearchTexts, test.want_texts)
+			}
+			filter, ok := options.ScalarFilter["categories.id"].(store.JSON)
+			if test.want_ids == nil {
+				if ok {
+					t.Errorf("categories.id = %v, want no category filter", filter)
+				}
+				return
+			}
+			if !ok || !reflect.DeepEqual(filter["$in"], test.want_ids) {
+				t.Errorf("categories.id = %v, want $in %v", filter, test.want_ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package beansack
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
+)
+
+// query operators
+const (
+	QUERY_EQUALS        = ":"
+	QUERY_GREATER       = ">"
+	QUERY_GREATER_EQUAL = ">="
+	QUERY_LESS          = "<"
+	QUERY_LESS_EQUAL    = "<="
+)
+
+// how the values of a query field are matched
+const (
+	_TEXT_FIELD     = iota // as typed, lowercase, uppercase or title case
+	_ENUM_FIELD            // one of the allowed values
+	_DATE_FIELD            // YYYY-MM-DD or RFC 3339. the only one that can be compared
+	_URL_FIELD             // the url or an alias of the bean
+	_CATEGORY_FIELD        // id or name of the category in the taxonomy. The sub-categories match as well
+	_ENTITY_FIELD          // id of the entity in the registry or any name it is known as
+)
+
+type queryField struct {
+	path string // in the beans collection
+	kind int
+	// for _ENUM_FIELD. the typed value -> the stored one
+	values map[string]string
+}
+
+var _QUERY_FIELDS = map[string]queryField{
+	"source":   {path: "source", kind: _TEXT_FIELD},
+	"author":   {path: "author", kind: _TEXT_FIELD},
+	"keyword":  {path: "keywords", kind: _TEXT_FIELD},
+	"topic":    {path: "topic", kind: _TEXT_FIELD},
+	"entity":   {path: "entities", kind: _ENTITY_FIELD},
+	"category": {path: "categories.id", kind: _CATEGORY_FIELD},
+	"story":    {path: "story_id", kind: _TEXT_FIELD},
+	"lang":     {path: "language", kind: _TEXT_FIELD},
+	"url":      {path: "url", kind: _URL_FIELD},
+	"created":  {path: "created", kind: _DATE_FIELD},
+	"updated":  {path: "updated", kind: _DATE_FIELD},
+	"kind": {path: "kind", kind: _ENUM_FIELD, values: map[string]string{
+		"article": ARTICLE, "news": ARTICLE, "post": POST, "comment": COMMENT, "channel": CHANNEL,
+		ARTICLE: ARTICLE, POST: POST, COMMENT: COMMENT, CHANNEL: CHANNEL,
+	}},
+	"sentiment": {path: "sentiment", kind: _ENUM_FIELD, values: map[string]string{
+		nlp.POSITIVE: nlp.POSITIVE, nlp.NEGATIVE: nlp.NEGATIVE, nlp.NEUTRAL: nlp.NEUTRAL,
+	}},
+}
+
+// One `[-]field<op>value` term of a query
+type QueryClause struct {
+	Field   string
+	Op      string
+	Value   string
+	Negated bool
+}
+
+// A parsed query such as `source:reddit kind:post author:"u/foo" created>2024-05-01 keyword:ransomware -source:medium`.
+// The clauses on different fields all have to match. The ones on the same field match any of their values and
+// the negated ones match none. Only the dates can be compared with > >= < <=. Dates compared with : match the whole day in UTC
+type Query struct {
+	Clauses []QueryClause
+}
+
+type QueryError string
+
+func (err QueryError) Error() string {
+	return string(err)
+}
+
+// Parses and validates the query. The fields and the operators come from a fixed set and the values are only ever
+// used as values so nothing in the query reaches the store as an operator
+func ParseQuery(text string) (*Query, error) {
+	query := &Query{}
+	runes := []rune(strings.TrimSpace(text))
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		clause, next, err := parseQueryClause(runes, i)
+		if err != nil {
+			return nil, err
+		}
+		query.Clauses = append(query.Clauses, clause)
+		i = next
+	}
+	return query, nil
+}
+
+// parses the clause starting at runes[start] and returns it along with where the next one starts
+func parseQueryClause(runes []rune, start int) (QueryClause, int, error) {
+	var clause QueryClause
+	i := start
+	if runes[i] == '-' {
+		clause.Negated = true
+		i++
+	}
+	// field
+	field_start := i
+	for i < len(runes) && (unicode.IsLetter(runes[i]) || runes[i] == '_') {
+		i++
+	}
+	clause.Field = strings.ToLower(string(runes[field_start:i]))
+	field, ok := _QUERY_FIELDS[clause.Field]
+	if !ok {
+		return clause, i, QueryError(fmt.Sprintf("unknown field %q at %d", clause.Field, field_start))
+	}
+	// operator
+	switch {
+	case i < len(runes) && runes[i] == ':':
+		clause.Op = QUERY_EQUALS
+	case i+1 < len(runes) && (runes[i] == '>' || runes[i] == '<') && runes[i+1] == '=':
+		clause.Op = string(runes[i : i+2])
+	case i < len(runes) && (runes[i] == '>' || runes[i] == '<'):
+		clause.Op = string(runes[i])
+	default:
+		return clause, i, QueryError(fmt.Sprintf("missing operator after %q at %d", clause.Field, i))
+	}
+	i += len(clause.Op)
+	if clause.Op != QUERY_EQUALS && (field.kind != _DATE_FIELD || clause.Negated) {
+		return clause, i, QueryError(fmt.Sprintf("%q cannot be compared with %s", clause.Field, clause.Op))
+	}
+	// value
+	var value strings.Builder
+	if i < len(runes) && runes[i] == '"' {
+		for i++; i < len(runes) && runes[i] != '"'; i++ {
+			if runes[i] == '\\' && i+1 < len(runes) {
+				i++
+			}
+			value.WriteRune(runes[i])
+		}
+		if i >= len(runes) {
+			return clause, i, QueryError(fmt.Sprintf("unterminated quote in %q", clause.Field))
+		}
+		i++
+	} else {
+		for ; i < len(runes) && !unicode.IsSpace(runes[i]); i++ {
+			value.WriteRune(runes[i])
+		}
+	}
+	clause.Value = strings.TrimSpace(value.String())
+	if clause.Value == "" {
+		return clause, i, QueryError(fmt.Sprintf("missing value for %q", clause.Field))
+	}
+	if i < len(runes) && !unicode.IsSpace(runes[i]) {
+		return clause, i, QueryError(fmt.Sprintf("unexpected %q after %q at %d", runes[i], clause.Field, i))
+	}
+	return clause, i, validateQueryClause(&clause, field)
+}
+
+func validateQueryClause(clause *QueryClause, field queryField) error {
+	switch field.kind {
+	case _ENUM_FIELD:
+		value, ok := field.values[strings.ToLower(clause.Value)]
+		if !ok {
+			return QueryError(fmt.Sprintf("%q is not a valid %s", clause.Value, clause.Field))
+		}
+		clause.Value = value
+	case _DATE_FIELD:
+		if _, err := parseQueryDate(clause.Value); err != nil {
+			return QueryError(fmt.Sprintf("%q is not a date for %s", clause.Value, clause.Field))
+		}
+	}
+	return nil
+}
+
+// Compiles the query into the filters of the beans keyed by the field paths.
+// The conditions that can't be keyed by one field path go in $and: the url clauses since they match the aliases as well,
+// the days of a date field when there are several of them and the days a date field can't be
+func (query *Query) Filter() store.JSON {
+	filter := make(store.JSON)
+	var and []store.JSON
+	var urls []string
+	days := make(map[string][]time.Time)
+	for _, clause := range query.Clauses {
+		field := _QUERY_FIELDS[clause.Field]
+		if field.kind == _DATE_FIELD && clause.Op == QUERY_EQUALS {
+			day, _ := parseQueryDate(clause.Value)
+			day = day.UTC().Truncate(24 * time.Hour)
+			if clause.Negated {
+				// outside of the day is either before or after it
+				and = append(and, store.JSON{field.path: store.JSON{"$not": getDayCondition(day)}})
+			} else {
+				days[field.path] = append(days[field.path], day)
+			}
+			continue
+		}
+		if field.kind == _URL_FIELD && !clause.Negated {
+			urls = append(urls, getQueryValues(&clause, field)...)
+			continue
+		}
+		if field.kind == _URL_FIELD {
+			// neither the url nor the aliases can be one of them
+			for _, path := range []string{field.path, "aliases"} {
+				condition, ok := filter[path].(store.JSON)
+				if !ok {
+					condition = make(store.JSON)
+					filter[path] = condition
+				}
+				values, _ := condition["$nin"].([]string)
+				condition["$nin"] = append(values, getQueryValues(&clause, field)...)
+			}
+			continue
+		}
+		condition, ok := filter[field.path].(store.JSON)
+		if !ok {
+			condition = make(store.JSON)
+			filter[field.path] = condition
+		}
+		switch field.kind {
+		case _DATE_FIELD:
+			date, _ := parseQueryDate(clause.Value)
+			tightenDateCondition(condition, getQueryOperator(clause.Op), date.Unix())
+		default:
+			op := "$in"
+			if clause.Negated {
+				op = "$nin"
+			}
+			values, _ := condition[op].([]string)
+			condition[op] = append(values, getQueryValues(&clause, field)...)
+		}
+	}
+	for _, path := range []string{CREATED_TIME, UPDATED_TIME} {
+		field_days := days[path]
+		if len(field_days) == 0 {
+			continue
+		}
+		// the field stays keyed by its path with the range of all the days so that it still counts as a time filter
+		condition, ok := filter[path].(store.JSON)
+		if !ok {
+			condition = make(store.JSON)
+			filter[path] = condition
+		}
+		tightenDateCondition(condition, "$gte", slices.MinFunc(field_days, time.Time.Compare).Unix())
+		tightenDateCondition(condition, "$lt", slices.MaxFunc(field_days, time.Time.Compare).AddDate(0, 0, 1).Unix())
+		if len(field_days) > 1 {
+			and = append(and, store.JSON{"$or": datautils.Transform(field_days, func(day *time.Time) store.JSON {
+				return store.JSON{path: getDayCondition(*day)}
+			})})
+		}
+	}
+	if len(urls) > 0 {
+		and = append(and, getUrlFilter(urls))
+	}
+	if len(and) > 0 {
+		filter["$and"] = and
+	}
+	return filter
+}
+
+func getQueryOperator(op string) string {
+	switch op {
+	case QUERY_GREATER:
+		return "$gt"
+	case QUERY_GREATER_EQUAL:
+		return "$gte"
+	case QUERY_LESS:
+		return "$lt"
+	default:
+		return "$lte"
+	}
+}
+
+// the comparisons of the same date field all have to match so only the tightest bound of each operator matters
+func tightenDateCondition(condition store.JSON, op string, value int64) {
+	existing, ok := condition[op].(int64)
+	switch {
+	case !ok:
+		condition[op] = value
+	case op == "$gt" || op == "$gte":
+		condition[op] = max(existing, value)
+	default:
+		condition[op] = min(existing, value)
+	}
+}
+
+// the whole day in UTC
+func getDayCondition(day time.Time) store.JSON {
+	return store.JSON{"$gte": day.Unix(), "$lt": day.AddDate(0, 0, 1).Unix()}
+}
+
+// the stored values the clause value can match
+func getQueryValues(clause *QueryClause, field queryField) []string {
+	switch field.kind {
+	case _URL_FIELD:
+		return resolveUrls([]string{clause.Value})
+	case _CATEGORY_FIELD:
+		return expandCategories([]string{clause.Value})
+	case _ENTITY_FIELD:
+		return expandEntities([]string{clause.Value})
+	case _TEXT_FIELD:
+		// the stored text fields are not consistent about the case. exact matching keeps the filters usable in vector search
+		values := []string{clause.Value, strings.ToLower(clause.Value), strings.ToUpper(clause.Value), toTitleCase(clause.Value)}
+		slices.Sort(values)
+		return slices.Compact(values)
+	default:
+		return []string{clause.Value}
+	}
+}
+
+func parseQueryDate(value string) (time.Time, error) {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func toTitleCase(text string) string {
+	words := strings.Fields(strings.ToLower(text))
+	datautils.ForEach(words, func(word *string) {
+		runes := []rune(*word)
+		runes[0] = unicode.ToUpper(runes[0])
+		*word = string(runes)
+	})
+	return strings.Join(words, " ")
+}
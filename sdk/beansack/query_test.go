@@ -0,0 +1,197 @@
+package beansack
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		want     []QueryClause
+		want_err bool
+	}{
+		{"empty", "  ", nil, false},
+		{"one clause", "source:reddit", []QueryClause{{Field: "source", Op: QUERY_EQUALS, Value: "reddit"}}, false},
+		{"field is case insensitive", "Source:reddit", []QueryClause{{Field: "source", Op: QUERY_EQUALS, Value: "reddit"}}, false},
+		{"negated", "-source:medium", []QueryClause{{Field: "source", Op: QUERY_EQUALS, Value: "medium", Negated: true}}, false},
+		{"quoted", `author:"u/foo bar"`, []QueryClause{{Field: "author", Op: QUERY_EQUALS, Value: "u/foo bar"}}, false},
+		{"escaped quote", `topic:"say \"hi\""`, []QueryClause{{Field: "topic", Op: QUERY_EQUALS, Value: `say "hi"`}}, false},
+		{"date comparison", "created>=2024-05-01", []QueryClause{{Field: "created", Op: QUERY_GREATER_EQUAL, Value: "2024-05-01"}}, false},
+		{"enum alias", "kind:news", []QueryClause{{Field: "kind", Op: QUERY_EQUALS, Value: ARTICLE}}, false},
+		{"several clauses", "source:reddit  kind:post", []QueryClause{
+			{Field: "source", Op: QUERY_EQUALS, Value: "reddit"},
+			{Field: "kind", Op: QUERY_EQUALS, Value: POST},
+		}, false},
+		{"unknown field", "title:foo", nil, true},
+		{"missing operator", "source reddit", nil, true},
+		{"missing value", "source:", nil, true},
+		{"unterminated quote", `author:"foo`, nil, true},
+		{"text compared", "source>reddit", nil, true},
+		{"negated date comparison", "-created>2024-05-01", nil, true},
+		{"not a date", "created:yesterday", nil, true},
+		{"not an enum value", "sentiment:angry", nil, true},
+		{"junk after quote", `author:"foo"bar`, nil, true},
+		// nothing in the query can become an operator or a field path
+		{"operator as field", `$where:1`, nil, true},
+		{"operator after field", `source$ne:1`, nil, true},
+		{"dotted field", `source.x:1`, nil, true},
+		{"operator in enum", `kind:{"$ne":1}`, nil, true},
+		{"operator in date", `created>{"$gt":0}`, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := ParseQuery(test.query)
+			if (err != nil) != test.want_err {
+				t.Fatalf("ParseQuery(%q) error = %v, want error %v", test.query, err, test.want_err)
+			}
+			if !test.want_err && !reflect.DeepEqual(query.Clauses, test.want) {
+				t.Errorf("ParseQuery(%q) = %+v, want %+v", test.query, query.Clauses, test.want)
+			}
+		})
+	}
+}
+
+func TestQueryFilter(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		query string
+		want  store.JSON
+	}{
+		{"text values in every case", "source:reddit", store.JSON{
+			"source": store.JSON{"$in": []string{"REDDIT", "Reddit", "reddit"}},
+		}},
+		{"same field matches any", "kind:post kind:comment", store.JSON{
+			"kind": store.JSON{"$in": []string{POST, COMMENT}},
+		}},
+		{"negated", "kind:post -kind:comment", store.JSON{
+			"kind": store.JSON{"$in": []string{POST}, "$nin": []string{COMMENT}},
+		}},
+		{"date range", "created>2024-05-01 created<=2024-05-01", store.JSON{
+			"created": store.JSON{"$gt": day.Unix(), "$lte": day.Unix()},
+		}},
+		{"whole day", "updated:2024-05-01", store.JSON{
+			"updated": store.JSON{"$gte": day.Unix(), "$lt": day.AddDate(0, 0, 1).Unix()},
+		}},
+		{"not the day", "-updated:2024-05-01", store.JSON{
+			"$and": []store.JSON{{"updated": store.JSON{"$not": store.JSON{"$gte": day.Unix(), "$lt": day.AddDate(0, 0, 1).Unix()}}}},
+		}},
+		{"any of the days", "created:2024-05-01 created:2024-05-03", store.JSON{
+			"created": store.JSON{"$gte": day.Unix(), "$lt": day.AddDate(0, 0, 3).Unix()},
+			"$and": []store.JSON{{"$or": []store.JSON{
+				{"created": store.JSON{"$gte": day.Unix(), "$lt": day.AddDate(0, 0, 1).Unix()}},
+				{"created": store.JSON{"$gte": day.AddDate(0, 0, 2).Unix(), "$lt": day.AddDate(0, 0, 3).Unix()}},
+			}}},
+		}},
+		{"none of the days", "-created:2024-05-01 -created:2024-05-03", store.JSON{
+			"$and": []store.JSON{
+				{"created": store.JSON{"$not": store.JSON{"$gte": day.Unix(), "$lt": day.AddDate(0, 0, 1).Unix()}}},
+				{"created": store.JSON{"$not": store.JSON{"$gte": day.AddDate(0, 0, 2).Unix(), "$lt": day.AddDate(0, 0, 3).Unix()}}},
+			},
+		}},
+		{"day within a range", "created>=2024-05-02 created:2024-05-01 created:2024-05-03", store.JSON{
+			"created": store.JSON{"$gte": day.AddDate(0, 0, 1).Unix(), "$lt": day.AddDate(0, 0, 3).Unix()},
+			"$and": []store.JSON{{"$or": []store.JSON{
+				{"created": store.JSON{"$gte": day.Unix(), "$lt": day.AddDate(0, 0, 1).Unix()}},
+				{"created": store.JSON{"$gte": day.AddDate(0, 0, 2).Unix(), "$lt": day.AddDate(0, 0, 3).Unix()}},
+			}}},
+		}},
+		{"tightest bound", "created>2024-05-01 created>2024-05-03", store.JSON{
+			"created": store.JSON{"$gt": day.AddDate(0, 0, 2).Unix()},
+		}},
+		{"entity id as is", "entity:org:openai", store.JSON{
+			"entities": store.JSON{"$in": []string{"org:openai"}},
+		}},
+		{"url matches the aliases", "url:https://example.com/a", store.JSON{
+			"$and": []store.JSON{getUrlFilter([]string{"https://example.com/a"})},
+		}},
+		{"url canonicalized", "url:http://m.example.com/a/", store.JSON{
+			"$and": []store.JSON{getUrlFilter([]string{"http://m.example.com/a/", "https://example.com/a"})},
+		}},
+		{"negated url", "-url:https://example.com/a", store.JSON{
+			"url":     store.JSON{"$nin": []string{"https://example.com/a"}},
+			"aliases": store.JSON{"$nin": []string{"https://example.com/a"}},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := query.Filter(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Filter(%q) = %v, want %v", test.query, got, test.want)
+			}
+		})
+	}
+}
+
+// the values that look like operators stay values
+func TestQueryFilterInjection(t *testing.T) {
+	queries := []string{
+		`source:{"$ne":1}`,
+		`author:"{\"$gt\": \"\"}"`,
+		`-keyword:{"$regex":".*"}`,
+		`topic:$where`,
+		`url:{"$exists":true}`,
+		`entity:"$in"`,
+	}
+	for _, text := range queries {
+		t.Run(text, func(t *testing.T) {
+			query, err := ParseQuery(text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkFilterKeys(t, text, query.Filter())
+		})
+	}
+}
+
+// every key is a field path or one of the operators Filter generates and every value is a plain string or number
+func checkFilterKeys(t *testing.T, query string, filter store.JSON) {
+	operators := []string{"$in", "$nin", "$gt", "$gte", "$lt", "$lte", "$not", "$and", "$or"}
+	for key, value := range filter {
+		if strings.HasPrefix(key, "$") && !slices.Contains(operators, key) {
+			t.Errorf("Filter(%q) has operator %q", query, key)
+		}
+		switch value := value.(type) {
+		case store.JSON:
+			checkFilterKeys(t, query, value)
+		case []store.JSON:
+			for _, item := range value {
+				checkFilterKeys(t, query, item)
+			}
+		case []string, string, int64:
+		default:
+			t.Errorf("Filter(%q)[%q] is %T", query, key, value)
+		}
+	}
+}
+
+func TestWithQueryAndURLs(t *testing.T) {
+	// the url filters of the options and the query both have to match
+	query, err := ParseQuery("url:https://m.example.com/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := NewSearchOptions().WithURLs([]string{"https://example.com/a"}).WithQuery(query)
+	if _, ok := options.ScalarFilter["$or"]; ok {
+		t.Errorf("ScalarFilter has a top level $or that other filters would overwrite. %v", options.ScalarFilter)
+	}
+	and, _ := options.ScalarFilter["$and"].([]store.JSON)
+	if len(and) != 2 {
+		t.Fatalf("ScalarFilter[$and] = %v, want the 2 url filters", options.ScalarFilter["$and"])
+	}
+	for i, want := range [][]string{{"https://example.com/a"}, {"https://m.example.com/b", "https://example.com/b"}} {
+		if !reflect.DeepEqual(and[i], getUrlFilter(want)) {
+			t.Errorf("ScalarFilter[$and][%d] = %v, want %v", i, and[i], getUrlFilter(want))
+		}
+	}
+}
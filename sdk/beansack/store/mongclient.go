@@ -111,6 +111,30 @@ func (store *Store[T]) Update(docs []any, filters []JSON) {
 	log.Printf("[%s]: %d items updated.\n", store.name, len(updates)-err_count)
 }
 
+// replaces the docs with the same id in one go or inserts the ones that don't exist yet.
+// Unlike Delete followed by Add, a reader never sees the doc missing. This needs the id function
+func (store *Store[T]) Upsert(docs []T) error {
+	if store.get_id == nil {
+		return fmt.Errorf("[%s]: Upsert needs the id function", store.name)
+	}
+	upserts := datautils.Transform(docs, func(item *T) mongo.WriteModel {
+		return mongo.NewReplaceOneModel().
+			SetFilter(store.get_id(item)).
+			SetReplacement(*item).
+			SetUpsert(true)
+	})
+	// only the failures are logged since the caches upsert one item at a time
+	var last_err error
+	for i := 0; i < len(upserts); i += _UPDATE_BATCH_SIZE {
+		batch := datautils.SafeSlice(upserts, i, i+_UPDATE_BATCH_SIZE)
+		if _, err := store.collection.BulkWrite(ctx.Background(), batch); err != nil {
+			log.Printf("[%s]: Upsert failed for docs[%d] - docs[%d]. %v\n", store.name, i, i+len(batch), err)
+			last_err = err
+		}
+	}
+	return last_err
+}
+
 // wrapper over mongodb get
 func (store *Store[T]) Get(filter JSON, fields JSON, sort_by JSON, top_n int) []T {
 	find_options := options.Find()
@@ -130,6 +154,12 @@ func (store *Store[T]) Aggregate(pipeline any) []T {
 	return store.extractFromCursor(store.collection.Aggregate(ctx.Background(), pipeline))
 }
 
+// runs the pipeline over the collection of the store for the results that are not shaped like T such as counts
+func AggregateAs[R, T any](store *Store[T], pipeline any) []R {
+	cursor, err := store.collection.Aggregate(ctx.Background(), pipeline)
+	return extractFromCursor[R](store.name, cursor, err)
+}
+
 // regular keyword/text search
 func (store *Store[T]) TextSearch(query_texts []string, options ...SearchOption) []T {
 	search_pipeline := createTextSearchPipeline(query_texts, options)
@@ -178,9 +208,13 @@ func (store *Store[T]) getIDs(items []T) []JSON {
 }
 
 func (store *Store[T]) extractFromCursor(cursor *mongo.Cursor, err error) []T {
+	return extractFromCursor[T](store.name, cursor, err)
+}
+
+func extractFromCursor[T any](name string, cursor *mongo.Cursor, err error) []T {
 	background := ctx.Background()
 	if err != nil {
-		log.Printf("[%s]: Couldn't retrieve items. %v\n", store.name, err)
+		log.Printf("[%s]: Couldn't retrieve items. %v\n", name, err)
 		return nil
 	}
 	defer cursor.Close(background)
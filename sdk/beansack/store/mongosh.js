@@ -67,6 +67,54 @@ db.beans.createIndex(
   }
 );
 
+// the publish time filters
+db.beans.createIndex(
+  { created: -1 },
+  { name: "beans_created" }
+);
+
+// the beans the subscriptions alert
+db.beans.createIndex(
+  { enriched: -1 },
+  { name: "beans_enriched" }
+);
+
+// the category filters
+db.beans.createIndex(
+  { "categories.id": 1 },
+  { name: "beans_categories" }
+);
+
+// the sentiment filters
+db.beans.createIndex(
+  { sentiment: 1 },
+  { name: "beans_sentiment" }
+);
+
+// the entity filters
+db.beans.createIndex(
+  { entities: 1 },
+  { name: "beans_entities" }
+);
+
+// the language filters
+db.beans.createIndex(
+  { language: 1 },
+  { name: "beans_language" }
+);
+
+// the beans of a story
+db.beans.createIndex(
+  { story_id: 1 },
+  { name: "beans_story" }
+);
+
+// the originals and their near-duplicates
+db.beans.createIndex(
+  { duplicate_of: 1 },
+  { name: "beans_duplicate_of" }
+);
+
 db.beans.createIndex(
   {
       title: "text",
@@ -79,6 +127,78 @@ db.beans.createIndex(
   }
 );
 
+// looking up beans by the urls they are also known as
+db.beans.createIndex(
+  { aliases: 1 },
+  { name: "beans_aliases" }
+);
+
+// near-duplicate lookups
+db.beans.createIndex(
+  { fingerprint_bands: 1 },
+  { name: "beans_fingerprint_bands" }
+);
+
+// INDEXES FOR ENTITIES
+// the names an entity is known as
+db.entities.createIndex(
+  { keys: 1 },
+  { name: "entities_keys" }
+);
+
+// names that don't normalize to the same key are matched by similarity within the same type
+db.entities.createIndex(
+  { type: 1 },
+  { name: "entities_scalar_search" }
+);
+
+db.runCommand(
+  {
+    "createIndexes": "entities",
+    "indexes": [
+      {
+        "name": "entities_vector_search",
+        "key": 
+        {
+          "embeddings": "cosmosSearch"
+        },
+        "cosmosSearchOptions": 
+        {
+          "kind": "vector-ivf",
+          "numLists": 10,
+          "similarity": "COS",
+          "dimensions": 768
+        }
+      }
+    ]
+  }
+);
+
+// INDEXES FOR ENGAGEMENT HISTORY
+db.engagements.createIndex(
+  { mapped_url: 1, timestamp: 1 },
+  { name: "engagements_history" }
+);
+
+// INDEXES FOR MEDIA NOISES
+// the channel facet looks up the media noises of the beans
+db.noises.createIndex(
+  { mapped_url: 1, updated: -1 },
+  { name: "noises_mapped_url" }
+);
+
+// INDEXES FOR SUBSCRIPTIONS
+db.subscriptions.createIndex(
+  { owner: 1, name: 1 },
+  { name: "subscriptions_owner_name", unique: true }
+);
+
+// the beans already delivered to each owner
+db.deliveries.createIndex(
+  { owner: 1, mapped_url: 1 },
+  { name: "deliveries_owner_url", unique: true }
+);
+
 // INDEXES FOR CONCEPTS/NEWS NUGGETS
 // text searching news nuggets
 db.concepts.createIndex(
@@ -127,4 +247,31 @@ db.runCommand(
       }
     ]
   }
-);
\ No newline at end of file
+);
+// INDEXES FOR STORIES
+db.stories.createIndex(
+  { last_seen: -1 }, 
+  { name: "stories_scalar_search"}
+);
+
+db.runCommand(
+  {
+    "createIndexes": "stories",
+    "indexes": [
+      {
+        "name": "stories_centroid_search",
+        "key": 
+        {
+          "centroid": "cosmosSearch"
+        },
+        "cosmosSearchOptions": 
+        {
+          "kind": "vector-ivf",
+          "numLists": 10,
+          "similarity": "COS",
+          "dimensions": 768
+        }
+      }
+    ]
+  }
+);
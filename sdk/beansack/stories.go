@@ -0,0 +1,236 @@
+package beansack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
+)
+
+const (
+	_STORY_WINDOW          = 3    // days after its last bean that a story can still get new beans
+	_STORY_MATCH_SCORE     = 0.82 // combined similarity for a bean to join a story
+	_STORY_EMBEDDINGS_PART = 0.8  // share of the embeddings similarity in the combined one. The rest is title similarity
+	_STORY_SEARCH_FACTOR   = 3    // beans to search for per requested one when returning one per story
+	_STORY_CANDIDATES      = 5    // nearest stories to compare each bean with
+)
+
+// A group of beans covering the same event
+type Story struct {
+	ID        string    `json:"id" bson:"_id"`
+	Title     string    `json:"title,omitempty" bson:"title,omitempty"` // title of the first bean of the story
+	Urls      []string  `json:"urls,omitempty" bson:"urls,omitempty"`
+	FirstSeen int64     `json:"first_seen,omitempty" bson:"first_seen,omitempty"`
+	LastSeen  int64     `json:"last_seen,omitempty" bson:"last_seen,omitempty"`
+	Centroid  []float32 `json:"-" bson:"centroid,omitempty"` // mean of the category embeddings of the beans
+	// media noise across all the beans of the story
+	Likes      int `json:"likes,omitempty" bson:"likes,omitempty"`
+	Comments   int `json:"comments,omitempty" bson:"comments,omitempty"`
+	NoiseScore int `json:"score,omitempty" bson:"score,omitempty"`
+}
+
+func getStoryId(story *Story) store.JSON {
+	return store.JSON{"_id": story.ID}
+}
+
+func storyEquals(a, b *Story) bool {
+	return a.ID == b.ID
+}
+
+// Returns the stories by their ids
+func GetStories(ids []string) []Story {
+	return storystore.Get(store.JSON{"_id": store.JSON{"$in": ids}}, store.JSON{"centroid": 0}, nil, -1)
+}
+
+// Assigns each bean to a story. This is incremental single pass agglomerative clustering:
+// each bean joins the most similar story that was active within the story window or starts a new one.
+// The similarity is a blend of the cosine similarity to the story centroid and the title overlap.
+// The candidate stories of each bean come from a vector search over the story centroids.
+// The beans need to have their category_embeddings generated before this
+func clusterBeans(beans []Bean) ([]any, []store.JSON) {
+	urls := datautils.Transform(beans, func(item *Bean) string { return item.Url })
+	beans = beanstore.Get(
+		store.JSON{
+			"url":               store.JSON{"$in": urls},
+			_CLASSIFICATION_EMB: store.JSON{"$exists": true},
+		},
+		store.JSON{"url": 1, "title": 1, "updated": 1, _CLASSIFICATION_EMB: 1},
+		nil, -1)
+	if len(beans) == 0 {
+		return nil, nil
+	}
+
+	story_ids, changed_stories := assignStories(beans, searchStories)
+	updates := datautils.Transform(story_ids, func(id *string) any { return Bean{StoryID: *id} })
+	filters := datautils.Transform(beans, func(bean *Bean) store.JSON { return getBeanId(bean) })
+	// the existing ones get updated and the new ones get added
+	storystore.Upsert(changed_stories)
+	log.Printf("[beanops] Clustered %d beans into %d stories.\n", len(beans), len(changed_stories))
+	return updates, filters
+}
+
+// the stories active within the story window with the centroids nearest to the bean
+func searchStories(bean *Bean) []Story {
+	return storystore.VectorSearch(
+		[][]float32{bean.CategoryEmbeddings},
+		"centroid",
+		store.WithVectorFilter(store.JSON{"last_seen": store.JSON{"$gte": timeValue(_STORY_WINDOW)}}),
+		store.WithVectorTopN(_STORY_CANDIDATES),
+	)
+}
+
+// assigns each bean to the most similar of its candidate stories and the stories the earlier beans of the batch changed.
+// The beans are sorted older ones first so that a story's title comes from its earliest bean.
+// Returns the story id of each bean in that order and the stories that changed
+func assignStories(beans []Bean, candidates func(bean *Bean) []Story) ([]string, []Story) {
+	sort.SliceStable(beans, func(i, j int) bool { return beans[i].Updated < beans[j].Updated })
+	// the stored centroids are stale for the stories of this batch so these take precedence over the search results
+	changed := make([]Story, 0, len(beans))
+	story_ids := make([]string, 0, len(beans))
+	datautils.ForEach(beans, func(bean *Bean) {
+		stories := append([]Story{}, changed...)
+		datautils.ForEach(candidates(bean), func(story *Story) {
+			if !datautils.In(*story, stories, storyEquals) {
+				stories = append(stories, *story)
+			}
+		})
+		var story Story
+		if i, score := findStory(bean, stories); score >= _STORY_MATCH_SCORE {
+			story = stories[i]
+			joinStory(&story, bean)
+		} else {
+			story = newStory(bean)
+		}
+		if i := datautils.IndexAny(changed, func(item *Story) bool { return item.ID == story.ID }); i >= 0 {
+			changed[i] = story
+		} else {
+			changed = append(changed, story)
+		}
+		story_ids = append(story_ids, story.ID)
+	})
+	return story_ids, changed
+}
+
+// index of the most similar story and the similarity. -1 if there is no story
+func findStory(bean *Bean, stories []Story) (int, float64) {
+	index, max_score := -1, 0.0
+	title_words := toTitleWords(bean.Title)
+	for i := range stories {
+		score := _STORY_EMBEDDINGS_PART*cosineSimilarity(bean.CategoryEmbeddings, stories[i].Centroid) +
+			(1-_STORY_EMBEDDINGS_PART)*jaccardSimilarity(title_words, toTitleWords(stories[i].Title))
+		if score > max_score {
+			index, max_score = i, score
+		}
+	}
+	return index, max_score
+}
+
+func newStory(bean *Bean) Story {
+	hash := sha256.Sum256([]byte(bean.Url))
+	return Story{
+		ID:        hex.EncodeToString(hash[:8]),
+		Title:     bean.Title,
+		Urls:      []string{bean.Url},
+		FirstSeen: bean.Updated,
+		LastSeen:  bean.Updated,
+		Centroid:  slices.Clone(bean.CategoryEmbeddings),
+	}
+}
+
+func joinStory(story *Story, bean *Bean) {
+	if slices.Contains(story.Urls, bean.Url) {
+		return
+	}
+	// running mean of the embeddings
+	count := float32(len(story.Urls))
+	for i := range story.Centroid {
+		story.Centroid[i] = (story.Centroid[i]*count + bean.CategoryEmbeddings[i]) / (count + 1)
+	}
+	story.Urls = append(story.Urls, bean.Url)
+	story.LastSeen = max(story.LastSeen, bean.Updated)
+}
+
+// aggregates the media noises of the beans of the stories active in the window
+func updateStoryNoises(window int) {
+	stories := storystore.Get(store.JSON{"last_seen": store.JSON{"$gte": timeValue(window)}}, store.JSON{"_id": 1, "urls": 1}, nil, -1)
+	if len(stories) == 0 {
+		return
+	}
+	urls := make([]string, 0, len(stories))
+	datautils.ForEach(stories, func(story *Story) { urls = append(urls, story.Urls...) })
+	noises := getMediaNoises(datautils.Transform(urls, func(url *string) Bean { return Bean{Url: *url} }), false)
+
+	totals := sumStoryNoises(stories, noises)
+	updates := make([]any, 0, len(totals))
+	filters := make([]store.JSON, 0, len(totals))
+	for id, total := range totals {
+		updates = append(updates, store.JSON{"likes": total.Likes, "comments": total.Comments, "score": total.NoiseScore})
+		filters = append(filters, store.JSON{"_id": id})
+	}
+	storystore.Update(updates, filters)
+}
+
+// the media noises of the beans of each story added up by the story id. The noises of the beans in no story are left out
+func sumStoryNoises(stories []Story, noises []MediaNoise) map[string]*Story {
+	story_urls := make(map[string]string)
+	datautils.ForEach(stories, func(story *Story) {
+		datautils.ForEach(story.Urls, func(url *string) { story_urls[*url] = story.ID })
+	})
+	totals := make(map[string]*Story)
+	datautils.ForEach(noises, func(noise *MediaNoise) {
+		id, ok := story_urls[noise.BeanUrl]
+		if !ok {
+			return
+		}
+		if _, ok := totals[id]; !ok {
+			totals[id] = &Story{}
+		}
+		totals[id].Likes += noise.ThumbsupCount
+		totals[id].Comments += noise.Comments
+		totals[id].NoiseScore += noise.Score
+	})
+	return totals
+}
+
+// keeps the first bean of each story in the given order. The beans without a story are kept as is
+func onePerStory(beans []Bean) []Bean {
+	seen := make(map[string]bool)
+	return datautils.Filter(beans, func(item *Bean) bool {
+		if item.StoryID == "" {
+			return true
+		}
+		if seen[item.StoryID] {
+			return false
+		}
+		seen[item.StoryID] = true
+		return true
+	})
+}
+
+func toTitleWords(title string) []string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	// short words are mostly stopwords
+	return datautils.Filter(words, func(word *string) bool { return len(*word) > 2 })
+}
+
+func jaccardSimilarity(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	intersection := 0
+	union := len(b)
+	for _, word := range a {
+		if slices.Contains(b, word) {
+			intersection++
+		} else {
+			union++
+		}
+	}
+	return float64(intersection) / float64(union)
+}
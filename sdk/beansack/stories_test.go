@@ -0,0 +1,158 @@
+package beansack
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssignStories(t *testing.T) {
+	existing := Story{ID: "existing", Title: "Fortra patches FileCatalyst Workflow flaw", Urls: []string{"https://a.com/1"}, Centroid: []float32{1, 0}}
+	tests := []struct {
+		name        string
+		beans       []Bean
+		candidates  []Story
+		want_ids    []string
+		want_counts map[string]int // urls of each changed story
+	}{
+		{
+			name:        "joins the candidate",
+			beans:       []Bean{{Url: "https://b.com/1", Title: "FileCatalyst Workflow flaw patched", Updated: 10, CategoryEmbeddings: []float32{1, 0}}},
+			candidates:  []Story{existing},
+			want_ids:    []string{"existing"},
+			want_counts: map[string]int{"existing": 2},
+		},
+		{
+			name:        "starts a new story when nothing is similar",
+			beans:       []Bean{{Url: "https://b.com/1", Title: "Local elections results", Updated: 10, CategoryEmbeddings: []float32{0, 1}}},
+			candidates:  []Story{existing},
+			want_ids:    []string{newStory(&Bean{Url: "https://b.com/1"}).ID},
+			want_counts: map[string]int{newStory(&Bean{Url: "https://b.com/1"}).ID: 1},
+		},
+		{
+			name:        "no candidates",
+			beans:       []Bean{{Url: "https://b.com/1", Title: "FileCatalyst Workflow flaw patched", Updated: 10, CategoryEmbeddings: []float32{1, 0}}},
+			want_ids:    []string{newStory(&Bean{Url: "https://b.com/1"}).ID},
+			want_counts: map[string]int{newStory(&Bean{Url: "https://b.com/1"}).ID: 1},
+		},
+		{
+			name: "later beans join the stories of the earlier beans of the batch",
+			beans: []Bean{
+				{Url: "https://c.com/2", Title: "Rust compiler release notes", Updated: 20, CategoryEmbeddings: []float32{0, 1}},
+				{Url: "https://c.com/1", Title: "Rust compiler release", Updated: 10, CategoryEmbeddings: []float32{0, 1}},
+			},
+			want_ids:    []string{newStory(&Bean{Url: "https://c.com/1"}).ID, newStory(&Bean{Url: "https://c.com/1"}).ID},
+			want_counts: map[string]int{newStory(&Bean{Url: "https://c.com/1"}).ID: 2},
+		},
+		{
+			name:        "already in the story",
+			beans:       []Bean{{Url: "https://a.com/1", Title: existing.Title, Updated: 10, CategoryEmbeddings: []float32{1, 0}}},
+			candidates:  []Story{existing},
+			want_ids:    []string{"existing"},
+			want_counts: map[string]int{"existing": 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			candidates := func(bean *Bean) []Story {
+				return cloneStories(test.candidates)
+			}
+			ids, changed := assignStories(test.beans, candidates)
+			if !reflect.DeepEqual(ids, test.want_ids) {
+				t.Errorf("story ids = %v, want %v", ids, test.want_ids)
+			}
+			counts := make(map[string]int)
+			for _, story := range changed {
+				counts[story.ID] = len(story.Urls)
+			}
+			if !reflect.DeepEqual(counts, test.want_counts) {
+				t.Errorf("changed stories = %v, want %v", counts, test.want_counts)
+			}
+		})
+	}
+}
+
+func TestAssignStoriesKeepsTheEarliestTitle(t *testing.T) {
+	beans := []Bean{
+		{Url: "https://c.com/2", Title: "Rust compiler release notes", Updated: 20, CategoryEmbeddings: []float32{0, 1}},
+		{Url: "https://c.com/1", Title: "Rust compiler release", Updated: 10, CategoryEmbeddings: []float32{0, 1}},
+	}
+	_, changed := assignStories(beans, func(bean *Bean) []Story { return nil })
+	if len(changed) != 1 || changed[0].Title != "Rust compiler release" || changed[0].FirstSeen != 10 || changed[0].LastSeen != 20 {
+		t.Errorf("changed stories = %+v, want one titled by the earliest bean seen from 10 to 20", changed)
+	}
+}
+
+func TestJoinStory(t *testing.T) {
+	story := newStory(&Bean{Url: "https://a.com/1", Updated: 10, CategoryEmbeddings: []float32{1, 0}})
+	joinStory(&story, &Bean{Url: "https://b.com/1", Updated: 5, CategoryEmbeddings: []float32{0, 1}})
+	if !reflect.DeepEqual(story.Centroid, []float32{0.5, 0.5}) {
+		t.Errorf("centroid = %v, want the mean of the beans", story.Centroid)
+	}
+	if story.LastSeen != 10 {
+		t.Errorf("last seen = %d, want the latest bean", story.LastSeen)
+	}
+	joinStory(&story, &Bean{Url: "https://b.com/1", Updated: 30, CategoryEmbeddings: []float32{0, 1}})
+	if len(story.Urls) != 2 || story.LastSeen != 10 {
+		t.Errorf("joining twice changed the story: %+v", story)
+	}
+}
+
+func TestOnePerStory(t *testing.T) {
+	tests := []struct {
+		name  string
+		beans []Bean
+		want  []string
+	}{
+		{"first of each story", []Bean{{Url: "1", StoryID: "a"}, {Url: "2", StoryID: "b"}, {Url: "3", StoryID: "a"}}, []string{"1", "2"}},
+		{"without a story", []Bean{{Url: "1"}, {Url: "2"}, {Url: "3", StoryID: "a"}}, []string{"1", "2", "3"}},
+		{"order kept", []Bean{{Url: "3", StoryID: "b"}, {Url: "1", StoryID: "a"}, {Url: "2", StoryID: "b"}}, []string{"3", "1"}},
+		{"empty", nil, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, bean := range onePerStory(test.beans) {
+				got = append(got, bean.Url)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("onePerStory() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSumStoryNoises(t *testing.T) {
+	stories := []Story{
+		{ID: "a", Urls: []string{"1", "2"}},
+		{ID: "b", Urls: []string{"3"}},
+		{ID: "quiet", Urls: []string{"4"}},
+	}
+	noises := []MediaNoise{
+		{BeanUrl: "1", ThumbsupCount: 10, Comments: 2, Score: 20},
+		{BeanUrl: "2", ThumbsupCount: 5, Comments: 1, Score: 7},
+		{BeanUrl: "3", ThumbsupCount: 1, Comments: 0, Score: 1},
+		{BeanUrl: "not in a story", ThumbsupCount: 100, Comments: 100, Score: 100},
+	}
+	got := make(map[string]Story)
+	for id, total := range sumStoryNoises(stories, noises) {
+		got[id] = *total
+	}
+	want := map[string]Story{
+		"a": {Likes: 15, Comments: 3, NoiseScore: 27},
+		"b": {Likes: 1, Comments: 0, NoiseScore: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sumStoryNoises() = %+v, want %+v", got, want)
+	}
+}
+
+// fresh copies of the stories like a search would return
+func cloneStories(stories []Story) []Story {
+	clones := make([]Story, len(stories))
+	for i, story := range stories {
+		clones[i] = story
+		clones[i].Urls = append([]string{}, story.Urls...)
+		clones[i].Centroid = append([]float32{}, story.Centroid...)
+	}
+	return clones
+}
@@ -0,0 +1,279 @@
+package beansack
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
+)
+
+const (
+	_ALERT_TOPN      = 25 // beans per alert
+	_ALERT_MAX_PAGES = 20 // alerts per saved search per collection run
+)
+
+// A saved search. The new beans matching it get delivered to its owner after each collection run
+type Subscription struct {
+	Name  string `json:"name" bson:"name"`
+	Owner string `json:"owner" bson:"owner"` // the user the alerts go to. Nobody gets the same bean twice across their subscriptions
+	// the SearchOptions fields
+	Query      string   `json:"q,omitempty" bson:"query,omitempty"` // such as `source:reddit keyword:ransomware`. see ParseQuery
+	Kinds      []string `json:"kinds,omitempty" bson:"kinds,omitempty"`
+	Categories []string `json:"categories,omitempty" bson:"categories,omitempty"` // ids or names of the categories in the taxonomy
+	Entities   []string `json:"entities,omitempty" bson:"entities,omitempty"`
+	Sentiments []string `json:"sentiments,omitempty" bson:"sentiments,omitempty"`
+	Languages  []string `json:"languages,omitempty" bson:"languages,omitempty"`
+	// vector searched the same way as the categories and the context of /beans/search
+	SearchTexts []string `json:"search_texts,omitempty" bson:"search_texts,omitempty"`
+	Context     string   `json:"context,omitempty" bson:"context,omitempty"`
+	// the lowest vector search score of a match on top of the default threshold. 0 means the default threshold
+	MinScore float64        `json:"min_score,omitempty" bson:"min_score,omitempty"`
+	Notify   []NotifyTarget `json:"notify" bson:"notify"`
+	Created  int64          `json:"created,omitempty" bson:"created,omitempty"`
+	// the beans enriched before this got delivered. It only moves when the alerts go through so the failed ones are tried again
+	LastAlerted int64 `json:"last_alerted,omitempty" bson:"last_alerted,omitempty"`
+}
+
+// where the alerts of a subscription go
+type NotifyTarget struct {
+	Notifier string `json:"notifier" bson:"notifier"` // WEBHOOK_NOTIFIER, SLACK_NOTIFIER, EMAIL_NOTIFIER or one added through WithNotifier
+	Target   string `json:"target" bson:"target"`     // the url or the email address
+}
+
+// the new beans of a subscription in a collection run
+type Alert struct {
+	Subscription string `json:"subscription"`
+	Owner        string `json:"owner"`
+	Beans        []Bean `json:"beans"`
+}
+
+// a bean alerted to an owner
+type delivery struct {
+	Owner        string `bson:"owner"`
+	BeanUrl      string `bson:"mapped_url"`
+	Subscription string `bson:"subscription"`
+	Delivered    int64  `bson:"delivered"`
+}
+
+func getSubscriptionId(sub *Subscription) store.JSON {
+	return store.JSON{"owner": sub.Owner, "name": sub.Name}
+}
+
+func subscriptionEquals(a, b *Subscription) bool {
+	return a.Owner == b.Owner && a.Name == b.Name
+}
+
+func getDeliveryId(item *delivery) store.JSON {
+	return store.JSON{"owner": item.Owner, "mapped_url": item.BeanUrl}
+}
+
+func deliveryEquals(a, b *delivery) bool {
+	return a.Owner == b.Owner && a.BeanUrl == b.BeanUrl
+}
+
+// Validates the subscription and stores it. This replaces the subscription of the owner with the same name
+func SaveSubscription(sub *Subscription) error {
+	if sub.Name == "" || sub.Owner == "" {
+		return BeanSackError("subscription needs a name and an owner")
+	}
+	if _, err := sub.searchOptions(); err != nil {
+		return err
+	}
+	if len(sub.Notify) == 0 {
+		return BeanSackError("subscription needs at least one notify target")
+	}
+	for i := range sub.Notify {
+		target := &sub.Notify[i]
+		notifier, ok := notifiers[target.Notifier]
+		if !ok {
+			return BeanSackError(fmt.Sprintf("%q is not a notifier", target.Notifier))
+		}
+		normalized, err := notifier.Validate(target.Target)
+		if err != nil {
+			return err
+		}
+		target.Target = normalized
+	}
+	sub.Created = time.Now().Unix()
+	sub.LastAlerted = 0
+	return subscriptionstore.Upsert([]Subscription{*sub})
+}
+
+func GetSubscriptions(owner string) []Subscription {
+	return subscriptionstore.Get(store.JSON{"owner": owner}, store.JSON{"_id": 0}, store.JSON{"name": 1}, -1)
+}
+
+func DeleteSubscription(owner, name string) {
+	subscriptionstore.Delete(store.JSON{"owner": owner, "name": name})
+}
+
+// Delivers the beans that match the saved searches and haven't been delivered to their owners yet.
+// It runs after each collection run with the start time of the run.
+// The beans are alerted by the time their last generated field got written instead of their update time so that the ones
+// still waiting for Rectify get alerted again in the run that fills them in.
+// Algorithm:
+//  1. Search for the original beans enriched since the last alert of each subscription, or since `since` if it never got one
+//  2. Drop the ones below the minimum score of the subscription and the ones already delivered to the owner
+//  3. Send an Alert to each notify target of the subscription
+//  4. Record the beans as delivered if any of the targets got them
+//  5. Repeat with the next _ALERT_TOPN beans until there are no more
+//  6. Move the last alert of the subscription to the start of this if every alert got delivered. Otherwise they are tried again in the next run
+func AlertSubscribers(since int64) {
+	until := time.Now().Unix()
+	subs := subscriptionstore.Get(store.JSON{}, store.JSON{"_id": 0}, store.JSON{"owner": 1, "name": 1}, -1)
+	for i := range subs {
+		sub := &subs[i]
+		options, err := sub.alertOptions(since, until)
+		if err != nil {
+			log.Printf("[subscriptions] Skipping %s of %s. %v\n", sub.Name, sub.Owner, err)
+			continue
+		}
+		// 6. move the watermark
+		if alertSubscriber(sub, options) {
+			subscriptionstore.Update([]any{store.JSON{"last_alerted": until}}, []store.JSON{getSubscriptionId(sub)})
+		}
+	}
+}
+
+// the search options of the original beans enriched since the last alert of the subscription, or since `since` if it never got one
+func (sub *Subscription) alertOptions(since, until int64) (*SearchOptions, error) {
+	options, err := sub.searchOptions()
+	if err != nil {
+		return nil, err
+	}
+	from := sub.LastAlerted
+	if from == 0 {
+		from = since
+	}
+	options.WithTopN(_ALERT_TOPN).WithExplain(true)
+	options.ScalarFilter[_ENRICHED] = store.JSON{"$gte": from, "$lt": until}
+	options.ScalarFilter["duplicate_of"] = store.JSON{"$exists": false}
+	return options, nil
+}
+
+// sends the beans matching the options to the targets of the subscription page by page, _ALERT_MAX_PAGES at most.
+// false if any of the alerts failed
+func alertSubscriber(sub *Subscription, options *SearchOptions) bool {
+	return pageAlerts(sub, options, FuzzySearch, func(beans []Bean) bool {
+		beans = filterUndelivered(sub.Owner, beans)
+		if len(beans) == 0 {
+			return true
+		}
+		beans = datautils.ForEach(beans, func(bean *Bean) { bean.Explanation = nil })
+		// 3. send the alert
+		if !notifySubscriber(sub, &Alert{Subscription: sub.Name, Owner: sub.Owner, Beans: beans}) {
+			return false
+		}
+		// 4. record the deliveries
+		now := time.Now().Unix()
+		deliverystore.Add(datautils.Transform(beans, func(bean *Bean) delivery {
+			return delivery{Owner: sub.Owner, BeanUrl: bean.Url, Subscription: sub.Name, Delivered: now}
+		}))
+		log.Printf("[subscriptions] Delivered %d beans for %s of %s.\n", len(beans), sub.Name, sub.Owner)
+		return true
+	})
+}
+
+// searches the pages of the beans matching the options and hands the matches of each page to deliver.
+// It stops at the last page, at the first weak match or when deliver fails. false if deliver failed
+func pageAlerts(sub *Subscription, options *SearchOptions, search func(*SearchOptions) []Bean, deliver func([]Bean) bool) bool {
+	var searched []string
+	for page := 0; page < _ALERT_MAX_PAGES; page++ {
+		// 1. search for the next page of the new beans
+		page_options := *options
+		if len(searched) > 0 {
+			page_options.ScalarFilter = options.copyScalarFilter()
+			page_options.withAnd(store.JSON{"url": store.JSON{"$nin": searched}})
+		}
+		beans := search(&page_options)
+		if len(beans) == 0 {
+			return true
+		}
+		searched = append(searched, datautils.Transform(beans, func(bean *Bean) string { return bean.Url })...)
+		last_page := len(beans) < options.TopN
+
+		// 2. drop the weak matches. deliver drops the ones the owner already got
+		matches := filterMinScore(beans, sub.MinScore)
+		// the vector search results come best first so the next pages are even weaker
+		last_page = last_page || len(matches) < len(beans)
+		if len(matches) > 0 && !deliver(matches) {
+			return false
+		}
+		// 5. next page
+		if last_page {
+			return true
+		}
+	}
+	// trying them again would only find the same pages
+	log.Printf("[subscriptions] %s of %s has more than %d pages of new beans. Dropping the rest.\n", sub.Name, sub.Owner, _ALERT_MAX_PAGES)
+	return true
+}
+
+// the beans that didn't come from a vector search or scored at least min_score in it
+func filterMinScore(beans []Bean, min_score float64) []Bean {
+	return datautils.Filter(beans, func(bean *Bean) bool {
+		return bean.Explanation == nil || bean.Explanation.Mode != VECTOR_SEARCH_MODE || bean.Explanation.VectorScore >= min_score
+	})
+}
+
+// true if any of the targets got the alert
+func notifySubscriber(sub *Subscription, alert *Alert) bool {
+	delivered := false
+	datautils.ForEach(sub.Notify, func(target *NotifyTarget) {
+		notifier, ok := notifiers[target.Notifier]
+		if !ok {
+			log.Printf("[subscriptions] %q is not a notifier anymore for %s of %s.\n", target.Notifier, sub.Name, sub.Owner)
+			return
+		}
+		if err := notifier.Notify(target.Target, alert); err != nil {
+			log.Printf("[subscriptions] Failed notifying %s of %s through %s. %v\n", sub.Name, sub.Owner, target.Notifier, err)
+			return
+		}
+		delivered = true
+	})
+	return delivered
+}
+
+func (sub *Subscription) searchOptions() (*SearchOptions, error) {
+	options := NewSearchOptions()
+	if sub.Query != "" {
+		query, err := ParseQuery(sub.Query)
+		if err != nil {
+			return nil, err
+		}
+		options.WithQuery(query)
+	}
+	if len(sub.Kinds) > 0 {
+		options.WithKind(sub.Kinds)
+	}
+	options.WithCategory(sub.Categories).
+		WithEntities(sub.Entities).
+		WithSentiment(sub.Sentiments).
+		WithLanguage(sub.Languages)
+	options.SearchTexts = sub.SearchTexts
+	options.Context = sub.Context
+	return options, nil
+}
+
+// the beans that haven't been delivered to the owner
+func filterUndelivered(owner string, beans []Bean) []Bean {
+	if len(beans) == 0 {
+		return beans
+	}
+	delivered := deliverystore.Get(
+		store.JSON{
+			"owner":      owner,
+			"mapped_url": store.JSON{"$in": datautils.Transform(beans, func(item *Bean) string { return item.Url })},
+		},
+		store.JSON{"mapped_url": 1},
+		nil, -1)
+	return excludeDelivered(beans, delivered)
+}
+
+func excludeDelivered(beans []Bean, delivered []delivery) []Bean {
+	return datautils.Filter(beans, func(bean *Bean) bool {
+		return datautils.IndexAny(delivered, func(item *delivery) bool { return item.BeanUrl == bean.Url }) < 0
+	})
+}
@@ -0,0 +1,199 @@
+package beansack
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+)
+
+// a search over the beans that skips the urls of the earlier pages like the $nin of pageAlerts does
+func fakeAlertSearch(beans []Bean, searches *int) func(*SearchOptions) []Bean {
+	return func(options *SearchOptions) []Bean {
+		*searches++
+		var searched []string
+		and, _ := options.ScalarFilter["$and"].([]store.JSON)
+		for _, condition := range and {
+			if url, ok := condition["url"].(store.JSON); ok {
+				searched = append(searched, url["$nin"].([]string)...)
+			}
+		}
+		page := make([]Bean, 0, options.TopN)
+		for _, bean := range beans {
+			if len(page) == options.TopN {
+				break
+			}
+			if !slices.Contains(searched, bean.Url) {
+				page = append(page, bean)
+			}
+		}
+		return page
+	}
+}
+
+// beans of a vector search with the scores
+func vectorBeans(scores ...float64) []Bean {
+	beans := make([]Bean, len(scores))
+	for i, score := range scores {
+		beans[i] = Bean{Url: fmt.Sprintf("https://example.com/%d", i), Explanation: &SearchExplanation{Mode: VECTOR_SEARCH_MODE, VectorScore: score}}
+	}
+	return beans
+}
+
+// beans of a scalar search
+func getBeans(count int) []Bean {
+	beans := make([]Bean, count)
+	for i := range beans {
+		beans[i] = Bean{Url: fmt.Sprintf("https://example.com/%d", i), Explanation: &SearchExplanation{Mode: GET_SEARCH_MODE}}
+	}
+	return beans
+}
+
+func TestPageAlerts(t *testing.T) {
+	tests := []struct {
+		name          string
+		beans         []Bean
+		min_score     float64
+		fail_delivery int // the delivery that fails. 0 means none
+		want_ok       bool
+		want_searches int
+		want_pages    []int // beans per delivery
+	}{
+		{"nothing new", nil, 0, 0, true, 1, nil},
+		{"one short page", getBeans(2), 0, 0, true, 1, []int{2}},
+		{"a full page and then nothing", getBeans(3), 0, 0, true, 2, []int{3}},
+		{"full pages and a short one", getBeans(7), 0, 0, true, 3, []int{3, 3, 1}},
+		{"more than the max pages", getBeans(3 * (_ALERT_MAX_PAGES + 2)), 0, 0, true, _ALERT_MAX_PAGES, repeat(3, _ALERT_MAX_PAGES)},
+		{"stops at the first weak match", vectorBeans(0.9, 0.85, 0.8, 0.78, 0.7, 0.6, 0.5), 0.75, 0, true, 2, []int{3, 1}},
+		{"weak matches only", vectorBeans(0.7, 0.6, 0.5, 0.4), 0.75, 0, true, 1, nil},
+		{"no min score", vectorBeans(0.9, 0.6, 0.5, 0.4), 0, 0, true, 2, []int{3, 1}},
+		{"failed delivery stops", getBeans(9), 0, 2, false, 2, []int{3, 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sub := &Subscription{Name: "test", Owner: "alice", MinScore: test.min_score}
+			options := NewSearchOptions().WithTopN(3)
+			searches := 0
+			var pages []int
+			ok := pageAlerts(sub, options, fakeAlertSearch(test.beans, &searches), func(beans []Bean) bool {
+				pages = append(pages, len(beans))
+				return len(pages) != test.fail_delivery
+			})
+			if ok != test.want_ok {
+				t.Errorf("pageAlerts() = %v, want %v", ok, test.want_ok)
+			}
+			if searches != test.want_searches {
+				t.Errorf("searches = %d, want %d", searches, test.want_searches)
+			}
+			if !reflect.DeepEqual(pages, test.want_pages) {
+				t.Errorf("deliveries = %v, want %v", pages, test.want_pages)
+			}
+			if _, ok := options.ScalarFilter["$and"]; ok {
+				t.Errorf("pageAlerts() changed the options to %v", options.ScalarFilter)
+			}
+		})
+	}
+}
+
+func repeat(value, count int) []int {
+	values := make([]int, count)
+	for i := range values {
+		values[i] = value
+	}
+	return values
+}
+
+func TestFilterMinScore(t *testing.T) {
+	beans := []Bean{
+		{Url: "strong", Explanation: &SearchExplanation{Mode: VECTOR_SEARCH_MODE, VectorScore: 0.8}},
+		{Url: "at the min score", Explanation: &SearchExplanation{Mode: VECTOR_SEARCH_MODE, VectorScore: 0.75}},
+		{Url: "weak", Explanation: &SearchExplanation{Mode: VECTOR_SEARCH_MODE, VectorScore: 0.7}},
+		{Url: "text", Explanation: &SearchExplanation{Mode: TEXT_FALLBACK_SEARCH_MODE, TextScore: 0.1}},
+		{Url: "get", Explanation: &SearchExplanation{Mode: GET_SEARCH_MODE}},
+		{Url: "unexplained"},
+	}
+	tests := []struct {
+		name      string
+		min_score float64
+		want      []string
+	}{
+		{"no min score", 0, []string{"strong", "at the min score", "weak", "text", "get", "unexplained"}},
+		{"min score", 0.75, []string{"strong", "at the min score", "text", "get", "unexplained"}},
+		{"above every vector score", 0.9, []string{"text", "get", "unexplained"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, bean := range filterMinScore(beans, test.min_score) {
+				got = append(got, bean.Url)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("filterMinScore() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExcludeDelivered(t *testing.T) {
+	beans := []Bean{{Url: "a"}, {Url: "b"}, {Url: "c"}}
+	tests := []struct {
+		name      string
+		delivered []delivery
+		want      []string
+	}{
+		{"none delivered", nil, []string{"a", "b", "c"}},
+		{"some delivered", []delivery{{Owner: "alice", BeanUrl: "b"}}, []string{"a", "c"}},
+		{"all delivered", []delivery{{BeanUrl: "c"}, {BeanUrl: "a"}, {BeanUrl: "b"}}, []string{}},
+		{"other beans delivered", []delivery{{BeanUrl: "d"}}, []string{"a", "b", "c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, bean := range excludeDelivered(beans, test.delivered) {
+				got = append(got, bean.Url)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("excludeDelivered() = %v, want %v", got, test.want)
+			}
+		})
+	}
+	if got := filterUndelivered("alice", nil); len(got) != 0 {
+		t.Errorf("filterUndelivered() of nothing = %v, want nothing", got)
+	}
+}
+
+// Rectify fills in the entities of a bean after the subscription got its alerts for the run that added the bean
+func TestAlertOptionsSeeLateEnrichment(t *testing.T) {
+	const added, alerted, rectified = int64(1000), int64(1100), int64(1200)
+	sub := &Subscription{Name: "test", Owner: "alice", Entities: []string{"fortra"}}
+	window := func(since, until int64) store.JSON {
+		options, err := sub.alertOptions(since, until)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := options.ScalarFilter["entities"]; !ok {
+			t.Fatalf("alertOptions() = %v, want the entities of the subscription", options.ScalarFilter)
+		}
+		return options.ScalarFilter[_ENRICHED].(store.JSON)
+	}
+	in := func(window store.JSON, enriched int64) bool {
+		return enriched >= window["$gte"].(int64) && enriched < window["$lt"].(int64)
+	}
+
+	// the collection run generates everything but the entities
+	updates, _ := getEnrichedUpdates([]any{Bean{Sentiment: nlp.NEGATIVE}}, []store.JSON{{"url": "a"}}, added)
+	enriched := updates[0].(store.JSON)[_ENRICHED].(int64)
+	if !in(window(added, alerted), enriched) {
+		t.Fatalf("bean enriched at %d is not in the first alert window %v", enriched, window(added, alerted))
+	}
+	// the first alert went through without the bean since it had no entities yet
+	sub.LastAlerted = alerted
+	updates, _ = getEnrichedUpdates([]any{Bean{Entities: []string{"fortra"}}}, []store.JSON{{"url": "a"}}, rectified)
+	enriched = updates[0].(store.JSON)[_ENRICHED].(int64)
+	if !in(window(added, rectified+1), enriched) {
+		t.Errorf("bean enriched by Rectify at %d is not in the next alert window %v", enriched, window(added, rectified+1))
+	}
+}
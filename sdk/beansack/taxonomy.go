@@ -0,0 +1,195 @@
+package beansack
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/nlp"
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
+)
+
+const (
+	_DEFAULT_CATEGORY_TOPN = 3
+)
+
+// A node in the category taxonomy. The taxonomy is loaded from a json file with a list of these
+type Category struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Parent      string   `json:"parent,omitempty"`   // id of the parent category. empty for the top level ones
+	Examples    []string `json:"examples,omitempty"` // optional seed texts that belong to the category
+	embeddings  [][]float32
+}
+
+// a category assigned to a bean
+type CategoryMatch struct {
+	ID    string  `json:"id" bson:"id"`
+	Score float64 `json:"score" bson:"score"`
+}
+
+// Loads the category taxonomy from a json file containing a list of Category.
+// Categories with a parent that is not in the file are dropped
+func LoadTaxonomy(filename string) []Category {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		log.Printf("[taxonomy] Failed reading %s. %v\n", filename, err)
+		return nil
+	}
+	var categories []Category
+	if err = json.Unmarshal(data, &categories); err != nil {
+		log.Printf("[taxonomy] Failed parsing %s. %v\n", filename, err)
+		return nil
+	}
+	categories = datautils.Filter(categories, func(item *Category) bool {
+		if item.Parent != "" && datautils.IndexAny(categories, func(parent *Category) bool { return parent.ID == item.Parent }) < 0 {
+			log.Printf("[taxonomy] Dropping %s. Parent %s not found.\n", item.ID, item.Parent)
+			return false
+		}
+		return item.ID != ""
+	})
+	log.Printf("[taxonomy] Loaded %d categories from %s\n", len(categories), filename)
+	return categories
+}
+
+// Returns the loaded taxonomy
+func GetCategories() []Category {
+	return taxonomy
+}
+
+// the description and each seed example of a category gets its own embedding
+// a bean's score for the category is its best match against any of them
+func embedTaxonomy(categories []Category) []Category {
+	for i := range categories {
+		texts := append([]string{categoryText(&categories[i])}, categories[i].Examples...)
+		categories[i].embeddings = datautils.Filter(
+			embedder.CreateBatchTextEmbeddings(texts, nlp.CLASSIFICATION),
+			func(emb *[]float32) bool { return len(*emb) > 0 })
+	}
+	return datautils.Filter(categories, func(item *Category) bool {
+		if len(item.embeddings) == 0 {
+			log.Printf("[taxonomy] Dropping %s. Failed generating embeddings.\n", item.ID)
+			return false
+		}
+		return true
+	})
+}
+
+func categoryText(category *Category) string {
+	if category.Description == "" {
+		return category.Name
+	}
+	return fmt.Sprintf("%s: %s", category.Name, category.Description)
+}
+
+// Assigns the top categories to each bean based on its category_embeddings.
+// The beans need to have their category_embeddings generated before this
+func classifyBeans(beans []Bean) ([]any, []store.JSON) {
+	urls := datautils.Transform(beans, func(item *Bean) string { return item.Url })
+	beans = beanstore.Get(
+		store.JSON{
+			"url":               store.JSON{"$in": urls},
+			_CLASSIFICATION_EMB: store.JSON{"$exists": true},
+		},
+		store.JSON{"url": 1, _CLASSIFICATION_EMB: 1},
+		nil, -1)
+
+	updates := make([]any, 0, len(beans))
+	filters := make([]store.JSON, 0, len(beans))
+	datautils.ForEach(beans, func(bean *Bean) {
+		if matches := classify(bean.CategoryEmbeddings); len(matches) > 0 {
+			updates = append(updates, Bean{Categories: matches})
+		} else {
+			// an empty list marks it as classified so that Rectify doesn't keep classifying it
+			updates = append(updates, store.JSON{_CATEGORIES: []CategoryMatch{}})
+		}
+		filters = append(filters, getBeanId(bean))
+	})
+	return updates, filters
+}
+
+func classify(emb []float32) []CategoryMatch {
+	matches := make([]CategoryMatch, 0, len(taxonomy))
+	for i := range taxonomy {
+		var score float64
+		datautils.ForEach(taxonomy[i].embeddings, func(cat_emb *[]float32) { score = math.Max(score, cosineSimilarity(emb, *cat_emb)) })
+		if score >= _DEFAULT_CLASSIFICATION_MATCH_SCORE {
+			matches = append(matches, CategoryMatch{ID: taxonomy[i].ID, Score: score})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	return datautils.SafeSlice(matches, 0, _DEFAULT_CATEGORY_TOPN)
+}
+
+// returns the ids of the categories matching the ids or names along with all their descendants
+func expandCategories(ids_or_names []string) []string {
+	ids := make([]string, 0, len(ids_or_names))
+	var add func(id string)
+	add = func(id string) {
+		if datautils.In(id, ids, func(a, b *string) bool { return *a == *b }) {
+			return
+		}
+		ids = append(ids, id)
+		datautils.ForEach(taxonomy, func(child *Category) {
+			if child.Parent == id {
+				add(child.ID)
+			}
+		})
+	}
+	datautils.ForEach(ids_or_names, func(value *string) { add(getCategoryId(*value)) })
+	return ids
+}
+
+// the sorted ids of the categories without their descendants so that the same categories by id or by name give the same key
+func getCategoryIds(ids_or_names []string) []string {
+	ids := make([]string, 0, len(ids_or_names))
+	datautils.ForEach(ids_or_names, func(value *string) {
+		if id := getCategoryId(*value); !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	})
+	slices.Sort(ids)
+	return ids
+}
+
+// the value is the id or the name of a category in the loaded taxonomy
+func isCategory(id_or_name string) bool {
+	return datautils.IndexAny(taxonomy, func(item *Category) bool {
+		return item.ID == id_or_name || strings.EqualFold(item.Name, id_or_name)
+	}) >= 0
+}
+
+// the id of the category by its id or name
+func getCategoryId(id_or_name string) string {
+	i := datautils.IndexAny(taxonomy, func(item *Category) bool {
+		return item.ID == id_or_name || strings.EqualFold(item.Name, id_or_name)
+	})
+	if i >= 0 {
+		return taxonomy[i].ID
+	}
+	// unknown categories still filter so that they match nothing instead of everything
+	return id_or_name
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, norm_a, norm_b float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		norm_a += float64(a[i]) * float64(a[i])
+		norm_b += float64(b[i]) * float64(b[i])
+	}
+	if norm_a == 0 || norm_b == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(norm_a) * math.Sqrt(norm_b))
+}
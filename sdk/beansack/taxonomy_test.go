@@ -0,0 +1,128 @@
+package beansack
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// swaps the loaded taxonomy for the test
+func setTestTaxonomy(t *testing.T, categories []Category) {
+	saved := taxonomy
+	taxonomy = categories
+	t.Cleanup(func() { taxonomy = saved })
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float64
+	}{
+		{"same", []float32{1, 2, 3}, []float32{1, 2, 3}, 1},
+		{"scaled", []float32{1, 2, 3}, []float32{2, 4, 6}, 1},
+		{"orthogonal", []float32{1, 0}, []float32{0, 1}, 0},
+		{"opposite", []float32{1, 0}, []float32{-1, 0}, -1},
+		{"different lengths", []float32{1, 0}, []float32{1, 0, 0}, 0},
+		{"empty", nil, nil, 0},
+		{"zero vector", []float32{0, 0}, []float32{1, 0}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := cosineSimilarity(test.a, test.b); math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("cosineSimilarity(%v, %v) = %f, want %f", test.a, test.b, got, test.want)
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	// the bean is (1, 0). each category's score is the cosine of its embedding with it
+	category := func(id string, scores ...float64) Category {
+		cat := Category{ID: id}
+		for _, score := range scores {
+			cat.embeddings = append(cat.embeddings, []float32{float32(score), float32(math.Sqrt(1 - score*score))})
+		}
+		return cat
+	}
+	bean := []float32{1, 0}
+	tests := []struct {
+		name       string
+		categories []Category
+		want       []string
+	}{
+		{"none above the threshold", []Category{category("a", 0.5), category("b", 0.67)}, []string{}},
+		{"around the threshold", []Category{category("a", 0.681), category("b", 0.679)}, []string{"a"}},
+		{"best first", []Category{category("a", 0.7), category("b", 0.9), category("c", 0.8)}, []string{"b", "c", "a"}},
+		{"top 3", []Category{category("a", 0.7), category("b", 0.9), category("c", 0.8), category("d", 0.95), category("e", 0.69)}, []string{"d", "b", "c"}},
+		{"best of the examples", []Category{category("a", 0.2, 0.75), category("b", 0.72)}, []string{"a", "b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestTaxonomy(t, test.categories)
+			got := make([]string, 0)
+			for _, match := range classify(bean) {
+				got = append(got, match.ID)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("classify() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExpandCategories(t *testing.T) {
+	setTestTaxonomy(t, []Category{
+		{ID: "tech", Name: "Technology"},
+		{ID: "ai", Name: "Artificial Intelligence", Parent: "tech"},
+		{ID: "llm", Name: "Language Models", Parent: "ai"},
+		{ID: "security", Name: "Cybersecurity", Parent: "tech"},
+		{ID: "health", Name: "Health"},
+	})
+	tests := []struct {
+		name  string
+		input []string
+		want  []string
+	}{
+		{"leaf", []string{"llm"}, []string{"llm"}},
+		{"all descendants", []string{"tech"}, []string{"tech", "ai", "llm", "security"}},
+		{"by name", []string{"artificial intelligence"}, []string{"ai", "llm"}},
+		{"overlapping", []string{"ai", "llm", "tech"}, []string{"ai", "llm", "tech", "security"}},
+		{"several trees", []string{"health", "security"}, []string{"health", "security"}},
+		{"unknown still filters", []string{"sports"}, []string{"sports"}},
+		{"empty", nil, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := expandCategories(test.input); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expandCategories(%v) = %v, want %v", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetCategoryIds(t *testing.T) {
+	setTestTaxonomy(t, []Category{
+		{ID: "tech", Name: "Technology"},
+		{ID: "ai", Name: "Artificial Intelligence", Parent: "tech"},
+	})
+	tests := []struct {
+		name  string
+		input []string
+		want  []string
+	}{
+		{"by id", []string{"tech", "ai"}, []string{"ai", "tech"}},
+		{"by name", []string{"Technology", "artificial intelligence"}, []string{"ai", "tech"}},
+		{"mixed and repeated", []string{"ai", "Artificial Intelligence", "tech"}, []string{"ai", "tech"}},
+		{"no descendants", []string{"tech"}, []string{"tech"}},
+		{"unknown kept", []string{"sports", "ai"}, []string{"ai", "sports"}},
+		{"empty", nil, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := getCategoryIds(test.input); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("getCategoryIds(%v) = %v, want %v", test.input, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package beansack
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
+)
+
+// names of the built-in trend scorers
+const (
+	DEFAULT_TREND_SCORER = "default" // time decayed with source diversity, velocity and per source noise normalization
+	CLASSIC_TREND_SCORER = "classic" // 5 x number_of_beans + sum_of(noise_scores)
+)
+
+const (
+	_TREND_CANDIDATES = 500 // nuggets to rescore when a search picks a different scorer than the stored one
+	_COMMENT_WEIGHT   = 3   // a comment is worth this many likes in the noise score
+)
+
+// what a trend is scored from: the beans covering it and their media noises
+type TrendSignals struct {
+	Beans []Bean // url, source, created and updated of the beans
+	// the latest media noise of each bean from each channel and the one from the collection run before it.
+	// The beans with an engagement history have one per source that adds up its channels.
+	// PreviousNoises[i] is the previous one of Noises[i]. Its Updated is 0 if there is none
+	Noises         []MediaNoise
+	PreviousNoises []MediaNoise
+}
+
+// Scores how much the beans covering a nugget are trending. The nuggets store the score of the configured scorer
+// as their trend score. Searches can pick a different one through SearchOptions.WithTrendScorer
+type TrendScorer interface {
+	Score(signals *TrendSignals) int
+}
+
+type TrendWeights struct {
+	Coverage        float64 `json:"coverage"`         // per bean covering the trend
+	SourceDiversity float64 `json:"source_diversity"` // per distinct source of the beans
+	Noise           float64 `json:"noise"`            // per normalized point of media noise
+	Velocity        float64 `json:"velocity"`         // per normalized point of media noise gained since the previous collection run
+	HalfLife        float64 `json:"half_life"`        // hours for the contribution of a bean or a media noise to halve. <= 0 means no decay
+	// typical noise score of a bean keyed by the source. The noise of each source is divided by its scale so that
+	// the sources with a large audience don't swamp the rest. Sources without a scale use 1
+	NoiseScales map[string]float64 `json:"noise_scales"`
+}
+
+func DefaultTrendWeights() TrendWeights {
+	return TrendWeights{
+		Coverage:        5,
+		SourceDiversity: 10,
+		Noise:           1,
+		Velocity:        2,
+		HalfLife:        24,
+		NoiseScales: map[string]float64{
+			"REDDIT":         20,
+			"YC HACKER NEWS": 2,
+		},
+	}
+}
+
+// The default TrendScorer. Every bean and media noise counts less as it gets older.
+// score = sum_of(decay x (coverage + noise x normalized_noise + velocity x normalized_noise_gain)) + source_diversity x number_of_sources
+func NewTrendScorer(weights TrendWeights) TrendScorer {
+	return decayingTrendScorer{weights: weights}
+}
+
+// Returns the built-in TrendScorer by its name. nil if there is none
+func GetTrendScorer(name string) TrendScorer {
+	switch name {
+	case DEFAULT_TREND_SCORER:
+		return trend_scorer
+	case CLASSIC_TREND_SCORER:
+		return classicTrendScorer{}
+	}
+	return nil
+}
+
+type decayingTrendScorer struct {
+	weights TrendWeights
+}
+
+func (scorer decayingTrendScorer) Score(signals *TrendSignals) int {
+	now := time.Now().Unix()
+	score := 0.0
+	sources := make(map[string]bool)
+	datautils.ForEach(signals.Beans, func(bean *Bean) {
+		created := bean.Created
+		if created <= 0 {
+			created = bean.Updated
+		}
+		score += scorer.weights.Coverage * scorer.decay(now, created)
+		sources[bean.Source] = true
+	})
+	score += scorer.weights.SourceDiversity * float64(len(sources))
+	for i := range signals.Noises {
+		latest, previous := &signals.Noises[i], &signals.PreviousNoises[i]
+		decay := scorer.decay(now, latest.Updated)
+		score += scorer.weights.Noise * decay * scorer.normalize(latest, getNoiseValue(latest))
+		if previous.Updated > 0 {
+			score += scorer.weights.Velocity * decay * scorer.normalize(latest, max(getNoiseValue(latest)-getNoiseValue(previous), 0))
+		}
+	}
+	return int(math.Round(score))
+}
+
+func (scorer decayingTrendScorer) decay(now, timestamp int64) float64 {
+	if scorer.weights.HalfLife <= 0 || timestamp <= 0 {
+		return 1
+	}
+	age := max(float64(now-timestamp)/3600, 0)
+	return math.Pow(0.5, age/scorer.weights.HalfLife)
+}
+
+func (scorer decayingTrendScorer) normalize(noise *MediaNoise, value int) float64 {
+	if scale := scorer.weights.NoiseScales[noise.Source]; scale > 0 {
+		return float64(value) / scale
+	}
+	return float64(value)
+}
+
+type classicTrendScorer struct{}
+
+func (classicTrendScorer) Score(signals *TrendSignals) int {
+	score := 5 * len(signals.Beans)
+	datautils.ForEach(signals.Noises, func(noise *MediaNoise) { score += getNoiseValue(noise) })
+	return score
+}
+
+// the noise score of a media noise regardless of the source
+func getNoiseValue(noise *MediaNoise) int {
+	return _COMMENT_WEIGHT*noise.Comments + noise.ThumbsupCount
+}
+
+// collects the signals of the beans with the urls
+func getTrendSignals(urls []string) *TrendSignals {
+	signals := &TrendSignals{}
+	if len(urls) == 0 {
+		return signals
+	}
+	signals.Beans = beanstore.Get(
+		store.JSON{"url": store.JSON{"$in": urls}},
+		store.JSON{"url": 1, "source": 1, "created": 1, "updated": 1},
+		nil, -1)
+	points := getEngagementPoints(urls, store.JSON{"timestamp": store.JSON{"$gte": timeValue(_FOUR_WEEKS)}})
+	signals.Noises, signals.PreviousNoises = getHistoryNoises(points)
+	// the beans collected before the engagement history was kept only have their media noises
+	missing := datautils.Filter(urls, func(url *string) bool {
+		return datautils.IndexAny(points, func(point *EngagementPoint) bool { return point.BeanUrl == *url }) < 0
+	})
+	if len(missing) > 0 {
+		// newest first so that the first two of each channel are the latest and the previous one
+		noises := noisestore.Get(
+			store.JSON{"mapped_url": store.JSON{"$in": missing}},
+			store.JSON{"mapped_url": 1, "source": 1, "channel": 1, "updated": 1, "likes": 1, "comments": 1},
+			store.JSON{"updated": -1}, -1)
+		latest, previous := getLatestNoises(noises)
+		signals.Noises = append(signals.Noises, latest...)
+		signals.PreviousNoises = append(signals.PreviousNoises, previous...)
+	}
+	return signals
+}
+
+// the latest and the previous media noise of each bean from each source in the engagement history. The points are oldest first
+func getHistoryNoises(points []EngagementPoint) ([]MediaNoise, []MediaNoise) {
+	toNoise := func(point *EngagementPoint) MediaNoise {
+		return MediaNoise{BeanUrl: point.BeanUrl, Source: point.Source, Updated: point.Timestamp, ThumbsupCount: point.Likes, Comments: point.Comments}
+	}
+	index := make(map[[2]string]int)
+	latest, previous := make([]MediaNoise, 0, len(points)), make([]MediaNoise, 0, len(points))
+	datautils.ForEach(points, func(point *EngagementPoint) {
+		key := [2]string{point.BeanUrl, point.Source}
+		if i, ok := index[key]; ok {
+			previous[i], latest[i] = latest[i], toNoise(point)
+		} else {
+			index[key] = len(latest)
+			latest = append(latest, toNoise(point))
+			previous = append(previous, MediaNoise{})
+		}
+	})
+	return latest, previous
+}
+
+// the latest and the previous media noise of each bean from each channel. The noises are newest first
+func getLatestNoises(noises []MediaNoise) ([]MediaNoise, []MediaNoise) {
+	index := make(map[[3]string]int)
+	latest, previous := make([]MediaNoise, 0, len(noises)), make([]MediaNoise, 0, len(noises))
+	datautils.ForEach(noises, func(noise *MediaNoise) {
+		key := [3]string{noise.BeanUrl, noise.Source, noise.Channel}
+		if i, ok := index[key]; !ok {
+			index[key] = len(latest)
+			latest = append(latest, *noise)
+			previous = append(previous, MediaNoise{})
+		} else if previous[i].Updated == 0 && noise.Updated < latest[i].Updated {
+			previous[i] = *noise
+		}
+	})
+	return latest, previous
+}
+
+// the signals of the beans with the urls
+func (signals *TrendSignals) subset(urls []string) *TrendSignals {
+	in_urls := make(map[string]bool, len(urls))
+	datautils.ForEach(urls, func(url *string) { in_urls[*url] = true })
+	subset := &TrendSignals{Beans: datautils.Filter(signals.Beans, func(bean *Bean) bool { return in_urls[bean.Url] })}
+	for i := range signals.Noises {
+		if in_urls[signals.Noises[i].BeanUrl] {
+			subset.Noises = append(subset.Noises, signals.Noises[i])
+			subset.PreviousNoises = append(subset.PreviousNoises, signals.PreviousNoises[i])
+		}
+	}
+	return subset
+}
+
+func calculateNuggetScore(urls []string) int {
+	return trend_scorer.Score(getTrendSignals(urls))
+}
+
+// scores the nuggets with the scorer instead of the stored trend score and stack ranks them
+func rescoreNuggets(nuggets []BeanNugget, scorer TrendScorer) []BeanNugget {
+	urls := make([]string, 0, len(nuggets)*5)
+	datautils.ForEach(nuggets, func(item *BeanNugget) { urls = append(urls, item.BeanUrls...) })
+	signals := getTrendSignals(urls)
+	nuggets = datautils.ForEach(nuggets, func(item *BeanNugget) { item.TrendScore = scorer.Score(signals.subset(item.BeanUrls)) })
+	sort.SliceStable(nuggets, func(i, j int) bool { return nuggets[i].TrendScore > nuggets[j].TrendScore })
+	return nuggets
+}
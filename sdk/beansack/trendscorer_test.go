@@ -0,0 +1,241 @@
+package beansack
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecay(t *testing.T) {
+	now := time.Now().Unix()
+	hours := func(h float64) int64 { return now - int64(h*3600) }
+	tests := []struct {
+		name      string
+		half_life float64
+		timestamp int64
+		want      float64
+	}{
+		{"now", 24, now, 1},
+		{"one half life", 24, hours(24), 0.5},
+		{"two half lives", 24, hours(48), 0.25},
+		{"half of a half life", 24, hours(12), math.Sqrt(0.5)},
+		{"a week", 24, hours(168), math.Pow(0.5, 7)},
+		{"shorter half life", 6, hours(24), 0.0625},
+		{"in the future", 24, now + 3600, 1},
+		{"no timestamp", 24, 0, 1},
+		{"no decay", 0, hours(48), 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scorer := decayingTrendScorer{weights: TrendWeights{HalfLife: test.half_life}}
+			if got := scorer.decay(now, test.timestamp); math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("decay() = %f, want %f", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecayingTrendScorer(t *testing.T) {
+	now := time.Now().Unix()
+	weights := TrendWeights{Coverage: 5, SourceDiversity: 10, Noise: 1, Velocity: 2, HalfLife: 24, NoiseScales: map[string]float64{"REDDIT": 20}}
+	tests := []struct {
+		name    string
+		weights TrendWeights
+		signals TrendSignals
+		want    int
+	}{
+		{"nothing", weights, TrendSignals{}, 0},
+		{
+			name:    "fresh beans from one source",
+			weights: weights,
+			signals: TrendSignals{Beans: []Bean{{Source: "a", Created: now}, {Source: "a", Created: now}}},
+			want:    2*5 + 10,
+		},
+		{
+			name:    "a day old bean counts half",
+			weights: weights,
+			signals: TrendSignals{Beans: []Bean{{Source: "a", Created: now - 24*3600}, {Source: "a", Created: now - 24*3600}}},
+			want:    5 + 10,
+		},
+		{
+			name:    "updated when there is no created",
+			weights: weights,
+			signals: TrendSignals{Beans: []Bean{{Source: "a", Updated: now - 48*3600}}},
+			want:    1 + 10, // 1.25
+		},
+		{
+			name:    "noise normalized by the source",
+			weights: weights,
+			signals: TrendSignals{
+				Noises:         []MediaNoise{{Source: "REDDIT", Updated: now, ThumbsupCount: 100, Comments: 20}, {Source: "OTHER", Updated: now, ThumbsupCount: 7}},
+				PreviousNoises: []MediaNoise{{}, {}},
+			},
+			want: 160/20 + 7,
+		},
+		{
+			name:    "noise gained since the previous run",
+			weights: weights,
+			signals: TrendSignals{
+				Noises:         []MediaNoise{{Source: "OTHER", Updated: now, ThumbsupCount: 30}},
+				PreviousNoises: []MediaNoise{{Source: "OTHER", Updated: now - 3600, ThumbsupCount: 10}},
+			},
+			want: 30 + 2*20,
+		},
+		{
+			name:    "noise lost since the previous run",
+			weights: weights,
+			signals: TrendSignals{
+				Noises:         []MediaNoise{{Source: "OTHER", Updated: now, ThumbsupCount: 10}},
+				PreviousNoises: []MediaNoise{{Source: "OTHER", Updated: now - 3600, ThumbsupCount: 30}},
+			},
+			want: 10,
+		},
+		{
+			name:    "old noise",
+			weights: weights,
+			signals: TrendSignals{
+				Noises:         []MediaNoise{{Source: "OTHER", Updated: now - 48*3600, ThumbsupCount: 40}},
+				PreviousNoises: []MediaNoise{{}},
+			},
+			want: 10,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := NewTrendScorer(test.weights).Score(&test.signals); got != test.want {
+				t.Errorf("Score() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+// the classic scorer ties the trends that only differ in what the default one weighs in
+func TestDecayingTrendScorerBreaksClassicTies(t *testing.T) {
+	now := time.Now().Unix()
+	noise := func(updated int64, likes int) MediaNoise {
+		return MediaNoise{Source: "OTHER", Updated: updated, ThumbsupCount: likes}
+	}
+	tests := []struct {
+		name          string
+		better, worse TrendSignals
+	}{
+		{
+			name:   "fresher beans",
+			better: TrendSignals{Beans: []Bean{{Source: "a", Created: now}, {Source: "a", Created: now}}},
+			worse:  TrendSignals{Beans: []Bean{{Source: "a", Created: now - 72*3600}, {Source: "a", Created: now - 72*3600}}},
+		},
+		{
+			name:   "more sources",
+			better: TrendSignals{Beans: []Bean{{Source: "a", Created: now}, {Source: "b", Created: now}}},
+			worse:  TrendSignals{Beans: []Bean{{Source: "a", Created: now}, {Source: "a", Created: now}}},
+		},
+		{
+			name: "fresher noise",
+			better: TrendSignals{
+				Beans:  []Bean{{Source: "a", Created: now}},
+				Noises: []MediaNoise{noise(now, 50)}, PreviousNoises: []MediaNoise{{}},
+			},
+			worse: TrendSignals{
+				Beans:  []Bean{{Source: "a", Created: now}},
+				Noises: []MediaNoise{noise(now-48*3600, 50)}, PreviousNoises: []MediaNoise{{}},
+			},
+		},
+		{
+			name: "gaining noise",
+			better: TrendSignals{
+				Beans:  []Bean{{Source: "a", Created: now}},
+				Noises: []MediaNoise{noise(now, 50)}, PreviousNoises: []MediaNoise{noise(now-3600, 10)},
+			},
+			worse: TrendSignals{
+				Beans:  []Bean{{Source: "a", Created: now}},
+				Noises: []MediaNoise{noise(now, 50)}, PreviousNoises: []MediaNoise{noise(now-3600, 50)},
+			},
+		},
+		{
+			name: "less noise from a large audience",
+			better: TrendSignals{
+				Beans:  []Bean{{Source: "a", Created: now}},
+				Noises: []MediaNoise{noise(now, 40)}, PreviousNoises: []MediaNoise{{}},
+			},
+			worse: TrendSignals{
+				Beans:  []Bean{{Source: "a", Created: now}},
+				Noises: []MediaNoise{{Source: "REDDIT", Updated: now, ThumbsupCount: 40}}, PreviousNoises: []MediaNoise{{}},
+			},
+		},
+	}
+	scorer := NewTrendScorer(DefaultTrendWeights())
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if better, worse := (classicTrendScorer{}).Score(&test.better), (classicTrendScorer{}).Score(&test.worse); better != worse {
+				t.Fatalf("classic Score() = %d and %d, want a tie", better, worse)
+			}
+			if better, worse := scorer.Score(&test.better), scorer.Score(&test.worse); better <= worse {
+				t.Errorf("Score() = %d and %d, want the first one higher", better, worse)
+			}
+		})
+	}
+}
+
+func TestGetHistoryNoises(t *testing.T) {
+	points := []EngagementPoint{
+		{BeanUrl: "a", Source: "REDDIT", Timestamp: 100, Likes: 10, Comments: 1},
+		{BeanUrl: "a", Source: "YC HACKER NEWS", Timestamp: 100, Likes: 3},
+		{BeanUrl: "a", Source: "REDDIT", Timestamp: 200, Likes: 20, Comments: 2},
+		{BeanUrl: "b", Source: "REDDIT", Timestamp: 150, Likes: 5},
+		{BeanUrl: "a", Source: "REDDIT", Timestamp: 300, Likes: 40, Comments: 4},
+	}
+	latest, previous := getHistoryNoises(points)
+	want_latest := []MediaNoise{
+		{BeanUrl: "a", Source: "REDDIT", Updated: 300, ThumbsupCount: 40, Comments: 4},
+		{BeanUrl: "a", Source: "YC HACKER NEWS", Updated: 100, ThumbsupCount: 3},
+		{BeanUrl: "b", Source: "REDDIT", Updated: 150, ThumbsupCount: 5},
+	}
+	want_previous := []MediaNoise{
+		{BeanUrl: "a", Source: "REDDIT", Updated: 200, ThumbsupCount: 20, Comments: 2},
+		{},
+		{},
+	}
+	if !reflect.DeepEqual(latest, want_latest) {
+		t.Errorf("latest = %+v, want %+v", latest, want_latest)
+	}
+	if !reflect.DeepEqual(previous, want_previous) {
+		t.Errorf("previous = %+v, want %+v", previous, want_previous)
+	}
+}
+
+func TestGetLatestNoises(t *testing.T) {
+	// newest first like the media noises are read
+	noises := []MediaNoise{
+		{BeanUrl: "a", Source: "REDDIT", Channel: "r/golang", Updated: 300, ThumbsupCount: 40},
+		{BeanUrl: "a", Source: "REDDIT", Channel: "r/programming", Updated: 300, ThumbsupCount: 7},
+		{BeanUrl: "a", Source: "REDDIT", Channel: "r/golang", Updated: 200, ThumbsupCount: 20},
+		{BeanUrl: "a", Source: "REDDIT", Channel: "r/golang", Updated: 100, ThumbsupCount: 10},
+		// the same run collected twice is not a previous run
+		{BeanUrl: "a", Source: "REDDIT", Channel: "r/programming", Updated: 300, ThumbsupCount: 6},
+	}
+	latest, previous := getLatestNoises(noises)
+	want_latest := []MediaNoise{noises[0], noises[1]}
+	want_previous := []MediaNoise{noises[2], {}}
+	if !reflect.DeepEqual(latest, want_latest) {
+		t.Errorf("latest = %+v, want %+v", latest, want_latest)
+	}
+	if !reflect.DeepEqual(previous, want_previous) {
+		t.Errorf("previous = %+v, want %+v", previous, want_previous)
+	}
+}
+
+func TestTrendSignalsSubset(t *testing.T) {
+	signals := &TrendSignals{
+		Beans:          []Bean{{Url: "a"}, {Url: "b"}, {Url: "c"}},
+		Noises:         []MediaNoise{{BeanUrl: "a", Updated: 2}, {BeanUrl: "b", Updated: 2}, {BeanUrl: "a", Source: "REDDIT", Updated: 2}},
+		PreviousNoises: []MediaNoise{{BeanUrl: "a", Updated: 1}, {}, {}},
+	}
+	subset := signals.subset([]string{"a", "c"})
+	if len(subset.Beans) != 2 || len(subset.Noises) != 2 || len(subset.PreviousNoises) != 2 {
+		t.Fatalf("subset() = %+v, want the 2 beans and their 2 noises", subset)
+	}
+	if subset.PreviousNoises[0].Updated != 1 || subset.Noises[1].Source != "REDDIT" {
+		t.Errorf("subset() = %+v, want the previous noises to stay paired with the latest ones", subset)
+	}
+}
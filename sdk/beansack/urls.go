@@ -0,0 +1,220 @@
+package beansack
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+	datautils "github.com/soumitsalman/data-utils"
+	"golang.org/x/net/publicsuffix"
+)
+
+// how the urls of a domain are canonicalized on top of the generic rules
+type urlRule struct {
+	Host       string   // the host to use instead. empty means the same host
+	KeepParams []string // the only query params that identify the content. nil means all the non-tracking ones
+	DropParams []string // tracking params of the domain on top of the generic ones. These names mean something else on other sites
+}
+
+var (
+	// matched against the host and then its parent domains
+	_URL_RULES = map[string]urlRule{
+		"reddit.com":           {Host: "www.reddit.com", KeepParams: []string{}},
+		"old.reddit.com":       {Host: "www.reddit.com", KeepParams: []string{}},
+		"youtube.com":          {Host: "www.youtube.com", KeepParams: []string{"v", "list"}},
+		"twitter.com":          {Host: "x.com", KeepParams: []string{}},
+		"x.com":                {KeepParams: []string{}},
+		"medium.com":           {KeepParams: []string{}},
+		"news.ycombinator.com": {KeepParams: []string{"id"}},
+		"github.com":           {KeepParams: []string{}},
+		"arxiv.org":            {KeepParams: []string{}},
+		"substack.com":         {DropParams: []string{"r", "ref", "source"}},
+		"producthunt.com":      {DropParams: []string{"ref"}},
+	}
+	// mobile and AMP versions of the sites
+	_MIRROR_HOST_PREFIXES = []string{"m.", "mobile.", "amp."}
+	_MIRROR_PATH_SUFFIXES = []string{"/amp", "/amp.html"}
+	// query params that only track where the visit came from on any site. utm_* is handled separately
+	_TRACKING_PARAMS = []string{"fbclid", "gclid", "dclid", "msclkid", "mc_cid", "mc_eid", "igshid", "ref_src", "ref_url", "referrer", "cmpid", "ocid", "smid", "amp", "outputType"}
+)
+
+// Returns the canonical form of the url so that the different urls of the same content map to the same bean:
+// https, lowercase host without the mobile/AMP prefix, no tracking params, no fragment and no trailing slash.
+// Urls that cannot be parsed are returned as is
+func CanonicalizeUrl(raw_url string) string {
+	raw_url = strings.TrimSpace(raw_url)
+	u, err := url.Parse(raw_url)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return raw_url
+	}
+	u.Scheme = "https"
+	u.User = nil
+	u.Fragment, u.RawFragment = "", ""
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for _, prefix := range _MIRROR_HOST_PREFIXES {
+		// the prefix is only a mirror if a domain is left after it. amp.dev and mobile.de are sites of their own
+		if trimmed, ok := strings.CutPrefix(host, prefix); ok && strings.Contains(trimmed, ".") {
+			host = trimmed
+		}
+	}
+	rule := getUrlRule(host)
+	if rule.Host != "" {
+		host = rule.Host
+	}
+	// the default ports are dropped along with the rest
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host = host + ":" + port
+	}
+	u.Host = host
+
+	path := strings.TrimRight(u.EscapedPath(), "/")
+	for _, suffix := range _MIRROR_PATH_SUFFIXES {
+		path = strings.TrimSuffix(path, suffix)
+	}
+	if unescaped, err := url.PathUnescape(strings.TrimRight(path, "/")); err == nil {
+		u.Path, u.RawPath = unescaped, ""
+	}
+
+	params := u.Query()
+	for key := range params {
+		if strings.HasPrefix(strings.ToLower(key), "utm_") || slices.Contains(_TRACKING_PARAMS, key) || slices.Contains(rule.DropParams, key) ||
+			(rule.KeepParams != nil && !slices.Contains(rule.KeepParams, key)) {
+			params.Del(key)
+		}
+	}
+	// Encode sorts the params so that their order doesn't matter
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+// Sets the bean url to its canonical form and keeps the original one as an alias.
+// canonical_url is the <link rel=canonical> of the page if it is known, otherwise ""
+func CanonicalizeBean(bean *Bean, canonical_url string) {
+	original := bean.Url
+	if canonical_url = CanonicalizeUrl(canonical_url); isValidCanonicalUrl(canonical_url, original) {
+		bean.Url = canonical_url
+	} else {
+		bean.Url = CanonicalizeUrl(original)
+	}
+	addAliases(bean, original)
+}
+
+// Returns the urls along with their canonical forms for matching them against the urls and the aliases of the beans
+func resolveUrls(urls []string) []string {
+	resolved := make([]string, 0, len(urls)*2)
+	datautils.ForEach(urls, func(item *string) {
+		resolved = append(resolved, *item)
+		if canonical := CanonicalizeUrl(*item); canonical != *item {
+			resolved = append(resolved, canonical)
+		}
+	})
+	return resolved
+}
+
+// the filter for the beans whose url or one of its aliases is one of the urls
+func getUrlFilter(urls []string) store.JSON {
+	return store.JSON{
+		"$or": []store.JSON{
+			{"url": store.JSON{"$in": urls}},
+			{"aliases": store.JSON{"$in": urls}},
+		},
+	}
+}
+
+// canonicalizes the urls of the beans and points the beans that are already stored under an alias to the stored url.
+// The beans with the same canonical url in the batch are merged into the first one
+func canonicalizeBeans(beans []Bean) []Bean {
+	datautils.ForEach(beans, func(item *Bean) { CanonicalizeBean(item, "") })
+	if len(beans) == 0 {
+		return beans
+	}
+	keys := make([]string, 0, len(beans)*2)
+	datautils.ForEach(beans, func(item *Bean) { keys = append(append(keys, item.Url), item.Aliases...) })
+	existing := beanstore.Get(
+		getUrlFilter(keys),
+		store.JSON{"url": 1, "aliases": 1},
+		nil, -1)
+
+	alias_updates := make(map[string]*Bean)
+	canonicalized := make([]Bean, 0, len(beans))
+	for i := range beans {
+		bean := &beans[i]
+		if j := datautils.IndexAny(existing, func(item *Bean) bool { return sharesUrl(item, bean) }); j >= 0 {
+			stored := &existing[j]
+			if stored.Url != bean.Url {
+				addAliases(bean, bean.Url)
+				bean.Url = stored.Url
+			}
+			// the stored bean learns the new aliases so that they resolve in the lookups
+			if len(addAliases(stored, bean.Aliases...)) > 0 {
+				alias_updates[stored.Url] = stored
+			}
+		}
+		if j := datautils.IndexAny(canonicalized, func(item *Bean) bool { return item.Url == bean.Url }); j >= 0 {
+			addAliases(&canonicalized[j], bean.Aliases...)
+		} else {
+			canonicalized = append(canonicalized, *bean)
+		}
+	}
+
+	if len(alias_updates) > 0 {
+		updates := make([]any, 0, len(alias_updates))
+		filters := make([]store.JSON, 0, len(alias_updates))
+		for stored_url, bean := range alias_updates {
+			updates = append(updates, store.JSON{"aliases": bean.Aliases})
+			filters = append(filters, store.JSON{"url": stored_url})
+		}
+		beanstore.Update(updates, filters)
+	}
+	return canonicalized
+}
+
+// adds the urls that are not the bean's url or an alias already and returns the ones that got added
+func addAliases(bean *Bean, urls ...string) []string {
+	added := datautils.Filter(urls, func(item *string) bool {
+		return *item != "" && *item != bean.Url && !slices.Contains(bean.Aliases, *item)
+	})
+	added = slices.Compact(added)
+	bean.Aliases = append(bean.Aliases, added...)
+	return added
+}
+
+func sharesUrl(a, b *Bean) bool {
+	return a.Url == b.Url || slices.Contains(a.Aliases, b.Url) || slices.Contains(b.Aliases, a.Url) ||
+		slices.ContainsFunc(a.Aliases, func(alias string) bool { return slices.Contains(b.Aliases, alias) })
+}
+
+func getUrlRule(host string) urlRule {
+	for domain := host; domain != ""; {
+		if rule, ok := _URL_RULES[domain]; ok {
+			return rule
+		}
+		_, domain, _ = strings.Cut(domain, ".")
+	}
+	return urlRule{}
+}
+
+// some sites point every page to their home page and a page can claim any url as its canonical one.
+// the canonical links to the home page or to another site are ignored
+func isValidCanonicalUrl(canonical_url, original_url string) bool {
+	canonical, err := url.Parse(canonical_url)
+	if err != nil || canonical.Host == "" {
+		return false
+	}
+	original, err := url.Parse(original_url)
+	return err == nil && isSameSite(canonical.Hostname(), original.Hostname()) &&
+		(canonical.Path != "" || strings.Trim(original.Path, "/") == "")
+}
+
+// whether the hosts are under the same registrable domain like www.bbc.co.uk and news.bbc.co.uk
+func isSameSite(a, b string) bool {
+	a, b = strings.TrimSuffix(strings.ToLower(a), "."), strings.TrimSuffix(strings.ToLower(b), ".")
+	if a == b {
+		return true
+	}
+	domain_a, err_a := publicsuffix.EffectiveTLDPlusOne(a)
+	domain_b, err_b := publicsuffix.EffectiveTLDPlusOne(b)
+	return err_a == nil && err_b == nil && domain_a == domain_b
+}
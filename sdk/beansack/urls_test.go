@@ -0,0 +1,90 @@
+package beansack
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/soumitsalman/coffeemaker/sdk/beansack/store"
+)
+
+func TestCanonicalizeUrl(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"already canonical", "https://example.com/a/b", "https://example.com/a/b"},
+		{"http and trailing slash", "http://Example.com/a/b/", "https://example.com/a/b"},
+		{"fragment and default port", "https://example.com:443/a#top", "https://example.com/a"},
+		{"other port", "https://example.com:8080/a", "https://example.com:8080/a"},
+		{"utm and generic tracking", "https://example.com/a?utm_source=x&fbclid=y&id=1", "https://example.com/a?id=1"},
+		{"sorted params", "https://example.com/a?b=2&a=1", "https://example.com/a?a=1&b=2"},
+		{"mobile prefix", "https://m.example.com/a", "https://example.com/a"},
+		{"mobile prefix of a subdomain", "https://mobile.news.example.com/a", "https://news.example.com/a"},
+		{"amp prefix and path", "https://amp.example.com/a/amp", "https://example.com/a"},
+		{"amp is the site", "https://amp.dev/documentation", "https://amp.dev/documentation"},
+		{"mobile is the site", "https://mobile.de/auto", "https://mobile.de/auto"},
+		{"m is the site", "https://m.io/a", "https://m.io/a"},
+		{"source is content elsewhere", "https://example.com/a?source=nasa", "https://example.com/a?source=nasa"},
+		{"ref and share are content elsewhere", "https://example.com/a?ref=main&share=1", "https://example.com/a?ref=main&share=1"},
+		{"source tracks on substack", "https://foo.substack.com/p/a?source=queue&r=abc", "https://foo.substack.com/p/a"},
+		{"ref tracks on producthunt", "https://www.producthunt.com/posts/a?ref=home", "https://www.producthunt.com/posts/a"},
+		{"reddit host and params", "https://old.reddit.com/r/golang/comments/1?share_id=x", "https://www.reddit.com/r/golang/comments/1"},
+		{"youtube keeps the video", "https://m.youtube.com/watch?v=abc&t=10&feature=share", "https://www.youtube.com/watch?v=abc"},
+		{"twitter is x", "https://mobile.twitter.com/user/status/1?s=20", "https://x.com/user/status/1"},
+		{"not http", "ftp://example.com/a", "ftp://example.com/a"},
+		{"not a url", "not a url", "not a url"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := CanonicalizeUrl(test.url); got != test.want {
+				t.Errorf("CanonicalizeUrl(%q) = %q, want %q", test.url, got, test.want)
+			}
+		})
+	}
+}
+
+func TestWithURLs(t *testing.T) {
+	// the url filter goes in the $and so that the other $or filters don't overwrite it
+	options := NewSearchOptions().WithURLs([]string{"https://m.example.com/a"})
+	if _, ok := options.ScalarFilter["$or"]; ok {
+		t.Errorf("ScalarFilter has a top level $or that other filters would overwrite. %v", options.ScalarFilter)
+	}
+	and, _ := options.ScalarFilter["$and"].([]store.JSON)
+	want := getUrlFilter([]string{"https://m.example.com/a", "https://example.com/a"})
+	if len(and) != 1 || !reflect.DeepEqual(and[0], want) {
+		t.Errorf("ScalarFilter[$and] = %v, want [%v]", options.ScalarFilter["$and"], want)
+	}
+}
+
+func TestCanonicalizeBean(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		canonical string
+		want      string
+	}{
+		{"same host", "https://example.com/a?utm_source=x", "https://example.com/articles/a", "https://example.com/articles/a"},
+		{"subdomain of the same site", "https://www.bbc.co.uk/news/1", "https://news.bbc.co.uk/1", "https://news.bbc.co.uk/1"},
+		{"mobile site", "https://m.example.com/a", "https://www.example.com/a", "https://www.example.com/a"},
+		{"another site", "https://example.com/a", "https://spam.com/a", "https://example.com/a"},
+		{"another site under the same public suffix", "https://alice.github.io/post", "https://bob.github.io/post", "https://alice.github.io/post"},
+		{"another site under a country suffix", "https://example.co.uk/a", "https://other.co.uk/a", "https://example.co.uk/a"},
+		{"home page", "https://example.com/a", "https://example.com/", "https://example.com/a"},
+		{"home page of the home page", "https://example.com/", "https://example.com", "https://example.com"},
+		{"no canonical", "https://example.com/a/", "", "https://example.com/a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bean := Bean{Url: test.url}
+			CanonicalizeBean(&bean, test.canonical)
+			if bean.Url != test.want {
+				t.Errorf("CanonicalizeBean(%q, %q) = %q, want %q", test.url, test.canonical, bean.Url, test.want)
+			}
+			if bean.Url != test.url && !slices.Contains(bean.Aliases, test.url) {
+				t.Errorf("aliases = %v, want the original url %q", bean.Aliases, test.url)
+			}
+		})
+	}
+}
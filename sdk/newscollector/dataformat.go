@@ -8,15 +8,16 @@ import (
 )
 
 type Article struct {
-	URL         string   `json:"url,omitempty"`
-	Source      string   `json:"source,omitempty"`
-	Title       string   `json:"title,omitempty"`
-	Text        string   `json:"text,omitempty"`
-	Author      string   `json:"author,omitempty"`
-	PublishDate int64    `json:"created,omitempty"`
-	Keywords    []string `json:"keywords,omitempty"`
-	Comments    int      `json:"comments,omitempty"`
-	Likes       int      `json:"likes,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	CanonicalURL string   `json:"canonical_url,omitempty"` // <link rel=canonical> of the page
+	Source       string   `json:"source,omitempty"`
+	Title        string   `json:"title,omitempty"`
+	Text         string   `json:"text,omitempty"`
+	Author       string   `json:"author,omitempty"`
+	PublishDate  int64    `json:"created,omitempty"`
+	Keywords     []string `json:"keywords,omitempty"`
+	Comments     int      `json:"comments,omitempty"`
+	Likes        int      `json:"likes,omitempty"`
 }
 
 func (c *Article) String() string {
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/go-shiori/go-readability"
 	"github.com/gocolly/colly/v2"
 	"github.com/gocolly/colly/v2/extensions"
@@ -89,6 +90,8 @@ func (c *WebLoader) LoadArticle(url string) *Article {
 		c.articles[url] = article
 		c.collector.Visit(url)
 		c.collector.Wait()
+		// the html handler replaces the article with the extracted one
+		article = c.articles[url]
 	}
 	return article
 }
@@ -187,6 +190,7 @@ func NewDefaultNewsSitemapLoader(days int, sitemap_url string) *WebLoader {
 	web_collector.collector.OnHTML(BODY_EXPR_SHORT, func(h *colly.HTMLElement) {
 		if article := web_collector.Get(h.Request.URL.String()); article != nil {
 			article.Text = readBodyFromResponse(h.Response)
+			article.CanonicalURL = readCanonicalUrl(h.Response)
 		}
 	})
 
@@ -234,6 +238,7 @@ func NewMediumSiteLoader(days int) *WebLoader {
 		// get or create because sometime's the URLs change benignly
 		if article := web_collector.Get(h.Request.URL.String()); article != nil {
 			article.Text = readBodyFromResponse(h.Response)
+			article.CanonicalURL = readCanonicalUrl(h.Response)
 		}
 	})
 
@@ -295,6 +300,7 @@ func NewYCHackerNewsSiteLoader() *WebLoader {
 	web_collector.collector.OnHTML(BODY_EXPR, func(h *colly.HTMLElement) {
 		if article := web_collector.Get(h.Request.URL.String()); article != nil {
 			article.Text = readBodyFromResponse(h.Response)
+			article.CanonicalURL = readCanonicalUrl(h.Response)
 		}
 	})
 
@@ -346,7 +352,8 @@ func readArticleFromResponse(resp *colly.Response) *Article {
 				}
 				return 0
 			}(),
-			Source: resp.Request.URL.Host,
+			Source:       resp.Request.URL.Host,
+			CanonicalURL: readCanonicalUrl(resp),
 		}
 	}
 	return nil
@@ -359,6 +366,18 @@ func readBodyFromResponse(resp *colly.Response) string {
 	return ""
 }
 
+// returns the absolute url in <link rel=canonical> of the page. "" if there is none
+func readCanonicalUrl(resp *colly.Response) string {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(resp.Body))
+	if err != nil {
+		return ""
+	}
+	if href := strings.TrimSpace(doc.Find(`link[rel="canonical"]`).First().AttrOr("href", "")); href != "" {
+		return resp.Request.AbsoluteURL(href)
+	}
+	return ""
+}
+
 // func ToPrettyJsonString(data any) string {
 // 	val, err := json.MarshalIndent(data, "", "\t")
 // 	if err != nil {
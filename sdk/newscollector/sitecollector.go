@@ -52,6 +52,7 @@ func toBeans(docs []*Article) []ds.Bean {
 	beans := make([]ds.Bean, len(docs))
 	for i, doc := range docs {
 		beans[i].Url = doc.URL
+		ds.CanonicalizeBean(&beans[i], doc.CanonicalURL)
 		beans[i].Source = doc.Source
 		beans[i].Title = doc.Title
 		beans[i].Kind = ds.ARTICLE
@@ -61,7 +62,7 @@ func toBeans(docs []*Article) []ds.Bean {
 		beans[i].Keywords = doc.Keywords
 		if doc.Comments > 0 || doc.Likes > 0 {
 			beans[i].MediaNoise = &ds.MediaNoise{
-				BeanUrl:       doc.URL,
+				BeanUrl:       beans[i].Url,
 				Source:        doc.Source,
 				Comments:      doc.Comments,
 				ThumbsupCount: doc.Likes,
@@ -158,7 +158,7 @@ func collectRedditItem(client *RedditClient, item *RedditItem, collect_similar b
 // DATA FORMAT TRANSFORMERS
 func (item *RedditItem) toBean(children []RedditItem) *ds.Bean {
 	// create the top level instance for item
-	return &ds.Bean{
+	bean := &ds.Bean{
 		Url:        item.contentUrl(),
 		Source:     REDDIT_SOURCE,
 		Title:      item.Title,
@@ -169,6 +169,9 @@ func (item *RedditItem) toBean(children []RedditItem) *ds.Bean {
 		Keywords:   item.category(),
 		MediaNoise: item.toBeanMediaNoise(children),
 	}
+	ds.CanonicalizeBean(bean, item.linkedCanonicalUrl())
+	bean.MediaNoise.BeanUrl = bean.Url
+	return bean
 }
 
 func (item *RedditItem) toBeanMediaNoise(children []RedditItem) *ds.MediaNoise {
@@ -210,7 +213,7 @@ func (item *RedditItem) toUserEngagement(user *RedditUser) *ds.Sip {
 	eng_item := &ds.Sip{
 		Username: user.Username,
 		Source:   REDDIT_SOURCE,
-		BeanUrl:  item.contentUrl(),
+		BeanUrl:  ds.CanonicalizeUrl(item.contentUrl()),
 	}
 	switch item.Kind {
 	case SUBREDDIT:
@@ -255,6 +258,17 @@ func (item *RedditItem) contentUrl() string {
 	}
 }
 
+// <link rel=canonical> of the news article the post links to. "" if it is not a link post or the article has not been loaded
+func (item *RedditItem) linkedCanonicalUrl() string {
+	if item.kind() != ds.ARTICLE {
+		return ""
+	}
+	if article := url_collector.Get(item.Url); article != nil {
+		return article.CanonicalURL
+	}
+	return ""
+}
+
 func (item *RedditItem) containerUrl() string {
 	switch item.kind() {
 	case ds.ARTICLE: